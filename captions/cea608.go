@@ -0,0 +1,309 @@
+package captions
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	rows = 15
+	cols = 32
+	// textSettle is how long roll-up and paint-on text may keep changing
+	// before what is on screen becomes a cue, so that captions typed out a
+	// character at a time don't become a cue per character
+	textSettle = 500 * time.Millisecond
+)
+
+type captionMode int
+
+const (
+	modePopOn captionMode = iota
+	modeRollUp
+	modePaintOn
+)
+
+// Cue is caption text shown between two times
+type Cue struct {
+	Start, End time.Duration
+	Text       string
+}
+
+type screen [rows][cols]rune
+
+func (s *screen) clear() { *s = screen{} }
+
+func (s *screen) text() string {
+	var lines []string
+	for _, row := range s {
+		line := strings.TrimSpace(strings.Map(func(r rune) rune {
+			if r == 0 {
+				return ' '
+			}
+			return r
+		}, string(row[:])))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Decoder turns CEA-608 caption channel 1 (CC1) into cues. Styling and
+// position within the row are not kept.
+type Decoder struct {
+	mode         captionMode
+	rollRows     int
+	displayed    screen
+	nonDisplayed screen
+	row, col     int
+	// otherChannel is set while data is for CC2 or a text service
+	otherChannel bool
+	lastCtrl     [2]byte
+
+	dirty      bool
+	dirtySince time.Duration
+	shown      string
+	shownAt    time.Duration
+	cues       []Cue
+}
+
+// Push decodes one byte pair shown at time t
+func (d *Decoder) Push(t time.Duration, b1, b2 byte) {
+	b1, b2 = b1&0x7f, b2&0x7f
+	if b1 == 0 && b2 == 0 {
+		return
+	}
+	if b1 >= 0x10 && b1 <= 0x1f {
+		// control codes are sent twice in case one is lost
+		if d.lastCtrl == [2]byte{b1, b2} {
+			d.lastCtrl = [2]byte{}
+			return
+		}
+		d.lastCtrl = [2]byte{b1, b2}
+		d.control(t, b1, b2)
+		return
+	}
+	d.lastCtrl = [2]byte{}
+	if d.otherChannel {
+		return
+	}
+	d.put(t, standardChar(b1))
+	if b2 >= 0x20 {
+		d.put(t, standardChar(b2))
+	}
+}
+
+// Tick makes roll-up and paint-on text into a cue once it has settled
+func (d *Decoder) Tick(t time.Duration) {
+	if d.dirty && t-d.dirtySince >= textSettle {
+		d.update(t)
+	}
+}
+
+// Take returns the cues that have finished since it was last called
+func (d *Decoder) Take() []Cue {
+	cues := d.cues
+	d.cues = nil
+	return cues
+}
+
+// Showing returns the cue on screen, which has no end yet
+func (d *Decoder) Showing() (Cue, bool) {
+	return Cue{Start: d.shownAt, Text: d.shown}, d.shown != ""
+}
+
+// Split ends the cue on screen at t and starts it again from there, so that
+// it can be written in pieces
+func (d *Decoder) Split(t time.Duration) {
+	if d.shown != "" && t > d.shownAt {
+		d.cues = append(d.cues, Cue{Start: d.shownAt, End: t, Text: d.shown})
+		d.shownAt = t
+	}
+}
+
+func (d *Decoder) control(t time.Duration, b1, b2 byte) {
+	d.otherChannel = b1&0x08 != 0
+	if d.otherChannel {
+		return
+	}
+	switch {
+	case b1 == 0x14 && b2 >= 0x20 && b2 <= 0x2f:
+		d.misc(t, b2)
+	case b1 == 0x17 && b2 >= 0x21 && b2 <= 0x23:
+		// tab offsets
+		d.col = min(d.col+int(b2-0x20), cols-1)
+	case b1 == 0x11 && b2 >= 0x20 && b2 <= 0x2f:
+		// mid-row style changes take up a space
+		d.put(t, ' ')
+	case b1 == 0x11 && b2 >= 0x30 && b2 <= 0x3f:
+		d.put(t, specialChars[b2-0x30])
+	case (b1 == 0x12 || b1 == 0x13) && b2 >= 0x20 && b2 <= 0x3f:
+		// extended characters replace the standard one sent before them
+		// for decoders that don't know them
+		d.backspace(t)
+		if b1 == 0x12 {
+			d.put(t, extendedChars12[b2-0x20])
+		} else {
+			d.put(t, extendedChars13[b2-0x20])
+		}
+	case b2 >= 0x40:
+		d.preamble(b1, b2)
+	}
+}
+
+func (d *Decoder) misc(t time.Duration, b2 byte) {
+	switch b2 {
+	case 0x20: // resume caption loading
+		d.mode = modePopOn
+	case 0x21: // backspace
+		d.backspace(t)
+	case 0x24: // delete to end of row
+		target := d.target()
+		for c := d.col; c < cols; c++ {
+			target[d.row][c] = 0
+		}
+		d.changed(t)
+	case 0x25, 0x26, 0x27: // roll-up with 2, 3 or 4 rows
+		if d.mode != modeRollUp {
+			d.displayed.clear()
+			d.nonDisplayed.clear()
+			d.update(t)
+		}
+		d.mode = modeRollUp
+		d.rollRows = int(b2-0x25) + 2
+		d.row, d.col = rows-1, 0
+	case 0x29: // resume direct captioning
+		d.mode = modePaintOn
+	case 0x2a, 0x2b: // text restart and resume text display
+		d.otherChannel = true
+	case 0x2c: // erase displayed memory
+		d.displayed.clear()
+		d.update(t)
+	case 0x2d: // carriage return
+		if d.mode == modeRollUp {
+			top := max(d.row-d.rollRows+1, 0)
+			for r := top; r < d.row; r++ {
+				d.displayed[r] = d.displayed[r+1]
+			}
+			for r := 0; r < top; r++ {
+				d.displayed[r] = [cols]rune{}
+			}
+			d.displayed[d.row] = [cols]rune{}
+			d.update(t)
+		} else if d.row < rows-1 {
+			d.row++
+		}
+		d.col = 0
+	case 0x2e: // erase non-displayed memory
+		d.nonDisplayed.clear()
+	case 0x2f: // end of caption
+		d.displayed, d.nonDisplayed = d.nonDisplayed, d.displayed
+		d.mode = modePopOn
+		d.update(t)
+	}
+}
+
+// pacRows maps the first byte of a preamble address code to the rows it can
+// select
+var pacRows = map[byte][2]int{
+	0x11: {0, 1}, 0x12: {2, 3}, 0x15: {4, 5}, 0x16: {6, 7}, 0x17: {8, 9},
+	0x10: {10, 10}, 0x13: {11, 12}, 0x14: {13, 14},
+}
+
+// preamble moves the cursor to the row and indent given by a preamble address
+// code
+func (d *Decoder) preamble(b1, b2 byte) {
+	r, ok := pacRows[b1]
+	if !ok {
+		return
+	}
+	row := r[0]
+	if b2&0x20 != 0 {
+		row = r[1]
+	}
+	// roll-up captions stay on their base row, as only the text is kept
+	if d.mode != modeRollUp {
+		d.row = row
+	}
+	d.col = 0
+	if b2&0x10 != 0 {
+		d.col = int(b2&0x0e) >> 1 * 4
+	}
+}
+
+func (d *Decoder) target() *screen {
+	if d.mode == modePopOn {
+		return &d.nonDisplayed
+	}
+	return &d.displayed
+}
+
+func (d *Decoder) put(t time.Duration, r rune) {
+	d.target()[d.row][d.col] = r
+	if d.col < cols-1 {
+		d.col++
+	}
+	d.changed(t)
+}
+
+func (d *Decoder) backspace(t time.Duration) {
+	if d.col > 0 {
+		d.col--
+	}
+	d.target()[d.row][d.col] = 0
+	d.changed(t)
+}
+
+// changed notes that roll-up or paint-on text on screen has changed
+func (d *Decoder) changed(t time.Duration) {
+	if d.mode != modePopOn && !d.dirty {
+		d.dirty, d.dirtySince = true, t
+	}
+}
+
+// update starts a new cue if the text on screen has changed
+func (d *Decoder) update(t time.Duration) {
+	d.dirty = false
+	text := d.displayed.text()
+	if text == d.shown {
+		return
+	}
+	if d.shown != "" && t > d.shownAt {
+		d.cues = append(d.cues, Cue{Start: d.shownAt, End: t, Text: d.shown})
+	}
+	d.shown, d.shownAt = text, t
+}
+
+// standardChar maps the basic character set, which is ASCII apart from a few
+// accented letters
+func standardChar(b byte) rune {
+	switch b {
+	case 0x2a:
+		return 'á'
+	case 0x5c:
+		return 'é'
+	case 0x5e:
+		return 'í'
+	case 0x5f:
+		return 'ó'
+	case 0x60:
+		return 'ú'
+	case 0x7b:
+		return 'ç'
+	case 0x7c:
+		return '÷'
+	case 0x7d:
+		return 'Ñ'
+	case 0x7e:
+		return 'ñ'
+	case 0x7f:
+		return '█'
+	}
+	return rune(b)
+}
+
+var (
+	specialChars    = []rune("®°½¿™¢£♪à èâêîôû")
+	extendedChars12 = []rune("ÁÉÓÚÜü‘¡*’—©℠•“”ÀÂÇÈÊËëÎÏïÔÙùÛ«»")
+	extendedChars13 = []rune("ÃãÍÌìÒòÕõ{}\\^_|~ÄäÖöß¥¤│ÅåØø┌┐└┘")
+)
@@ -0,0 +1,96 @@
+package captions
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ccPair is a byte pair as carried in field 1, with odd parity, or just a
+// tick of the clock if it is empty
+type ccPair struct {
+	t    time.Duration
+	pair string
+}
+
+// TestDecoder feeds byte pair sequences through the decoder and checks the
+// cues that come out
+func TestDecoder(t *testing.T) {
+	ms := time.Millisecond
+	for _, tc := range []struct {
+		name    string
+		pairs   []ccPair
+		cues    []Cue
+		showing string
+	}{
+		{
+			name: "pop-on",
+			pairs: []ccPair{
+				{0, "9420"}, {0, "9420"}, // resume caption loading
+				{0, "94ae"}, {0, "94ae"}, // erase non-displayed memory
+				{0, "9470"}, {0, "9470"}, // row 15, column 0
+				{0, "c845"}, {0, "4c4c"}, {0, "4fa1"}, // HELLO!
+				{0, "8080"},
+				{1000 * ms, "942f"}, {1000 * ms, "942f"}, // end of caption
+				{3000 * ms, "942c"}, {3000 * ms, "942c"}, // erase displayed memory
+			},
+			cues: []Cue{{Start: 1000 * ms, End: 3000 * ms, Text: "HELLO!"}},
+		},
+		{
+			name: "roll-up",
+			pairs: []ccPair{
+				{0, "9425"}, {0, "9425"}, // roll-up, 2 rows
+				{0, "c849"}, // HI
+				{500 * ms, ""},
+				{1000 * ms, "94ad"}, {1000 * ms, "94ad"}, // carriage return
+				{1200 * ms, "d94f"}, // YO
+				{1700 * ms, ""},
+			},
+			cues:    []Cue{{Start: 500 * ms, End: 1700 * ms, Text: "HI"}},
+			showing: "HI\nYO",
+		},
+		{
+			name: "special and extended characters",
+			pairs: []ccPair{
+				{0, "9420"}, {0, "9420"},
+				{0, "9470"}, {0, "9470"},
+				{0, "c180"},              // A
+				{0, "9137"}, {0, "9137"}, // ♪
+				{0, "4580"},              // E, for decoders without the extended set
+				{0, "9220"}, {0, "9220"}, // Á, replacing the E
+				{0, "2a80"}, // á
+				{1000 * ms, "942f"}, {1000 * ms, "942f"},
+			},
+			showing: "A♪Áá",
+		},
+		{
+			name: "second channel",
+			pairs: []ccPair{
+				{0, "1c20"}, {0, "1c20"}, // resume caption loading on CC2
+				{0, "ce4f"}, // NO
+				{1000 * ms, "1c2f"}, {1000 * ms, "1c2f"},
+			},
+		},
+	} {
+		d := new(Decoder)
+		for _, p := range tc.pairs {
+			d.Tick(p.t)
+			if p.pair == "" {
+				continue
+			}
+			b, err := hex.DecodeString(p.pair)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Push(p.t, b[0], b[1])
+		}
+		if cues := d.Take(); !reflect.DeepEqual(cues, tc.cues) {
+			t.Errorf("%s: got cues %q, want %q", tc.name, cues, tc.cues)
+		}
+		showing, _ := d.Showing()
+		if showing.Text != tc.showing {
+			t.Errorf("%s: got %q on screen, want %q", tc.name, showing.Text, tc.showing)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package captions decodes the CEA-608 closed captions that encoders embed in
+// H.264 video and writes them out as WebVTT
+package captions
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+// reorderDepth is how many frames are held to put captions back into
+// presentation order, enough for the B-frame patterns encoders use
+const reorderDepth = 8
+
+const (
+	naluTypeSEI       = 6
+	seiUserDataT35    = 4
+	ccTypeNTSCField1  = 0
+	ccDataFlagProcess = 0x40
+)
+
+// ccPairs extracts the CEA-608 byte pairs for field 1 carried in a H.264
+// packet, as ATSC A/53 user data in SEI NAL units
+func ccPairs(data []byte) (pairs [][2]byte) {
+	nalus, _ := h264parser.SplitNALUs(data)
+	for _, nalu := range nalus {
+		if len(nalu) < 2 || nalu[0]&0x1f != naluTypeSEI {
+			continue
+		}
+		rbsp := unescapeRBSP(nalu[1:])
+		for len(rbsp) > 2 {
+			var ptype, psize int
+			for len(rbsp) > 0 && rbsp[0] == 0xff {
+				ptype += 255
+				rbsp = rbsp[1:]
+			}
+			if len(rbsp) == 0 {
+				break
+			}
+			ptype += int(rbsp[0])
+			rbsp = rbsp[1:]
+			for len(rbsp) > 0 && rbsp[0] == 0xff {
+				psize += 255
+				rbsp = rbsp[1:]
+			}
+			if len(rbsp) == 0 {
+				break
+			}
+			psize += int(rbsp[0])
+			rbsp = rbsp[1:]
+			if psize > len(rbsp) {
+				break
+			}
+			if ptype == seiUserDataT35 {
+				pairs = append(pairs, a53Pairs(rbsp[:psize])...)
+			}
+			rbsp = rbsp[psize:]
+		}
+	}
+	return pairs
+}
+
+// a53Pairs parses the cc_data of an ITU-T T.35 payload registered to ATSC
+func a53Pairs(b []byte) (pairs [][2]byte) {
+	// US country code, ATSC provider code, "GA94" and the cc_data type
+	if len(b) < 10 || b[0] != 0xb5 || b[1] != 0 || b[2] != 0x31 || string(b[3:7]) != "GA94" || b[7] != 3 {
+		return nil
+	}
+	if b[8]&ccDataFlagProcess == 0 {
+		return nil
+	}
+	count := int(b[8] & 0x1f)
+	b = b[10:]
+	for i := 0; i < count && len(b) >= 3; i++ {
+		valid, typ := b[0]&4 != 0, b[0]&3
+		if valid && typ == ccTypeNTSCField1 {
+			pairs = append(pairs, [2]byte{b[1], b[2]})
+		}
+		b = b[3:]
+	}
+	return pairs
+}
+
+// unescapeRBSP removes the emulation prevention bytes from a NAL unit
+func unescapeRBSP(b []byte) []byte {
+	if !bytes.Contains(b, []byte{0, 0, 3}) {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+type frameCC struct {
+	pts   time.Duration
+	pairs [][2]byte
+}
+
+// Extractor decodes the captions in a H.264 stream. Frames are given in
+// decode order and their captions decoded in presentation order.
+type Extractor struct {
+	Decoder
+	// Found is set once any caption data has been seen
+	Found bool
+
+	pending []frameCC
+}
+
+// WritePacket reads the captions from a video packet
+func (e *Extractor) WritePacket(pkt av.Packet) {
+	pairs := ccPairs(pkt.Data)
+	if len(pairs) != 0 {
+		e.Found = true
+	}
+	if !e.Found {
+		return
+	}
+	pts := pkt.Time + pkt.CompositionTime
+	i := sort.Search(len(e.pending), func(i int) bool { return e.pending[i].pts > pts })
+	e.pending = append(e.pending, frameCC{})
+	copy(e.pending[i+1:], e.pending[i:])
+	e.pending[i] = frameCC{pts, pairs}
+	for len(e.pending) > reorderDepth {
+		f := e.pending[0]
+		e.pending = e.pending[1:]
+		for _, p := range f.pairs {
+			e.Decoder.Push(f.pts, p[0], p[1])
+		}
+		e.Decoder.Tick(f.pts)
+	}
+}
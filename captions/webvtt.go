@@ -0,0 +1,32 @@
+package captions
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteWebVTT writes cues as a WebVTT file. Times are media timestamps, which
+// the X-TIMESTAMP-MAP header ties to the video's clock for HLS players: local
+// is the same moment as mpegts, in 90kHz units.
+func WriteWebVTT(w io.Writer, cues []Cue, mpegts int64, local time.Duration) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:%s\n", mpegts, vttTime(local))
+	for _, cue := range cues {
+		// a blank line would end the cue early
+		text := strings.ReplaceAll(cue.Text, "\n\n", "\n")
+		text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", vttTime(cue.Start), vttTime(cue.End), text)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func vttTime(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/locale"
+	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
+	"github.com/jackc/pgx/v5"
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: gunk [command] [-config file] [args]
+
+commands:
+  serve                        run the server (default)
+  migrate [-baseline N]        apply database migrations
+  user add USER_ID             add a user before they first log in
+  user list
+  user delete USER_ID          delete a user and all of their channels
+  user quota USER_ID N|default set how many channels a user may create, 0 for no limit
+  channel create -user USER_ID NAME
+  channel list
+  channel delete NAME
+  channel max-viewers NAME N   cap a channel's concurrent viewers, 0 for no limit
+  key show NAME
+  key rotate NAME              replace a channel's stream key
+  moderation list              list channels flagged for review
+  moderation image ID          write the image that triggered a flag to stdout
+  moderation resolve ID        close a flag and let the channel owner know
+  app add [-public] NAME REDIRECT_URI...
+                               register an app that can ask users for API tokens
+  app list
+  app delete CLIENT_ID         delete an app and revoke its tokens
+  ban add [-for DURATION] [-reason TEXT] USER_ID|IP|CIDR
+                               keep a user or addresses from watching, publishing or
+                               logging in on any channel
+  ban list
+  ban remove ID                lift a ban
+  netsim -server ADDR -channel FTL_ID -key KEY [-loss F] [-delay D] [-jitter D] [-duration D]
+                               stream a test pattern over FTL with simulated packet loss
+`)
+}
+
+// parseFlags parses the options common to every command and loads the
+// config. setup adds any options of the command's own.
+func parseFlags(name string, args []string, setup func(*flag.FlagSet)) (*config, []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = usage
+	configPath := fs.String("config", os.Getenv("GUNK_CONFIG"), "path to TOML config file")
+	if setup != nil {
+		setup(fs)
+	}
+	fs.Parse(args)
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalln("error: loading config:", err)
+	}
+	return cfg, fs.Args()
+}
+
+func connect(cfg *config) {
+	if err := model.Connect(context.Background(), cfg.Database.URL, time.Duration(cfg.Database.Timeout)); err != nil {
+		log.Fatalln("error: connecting to database:", err)
+	}
+}
+
+// subcommand splits off the action of a command like "user add"
+func subcommand(args []string) (string, []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	return args[0], args[1:]
+}
+
+// oneArg returns the single positional argument of an action
+func oneArg(args []string) string {
+	if len(args) != 1 {
+		usage()
+		os.Exit(2)
+	}
+	return args[0]
+}
+
+func checkFound(what string, err error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Fatalf("error: %s not found", what)
+	} else if err != nil {
+		log.Fatalln("error:", err)
+	}
+}
+
+func migrate(args []string) {
+	var baseline int
+	cfg, _ := parseFlags("migrate", args, func(fs *flag.FlagSet) {
+		fs.IntVar(&baseline, "baseline", 0, "record migrations up to this number as applied without running them")
+	})
+	connect(cfg)
+	ran, err := model.Migrate(context.Background(), baseline)
+	for _, name := range ran {
+		fmt.Println("applied", name)
+	}
+	if err != nil {
+		log.Fatalln("error:", err)
+	} else if len(ran) == 0 {
+		fmt.Println("database is up to date")
+	}
+}
+
+func userCmd(args []string) {
+	action, args := subcommand(args)
+	cfg, args := parseFlags("user "+action, args, nil)
+	ctx := context.Background()
+	switch action {
+	case "add":
+		userID := oneArg(args)
+		connect(cfg)
+		if err := model.AddUser(ctx, userID); err != nil {
+			log.Fatalln("error:", err)
+		}
+	case "list":
+		connect(cfg)
+		users, err := model.ListUsers(ctx)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tANNOUNCE\tCHANNELS")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%t\t%d\n", u.UserID, u.Announce, u.Channels)
+		}
+		w.Flush()
+	case "delete":
+		userID := oneArg(args)
+		connect(cfg)
+		checkFound("user", model.DeleteUser(ctx, userID))
+	case "quota":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		var max *int
+		if args[1] != "default" {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				log.Fatalf("error: invalid quota %q", args[1])
+			}
+			max = &n
+		}
+		connect(cfg)
+		checkFound("user", model.SetChannelQuota(ctx, args[0], max))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func channelCmd(args []string) {
+	action, args := subcommand(args)
+	var userID string
+	cfg, args := parseFlags("channel "+action, args, func(fs *flag.FlagSet) {
+		if action == "create" {
+			fs.StringVar(&userID, "user", "", "user ID of the owner")
+		}
+	})
+	ctx := context.Background()
+	switch action {
+	case "create":
+		name := oneArg(args)
+		if userID == "" {
+			log.Fatalln("error: -user is required")
+		}
+		connect(cfg)
+		// admins aren't held to the quota
+		def, err := model.CreateChannel(ctx, userID, name, 0)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		fmt.Println("key:", def.Key)
+	case "list":
+		connect(cfg)
+		channels, err := model.ListAllChannels(ctx)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tUSER\tROOM")
+		for _, c := range channels {
+			fmt.Fprintf(w, "%s\t%s\t%t\n", c.Name, c.UserID, c.Ephemeral)
+		}
+		w.Flush()
+	case "delete":
+		name := oneArg(args)
+		connect(cfg)
+		checkFound("channel", model.RemoveChannel(ctx, name))
+	case "max-viewers":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			log.Fatalf("error: invalid viewer count %q", args[1])
+		}
+		connect(cfg)
+		checkFound("channel", model.SetMaxViewers(ctx, args[0], n))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func keyCmd(args []string) {
+	action, args := subcommand(args)
+	cfg, args := parseFlags("key "+action, args, nil)
+	ctx := context.Background()
+	var key string
+	var err error
+	switch action {
+	case "show":
+		name := oneArg(args)
+		connect(cfg)
+		key, err = model.ChannelKey(ctx, name)
+	case "rotate":
+		name := oneArg(args)
+		connect(cfg)
+		key, err = model.RotateKey(ctx, name)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	checkFound("channel", err)
+	fmt.Println(key)
+}
+
+func moderationCmd(args []string) {
+	action, args := subcommand(args)
+	cfg, args := parseFlags("moderation "+action, args, nil)
+	ctx := context.Background()
+	switch action {
+	case "list":
+		connect(cfg)
+		flags, err := model.ListFlags(ctx)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCHANNEL\tREASON\tSCORE\tFLAGGED")
+		for _, f := range flags {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%.2f\t%s\n", f.ID, f.Name, f.Reason, f.Score, f.Created.Format(time.RFC3339))
+		}
+		w.Flush()
+	case "image":
+		id := flagID(oneArg(args))
+		connect(cfg)
+		image, err := model.GetFlagImage(ctx, id)
+		checkFound("flag image", err)
+		os.Stdout.Write(image)
+	case "resolve":
+		id := flagID(oneArg(args))
+		connect(cfg)
+		name, err := model.ResolveFlag(ctx, id)
+		checkFound("open flag", err)
+		notifyResolved(ctx, cfg, name)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func appCmd(args []string) {
+	action, args := subcommand(args)
+	var public bool
+	cfg, args := parseFlags("app "+action, args, func(fs *flag.FlagSet) {
+		if action == "add" {
+			fs.BoolVar(&public, "public", false, "app can't keep a secret and must use PKCE")
+		}
+	})
+	ctx := context.Background()
+	switch action {
+	case "add":
+		if len(args) < 2 {
+			usage()
+			os.Exit(2)
+		}
+		for _, uri := range args[1:] {
+			if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+				log.Fatalf("error: invalid redirect URI %q", uri)
+			}
+		}
+		connect(cfg)
+		c, secret, err := model.CreateOAuthClient(ctx, args[0], args[1:], public)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		fmt.Println("client_id:", c.ClientID)
+		if secret != "" {
+			fmt.Println("client_secret:", secret)
+		}
+	case "list":
+		connect(cfg)
+		clients, err := model.ListOAuthClients(ctx)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CLIENT_ID\tNAME\tPUBLIC\tREDIRECT_URIS")
+		for _, c := range clients {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", c.ClientID, c.Name, c.Public(), strings.Join(c.RedirectURIs, " "))
+		}
+		w.Flush()
+	case "delete":
+		clientID := oneArg(args)
+		connect(cfg)
+		checkFound("app", model.DeleteOAuthClient(ctx, clientID))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func banCmd(args []string) {
+	action, args := subcommand(args)
+	var duration time.Duration
+	var reason string
+	cfg, args := parseFlags("ban "+action, args, func(fs *flag.FlagSet) {
+		if action == "add" {
+			fs.DurationVar(&duration, "for", 0, "lift the ban after this long instead of never")
+			fs.StringVar(&reason, "reason", "", "why the ban was made, for other admins")
+		}
+	})
+	ctx := context.Background()
+	switch action {
+	case "add":
+		b := model.GlobalBan{Reason: reason}
+		target := oneArg(args)
+		if nets, err := ingest.ParseNets([]string{target}); err == nil && strings.ContainsAny(target, ".:") {
+			b.Addr = nets[0].String()
+		} else {
+			b.UserID = target
+		}
+		if duration < 0 {
+			log.Fatalln("error: -for must be positive")
+		} else if duration > 0 {
+			expires := time.Now().Add(duration)
+			b.Expires = &expires
+		}
+		connect(cfg)
+		id, err := model.AddGlobalBan(ctx, b)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		fmt.Println("ban:", id)
+	case "list":
+		connect(cfg)
+		bans, err := model.ListGlobalBans(ctx)
+		if err != nil {
+			log.Fatalln("error:", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tADDRESS\tCREATED\tEXPIRES\tREASON")
+		for _, b := range bans {
+			expires := "never"
+			if b.Expires != nil {
+				expires = b.Expires.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", b.ID, b.UserID, b.Addr, b.Created.Format(time.RFC3339), expires, b.Reason)
+		}
+		w.Flush()
+	case "remove":
+		v := oneArg(args)
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("error: invalid ban ID %q", v)
+		}
+		connect(cfg)
+		checkFound("ban", model.RemoveGlobalBan(ctx, id))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// notifyResolved tells a channel's owner that a review is over. It goes
+// straight to the inbox as the other sinks belong to the running server.
+func notifyResolved(ctx context.Context, cfg *config, name string) {
+	userID, err := model.GetChannelOwner(ctx, name)
+	if err != nil {
+		log.Printf("warning: looking up owner of %s: %s", name, err)
+		return
+	}
+	prefs, err := model.GetNotifyPrefs(ctx, userID)
+	if err != nil {
+		log.Printf("warning: looking up notification preferences of %s: %s", userID, err)
+	}
+	if !prefs.Wants(notify.ModerationResolved) {
+		return
+	}
+	messages, err := locale.Load(cfg.Locale.Dir, cfg.Locale.Default)
+	if err != nil {
+		log.Printf("warning: loading messages: %s", err)
+		messages = locale.Builtin()
+	}
+	msg := messages.Render(prefs.Language, "moderation_resolved", map[string]any{"Channel": name})
+	if _, err := model.AddNotification(ctx, userID, notify.ModerationResolved, name, msg); err != nil {
+		log.Printf("warning: notifying owner of %s: %s", name, err)
+	}
+}
+
+func flagID(v string) int64 {
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Fatalf("error: invalid flag ID %q", v)
+	}
+	return id
+}
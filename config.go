@@ -0,0 +1,499 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/web"
+	"github.com/BurntSushi/toml"
+)
+
+// defaultMaxChannels is how many channels a user may create unless the config
+// says otherwise
+const defaultMaxChannels = 10
+
+// defaultReleaseAfterDays is how long a deactivated user has to come back
+// before their channel names are freed
+const defaultReleaseAfterDays = 30
+
+// defaultSoakInterval is how often a soak test snapshots the heap and
+// goroutines
+const defaultSoakInterval = time.Hour
+
+// config holds the server settings. They are read from a TOML file if one is
+// given, then any of the environment variables below override them.
+type config struct {
+	BaseURL        string   `toml:"base_url"`        // BASE_URL
+	UI             string   `toml:"ui"`              // UI
+	CookieSecret   string   `toml:"cookie_secret"`   // COOKIE_SECRET
+	WorkDir        string   `toml:"work_dir"`        // WORK_DIR
+	PlayoutDir     string   `toml:"playout_dir"`     // PLAYOUT_DIR
+	PlayoutURLs    bool     `toml:"playout_urls"`    // PLAYOUT_URLS: let playlists play http(s) URLs
+	TrustedProxies []string `toml:"trusted_proxies"` // TRUSTED_PROXIES
+	APIDocs        bool     `toml:"api_docs"`        // API_DOCS: serve Swagger UI at /api/docs
+	EmbedAncestors []string `toml:"embed_ancestors"` // EMBED_ANCESTORS: sites that may frame the player
+	MaxChannels    int      `toml:"max_channels"`    // MAX_CHANNELS: per user, 0 for no limit
+
+	Listen struct {
+		HTTP    string `toml:"http"`    // LISTEN_HTTP
+		RTMP    string `toml:"rtmp"`    // LISTEN_RTMP
+		RTSP    string `toml:"rtsp"`    // LISTEN_RTSP
+		FTL     string `toml:"ftl"`     // LISTEN_FTL
+		Metrics string `toml:"metrics"` // METRICS
+	} `toml:"listen"`
+
+	Database struct {
+		// URL is a postgres connection string. If empty the libpq PG*
+		// environment variables are used.
+		URL     string   `toml:"url"`     // DATABASE_URL
+		Timeout duration `toml:"timeout"` // DB_TIMEOUT
+	} `toml:"database"`
+
+	OAuth struct {
+		ClientID            string `toml:"client_id"`             // CLIENT_ID
+		ClientSecret        string `toml:"client_secret"`         // CLIENT_SECRET
+		PatreonClientID     string `toml:"patreon_client_id"`     // PATREON_CLIENT_ID
+		PatreonClientSecret string `toml:"patreon_client_secret"` // PATREON_CLIENT_SECRET
+	} `toml:"oauth"`
+
+	Announce struct {
+		Webhook string   `toml:"webhook"` // WEBHOOK
+		Lead    duration `toml:"lead"`    // ANNOUNCE_LEAD
+	} `toml:"announce"`
+
+	Ingest struct {
+		RTMPURL          string   `toml:"rtmp_url"`           // RTMP_URL
+		LiveURL          string   `toml:"live_url"`           // LIVE_URL
+		RTSPURL          string   `toml:"rtsp_url"`           // RTSP_URL
+		Allow            []string `toml:"allow"`              // INGEST_ALLOW
+		Deny             []string `toml:"deny"`               // INGEST_DENY
+		ReconnectGrace   duration `toml:"reconnect_grace"`    // RECONNECT_GRACE
+		FailoverLimit    duration `toml:"failover_limit"`     // FAILOVER_LIMIT
+		OpusBitrate      int      `toml:"opus_bitrate"`       // OPUS_BITRATE
+		FTLMediaAddr     string   `toml:"ftl_media_addr"`     // FTL_MEDIA_ADDR
+		FTLAdvertisePort int      `toml:"ftl_advertise_port"` // FTL_ADVERTISE_PORT
+		FTLMediaPorts    string   `toml:"ftl_media_ports"`    // FTL_MEDIA_PORTS
+		// PublishHook is asked to allow or deny each live publisher
+		PublishHook         string   `toml:"publish_hook"`           // PUBLISH_HOOK
+		PublishHookTimeout  duration `toml:"publish_hook_timeout"`   // PUBLISH_HOOK_TIMEOUT
+		PublishHookFailOpen bool     `toml:"publish_hook_fail_open"` // PUBLISH_HOOK_FAIL_OPEN
+		// limits on what live publishers may send
+		Codecs              []string `toml:"codecs"`                // INGEST_CODECS
+		MaxBitrate          int      `toml:"max_bitrate"`           // MAX_BITRATE
+		MaxKeyframeInterval duration `toml:"max_keyframe_interval"` // MAX_KEYFRAME_INTERVAL
+		MaxWidth            int      `toml:"max_width"`             // MAX_WIDTH
+		MaxHeight           int      `toml:"max_height"`            // MAX_HEIGHT
+	} `toml:"ingest"`
+
+	HLS struct {
+		TargetDuration  duration `toml:"target_duration"`   // HLS_TARGET_DURATION
+		Window          duration `toml:"window"`            // HLS_WINDOW
+		TSPrebufferGOPs int      `toml:"ts_prebuffer_gops"` // TS_PREBUFFER_GOPS
+		Encrypt         bool     `toml:"encrypt"`           // HLS_ENCRYPT
+		KeyRotation     duration `toml:"key_rotation"`      // HLS_KEY_ROTATION
+	} `toml:"hls"`
+
+	Thumbs struct {
+		Interval duration `toml:"interval"` // THUMB_INTERVAL
+		Width    int      `toml:"width"`    // THUMB_WIDTH
+		Quality  int      `toml:"quality"`  // THUMB_QUALITY
+		Format   string   `toml:"format"`   // THUMB_FORMAT
+		Store    string   `toml:"store"`    // THUMB_STORE
+	} `toml:"thumbs"`
+
+	S3 struct {
+		Endpoint  string `toml:"endpoint"`   // S3_ENDPOINT
+		Region    string `toml:"region"`     // S3_REGION
+		Bucket    string `toml:"bucket"`     // S3_BUCKET
+		AccessKey string `toml:"access_key"` // S3_ACCESS_KEY
+		SecretKey string `toml:"secret_key"` // S3_SECRET_KEY
+	} `toml:"s3"`
+
+	Log struct {
+		Level  string `toml:"level"`  // LOG_LEVEL: debug, info, warn or error
+		Format string `toml:"format"` // LOG_FORMAT: text or json
+	} `toml:"log"`
+
+	Notify struct {
+		SMTPAddr        string `toml:"smtp_addr"`         // SMTP_ADDR
+		SMTPFrom        string `toml:"smtp_from"`         // SMTP_FROM
+		SMTPUsername    string `toml:"smtp_username"`     // SMTP_USERNAME
+		SMTPPassword    string `toml:"smtp_password"`     // SMTP_PASSWORD
+		DiscordBotToken string `toml:"discord_bot_token"` // DISCORD_BOT_TOKEN
+	} `toml:"notify"`
+
+	NSFW struct {
+		URL       string   `toml:"url"`       // NSFW_URL
+		Threshold float64  `toml:"threshold"` // NSFW_THRESHOLD
+		Interval  duration `toml:"interval"`  // NSFW_INTERVAL
+	} `toml:"nsfw"`
+
+	Directory struct {
+		Name     string `toml:"name"`      // DIRECTORY_NAME: defaults to the base URL's host
+		ReportTo string `toml:"report_to"` // DIRECTORY_REPORT_TO: directory to be listed in
+		Serve    bool   `toml:"serve"`     // DIRECTORY_SERVE: run a directory for other instances
+	} `toml:"directory"`
+
+	RateLimit struct {
+		Login    string `toml:"login"`    // RATE_LIMIT_LOGIN
+		API      string `toml:"api"`      // RATE_LIMIT_API
+		Playback string `toml:"playback"` // RATE_LIMIT_PLAYBACK
+		Session  string `toml:"session"`  // RATE_LIMIT_SESSION
+		// Anonymous applies to the public API without credentials
+		Anonymous string `toml:"anonymous"` // RATE_LIMIT_ANONYMOUS
+	} `toml:"rate_limit"`
+
+	Accounts struct {
+		DeactivateAfterMonths int `toml:"deactivate_after_months"` // DEACTIVATE_AFTER_MONTHS: 0 to never
+		ReleaseAfterDays      int `toml:"release_after_days"`      // RELEASE_AFTER_DAYS
+	} `toml:"accounts"`
+
+	Locale struct {
+		// Default is for users who haven't chosen a language and for
+		// announcements
+		Default string `toml:"default"` // LANGUAGE: defaults to en
+		// Dir holds TOML files of messages that override or add to the
+		// built-in ones, named after their language
+		Dir string `toml:"dir"` // LOCALE_DIR
+		// Templates holds templates that lay out announcements, webhook
+		// bodies and emails
+		Templates string `toml:"templates"` // TEMPLATES_DIR
+	} `toml:"locale"`
+
+	Captions struct {
+		// Transcriber is a speech to text service URL or a command, for
+		// channels that turn on automatic captions
+		Transcriber string `toml:"transcriber"` // TRANSCRIBER: off if empty
+	} `toml:"captions"`
+
+	Diagnostics struct {
+		SoakDir      string   `toml:"soak_dir"`      // SOAK_DIR: off if empty
+		SoakInterval duration `toml:"soak_interval"` // SOAK_INTERVAL
+	} `toml:"diagnostics"`
+}
+
+// duration is a time.Duration written like "30s" in the config file
+type duration time.Duration
+
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	*d = duration(v)
+	return err
+}
+
+// loadConfig reads the config file, if any, and applies environment
+// overrides
+func loadConfig(path string) (*config, error) {
+	c := new(config)
+	c.Listen.HTTP = ":8009"
+	c.Log.Level = "info"
+	c.MaxChannels = defaultMaxChannels
+	c.Accounts.ReleaseAfterDays = defaultReleaseAfterDays
+	c.Diagnostics.SoakInterval = duration(defaultSoakInterval)
+	if path != "" {
+		md, err := toml.DecodeFile(path, c)
+		if err != nil {
+			return nil, err
+		}
+		if undec := md.Undecoded(); len(undec) != 0 {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, undec[0].String())
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *config) applyEnv() error {
+	vars := []struct {
+		name string
+		dst  interface{}
+	}{
+		{"BASE_URL", &c.BaseURL},
+		{"UI", &c.UI},
+		{"COOKIE_SECRET", &c.CookieSecret},
+		{"WORK_DIR", &c.WorkDir},
+		{"PLAYOUT_DIR", &c.PlayoutDir},
+		{"PLAYOUT_URLS", &c.PlayoutURLs},
+		{"TRUSTED_PROXIES", &c.TrustedProxies},
+		{"API_DOCS", &c.APIDocs},
+		{"EMBED_ANCESTORS", &c.EmbedAncestors},
+		{"MAX_CHANNELS", &c.MaxChannels},
+		{"LISTEN_HTTP", &c.Listen.HTTP},
+		{"LISTEN_RTMP", &c.Listen.RTMP},
+		{"LISTEN_RTSP", &c.Listen.RTSP},
+		{"LISTEN_FTL", &c.Listen.FTL},
+		{"METRICS", &c.Listen.Metrics},
+		{"DATABASE_URL", &c.Database.URL},
+		{"DB_TIMEOUT", &c.Database.Timeout},
+		{"CLIENT_ID", &c.OAuth.ClientID},
+		{"CLIENT_SECRET", &c.OAuth.ClientSecret},
+		{"PATREON_CLIENT_ID", &c.OAuth.PatreonClientID},
+		{"PATREON_CLIENT_SECRET", &c.OAuth.PatreonClientSecret},
+		{"WEBHOOK", &c.Announce.Webhook},
+		{"ANNOUNCE_LEAD", &c.Announce.Lead},
+		{"RTMP_URL", &c.Ingest.RTMPURL},
+		{"LIVE_URL", &c.Ingest.LiveURL},
+		{"RTSP_URL", &c.Ingest.RTSPURL},
+		{"INGEST_ALLOW", &c.Ingest.Allow},
+		{"INGEST_DENY", &c.Ingest.Deny},
+		{"RECONNECT_GRACE", &c.Ingest.ReconnectGrace},
+		{"FAILOVER_LIMIT", &c.Ingest.FailoverLimit},
+		{"OPUS_BITRATE", &c.Ingest.OpusBitrate},
+		{"FTL_MEDIA_ADDR", &c.Ingest.FTLMediaAddr},
+		{"FTL_ADVERTISE_PORT", &c.Ingest.FTLAdvertisePort},
+		{"FTL_MEDIA_PORTS", &c.Ingest.FTLMediaPorts},
+		{"PUBLISH_HOOK", &c.Ingest.PublishHook},
+		{"PUBLISH_HOOK_TIMEOUT", &c.Ingest.PublishHookTimeout},
+		{"PUBLISH_HOOK_FAIL_OPEN", &c.Ingest.PublishHookFailOpen},
+		{"INGEST_CODECS", &c.Ingest.Codecs},
+		{"MAX_BITRATE", &c.Ingest.MaxBitrate},
+		{"MAX_KEYFRAME_INTERVAL", &c.Ingest.MaxKeyframeInterval},
+		{"MAX_WIDTH", &c.Ingest.MaxWidth},
+		{"MAX_HEIGHT", &c.Ingest.MaxHeight},
+		{"HLS_TARGET_DURATION", &c.HLS.TargetDuration},
+		{"HLS_WINDOW", &c.HLS.Window},
+		{"TS_PREBUFFER_GOPS", &c.HLS.TSPrebufferGOPs},
+		{"HLS_ENCRYPT", &c.HLS.Encrypt},
+		{"HLS_KEY_ROTATION", &c.HLS.KeyRotation},
+		{"THUMB_INTERVAL", &c.Thumbs.Interval},
+		{"THUMB_WIDTH", &c.Thumbs.Width},
+		{"THUMB_QUALITY", &c.Thumbs.Quality},
+		{"THUMB_FORMAT", &c.Thumbs.Format},
+		{"THUMB_STORE", &c.Thumbs.Store},
+		{"S3_ENDPOINT", &c.S3.Endpoint},
+		{"S3_REGION", &c.S3.Region},
+		{"S3_BUCKET", &c.S3.Bucket},
+		{"S3_ACCESS_KEY", &c.S3.AccessKey},
+		{"S3_SECRET_KEY", &c.S3.SecretKey},
+		{"LOG_LEVEL", &c.Log.Level},
+		{"LOG_FORMAT", &c.Log.Format},
+		{"SMTP_ADDR", &c.Notify.SMTPAddr},
+		{"SMTP_FROM", &c.Notify.SMTPFrom},
+		{"SMTP_USERNAME", &c.Notify.SMTPUsername},
+		{"SMTP_PASSWORD", &c.Notify.SMTPPassword},
+		{"DISCORD_BOT_TOKEN", &c.Notify.DiscordBotToken},
+		{"NSFW_URL", &c.NSFW.URL},
+		{"NSFW_THRESHOLD", &c.NSFW.Threshold},
+		{"NSFW_INTERVAL", &c.NSFW.Interval},
+		{"DIRECTORY_NAME", &c.Directory.Name},
+		{"DIRECTORY_REPORT_TO", &c.Directory.ReportTo},
+		{"DIRECTORY_SERVE", &c.Directory.Serve},
+		{"RATE_LIMIT_LOGIN", &c.RateLimit.Login},
+		{"RATE_LIMIT_API", &c.RateLimit.API},
+		{"RATE_LIMIT_PLAYBACK", &c.RateLimit.Playback},
+		{"RATE_LIMIT_SESSION", &c.RateLimit.Session},
+		{"RATE_LIMIT_ANONYMOUS", &c.RateLimit.Anonymous},
+		{"DEACTIVATE_AFTER_MONTHS", &c.Accounts.DeactivateAfterMonths},
+		{"RELEASE_AFTER_DAYS", &c.Accounts.ReleaseAfterDays},
+		{"LANGUAGE", &c.Locale.Default},
+		{"LOCALE_DIR", &c.Locale.Dir},
+		{"TEMPLATES_DIR", &c.Locale.Templates},
+		{"TRANSCRIBER", &c.Captions.Transcriber},
+		{"SOAK_DIR", &c.Diagnostics.SoakDir},
+		{"SOAK_INTERVAL", &c.Diagnostics.SoakInterval},
+	}
+	for _, v := range vars {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		var err error
+		switch dst := v.dst.(type) {
+		case *string:
+			*dst = s
+		case *[]string:
+			*dst = strings.Split(s, ",")
+		case *bool:
+			*dst, err = strconv.ParseBool(s)
+		case *int:
+			*dst, err = strconv.Atoi(s)
+		case *float64:
+			*dst, err = strconv.ParseFloat(s, 64)
+		case *duration:
+			err = dst.UnmarshalText([]byte(s))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks settings that would otherwise only fail once they are
+// used, returning every problem found
+func (c *config) validate() []error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if c.CookieSecret == "" {
+		add("cookie_secret must be set")
+	}
+	if c.UI == "" {
+		add("ui must be set to the location of the UI, either a local path or URL")
+	}
+	if _, err := url.Parse(c.BaseURL); err != nil {
+		add("base_url: %s", err)
+	}
+	if c.Ingest.LiveURL != "" {
+		if _, err := url.Parse(c.Ingest.LiveURL); err != nil {
+			add("ingest.live_url: %s", err)
+		}
+	}
+	for name, d := range map[string]duration{
+		"database.timeout":            c.Database.Timeout,
+		"announce.lead":               c.Announce.Lead,
+		"ingest.reconnect_grace":      c.Ingest.ReconnectGrace,
+		"ingest.failover_limit":       c.Ingest.FailoverLimit,
+		"ingest.publish_hook_timeout": c.Ingest.PublishHookTimeout,
+		"hls.target_duration":         c.HLS.TargetDuration,
+		"hls.window":                  c.HLS.Window,
+		"hls.key_rotation":            c.HLS.KeyRotation,
+		"thumbs.interval":             c.Thumbs.Interval,
+		"nsfw.interval":               c.NSFW.Interval,
+	} {
+		if d < 0 {
+			add("%s must not be negative", name)
+		}
+	}
+	for name, nets := range map[string][]string{
+		"trusted_proxies": c.TrustedProxies,
+		"ingest.allow":    c.Ingest.Allow,
+		"ingest.deny":     c.Ingest.Deny,
+	} {
+		if _, err := ingest.ParseNets(nets); err != nil {
+			add("%s: %s", name, err)
+		}
+	}
+	if v := c.Ingest.PublishHook; v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("ingest.publish_hook must be an http or https URL")
+		}
+	}
+	if v := c.Ingest.FTLMediaPorts; v != "" {
+		if _, _, err := parsePortRange(v); err != nil {
+			add("ingest.ftl_media_ports: %s", err)
+		}
+	}
+	if v := c.Directory.ReportTo; v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("directory.report_to must be an http or https URL")
+		}
+	}
+	for _, v := range c.EmbedAncestors {
+		if v == "" || strings.ContainsAny(v, " ;,'\"") {
+			add("embed_ancestors: invalid source %q", v)
+		}
+	}
+	if c.MaxChannels < 0 {
+		add("max_channels must not be negative")
+	}
+	if c.HLS.TSPrebufferGOPs < 0 {
+		add("hls.ts_prebuffer_gops must not be negative")
+	}
+	if c.Accounts.DeactivateAfterMonths < 0 {
+		add("accounts.deactivate_after_months must not be negative")
+	}
+	if c.Accounts.ReleaseAfterDays < 1 {
+		add("accounts.release_after_days must be at least 1")
+	}
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("log.level must be debug, info, warn or error")
+	}
+	switch c.Log.Format {
+	case "", "text", "json":
+	default:
+		add("log.format must be text or json")
+	}
+	if c.Notify.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Notify.SMTPAddr); err != nil {
+			add("notify.smtp_addr must be host:port")
+		}
+		if c.Notify.SMTPFrom == "" {
+			add("notify.smtp_from is required to send email")
+		}
+	}
+	if c.NSFW.Threshold < 0 || c.NSFW.Threshold > 1 {
+		add("nsfw.threshold must be between 0 and 1")
+	} else if c.NSFW.URL != "" {
+		if u, err := url.Parse(c.NSFW.URL); err != nil || u.Host == "" {
+			add("nsfw.url must be an absolute URL")
+		}
+	}
+	switch c.Thumbs.Format {
+	case "", "jpeg", "webp":
+	default:
+		add("thumbs.format must be jpeg or webp")
+	}
+	switch v := c.Thumbs.Store; {
+	case v == "" || v == "db":
+	case strings.HasPrefix(v, "file:"):
+	case v == "s3":
+		if c.S3.Endpoint == "" || c.S3.Bucket == "" || c.S3.Region == "" {
+			add("s3.endpoint, s3.bucket and s3.region must be set to store thumbnails in S3")
+		}
+	default:
+		add("thumbs.store must be db, file:<dir> or s3")
+	}
+	if c.Diagnostics.SoakDir != "" && c.Diagnostics.SoakInterval <= 0 {
+		add("diagnostics.soak_interval must be positive")
+	}
+	for name, v := range map[string]string{
+		"rate_limit.login":     c.RateLimit.Login,
+		"rate_limit.api":       c.RateLimit.API,
+		"rate_limit.playback":  c.RateLimit.Playback,
+		"rate_limit.session":   c.RateLimit.Session,
+		"rate_limit.anonymous": c.RateLimit.Anonymous,
+	} {
+		if _, err := parseRateLimit(v, web.RateLimit{}); err != nil {
+			add("%s: %s", name, err)
+		}
+	}
+	return errs
+}
+
+// parseRateLimit parses a limit given as "rate/burst" in requests per second,
+// or "off" to disable it
+func parseRateLimit(v string, def web.RateLimit) (web.RateLimit, error) {
+	switch v {
+	case "":
+		return def, nil
+	case "off":
+		return web.RateLimit{}, nil
+	}
+	rate, burst, _ := strings.Cut(v, "/")
+	var l web.RateLimit
+	var err error
+	l.Rate, err = strconv.ParseFloat(rate, 64)
+	if err == nil {
+		l.Burst, err = strconv.Atoi(burst)
+	}
+	if err != nil || l.Rate <= 0 || l.Burst < 1 {
+		return l, errors.New("expected rate/burst like 5/50 or off")
+	}
+	return l, nil
+}
+
+// parsePortRange parses a range of ports like 10000-10100
+func parsePortRange(v string) (lo, hi int, err error) {
+	los, his, _ := strings.Cut(v, "-")
+	lo, err = strconv.Atoi(los)
+	if err == nil {
+		hi, err = strconv.Atoi(his)
+	}
+	if err != nil || lo <= 0 || hi < lo || hi > 65535 {
+		return 0, 0, errors.New("expected a range like 10000-10100")
+	}
+	return lo, hi, nil
+}
@@ -1,18 +1,19 @@
 module eaglesong.dev/gunk
 
-go 1.12
+go 1.21
 
 require (
 	eaglesong.dev/hls v0.3.0
-	github.com/cockroachdb/apd v1.1.0 // indirect
+	github.com/BurntSushi/toml v1.3.2
 	github.com/golang/mock v1.3.1 // indirect
 	github.com/golang/protobuf v1.3.2 // indirect
 	github.com/gorilla/mux v1.7.3
 	github.com/gorilla/websocket v1.4.1
-	github.com/jackc/fake v0.0.0-20150926172116-812a484cc733 // indirect
-	github.com/jackc/pgx v3.5.0+incompatible
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/pgx/v5 v5.5.5
+	github.com/jackc/puddle/v2 v2.2.1 // indirect
 	github.com/kr/pretty v0.1.0
-	github.com/lib/pq v1.1.1 // indirect
 	github.com/lucas-clemente/quic-go v0.7.1-0.20190825070216-f1d14ecdeafb // indirect
 	github.com/nareix/joy4 v0.0.0-20190831160920-566887487cc0
 	github.com/onsi/ginkgo v1.9.0 // indirect
@@ -26,14 +27,14 @@ require (
 	github.com/pion/turn v1.3.4 // indirect
 	github.com/pion/webrtc/v2 v2.1.2
 	github.com/pkg/errors v0.8.1
-	github.com/satori/go.uuid v1.2.0 // indirect
-	github.com/shopspring/decimal v0.0.0-20180709203117-cd690d0c9e24 // indirect
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/stretchr/testify v1.4.0 // indirect
-	golang.org/x/crypto v0.0.0-20190820162420-60c769a6c586
-	golang.org/x/net v0.0.0-20190827160401-ba9fcec4b297 // indirect
+	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
-	golang.org/x/sync v0.0.0-20190423024810-112230192c58
-	golang.org/x/sys v0.0.0-20190826190057-c7b8b68b1456 // indirect
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/appengine v1.6.2 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	layeh.com/gopus v0.0.0-20161224163843-0ebf989153aa
@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"eaglesong.dev/gunk/model"
+)
+
+var errAddrDenied = errors.New("address is not allowed to publish to this channel")
+
+// AddrFilter restricts which addresses may publish. Denied ranges take
+// precedence, and if any ranges are allowed then everything else is denied.
+type AddrFilter struct {
+	Allow, Deny []*net.IPNet
+}
+
+// Permits returns true if ip may publish
+func (f AddrFilter) Permits(ip net.IP) bool {
+	if containsIP(f.Deny, ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || containsIP(f.Allow, ip)
+}
+
+// ParseNets parses a list of IPs and CIDR ranges
+func ParseNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			if strings.Contains(v, ":") {
+				v += "/128"
+			} else {
+				v += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAddr returns true if the server-wide filter and bans let ip publish. It
+// is called as soon as an ingest connection is accepted.
+func (m *Manager) CheckAddr(ip net.IP) bool {
+	return m.IngestFilter.Permits(ip) && !m.bans.Load().bannedAddr(ip)
+}
+
+// checkChannelAddr applies a channel's own allowlist to a live publisher
+func checkChannelAddr(auth model.ChannelAuth, remote string) error {
+	if len(auth.IngestAllow) == 0 {
+		return nil
+	}
+	allow, err := ParseNets(auth.IngestAllow)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(remote); ip == nil || !containsIP(allow, ip) {
+		return errAddrDenied
+	}
+	return nil
+}
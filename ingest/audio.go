@@ -0,0 +1,66 @@
+package ingest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+)
+
+// ErrNoAAC is returned when a channel's audio isn't AAC, such as Opus from
+// FTL, or it has no audio at all
+var ErrNoAAC = errors.New("channel has no AAC audio")
+
+// aacFrameSamples is how many samples each AAC packet holds
+const aacFrameSamples = 1024
+
+// The channel's audio alone as ADTS, for radio and podcast players that don't
+// handle video. Icecast-style headers name the stream.
+func init() {
+	RegisterFormat("aac", &Format{
+		Kind:        "aac",
+		ContentType: "audio/aac",
+		Header: func(h http.Header, channel string) {
+			h.Set("Cache-Control", "no-cache, no-store")
+			h.Set("icy-name", channel)
+			h.Set("icy-pub", "0")
+		},
+		Flush:    true,
+		NewMuxer: newADTSMuxer,
+	})
+}
+
+// adtsMuxer writes the first AAC stream with an ADTS header on each frame and
+// drops the rest
+type adtsMuxer struct {
+	w     io.Writer
+	idx   int
+	codec aacparser.CodecData
+	buf   []byte
+}
+
+func newADTSMuxer(w io.Writer, streams []av.CodecData) (av.Muxer, error) {
+	for i, stream := range streams {
+		if c, ok := stream.(aacparser.CodecData); ok {
+			return &adtsMuxer{w: w, idx: i, codec: c}, nil
+		}
+	}
+	return nil, ErrNoAAC
+}
+
+func (m *adtsMuxer) WriteHeader([]av.CodecData) error { return nil }
+func (m *adtsMuxer) WriteTrailer() error              { return nil }
+
+func (m *adtsMuxer) WritePacket(pkt av.Packet) error {
+	if int(pkt.Idx) != m.idx {
+		return nil
+	}
+	// one write per frame so that it is flushed whole
+	m.buf = append(m.buf[:0], make([]byte, aacparser.ADTSHeaderLength)...)
+	aacparser.FillADTSHeader(m.buf, m.codec.Config, aacFrameSamples, len(pkt.Data))
+	m.buf = append(m.buf, pkt.Data...)
+	_, err := m.w.Write(m.buf)
+	return err
+}
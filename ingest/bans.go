@@ -0,0 +1,94 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+)
+
+// banRefreshInterval is how soon bans made from the command line take effect
+const banRefreshInterval = 30 * time.Second
+
+var errBanned = errors.New("publisher is banned")
+
+// banList is a snapshot of the global bans
+type banList struct {
+	users map[string]time.Time
+	nets  []*net.IPNet
+	// expires is when each range's ban ends, or zero for never
+	expires []time.Time
+}
+
+func (b *banList) bannedUser(userID string) bool {
+	if b == nil || userID == "" {
+		return false
+	}
+	exp, ok := b.users[userID]
+	return ok && (exp.IsZero() || time.Now().Before(exp))
+}
+
+func (b *banList) bannedAddr(ip net.IP) bool {
+	if b == nil || ip == nil {
+		return false
+	}
+	now := time.Now()
+	for i, ipnet := range b.nets {
+		if ipnet.Contains(ip) && (b.expires[i].IsZero() || now.Before(b.expires[i])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Banned returns true if a global ban covers the user or the address. Either
+// may be left empty.
+func (m *Manager) Banned(userID string, ip net.IP) bool {
+	b := m.bans.Load()
+	return b.bannedUser(userID) || b.bannedAddr(ip)
+}
+
+// RefreshBans periodically reloads the global bans and disconnects live
+// publishers whose owner has been banned
+func (m *Manager) RefreshBans() {
+	m.refreshBans()
+	for range time.NewTicker(banRefreshInterval).C {
+		m.refreshBans()
+	}
+}
+
+func (m *Manager) refreshBans() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	bans, err := model.ListGlobalBans(ctx)
+	if err != nil {
+		slog.Error("loading global bans", "err", err)
+		return
+	}
+	b := &banList{users: make(map[string]time.Time)}
+	for _, ban := range bans {
+		var exp time.Time
+		if ban.Expires != nil {
+			exp = *ban.Expires
+		}
+		if ban.UserID != "" {
+			b.users[ban.UserID] = exp
+		} else if nets, err := ParseNets([]string{ban.Addr}); err == nil {
+			b.nets = append(b.nets, nets...)
+			b.expires = append(b.expires, exp)
+		}
+	}
+	m.bans.Store(b)
+	m.channels.Range(func(k, v interface{}) bool {
+		ch := v.(*channel)
+		if b.bannedUser(ch.publisher()) {
+			if err := ch.kick(time.Now()); err == nil {
+				slog.Info("banned publisher kicked", "channel", k.(string), "user_id", ch.publisher())
+			}
+		}
+		return true
+	})
+}
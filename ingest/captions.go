@@ -0,0 +1,211 @@
+package ingest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eaglesong.dev/gunk/captions"
+	"github.com/nareix/joy4/av"
+)
+
+// names of a channel's HLS subtitle playlists, each with WebVTT segments named
+// after it
+const (
+	// SubtitlePlaylist carries any CEA-608 captions embedded in the video
+	SubtitlePlaylist = "subtitles.m3u8"
+	// TranscriptPlaylist carries captions from the speech to text service
+	TranscriptPlaylist = "transcript.m3u8"
+)
+
+// captionSegmentLength is how much media each WebVTT segment covers
+const captionSegmentLength = 4 * time.Second
+
+type vttSegment struct {
+	duration      time.Duration
+	cues          []captions.Cue
+	discontinuity bool
+	// timestamps ties the cues to the video segments of the same publisher
+	timestamps *timestampMap
+}
+
+// captionTrack cuts the captions in a channel's video, or those added by a
+// transcriber, into WebVTT segments. Like the HLS publisher it lives across
+// reconnects.
+type captionTrack struct {
+	mu sync.Mutex
+	// name is the playlist's name without the extension
+	name string
+	ex   captions.Extractor
+	// maxSegments keeps the playlist about as long as the video's
+	maxSegments int
+
+	started       bool
+	segStart      time.Duration
+	last          time.Duration
+	discontinuity bool
+	timestamps    *timestampMap
+	// seq is the media sequence number of segments[0], and discontinuities
+	// counts those that have left the playlist
+	seq             int
+	discontinuities int
+	segments        []vttSegment
+}
+
+func newCaptionTrack(playlist string, window time.Duration, timestamps *timestampMap) *captionTrack {
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &captionTrack{
+		name:        strings.TrimSuffix(playlist, ".m3u8"),
+		maxSegments: int(window/captionSegmentLength) + 2,
+		timestamps:  timestamps,
+	}
+}
+
+// add reads the captions from a video packet
+func (c *captionTrack) add(pkt av.Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ex.WritePacket(pkt)
+	if !c.ex.Found {
+		return
+	}
+	if !c.started {
+		c.segStart, c.started = pkt.Time, true
+	}
+	c.last = pkt.Time
+	if pkt.Time-c.segStart >= captionSegmentLength {
+		c.cut(pkt.Time)
+	}
+}
+
+func (c *captionTrack) cut(t time.Duration) {
+	c.ex.Split(t)
+	seg := vttSegment{duration: t - c.segStart, discontinuity: c.discontinuity}
+	for _, cue := range c.ex.Take() {
+		if cue.End > cue.Start {
+			seg.cues = append(seg.cues, cue)
+		}
+	}
+	c.appendSegment(seg)
+	c.segStart = t
+}
+
+func (c *captionTrack) appendSegment(seg vttSegment) {
+	seg.timestamps = c.timestamps
+	c.segments = append(c.segments, seg)
+	if n := len(c.segments) - c.maxSegments; n > 0 {
+		for _, old := range c.segments[:n] {
+			if old.discontinuity {
+				c.discontinuities++
+			}
+		}
+		c.segments = c.segments[n:]
+		c.seq += n
+	}
+	c.discontinuity = false
+}
+
+// addSegment adds a segment of cues made elsewhere, such as by a transcriber
+func (c *captionTrack) addSegment(duration time.Duration, cues []captions.Cue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.appendSegment(vttSegment{duration: duration, cues: cues, discontinuity: c.discontinuity})
+}
+
+// restart follows the video to a new publisher, whose clock and captions
+// start afresh
+func (c *captionTrack) restart(timestamps *timestampMap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started && c.last > c.segStart {
+		c.cut(c.last)
+	}
+	c.ex = captions.Extractor{Found: c.ex.Found}
+	c.started = false
+	c.discontinuity = true
+	c.timestamps = timestamps
+}
+
+// found returns true once the track has any segments
+func (c *captionTrack) found() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.segments) != 0
+}
+
+// serve writes the subtitle playlist or one of its segments
+func (c *captionTrack) serve(rw http.ResponseWriter, filename string) error {
+	if !c.found() {
+		return ErrNoChannel
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if filename == c.name+".m3u8" {
+		var b strings.Builder
+		fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", int(captionSegmentLength/time.Second)+1, c.seq, c.discontinuities)
+		for i, seg := range c.segments {
+			if seg.discontinuity {
+				b.WriteString("#EXT-X-DISCONTINUITY\n")
+			}
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s-%d.vtt\n", seg.duration.Seconds(), c.name, c.seq+i)
+		}
+		rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		rw.Header().Set("Cache-Control", "no-cache")
+		_, err := rw.Write([]byte(b.String()))
+		return err
+	}
+	seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filename, c.name+"-"), ".vtt"))
+	if err != nil || seq < c.seq || seq >= c.seq+len(c.segments) {
+		return ErrNoChannel
+	}
+	seg := c.segments[seq-c.seq]
+	mpegts, local, mapped := seg.timestamps.get()
+	rw.Header().Set("Content-Type", "text/vtt")
+	if mapped {
+		rw.Header().Set("Cache-Control", "max-age=3600")
+	} else {
+		rw.Header().Set("Cache-Control", "no-cache")
+	}
+	return captions.WriteWebVTT(rw, seg.cues, mpegts, local)
+}
+
+// transcriptTrack returns the track for a new publisher's transcript,
+// carrying on from the previous publisher's if there was one
+func (ch *channel) transcriptTrack() *captionTrack {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.transcript == nil {
+		ch.transcript = newCaptionTrack(TranscriptPlaylist, ch.hls.BufferLength, ch.timestamps)
+	} else {
+		ch.transcript.restart(ch.timestamps)
+	}
+	return ch.transcript
+}
+
+// subtitleTrack returns the caption track a file belongs to, if it is one of
+// the subtitle playlists or their segments
+func (ch *channel) subtitleTrack(filename string) (track *captionTrack, ok bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for _, t := range []struct {
+		playlist string
+		track    *captionTrack
+	}{
+		{SubtitlePlaylist, ch.captions},
+		{TranscriptPlaylist, ch.transcript},
+	} {
+		name := strings.TrimSuffix(t.playlist, ".m3u8")
+		if filename == t.playlist || strings.HasPrefix(filename, name+"-") && strings.HasSuffix(filename, ".vtt") {
+			return t.track, true
+		}
+	}
+	return nil, false
+}
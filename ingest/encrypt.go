@@ -0,0 +1,225 @@
+package ingest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultKeyRotation is how long each HLS segment key is used for
+const defaultKeyRotation = time.Minute
+
+// segmentKeys encrypts a channel's HLS segments with AES-128, changing keys
+// every so many segments. Each key is derived from a secret that lives as
+// long as the HLS publisher, so keys are the same for every viewer without
+// having to be stored.
+type segmentKeys struct {
+	secret   [32]byte
+	rotation time.Duration
+
+	mu sync.Mutex
+	// perKey is how many segments share a key, fixed by the first playlist's
+	// target duration
+	perKey int64
+	// seqs maps the file names of segments and their parts to their media
+	// sequence number, which is also the IV
+	seqs  map[string]int64
+	order []string
+	// plain are the init sections, which go out as they are
+	plain map[string]bool
+}
+
+func newSegmentKeys(rotation time.Duration) *segmentKeys {
+	if rotation <= 0 {
+		rotation = defaultKeyRotation
+	}
+	k := &segmentKeys{rotation: rotation, seqs: make(map[string]int64), plain: make(map[string]bool)}
+	if _, err := io.ReadFull(rand.Reader, k.secret[:]); err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func (k *segmentKeys) key(epoch int64) []byte {
+	mac := hmac.New(sha256.New, k.secret[:])
+	mac.Write([]byte(strconv.FormatInt(epoch, 10)))
+	return mac.Sum(nil)[:16]
+}
+
+// tag adds EXT-X-KEY to a media playlist wherever the key changes, and learns
+// which key each segment is encrypted with. Init sections are not encrypted,
+// so each EXT-X-MAP after a key is taken out from under it.
+func (k *segmentKeys) tag(p *mediaPlaylist) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.perKey == 0 {
+		k.perKey = 1
+		if target := p.targetDuration; target > 0 && k.rotation > target {
+			k.perKey = int64(k.rotation / target)
+		}
+	}
+	for _, i := range p.maps {
+		k.plain[resourceName(playlistAttr(p.lines[i], "URI"))] = true
+		// the last segment to start before the map has the key in force
+		for j := len(p.segments) - 1; j >= 0; j-- {
+			if seg := p.segments[j]; seg.start < i {
+				p.insert(i, "#EXT-X-KEY:METHOD=NONE")
+				p.insert(i+1, keyTag(seg.seq/k.perKey))
+				break
+			}
+		}
+	}
+	epoch := int64(-1)
+	for _, seg := range p.segments {
+		k.remember(resourceName(seg.uri), seg.seq)
+		for _, part := range seg.parts {
+			k.remember(resourceName(part), seg.seq)
+		}
+		if e := seg.seq / k.perKey; e != epoch {
+			epoch = e
+			p.insert(seg.start, keyTag(epoch))
+		}
+	}
+}
+
+func keyTag(epoch int64) string {
+	return `#EXT-X-KEY:METHOD=AES-128,URI="` + strconv.FormatInt(epoch, 10) + `.key"`
+}
+
+// remember records a segment's sequence number, forgetting the oldest once
+// there are more than a few playlists hold
+func (k *segmentKeys) remember(name string, seq int64) {
+	if name == "." || name == "" {
+		return
+	}
+	if _, ok := k.seqs[name]; ok {
+		return
+	}
+	k.seqs[name] = seq
+	k.order = append(k.order, name)
+	if len(k.order) > 4*metadataSegments {
+		delete(k.seqs, k.order[0])
+		k.order = k.order[1:]
+	}
+}
+
+// serveKey answers a request for one of the keys a playlist refers to
+func (k *segmentKeys) serveKey(rw http.ResponseWriter, req *http.Request, filename string) {
+	epoch, err := strconv.ParseInt(strings.TrimSuffix(filename, ".key"), 10, 64)
+	if err != nil || epoch < 0 {
+		http.NotFound(rw, req)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write(k.key(epoch))
+}
+
+// writer wraps a response for a segment so it goes out encrypted. It returns
+// rw as it is for init sections, and false for files no playlist has named.
+func (k *segmentKeys) writer(rw http.ResponseWriter, filename string) (http.ResponseWriter, *cbcWriter, bool) {
+	k.mu.Lock()
+	seq, ok := k.seqs[filename]
+	perKey, plain := k.perKey, k.plain[filename]
+	k.mu.Unlock()
+	if plain {
+		return rw, nil, true
+	} else if !ok {
+		return nil, nil, false
+	}
+	block, _ := aes.NewCipher(k.key(seq / perKey))
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(seq))
+	cw := &cbcWriter{ResponseWriter: rw, mode: cipher.NewCBCEncrypter(block, iv)}
+	return cw, cw, true
+}
+
+// cbcWriter encrypts a response with AES-128-CBC as it streams, holding back
+// any partial block until the next write. finish pads the last block.
+type cbcWriter struct {
+	http.ResponseWriter
+	mode        cipher.BlockMode
+	pending     []byte
+	wroteHeader bool
+	plain       bool
+}
+
+func (w *cbcWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	if code != http.StatusOK {
+		// errors go out as they are
+		w.plain = true
+	} else if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(n+aes.BlockSize-n%aes.BlockSize, 10))
+	}
+	w.Header().Del("Accept-Ranges")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cbcWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.plain {
+		return w.ResponseWriter.Write(p)
+	}
+	w.pending = append(w.pending, p...)
+	whole := len(w.pending) - len(w.pending)%aes.BlockSize
+	if whole == 0 {
+		return len(p), nil
+	}
+	w.mode.CryptBlocks(w.pending[:whole], w.pending[:whole])
+	if _, err := w.ResponseWriter.Write(w.pending[:whole]); err != nil {
+		return 0, err
+	}
+	w.pending = append(w.pending[:0], w.pending[whole:]...)
+	return len(p), nil
+}
+
+func (w *cbcWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish writes the last block with PKCS#7 padding
+func (w *cbcWriter) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.plain {
+		return nil
+	}
+	pad := aes.BlockSize - len(w.pending)
+	for i := 0; i < pad; i++ {
+		w.pending = append(w.pending, byte(pad))
+	}
+	w.mode.CryptBlocks(w.pending, w.pending)
+	_, err := w.ResponseWriter.Write(w.pending)
+	return err
+}
+
+func (ch *channel) segmentKeys() *segmentKeys {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.keys
+}
+
+// encryptHLS starts encrypting the channel's HLS segments, if they aren't
+// already
+func (ch *channel) encryptHLS(rotation time.Duration) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.keys == nil && ch.hls != nil {
+		ch.keys = newSegmentKeys(rotation)
+	}
+}
@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"io"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/av/pubsub"
+)
+
+// gap inserted between the last packet of one publish and the first of the
+// next, roughly a frame
+const followGap = 40 * time.Millisecond
+
+// follower reads from a channel's ingest queue and carries on with the next
+// queue if the publisher reconnects within the grace period. Timestamps are
+// rebased so they keep increasing across the switch.
+type follower struct {
+	ch   *channel
+	opus bool
+	q    *pubsub.Queue
+	cur  av.Demuxer
+
+	offset, last time.Duration
+	rebase       bool
+}
+
+// playSource returns a demuxer for viewers of a channel, or nil if it is
+// offline. Viewers start delay GOPs behind live if that much is buffered.
+func (m *Manager) playSource(ch *channel, opus bool, delay int) av.Demuxer {
+	if ch == nil {
+		return nil
+	}
+	q := ch.source(opus)
+	if q == nil {
+		return nil
+	}
+	cur := q.Latest()
+	if delay > 1 {
+		cur = q.DelayedGopCount(delay)
+	}
+	if m.ReconnectGrace <= 0 {
+		return cur
+	}
+	return &follower{ch: ch, opus: opus, q: q, cur: cur}
+}
+
+// Streams returns the codecs of the first publish. A publisher that reconnects
+// is assumed to use the same encoder settings.
+func (f *follower) Streams() ([]av.CodecData, error) {
+	return f.cur.Streams()
+}
+
+func (f *follower) ReadPacket() (av.Packet, error) {
+	for {
+		pkt, err := f.cur.ReadPacket()
+		if err == io.EOF {
+			if !f.next() {
+				return pkt, io.EOF
+			}
+			continue
+		} else if err != nil {
+			return pkt, err
+		}
+		if f.rebase {
+			f.offset = f.last + followGap - pkt.Time
+			f.rebase = false
+		}
+		pkt.Time += f.offset
+		f.last = pkt.Time
+		return pkt, nil
+	}
+}
+
+// next waits for the channel to switch to a new queue, returning false if it
+// goes offline instead
+func (f *follower) next() bool {
+	for {
+		changed := f.ch.changes()
+		q := f.ch.source(f.opus)
+		if q == nil {
+			return false
+		} else if q != f.q {
+			f.q = q
+			f.cur = q.Latest()
+			f.rebase = true
+			return true
+		}
+		<-changed
+	}
+}
@@ -0,0 +1,126 @@
+package ingest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/format/ts"
+)
+
+// ErrNoFormat is returned for extensions no format is registered for
+var ErrNoFormat = errors.New("unknown stream format")
+
+// Format is a container that viewers can stream a channel in over a single
+// HTTP response, such as MPEG-TS or bare AAC. Registering one serves it at
+// /live/{channel}.{ext} with viewer counts and usage handled the same as the
+// others, without touching the channel pipeline.
+//
+// HLS is not a Format. It has one segmenter per channel, fed by the channel
+// pipeline and kept across reconnects, and viewers fetch its playlists and
+// segments over many requests instead of holding a muxer of their own. WebRTC
+// negotiates its own sessions for the same reason.
+type Format struct {
+	// Kind names the format in viewer counts and usage
+	Kind        string
+	ContentType string
+	// Header, if set, adds response headers
+	Header func(h http.Header, channel string)
+	// Prebuffer starts viewers the configured number of GOPs behind live
+	Prebuffer bool
+	// Opus asks for Opus audio rather than AAC
+	Opus bool
+	// Flush sends each packet as soon as it is written, for low bitrate
+	// formats that would otherwise sit in the response buffer
+	Flush bool
+	// NewMuxer sets up the container for a channel's streams and writes its
+	// header. An error means the channel can't be played in this format.
+	NewMuxer func(w io.Writer, streams []av.CodecData) (av.Muxer, error)
+}
+
+var formats = make(map[string]*Format)
+
+// RegisterFormat makes a format available under a file extension. It is meant
+// to be called from init functions.
+func RegisterFormat(ext string, f *Format) {
+	if _, ok := formats[ext]; ok {
+		panic("ingest: format registered twice: " + ext)
+	}
+	formats[ext] = f
+}
+
+// Formats lists the extensions of the registered formats
+func Formats() []string {
+	exts := make([]string, 0, len(formats))
+	for ext := range formats {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+func init() {
+	RegisterFormat("ts", &Format{
+		Kind:        "ts",
+		ContentType: "video/MP2T",
+		Header: func(h http.Header, channel string) {
+			h.Set("Transfer-Encoding", "chunked")
+		},
+		Prebuffer: true,
+		NewMuxer: func(w io.Writer, streams []av.CodecData) (av.Muxer, error) {
+			muxer := ts.NewMuxer(w)
+			return muxer, muxer.WriteHeader(streams)
+		},
+	})
+}
+
+// ServeStream streams a channel to one viewer at addr in the format registered
+// for ext
+func (m *Manager) ServeStream(rw http.ResponseWriter, req *http.Request, name, ext, addr string) error {
+	f := formats[ext]
+	if f == nil {
+		return ErrNoFormat
+	}
+	ch := m.channel(name)
+	delay := 0
+	if f.Prebuffer {
+		delay = m.TSPrebuffer
+	}
+	src := m.playSource(ch, f.Opus, delay)
+	if src == nil {
+		return ErrNoChannel
+	}
+	streams, err := src.Streams()
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", f.ContentType)
+	if f.Header != nil {
+		f.Header(rw.Header(), name)
+	}
+	var w io.Writer = meteredWriter{rw, m.usageFunc(name, f.Kind)}
+	if f.Flush {
+		w = flushWriter{meteredWriter{rw, m.usageFunc(name, f.Kind)}}
+	}
+	muxer, err := f.NewMuxer(w, streams)
+	if err != nil {
+		return err
+	}
+	key := connectionKey()
+	ch.viewers.heartbeat(key, viewerID(req, addr), addr, f.Kind)
+	defer ch.viewers.leave(key)
+	return copyStream(req.Context(), muxer, src)
+}
+
+// flushWriter sends each write to the viewer straight away
+type flushWriter struct {
+	meteredWriter
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.meteredWriter.Write(p)
+	w.Flush()
+	return n, err
+}
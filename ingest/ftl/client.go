@@ -0,0 +1,128 @@
+package ftl
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// payload types announced by Client
+const (
+	ClientVideoPT = 96
+	ClientAudioPT = 97
+)
+
+// Client is the control connection of an FTL publisher. It only negotiates
+// the session; media is sent separately over UDP to MediaAddr.
+type Client struct {
+	MediaAddr *net.UDPAddr
+
+	conn      net.Conn
+	tpc       *textproto.Conn
+	channelID string
+}
+
+// Dial authenticates to an FTL server and announces an H.264 video and Opus
+// audio stream with the given SSRCs
+func Dial(addr, channelID, key string, vssrc, assrc uint32) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:      conn,
+		tpc:       textproto.NewConn(conn),
+		channelID: channelID,
+	}
+	if err := c.handshake(key, vssrc, assrc); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) handshake(key string, vssrc, assrc uint32) error {
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	reply, err := c.cmd("HMAC")
+	if err != nil {
+		return err
+	}
+	nonce, err := hex.DecodeString(reply)
+	if err != nil {
+		return fmt.Errorf("parsing HMAC nonce: %w", err)
+	}
+	hm := hmac.New(sha512.New, []byte(key))
+	hm.Write(nonce)
+	if _, err := c.cmd(fmt.Sprintf("CONNECT %s $%x", c.channelID, hm.Sum(nil))); err != nil {
+		return err
+	}
+	for _, line := range []string{
+		"ProtocolVersion: 0.9",
+		"VendorName: gunk",
+		"VendorVersion: 0",
+		"Video: true",
+		"VideoCodec: H264",
+		"VideoPayloadType: " + strconv.Itoa(ClientVideoPT),
+		"VideoIngestSSRC: " + strconv.FormatUint(uint64(vssrc), 10),
+		"Audio: true",
+		"AudioCodec: OPUS",
+		"AudioPayloadType: " + strconv.Itoa(ClientAudioPT),
+		"AudioIngestSSRC: " + strconv.FormatUint(uint64(assrc), 10),
+	} {
+		if err := c.tpc.PrintfLine("%s", line); err != nil {
+			return err
+		}
+	}
+	reply, err = c.cmd(".")
+	if err != nil {
+		return err
+	}
+	// 200 OK. Use UDP port N
+	words := strings.Fields(reply)
+	port, err := strconv.Atoi(words[len(words)-1])
+	if err != nil {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	c.MediaAddr, err = net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	return err
+}
+
+// cmd sends a command and returns the text of a 2xx reply
+func (c *Client) cmd(line string) (string, error) {
+	if err := c.tpc.PrintfLine("%s", line); err != nil {
+		return "", err
+	}
+	reply, err := c.tpc.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	code, text, _ := strings.Cut(reply, " ")
+	if !strings.HasPrefix(code, "2") {
+		return "", fmt.Errorf("%s: server replied %q", strings.Fields(line)[0], reply)
+	}
+	return text, nil
+}
+
+// Ping keeps the session alive. The server drops publishers that are silent
+// on the control connection for too long.
+func (c *Client) Ping() error {
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	_, err := c.cmd("PING " + c.channelID)
+	return err
+}
+
+// Close ends the session cleanly
+func (c *Client) Close() error {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c.cmd("DISCONNECT")
+	return c.conn.Close()
+}
@@ -3,7 +3,8 @@ package ftl
 import (
 	"bytes"
 	"errors"
-	"log"
+	"log/slog"
+	"sync/atomic"
 
 	"eaglesong.dev/gunk/h264util"
 	"eaglesong.dev/gunk/internal"
@@ -21,6 +22,9 @@ type Deframer struct {
 	baseTS  uint64
 	lastTS  uint32
 	lastSeq uint16
+	started bool
+	// lost counts packets missing from the sequence
+	lost atomic.Int64
 }
 
 type Parser interface {
@@ -30,10 +34,14 @@ type Parser interface {
 
 func (f *Deframer) Deframe(rp *rtp.Packet) ([]av.Packet, error) {
 	seqDelta := rp.SequenceNumber - f.lastSeq
-	if seqDelta != 1 {
-		log.Printf("seq delta %d", int16(seqDelta))
+	if f.started && seqDelta != 1 {
+		slog.Debug("RTP sequence gap", "delta", int16(seqDelta))
+		if int16(seqDelta) > 1 {
+			f.lost.Add(int64(seqDelta) - 1)
+		}
 	}
 	f.lastSeq = rp.SequenceNumber
+	f.started = true
 
 	if rp.Timestamp < f.lastTS && rp.Timestamp < (1<<31) {
 		f.baseTS += (1 << 32)
@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/textproto"
 	"runtime"
@@ -19,16 +19,32 @@ import (
 type Server struct {
 	CheckUser CheckUserFunc
 	Publish   PublishFunc
-	Listener  net.Listener
-	RTPSocket net.PacketConn
-
+	// CheckAddr, if set, drops connections from addresses that may not publish
+	// before anything is read from them
+	CheckAddr func(net.IP) bool
+	// AuthFailed, if set, is told about publishers whose credentials were
+	// rejected
+	AuthFailed func(err error, remote string)
+	Listener   net.Listener
+	RTPSocket  net.PacketConn
+
+	// MediaAddr is the UDP address shared by all publishers for media,
+	// defaulting to the control address
+	MediaAddr string
+	// RTPAdvertisePort is the media port sent to publishers when the shared
+	// port is behind a NAT that maps it to a different number
 	RTPAdvertisePort int
+	// MediaPortMin and MediaPortMax, if set, give each publisher its own UDP
+	// port from this range instead of sharing MediaAddr. The range must be
+	// forwarded to the same port numbers.
+	MediaPortMin, MediaPortMax int
 
 	mu        sync.Mutex
 	receivers map[string]chan<- []byte
+	nextPort  int
 }
 
-type CheckUserFunc func(channelID string, nonce, hmacProvided []byte) (auth model.ChannelAuth, err error)
+type CheckUserFunc func(ctx context.Context, channelID string, nonce, hmacProvided []byte) (auth model.ChannelAuth, err error)
 type PublishFunc func(auth model.ChannelAuth, kind, remoteAddr string, src av.Demuxer) error
 
 func (s *Server) Listen(addr string) (err error) {
@@ -39,22 +55,45 @@ func (s *Server) Listen(addr string) (err error) {
 	if err != nil {
 		return err
 	}
-	s.RTPSocket, err = net.ListenPacket("udp", addr)
+	if s.MediaPortMin != 0 {
+		if s.MediaPortMin < 0 || s.MediaPortMax < s.MediaPortMin || s.MediaPortMax > 65535 {
+			return fmt.Errorf("invalid media port range %d-%d", s.MediaPortMin, s.MediaPortMax)
+		}
+		return nil
+	}
+	mediaAddr := s.MediaAddr
+	if mediaAddr == "" {
+		mediaAddr = addr
+	}
+	s.RTPSocket, err = net.ListenPacket("udp", mediaAddr)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Name is the kind FTL publishers publish as
+func (s *Server) Name() string { return "ftl" }
+
+// Codecs lists what FTL carries
+func (s *Server) Codecs() []string { return []string{"h264", "opus"} }
+
 func (s *Server) Serve() error {
-	go s.serveRTP()
+	if s.RTPSocket != nil {
+		go s.serveRTP(s.RTPSocket)
+	}
 	for {
 		conn, err := s.Listener.Accept()
 		if err != nil {
-			log.Println("error: accepting FTL connection:", err)
+			slog.Error("accepting connection", "proto", "ftl", "err", err)
 			time.Sleep(time.Second)
 			continue
 		}
+		if s.CheckAddr != nil && !s.CheckAddr(conn.RemoteAddr().(*net.TCPAddr).IP) {
+			slog.Info("rejected publisher address", "proto", "ftl", "remote_addr", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
 		c := &Conn{
 			s:    s,
 			conn: conn,
@@ -65,12 +104,12 @@ func (s *Server) Serve() error {
 					const size = 64 << 10
 					buf := make([]byte, size)
 					buf = buf[:runtime.Stack(buf, false)]
-					log.Printf("error: panic in handler for FTL connection %s: %s\n%s\n", conn.RemoteAddr(), r, string(buf))
+					slog.Error("panic in connection handler", "proto", "ftl", "remote_addr", conn.RemoteAddr().String(), "panic", r, "stack", string(buf))
 				}
 				conn.Close()
 			}()
 			if err := c.serve(); err != nil {
-				log.Printf("error: handling FTL connection from %s: %s", conn.RemoteAddr(), err)
+				slog.Error("connection failed", "proto", "ftl", "remote_addr", conn.RemoteAddr().String(), "channel", c.auth.Name, "err", err)
 			}
 		}()
 	}
@@ -136,7 +175,7 @@ func (c *Conn) serve() error {
 		case "CONNECT":
 			err = c.handleConnect(words)
 		case "DISCONNECT":
-			log.Printf("[ftl] %s disconnected cleanly", c.conn.RemoteAddr())
+			slog.Info("publisher disconnected", "proto", "ftl", "remote_addr", c.conn.RemoteAddr().String(), "channel", c.auth.Name)
 			c.sendOK()
 			return nil
 		case "PING":
@@ -7,7 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
@@ -46,8 +46,11 @@ func (c *Conn) handleConnect(words []string) error {
 	if err != nil {
 		return fmt.Errorf("parsing CONNECT: %s", err)
 	}
-	c.auth, err = c.s.CheckUser(channelID, c.nonce, digest)
+	c.auth, err = c.s.CheckUser(c.ctx, channelID, c.nonce, digest)
 	if err != nil {
+		if c.s.AuthFailed != nil {
+			c.s.AuthFailed(err, c.conn.RemoteAddr().(*net.TCPAddr).IP.String())
+		}
 		return err
 	}
 	c.state = stateConfig
@@ -166,21 +169,36 @@ func (c *Conn) handleLive() error {
 		deframers:  []*Deframer{vdeframer /*, adeframer FIXME*/},
 	}
 	_ = adeframer
+	var listenPort int
+	var sock net.PacketConn
+	if c.s.RTPSocket == nil {
+		var err error
+		sock, err = c.s.listenMediaPort()
+		if err != nil {
+			return err
+		}
+		listenPort = sock.LocalAddr().(*net.UDPAddr).Port
+		go c.s.serveRTP(sock)
+	} else {
+		listenPort = c.s.RTPAdvertisePort
+		if listenPort == 0 {
+			listenPort = c.s.RTPSocket.LocalAddr().(*net.UDPAddr).Port
+		}
+	}
 	hashKeys := c.hashKeys(ip)
 	c.s.addReceiver(hashKeys, rch)
 	remote := ip.String()
 	go func() {
 		defer c.s.delReceiver(hashKeys, rch)
+		if sock != nil {
+			defer sock.Close()
+		}
 		if err := c.s.Publish(c.auth, "ftl", remote, pktSrc); err != nil {
-			log.Printf("[ftl] error: publishing from %s: %s", remote, err)
+			slog.Error("publish failed", "proto", "ftl", "channel", c.auth.Name, "remote_addr", remote, "err", err)
 			c.cancel()
 		}
 	}()
 
-	listenPort := c.s.RTPAdvertisePort
-	if listenPort == 0 {
-		listenPort = c.s.RTPSocket.LocalAddr().(*net.UDPAddr).Port
-	}
 	_, err := fmt.Fprintf(c.tpc.W, "200 OK. Use UDP port %d\n", listenPort)
 	return err
 }
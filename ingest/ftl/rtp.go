@@ -4,8 +4,9 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/kr/pretty"
@@ -13,12 +14,16 @@ import (
 	"github.com/pion/rtp"
 )
 
-func (s *Server) serveRTP() {
+// serveRTP reads media from a UDP socket and dispatches it to publishers by
+// source IP and SSRC until the socket is closed
+func (s *Server) serveRTP(sock net.PacketConn) {
 	for {
 		d := make([]byte, 1500)
-		n, addr, err := s.RTPSocket.ReadFrom(d)
-		if err != nil {
-			log.Println("error: receiving from UDP socket:", err)
+		n, addr, err := sock.ReadFrom(d)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			slog.Error("receiving from UDP socket", "proto", "ftl", "err", err)
 			time.Sleep(time.Second)
 			continue
 		}
@@ -38,7 +43,7 @@ func (s *Server) serveRTP() {
 			ok := s.receivers[key] != nil
 			s.mu.Unlock()
 			if ok {
-				s.RTPSocket.WriteTo(d, addr)
+				sock.WriteTo(d, addr)
 			}
 			continue
 		} else if d[1] == 0xc8 {
@@ -59,11 +64,32 @@ func (s *Server) serveRTP() {
 		select {
 		case rcv <- d:
 		default:
-			log.Printf("[ftl] %s overflow in UDP handler", addr)
+			slog.Warn("UDP handler overflow", "proto", "ftl", "remote_addr", addr.String())
 		}
 	}
 }
 
+// listenMediaPort binds the next free UDP port in the media port range
+func (s *Server) listenMediaPort() (net.PacketConn, error) {
+	host := ""
+	if addr, ok := s.Listener.Addr().(*net.TCPAddr); ok && !addr.IP.IsUnspecified() {
+		host = addr.IP.String()
+	}
+	count := s.MediaPortMax - s.MediaPortMin + 1
+	s.mu.Lock()
+	start := s.nextPort
+	s.nextPort = (s.nextPort + 1) % count
+	s.mu.Unlock()
+	for i := 0; i < count; i++ {
+		port := s.MediaPortMin + (start+i)%count
+		sock, err := net.ListenPacket("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err == nil {
+			return sock, nil
+		}
+	}
+	return nil, errors.New("no free ports in media port range")
+}
+
 func (s *Server) addReceiver(keys []string, rch chan<- []byte) {
 	s.mu.Lock()
 	if s.receivers == nil {
@@ -143,7 +169,7 @@ func (r *rtpReader) Streams() ([]av.CodecData, error) {
 	if r.streams != nil {
 		return r.streams, nil
 	}
-	log.Printf("getting streams")
+	slog.Debug("waiting for stream parameters", "proto", "ftl")
 	ctx, cancel := context.WithTimeout(r.ctx, 10*time.Second)
 	defer cancel()
 	streams := make([]av.CodecData, len(r.deframers))
@@ -176,6 +202,15 @@ func (r *rtpReader) Streams() ([]av.CodecData, error) {
 	return nil, errors.New("timed out waiting for codec data")
 }
 
+// Dropped counts the RTP packets lost on the way from the publisher
+func (r *rtpReader) Dropped() int64 {
+	var n int64
+	for _, def := range r.deframers {
+		n += def.lost.Load()
+	}
+	return n
+}
+
 func (r *rtpReader) ReadPacket() (av.Packet, error) {
 	if r.streams == nil {
 		_, err := r.Streams()
@@ -1,9 +1,11 @@
 package irtmp
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net"
 	"net/url"
+	"path"
 
 	"eaglesong.dev/gunk/model"
 	"github.com/nareix/joy4/av"
@@ -15,29 +17,59 @@ type Server struct {
 	rtmp.Server
 	CheckUser CheckUserFunc
 	Publish   PublishFunc
+	// CheckAddr, if set, rejects publishers from addresses that may not
+	// publish before their stream key is checked
+	CheckAddr func(net.IP) bool
+	// AuthFailed, if set, is told about publishers whose credentials were
+	// rejected
+	AuthFailed func(err error, remote string)
 }
 
-type CheckUserFunc func(*url.URL) (model.ChannelAuth, error)
+type CheckUserFunc func(context.Context, *url.URL) (model.ChannelAuth, error)
 type PublishFunc func(auth model.ChannelAuth, kind, remoteAddr string, src av.Demuxer) error
 
-func (s *Server) ListenAndServe() error {
+// Name is the kind RTMP publishers publish as
+func (s *Server) Name() string { return "rtmp" }
+
+// Codecs lists what joy4's RTMP demuxer understands. HEVC and AV1 need
+// enhanced RTMP.
+func (s *Server) Codecs() []string { return []string{"h264", "aac"} }
+
+// Serve listens on the configured address and accepts publishers
+func (s *Server) Serve() error {
 	s.HandlePublish = s.handlePublish
 	return s.Server.ListenAndServe()
 }
 
 func (s *Server) handlePublish(conn *rtmp.Conn) {
 	defer conn.Close()
-	remote := conn.NetConn().RemoteAddr().(*net.TCPAddr).IP.String()
+	ip := conn.NetConn().RemoteAddr().(*net.TCPAddr).IP
+	remote := ip.String()
+	if s.CheckAddr != nil && !s.CheckAddr(ip) {
+		slog.Info("rejected publisher address", "proto", "rtmp", "remote_addr", remote)
+		return
+	}
 	fm := &pktque.FilterDemuxer{
 		Demuxer: conn,
 		Filter:  &pktque.FixTime{MakeIncrement: true},
 	}
-	auth, err := s.CheckUser(conn.URL)
+	auth, err := s.CheckUser(context.Background(), conn.URL)
 	if err != nil {
-		log.Printf("[rtmp] error: %s from %s: %s", conn.URL, remote, err)
+		slog.Warn("publisher rejected", "proto", "rtmp", "path", redactURL(conn.URL), "remote_addr", remote, "err", err)
+		if s.AuthFailed != nil {
+			s.AuthFailed(err, remote)
+		}
 		return
 	}
 	if err := s.Publish(auth, "rtmp", remote, fm); err != nil {
-		log.Printf("[rtmp] error: %s from %s: %s", conn.URL, remote, err)
+		slog.Error("publish failed", "proto", "rtmp", "channel", auth.Name, "remote_addr", remote, "err", err)
+	}
+}
+
+// redactURL hides the stream key from a publish URL for logging
+func redactURL(u *url.URL) string {
+	if u.Query().Has("key") {
+		return u.Path
 	}
+	return path.Dir(u.Path) + "/<key>"
 }
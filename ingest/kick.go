@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// kickHold is how long a channel refuses publishers after one is kicked, so
+// that an encoder with a leaked key can't reconnect before the key is rotated
+const kickHold = 30 * time.Second
+
+var (
+	errKicked        = errors.New("channel was disconnected by its owner")
+	ErrNotPublishing = errors.New("channel has no live publisher")
+)
+
+// Kick disconnects a channel's live publisher and takes the channel offline
+// straight away, skipping the reconnect grace period and any failover
+// playlist. Playlists aren't affected.
+func (m *Manager) Kick(name string) error {
+	ch := m.channel(name)
+	if ch == nil {
+		return ErrNotPublishing
+	}
+	return ch.kick(time.Now().Add(kickHold))
+}
+
+func (ch *channel) kick(until time.Time) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.ingest == nil || ch.playlist != "" {
+		return ErrNotPublishing
+	}
+	// the publisher notices on its next packet
+	atomic.StoreInt64(&ch.kickedUntil, until.UnixNano())
+	if ch.pendingStop != nil {
+		// the publisher already dropped and the channel is waiting for it to
+		// come back
+		ch.pendingStop.Reset(0)
+	}
+	return nil
+}
+
+func (ch *channel) isKicked() bool {
+	return ch != nil && time.Now().UnixNano() < atomic.LoadInt64(&ch.kickedUntil)
+}
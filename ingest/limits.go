@@ -0,0 +1,118 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+// bitrateWindow is how much media the bitrate is averaged over
+const bitrateWindow = 10 * time.Second
+
+// SupportedCodecs are the codecs that every output can handle, given that
+// AAC is converted for WebRTC
+var SupportedCodecs = []string{"h264", "aac", "opus"}
+
+// PublishLimits refuses live publishers whose streams the outputs can't
+// handle or that would swamp viewers. Zero values are unlimited.
+type PublishLimits struct {
+	// Codecs publishers may use, from SupportedCodecs. Empty allows all of
+	// them.
+	Codecs []string
+	// MaxBitrate is in bits per second, averaged over 10 seconds
+	MaxBitrate          int
+	MaxKeyframeInterval time.Duration
+	MaxWidth, MaxHeight int
+}
+
+// checkStreams returns an error if a publisher's video size isn't allowed.
+// Codecs are checked against the protocol by negotiateCodecs.
+func (l *PublishLimits) checkStreams(streams []av.CodecData) error {
+	for _, stream := range streams {
+		if v, ok := stream.(av.VideoCodecData); ok {
+			if l.MaxWidth > 0 && v.Width() > l.MaxWidth {
+				return fmt.Errorf("video width %d is over the limit of %d", v.Width(), l.MaxWidth)
+			}
+			if l.MaxHeight > 0 && v.Height() > l.MaxHeight {
+				return fmt.Errorf("video height %d is over the limit of %d", v.Height(), l.MaxHeight)
+			}
+		}
+	}
+	return nil
+}
+
+// limitChecker ends a publish once its bitrate or keyframe interval go over
+// the limits
+type limitChecker struct {
+	av.Demuxer
+	limits *PublishLimits
+	video  int
+
+	bitrate      bitrateMeter
+	lastKey      time.Duration
+	videoStarted bool
+}
+
+// checkLimits wraps src if any limits apply to it while it plays
+func (l *PublishLimits) checkLimits(src av.Demuxer, streams []av.CodecData) av.Demuxer {
+	if l.MaxBitrate <= 0 && l.MaxKeyframeInterval <= 0 {
+		return src
+	}
+	video := -1
+	for i, stream := range streams {
+		if stream.Type().IsVideo() {
+			video = i
+		}
+	}
+	return &limitChecker{Demuxer: src, limits: l, video: video}
+}
+
+func (c *limitChecker) ReadPacket() (av.Packet, error) {
+	pkt, err := c.Demuxer.ReadPacket()
+	if err != nil {
+		return pkt, err
+	}
+	if max := c.limits.MaxBitrate; max > 0 {
+		if rate := c.bitrate.add(pkt); rate > max {
+			return pkt, fmt.Errorf("bitrate %d kbps is over the limit of %d kbps", rate/1000, max/1000)
+		}
+	}
+	if max := c.limits.MaxKeyframeInterval; max > 0 && int(pkt.Idx) == c.video {
+		if !c.videoStarted || pkt.IsKeyFrame {
+			c.lastKey, c.videoStarted = pkt.Time, true
+		} else if d := pkt.Time - c.lastKey; d > max {
+			return pkt, fmt.Errorf("no keyframe for %s, over the limit of %s", d.Round(time.Millisecond), max)
+		}
+	}
+	return pkt, nil
+}
+
+// bitrateMeter averages a stream's bitrate over windows of media time
+type bitrateMeter struct {
+	// window defaults to bitrateWindow
+	window time.Duration
+	start  time.Duration
+	bytes  int
+	active bool
+}
+
+// add counts a packet, returning the bitrate in bits per second of the window
+// it completes, or 0 if the window isn't complete yet
+func (b *bitrateMeter) add(pkt av.Packet) int {
+	if !b.active {
+		b.start, b.active = pkt.Time, true
+	}
+	b.bytes += len(pkt.Data)
+	window := b.window
+	if window == 0 {
+		window = bitrateWindow
+	}
+	d := pkt.Time - b.start
+	if d < window {
+		return 0
+	}
+	rate := int(int64(b.bytes) * 8 * int64(time.Second) / int64(d))
+	b.start, b.bytes = pkt.Time, 0
+	return rate
+}
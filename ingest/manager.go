@@ -1,6 +1,7 @@
 package ingest
 
 import (
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"eaglesong.dev/gunk/ingest/ftl"
 	"eaglesong.dev/gunk/model"
 	"eaglesong.dev/gunk/sinks/grabber"
+	"eaglesong.dev/gunk/transcode/opus"
 	"eaglesong.dev/hls"
 	"github.com/nareix/joy4/av"
 	"github.com/nareix/joy4/av/pubsub"
@@ -22,25 +24,108 @@ type Manager struct {
 	PublishEvent PublishEvent
 	FTL          ftl.Server
 	WorkDir      string
+	SecretKey    [32]byte // for unsealing restream keys
+	Thumbs       grabber.Options
+	// ReconnectGrace keeps a channel live for this long after its publisher
+	// drops so that viewers survive a quick reconnect
+	ReconnectGrace time.Duration
+	// PlayoutDir holds local files that playlists may refer to
+	PlayoutDir string
+	// PlayoutURLs lets playlists play http and https URLs, which ffmpeg
+	// fetches from the server
+	PlayoutURLs bool
+	// HLSTargetDuration is the segment duration advertised until the first
+	// segment completes, after which segments follow the keyframe interval
+	HLSTargetDuration time.Duration
+	// HLSWindow is roughly how much media the HLS playlist spans
+	HLSWindow time.Duration
+	// HLSEncrypt encrypts HLS segments with AES-128, with keys served next to
+	// the playlists
+	HLSEncrypt bool
+	// HLSKeyRotation is how long each segment key is used for
+	HLSKeyRotation time.Duration
+	// TSPrebuffer is how many GOPs behind live TS viewers start, trading
+	// latency for fewer stalls
+	TSPrebuffer int
+	// FailoverLimit is how long a failover playlist covers for a dropped live
+	// publisher before the channel goes offline
+	FailoverLimit time.Duration
+	// IngestFilter restricts RTMP and FTL publishing to certain addresses
+	// across all channels
+	IngestFilter AddrFilter
+	// Limits refuses live publishers with unsupported codecs or excessive
+	// settings
+	Limits PublishLimits
+	// PublishHook, if set, is asked before each live publisher goes on air
+	PublishHook *PublishHook
+	// Transcriber, if set, captions the speech of channels that have
+	// automatic captions turned on
+	Transcriber Transcriber
+	// SessionEnded, if set, is given the summary of each live session once
+	// the channel goes offline
+	SessionEnded func(auth model.ChannelAuth, sess *model.StreamSession)
+	// LivePublisher, if set, is told the protocol and address of each live
+	// publisher that is allowed to go live
+	LivePublisher func(auth model.ChannelAuth, kind, remote string)
 
-	channels sync.Map
+	channels  sync.Map
+	restreams sync.Map
+	playouts  sync.Map
+	usage     sync.Map
+	bans      atomic.Pointer[banList]
+	protoMu   sync.Mutex
+	protocols []Protocol
 }
 
 func (m *Manager) Initialize() {
 	m.FTL.Publish = m.Publish
+	m.FTL.CheckAddr = m.CheckAddr
+	m.AddProtocol(&m.FTL)
 }
 
 type channel struct {
 	mu        sync.Mutex
 	ingest    *pubsub.Queue
 	aac, opus *pubsub.Queue
-	hls       *hls.Publisher
-	stoppedAt time.Time
+	// opusConv makes the opus queue from AAC audio, if it isn't Opus already
+	opusConv *opusConverter
+	hls      *hls.Publisher
+	// captions carries the video's embedded captions alongside hls, and
+	// transcript those from the speech to text service if the channel uses it
+	captions, transcript *captionTrack
+	// metadata carries timed metadata in the hls segments
+	metadata *metadataTrack
+	// clock gives the hls segments their program date and time
+	clock *segmentClock
+	// keys encrypts the hls segments, if the manager is set to
+	keys *segmentKeys
+	// timestamps ties the current publisher's clock to the hls segments
+	timestamps *timestampMap
+	stoppedAt  time.Time
+	// playlist is the kind of playlist on air, or empty for a live publisher
+	playlist string
+	// pendingStop takes the channel offline when the reconnect grace period
+	// expires
+	pendingStop *time.Timer
+	// changed is closed when the ingest queues are replaced or removed
+	changed chan struct{}
+	// current publish session, which continues across reconnects
+	sessionID int64
+	peak      int
+	totals    model.SessionTotals
+	// kickedUntil refuses live publishers after the owner disconnected one,
+	// in Unix nanoseconds
+	kickedUntil int64
+	// userID owns the channel's live publisher
+	userID string
+	// peakBitrate is the highest bitrate of the current publisher over
+	// roughly a segment, in bits per second
+	peakBitrate int
 
 	live, rtc uintptr
-	viewers   int32 // excluding hls
-	hlsv      sync.Map
-	hlsvTotal int32
+	viewers   viewerSet
+	// trace is set while the operator is capturing the publisher's packets
+	trace atomic.Pointer[packetTrace]
 }
 
 func (m *Manager) channel(name string) *channel {
@@ -51,54 +136,111 @@ func (m *Manager) channel(name string) *channel {
 	return nil
 }
 
-func (ch *channel) queue(opus bool) av.Demuxer {
-	if ch == nil {
-		return nil
-	}
+// source returns the channel's current ingest queue, or nil if the channel is
+// offline
+func (ch *channel) source(opus bool) *pubsub.Queue {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
-	var q *pubsub.Queue
 	if opus {
-		q = ch.opus
-	} else {
-		q = ch.aac
+		if ch.opusConv != nil {
+			ch.opusConv.want()
+		}
+		return ch.opus
 	}
-	if q == nil {
-		return nil
+	return ch.aac
+}
+
+// notify wakes anyone waiting for the ingest queues to change. Must be called
+// with ch.mu held.
+func (ch *channel) notify() {
+	if ch.changed != nil {
+		close(ch.changed)
+		ch.changed = nil
 	}
-	return q.Latest()
 }
 
-func (ch *channel) isLive() bool {
-	if ch == nil {
-		return false
+// changes returns a channel that is closed the next time the ingest queues
+// are replaced or removed
+func (ch *channel) changes() <-chan struct{} {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.changed == nil {
+		ch.changed = make(chan struct{})
 	}
-	return atomic.LoadUintptr(&ch.live) != 0
+	return ch.changed
 }
 
-func (ch *channel) addViewer(delta int32) {
-	if ch == nil {
-		return
+func (ch *channel) startSession(sessionID int64) {
+	ch.mu.Lock()
+	ch.sessionID = sessionID
+	ch.peak = 0
+	ch.totals = model.SessionTotals{}
+	ch.mu.Unlock()
+	ch.viewers.expire(hlsViewTimeout)
+	ch.viewers.resetUnique()
+}
+
+func (ch *channel) session() (sessionID int64, peak, unique int) {
+	_, unique = ch.viewers.counts()
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.sessionID, ch.peak, unique
+}
+
+// sessionTotals returns what the session has measured besides viewers
+func (ch *channel) sessionTotals() model.SessionTotals {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.totals
+}
+
+// addDropped counts packets a publisher lost towards the session
+func (ch *channel) addDropped(n int64) {
+	ch.mu.Lock()
+	ch.totals.DroppedPackets += n
+	ch.mu.Unlock()
+}
+
+// updatePeak records the current viewer count if it is a new high for the
+// session
+func (ch *channel) updatePeak() (sessionID int64, peak, unique int) {
+	v := ch.currentViewers()
+	_, unique = ch.viewers.counts()
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if v > ch.peak {
+		ch.peak = v
 	}
-	atomic.AddInt32(&ch.viewers, delta)
+	return ch.sessionID, ch.peak, unique
 }
 
-func (ch *channel) hlsViewed(host string) {
-	ch.hlsv.Store(host, time.Now())
+func (ch *channel) setPublisher(userID string) {
+	ch.mu.Lock()
+	ch.userID = userID
+	ch.mu.Unlock()
 }
 
-func (ch *channel) countHLSViewers() {
-	var views int32
-	ch.hlsv.Range(func(key, value interface{}) bool {
-		t := value.(time.Time)
-		if time.Since(t) > hlsViewTimeout {
-			ch.hlsv.Delete(key)
-		} else {
-			views++
-		}
-		return true
-	})
-	atomic.StoreInt32(&ch.hlsvTotal, views)
+// publisher returns the owner of the channel's live publisher, if it has one
+func (ch *channel) publisher() string {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.ingest == nil || ch.playlist != "" {
+		return ""
+	}
+	return ch.userID
+}
+
+func (ch *channel) isPlayout() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.playlist != ""
+}
+
+func (ch *channel) isLive() bool {
+	if ch == nil {
+		return false
+	}
+	return atomic.LoadUintptr(&ch.live) != 0
 }
 
 func (ch *channel) getHLS() *hls.Publisher {
@@ -111,8 +253,37 @@ func (ch *channel) getHLS() *hls.Publisher {
 	return p
 }
 
+// codecs names the codecs the channel is receiving, or is nil if it is offline
+func (ch *channel) codecs() []string {
+	ch.mu.Lock()
+	q := ch.ingest
+	ch.mu.Unlock()
+	if q == nil {
+		return nil
+	}
+	// the header is written before the queue goes live so this doesn't block
+	streams, err := q.Latest().Streams()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(streams))
+	for i, stream := range streams {
+		names[i] = codecName(stream.Type())
+	}
+	return names
+}
+
+func codecName(t av.CodecType) string {
+	switch t {
+	case opus.OPUS:
+		return "opus"
+	default:
+		return strings.ToLower(t.String())
+	}
+}
+
 func (ch *channel) currentViewers() int {
-	v := int(atomic.LoadInt32(&ch.viewers))
-	v += int(atomic.LoadInt32(&ch.hlsvTotal))
+	ch.viewers.expire(hlsViewTimeout)
+	v, _ := ch.viewers.counts()
 	return v
 }
@@ -0,0 +1,137 @@
+package ingest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"eaglesong.dev/gunk/transcode/opus"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+// segmentBitrateWindow is roughly a segment, over which the peak bitrate
+// advertised in the master playlist is measured
+const segmentBitrateWindow = 2 * time.Second
+
+// MasterPlaylist is the name of a channel's HLS master playlist, next to the
+// media playlists it lists
+const MasterPlaylist = "master.m3u8"
+
+// rendition is one media playlist listed in a master playlist
+type rendition struct {
+	uri        string
+	bandwidth  int
+	codecs     []string
+	resolution string
+	// subtitles is set if the rendition has the caption track
+	subtitles bool
+}
+
+func (ch *channel) notePeakBitrate(rate int) {
+	ch.mu.Lock()
+	if rate > ch.peakBitrate {
+		ch.peakBitrate = rate
+	}
+	if rate > ch.totals.PeakBitrate {
+		ch.totals.PeakBitrate = rate
+	}
+	ch.mu.Unlock()
+}
+
+// renditions lists the channel's HLS renditions, which for now is just the
+// source. It returns nil until the bitrate is known.
+func (ch *channel) renditions() []rendition {
+	ch.mu.Lock()
+	q, peak := ch.ingest, ch.peakBitrate
+	ch.mu.Unlock()
+	if q == nil || peak == 0 {
+		return nil
+	}
+	streams, err := q.Latest().Streams()
+	if err != nil {
+		return nil
+	}
+	r := rendition{uri: "index.m3u8", bandwidth: peak, subtitles: len(ch.subtitles()) != 0}
+	for _, stream := range streams {
+		codec := codecString(stream)
+		if codec == "" {
+			// an incomplete CODECS attribute is worse than none
+			r.codecs = nil
+			break
+		}
+		r.codecs = append(r.codecs, codec)
+	}
+	for _, stream := range streams {
+		if v, ok := stream.(av.VideoCodecData); ok {
+			r.resolution = fmt.Sprintf("%dx%d", v.Width(), v.Height())
+		}
+	}
+	return []rendition{r}
+}
+
+type subtitleOption struct {
+	name, playlist string
+}
+
+// subtitles lists the channel's subtitle playlists that have segments
+func (ch *channel) subtitles() []subtitleOption {
+	ch.mu.Lock()
+	cc, tr := ch.captions, ch.transcript
+	ch.mu.Unlock()
+	var opts []subtitleOption
+	if cc.found() {
+		opts = append(opts, subtitleOption{"Captions", SubtitlePlaylist})
+	}
+	if tr.found() {
+		opts = append(opts, subtitleOption{"Automatic captions", TranscriptPlaylist})
+	}
+	return opts
+}
+
+// codecString gives the RFC 6381 name of a stream's codec, or an empty string
+// if it isn't known
+func codecString(stream av.CodecData) string {
+	switch c := stream.(type) {
+	case h264parser.CodecData:
+		return fmt.Sprintf("avc1.%02x%02x%02x", c.RecordInfo.AVCProfileIndication, c.RecordInfo.ProfileCompatibility, c.RecordInfo.AVCLevelIndication)
+	case aacparser.CodecData:
+		return fmt.Sprintf("mp4a.40.%d", c.Config.ObjectType)
+	}
+	if stream.Type() == opus.OPUS {
+		return "opus"
+	}
+	return ""
+}
+
+// serveMaster writes a master playlist listing the channel's renditions
+func (ch *channel) serveMaster(rw http.ResponseWriter) error {
+	renditions := ch.renditions()
+	if len(renditions) == 0 {
+		return ErrNoChannel
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, s := range ch.subtitles() {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"cc\",NAME=\"%s\",DEFAULT=NO,AUTOSELECT=YES,URI=\"%s\"\n", s.name, s.playlist)
+	}
+	for _, r := range renditions {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d", r.bandwidth)
+		if len(r.codecs) != 0 {
+			fmt.Fprintf(&b, ",CODECS=\"%s\"", strings.Join(r.codecs, ","))
+		}
+		if r.resolution != "" {
+			fmt.Fprintf(&b, ",RESOLUTION=%s", r.resolution)
+		}
+		if r.subtitles {
+			b.WriteString(",SUBTITLES=\"cc\"")
+		}
+		fmt.Fprintf(&b, "\n%s\n", r.uri)
+	}
+	rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	rw.Header().Set("Cache-Control", "no-cache")
+	_, err := rw.Write([]byte(b.String()))
+	return err
+}
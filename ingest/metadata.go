@@ -0,0 +1,320 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// id3Scheme marks event messages that carry an ID3 tag, which players
+	// such as hls.js and Safari surface as timed metadata cues
+	id3Scheme = "https://aomedia.org/emsg/ID3"
+	// metadataExpiry drops metadata that no viewer fetched a segment for in
+	// time, rather than have it show up late
+	metadataExpiry = 30 * time.Second
+	// metadataSegments is how many segment names are remembered, which is
+	// more than a playlist holds
+	metadataSegments = 256
+)
+
+// Metadata is timed metadata for players, such as the song playing or a
+// change of game. Each field becomes an ID3 frame.
+type Metadata struct {
+	// Title goes in a TIT2 frame
+	Title string
+	// URL goes in a WXXX frame
+	URL string
+	// Data is free-form text, each entry in a TXXX frame with the key as
+	// its description
+	Data map[string]string
+}
+
+// InsertMetadata adds timed metadata to a channel's live HLS stream. It is
+// carried in an event message box at the start of the newest segment, so it
+// shows up about a segment after it was sent.
+func (m *Manager) InsertMetadata(name string, md Metadata) error {
+	t, err := m.liveMetadata(name)
+	if err != nil {
+		return err
+	}
+	t.add(id3Scheme, md.id3Tag())
+	return nil
+}
+
+// liveMetadata returns the metadata track of a channel that is on air
+func (m *Manager) liveMetadata(name string) (*metadataTrack, error) {
+	ch := m.channel(name)
+	if ch == nil || atomic.LoadUintptr(&ch.live) == 0 {
+		return nil, ErrNotPublishing
+	}
+	ch.mu.Lock()
+	t := ch.metadata
+	ch.mu.Unlock()
+	if t == nil {
+		return nil, ErrNotPublishing
+	}
+	return t, nil
+}
+
+type pendingMetadata struct {
+	box    []byte
+	posted time.Time
+	// cue is set for SCTE-35 cues, which are marked in the playlist as well
+	cue *spliceCue
+}
+
+// metadataTrack hands out timed metadata to the HLS segments served after it
+// was posted. Each piece goes in the newest segment of the last playlist
+// served, unless a viewer fetched that segment before the metadata was
+// posted, in which case it waits for the next one. Every viewer of that
+// segment gets the same boxes, and older segments that viewers are still
+// catching up on are left alone. Like the HLS publisher it lives across
+// reconnects.
+type metadataTrack struct {
+	mu      sync.Mutex
+	nextID  uint32
+	pending []pendingMetadata
+	// attached holds what was given to each segment fetched so far, which is
+	// nothing for most of them
+	attached map[string][]pendingMetadata
+	order    []string
+	// newest is the file name of the last complete segment in the playlist
+	newest string
+	// lastSplice is the event ID of the last SCTE-35 splice, which a return
+	// to the program refers to
+	lastSplice uint32
+}
+
+func newMetadataTrack() *metadataTrack {
+	return &metadataTrack{attached: make(map[string][]pendingMetadata)}
+}
+
+// add queues a message for the next segment in an event message box of the
+// given scheme
+func (t *metadataTrack) add(scheme string, message []byte) {
+	t.addCue(scheme, message, nil)
+}
+
+func (t *metadataTrack) addCue(scheme string, message []byte, cue *spliceCue) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	t.pending = append(t.pending, pendingMetadata{emsgBox(scheme, t.nextID, message), time.Now(), cue})
+}
+
+// observe notes the newest segment in a media playlist as it goes out, and
+// settles that pending metadata goes in it so that the playlist can say so
+func (t *metadataTrack) observe(p *mediaPlaylist) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(p.segments) - 1; i >= 0; i-- {
+		if uri := p.segments[i].uri; uri != "" {
+			t.newest = resourceName(uri)
+			break
+		}
+	}
+	if _, ok := t.attached[t.newest]; !ok && t.newest != "" && len(t.pending) != 0 {
+		t.attach(t.newest)
+	}
+}
+
+// forSegment returns the boxes that go in front of a segment, attaching any
+// pending metadata if it is the newest segment and no viewer has fetched it
+// before
+func (t *metadataTrack) forSegment(filename string) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attached, ok := t.attached[filename]
+	if !ok {
+		if filename != t.newest {
+			return nil
+		}
+		attached = t.attach(filename)
+	}
+	var boxes []byte
+	for _, md := range attached {
+		boxes = append(boxes, md.box...)
+	}
+	return boxes
+}
+
+// attach gives the pending metadata to a segment. The caller holds t.mu.
+func (t *metadataTrack) attach(filename string) []pendingMetadata {
+	var attached []pendingMetadata
+	for _, md := range t.pending {
+		if time.Since(md.posted) < metadataExpiry {
+			attached = append(attached, md)
+		}
+	}
+	t.pending = nil
+	t.attached[filename] = attached
+	t.order = append(t.order, filename)
+	if len(t.order) > metadataSegments {
+		delete(t.attached, t.order[0])
+		t.order = t.order[1:]
+	}
+	return attached
+}
+
+// release puts a file's metadata back if it turned out not to be a media
+// segment, such as the initialization segment
+func (t *metadataTrack) release(filename string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attached := t.attached[filename]
+	if len(attached) == 0 {
+		return
+	}
+	delete(t.attached, filename)
+	for i := range attached {
+		attached[i].posted = time.Now()
+	}
+	t.pending = append(attached, t.pending...)
+}
+
+// emsgWriter puts event message boxes in front of a fragmented MP4 media
+// segment, after its styp box if it has one. Anything else, including MPEG-TS
+// segments, passes through as is.
+type emsgWriter struct {
+	http.ResponseWriter
+	boxes       []byte
+	release     func()
+	wroteHeader bool
+	checked     bool
+}
+
+func (w *emsgWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	if code == http.StatusOK {
+		// the length isn't known until the body shows what the file is
+		w.Header().Del("Content-Length")
+	} else {
+		w.checked = true
+		w.release()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *emsgWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.checked {
+		return w.ResponseWriter.Write(p)
+	}
+	w.checked = true
+	if len(p) < 8 {
+		w.release()
+		return w.ResponseWriter.Write(p)
+	}
+	split := 0
+	switch string(p[4:8]) {
+	case "styp":
+		split = int(binary.BigEndian.Uint32(p))
+		if split < 8 || split > len(p) {
+			w.release()
+			return w.ResponseWriter.Write(p)
+		}
+	case "moof":
+	default:
+		w.release()
+		return w.ResponseWriter.Write(p)
+	}
+	if _, err := w.ResponseWriter.Write(p[:split]); err != nil {
+		return 0, err
+	}
+	if _, err := w.ResponseWriter.Write(w.boxes); err != nil {
+		return split, err
+	}
+	n, err := w.ResponseWriter.Write(p[split:])
+	return split + n, err
+}
+
+func (w *emsgWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// emsgBox builds a version 0 event message box, taking effect at the start of
+// the segment it is in
+func emsgBox(scheme string, id uint32, message []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0, 0, 0, 0})
+	b.WriteString("emsg")
+	b.Write([]byte{0, 0, 0, 0}) // version and flags
+	b.WriteString(scheme + "\x00")
+	b.WriteString("\x00") // value
+	for _, v := range []uint32{
+		1000,       // timescale
+		0,          // presentation time delta
+		0xffffffff, // duration unknown
+		id,
+	} {
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	b.Write(message)
+	box := b.Bytes()
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	return box
+}
+
+// id3Tag builds an ID3v2.4 tag with UTF-8 text frames
+func (md Metadata) id3Tag() []byte {
+	var frames bytes.Buffer
+	if md.Title != "" {
+		id3Frame(&frames, "TIT2", "\x03"+md.Title)
+	}
+	if md.URL != "" {
+		id3Frame(&frames, "WXXX", "\x03\x00"+md.URL)
+	}
+	keys := make([]string, 0, len(md.Data))
+	for k := range md.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		id3Frame(&frames, "TXXX", "\x03"+k+"\x00"+md.Data[k])
+	}
+	tag := []byte{'I', 'D', '3', 4, 0, 0}
+	tag = append(tag, synchsafe(frames.Len())...)
+	return append(tag, frames.Bytes()...)
+}
+
+func id3Frame(b *bytes.Buffer, id, body string) {
+	b.WriteString(id)
+	b.Write(synchsafe(len(body)))
+	b.Write([]byte{0, 0}) // flags
+	b.WriteString(body)
+}
+
+// synchsafe encodes an ID3 size, 7 bits to a byte
+func synchsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// metadataWriter wraps a response for a file from the HLS publisher so that
+// media segments carry the channel's timed metadata
+func (ch *channel) metadataWriter(rw http.ResponseWriter, req *http.Request, filename string) http.ResponseWriter {
+	ch.mu.Lock()
+	t := ch.metadata
+	ch.mu.Unlock()
+	// byte ranges would no longer line up
+	if t == nil || req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return rw
+	}
+	boxes := t.forSegment(filename)
+	if len(boxes) == 0 {
+		return rw
+	}
+	return &emsgWriter{
+		ResponseWriter: rw,
+		boxes:          boxes,
+		release:        func() { t.release(filename) },
+	}
+}
@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// TestSynchsafe checks ID3 sizes around the 7 bit boundaries
+func TestSynchsafe(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{0, "00000000"},
+		{127, "0000007f"},
+		{128, "00000100"},
+		{201, "00000149"},
+		{0x0fffffff, "7f7f7f7f"},
+	} {
+		if got := hex.EncodeToString(synchsafe(tc.n)); got != tc.want {
+			t.Errorf("%d: got %s, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+// TestID3Tag checks tags byte for byte against ones laid out by hand from the
+// ID3v2.4 structure and frames documents
+func TestID3Tag(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		md   Metadata
+		want []string
+	}{
+		{
+			"title",
+			Metadata{Title: "Hi"},
+			[]string{
+				"494433 0400 00 0000000d",
+				"54495432 00000003 0000 03 4869",
+			},
+		},
+		{
+			"all frames",
+			Metadata{Title: "Hi", URL: "https://e.x", Data: map[string]string{"b": "2", "a": "1"}},
+			[]string{
+				"494433 0400 00 00000040",
+				"54495432 00000003 0000 03 4869",
+				"57585858 0000000d 0000 03 00 68747470733a2f2f652e78",
+				// sorted by description
+				"54585858 00000004 0000 03 61 00 31",
+				"54585858 00000004 0000 03 62 00 32",
+			},
+		},
+		{
+			"long title",
+			Metadata{Title: strings.Repeat("x", 200)},
+			[]string{
+				"494433 0400 00 00000153",
+				"54495432 00000149 0000 03" + strings.Repeat("78", 200),
+			},
+		},
+	} {
+		want := strings.ReplaceAll(strings.Join(tc.want, ""), " ", "")
+		if got := hex.EncodeToString(tc.md.id3Tag()); got != want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, want)
+		}
+	}
+}
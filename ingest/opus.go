@@ -0,0 +1,117 @@
+package ingest
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"eaglesong.dev/gunk/transcode/opus"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/av/pubsub"
+)
+
+// opusIdle is how long AAC is still converted after the last WebRTC or RTSP
+// viewer leaves, which also gives new viewers time to connect
+const opusIdle = 30 * time.Second
+
+// opusConverter transcodes a channel's AAC audio to Opus for WebRTC and RTSP
+// viewers, but only while there are some. The output queue carries on across
+// restarts so that viewers can follow it.
+type opusConverter struct {
+	src      *pubsub.Queue
+	out      *pubsub.Queue
+	bitrate  int
+	watching func() bool
+
+	mu      sync.Mutex
+	running bool
+	closed  bool
+	wanted  time.Time
+}
+
+func newOpusConverter(src *pubsub.Queue, bitrate int, watching func() bool) *opusConverter {
+	if bitrate == 0 {
+		bitrate = 128000
+	}
+	return &opusConverter{
+		src:      src,
+		out:      pubsub.NewQueue(),
+		bitrate:  bitrate,
+		watching: watching,
+	}
+}
+
+// want starts converting if it isn't already and holds off stopping
+func (c *opusConverter) want() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wanted = time.Now()
+	if c.running || c.closed {
+		return
+	}
+	c.running = true
+	go c.run()
+}
+
+// idle returns true once nobody has needed Opus for a while
+func (c *opusConverter) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(c.wanted) > opusIdle && !c.watching()
+}
+
+func (c *opusConverter) run() {
+	for {
+		src := &untilIdle{Demuxer: c.src.Latest(), idle: c.idle}
+		err := opus.Convert(src, c.out, c.bitrate)
+		if err != nil {
+			slog.Error("opus conversion failed", "err", err)
+		}
+		c.mu.Lock()
+		if src.stopped && !c.closed && time.Since(c.wanted) <= opusIdle {
+			// wanted again while stopping
+			c.mu.Unlock()
+			continue
+		}
+		c.running = false
+		// if ffmpeg failed then the next viewer will try again, unless the
+		// publisher went away as well
+		if !src.stopped && (err == nil || c.closed) {
+			c.closed = true
+			c.out.Close()
+		}
+		c.mu.Unlock()
+		return
+	}
+}
+
+// close ends the output once the publisher has gone away
+func (c *opusConverter) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if !c.running {
+		c.out.Close()
+	}
+}
+
+// untilIdle ends the stream once idle returns true, checked about once a
+// second
+type untilIdle struct {
+	av.Demuxer
+	idle    func() bool
+	checked time.Time
+	stopped bool
+}
+
+func (d *untilIdle) ReadPacket() (av.Packet, error) {
+	if time.Since(d.checked) > time.Second {
+		d.checked = time.Now()
+		if d.idle() {
+			d.stopped = true
+			return av.Packet{}, io.EOF
+		}
+	}
+	return d.Demuxer.ReadPacket()
+}
@@ -0,0 +1,80 @@
+package ingest
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// programDateTime is the tag that ties a segment to the wall-clock time it
+// was captured, which lets players watching together line up on the same
+// moment
+const programDateTime = "#EXT-X-PROGRAM-DATE-TIME:"
+
+// dateFormat is how dates are written in playlists
+const dateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// segmentClock remembers when each HLS segment started, so every viewer's
+// playlist gives a segment the same time. Like the metadata track it lives
+// across reconnects.
+type segmentClock struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+	order   []string
+}
+
+func newSegmentClock() *segmentClock {
+	return &segmentClock{started: make(map[string]time.Time)}
+}
+
+// stamp adds EXT-X-PROGRAM-DATE-TIME to a media playlist in front of each
+// segment. A segment starts when the one before it ends, and the first one
+// seen, or the first after a discontinuity, is taken to have been captured
+// just before the live edge. Playlists that already have the tag are left
+// alone.
+func (c *segmentClock) stamp(p *mediaPlaylist, now time.Time) {
+	for _, line := range p.lines {
+		if strings.HasPrefix(line, programDateTime) {
+			return
+		}
+	}
+	var total time.Duration
+	for _, seg := range p.segments {
+		total += seg.duration
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var prev time.Time
+	var prevDuration time.Duration
+	remaining := total
+	for i, seg := range p.segments {
+		if seg.uri == "" {
+			// still being written
+			continue
+		}
+		t, ok := c.started[seg.uri]
+		if !ok {
+			if !prev.IsZero() && !seg.discontinuity {
+				t = prev.Add(prevDuration)
+			} else {
+				t = now.Add(-remaining)
+			}
+			c.remember(seg.uri, t)
+		}
+		p.insert(seg.start, programDateTime+t.UTC().Format(dateFormat))
+		p.segments[i].date = t
+		prev, prevDuration = t, seg.duration
+		remaining -= seg.duration
+	}
+}
+
+// remember records a segment's start, forgetting the oldest once there are
+// more than a playlist holds
+func (c *segmentClock) remember(uri string, t time.Time) {
+	c.started[uri] = t
+	c.order = append(c.order, uri)
+	if len(c.order) > metadataSegments {
+		delete(c.started, c.order[0])
+		c.order = c.order[1:]
+	}
+}
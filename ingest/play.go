@@ -5,6 +5,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"path"
 	"strings"
 	"sync/atomic"
 
@@ -12,71 +13,156 @@ import (
 	"eaglesong.dev/gunk/sinks/playrtc"
 	"eaglesong.dev/gunk/sinks/rtsp"
 	"github.com/nareix/joy4/av"
-	"github.com/nareix/joy4/format/ts"
 	"github.com/pkg/errors"
 )
 
 var ErrNoChannel = errors.New("channel not found")
 
-func (m *Manager) ServeTS(rw http.ResponseWriter, req *http.Request, name string) error {
-	ch := m.channel(name)
-	src := ch.queue(false)
-	if src == nil {
-		return ErrNoChannel
-	}
-	rw.Header().Set("Content-Type", "video/MP2T")
-	rw.Header().Set("Transfer-Encoding", "chunked")
-	muxer := ts.NewMuxer(rw)
-	streams, _ := src.Streams()
-	muxer.WriteHeader(streams)
-	ch.addViewer(1)
-	defer ch.addViewer(-1)
-	return copyStream(req.Context(), muxer, src)
-}
-
-func (m *Manager) ServeHLS(rw http.ResponseWriter, req *http.Request, name string) error {
+// ServeHLS serves a file of a channel's HLS stream to a viewer at addr
+func (m *Manager) ServeHLS(rw http.ResponseWriter, req *http.Request, name, addr string) error {
 	ch := m.channel(name)
 	if ch == nil {
 		return ErrNoChannel
 	}
-	host, _, _ := net.SplitHostPort(req.RemoteAddr)
-	if host == "" {
-		host = req.RemoteAddr
+	// playlist polls keep the session alive
+	if strings.HasSuffix(req.URL.Path, ".m3u8") {
+		id := ch.viewers.hlsKey(viewerID(req, addr), addr)
+		ch.viewers.heartbeat(id, id, addr, "hls")
 	}
-	if host != "" {
-		ch.hlsViewed(host)
+	if path.Base(req.URL.Path) == MasterPlaylist {
+		return ch.serveMaster(meteredWriter{rw, m.usageFunc(name, "hls")})
+	}
+	if filename := path.Base(req.URL.Path); strings.HasSuffix(filename, ".vtt") || strings.HasSuffix(filename, ".m3u8") {
+		if track, ok := ch.subtitleTrack(filename); ok {
+			return track.serve(meteredWriter{rw, m.usageFunc(name, "hls")}, filename)
+		}
 	}
 	p := ch.getHLS()
 	if p == nil {
 		return ErrNoChannel
 	}
-	p.ServeHTTP(rw, req)
+	var w http.ResponseWriter = meteredWriter{rw, m.usageFunc(name, "hls")}
+	filename := path.Base(req.URL.Path)
+	if strings.HasSuffix(filename, ".m3u8") {
+		pw := &playlistWriter{ResponseWriter: w}
+		p.ServeHTTP(pw, req)
+		return pw.finish(ch)
+	}
+	keys := ch.segmentKeys()
+	if keys != nil && strings.HasSuffix(filename, ".key") {
+		keys.serveKey(w, req, filename)
+		return nil
+	}
+	var cw *cbcWriter
+	if keys != nil {
+		var ok bool
+		if w, cw, ok = keys.writer(w, filename); !ok {
+			http.NotFound(rw, req)
+			return nil
+		}
+		// ranges of the plain file don't line up with the encrypted one
+		req.Header.Del("Range")
+	}
+	w = ch.metadataWriter(w, req, filename)
+	p.ServeHTTP(w, req)
+	if cw != nil {
+		return cw.finish()
+	}
 	return nil
 }
 
-func (m *Manager) ServeSDP(rw http.ResponseWriter, req *http.Request, name string) error {
+// ServeSDP starts a WebRTC session with a viewer at addr
+func (m *Manager) ServeSDP(rw http.ResponseWriter, req *http.Request, name, addr string) error {
 	ch := m.channel(name)
-	src := ch.queue(true)
+	src := m.playSource(ch, true, 0)
 	if src == nil {
 		return ErrNoChannel
 	}
-	return playrtc.HandleSDP(rw, req, src, func(delta int) { ch.addViewer(int32(delta)) })
+	src = meteredDemuxer{src, m.usageFunc(name, "rtc")}
+	key, id := connectionKey(), viewerID(req, addr)
+	return playrtc.HandleSDP(rw, req, src, func(delta int) {
+		if delta > 0 {
+			ch.viewers.heartbeat(key, id, addr, "rtc")
+		} else {
+			ch.viewers.leave(key)
+		}
+	})
 }
 
 func (m *Manager) GetRTSPSource(req *rtsp.Request) (av.Demuxer, error) {
-	chname := req.URL.Path
-	if strings.HasPrefix(chname, "/") {
-		chname = chname[1:]
+	if addr, ok := req.RemoteAddr.(*net.TCPAddr); ok && m.bans.Load().bannedAddr(addr.IP) {
+		return nil, rtsp.ErrNotFound
 	}
-	chname = strings.Split(chname, "/")[0]
-	src := m.channel(chname).queue(true)
+	chname := rtspChannel(req)
+	ch := m.channel(chname)
+	// RTSP clients have no way to prove access or acknowledge warnings
+	rules, err := model.ChannelAccess(context.Background(), chname)
+	if err == nil && (rules.Gated() || rules.Rating != model.RatingGeneral) {
+		return nil, rtsp.ErrNotFound
+	}
+	src := m.playSource(ch, true, 0)
 	if src == nil {
 		return nil, rtsp.ErrNotFound
 	}
-	// TODO: viewer count
+	if req.Method == "PLAY" {
+		if err == nil && rules.MaxViewers > 0 && ch.currentViewers() >= rules.MaxViewers {
+			return nil, rtsp.ErrFull
+		}
+		src = meteredDemuxer{src, m.usageFunc(chname, "rtsp")}
+	}
 	return src, nil
 }
 
+// RTSPWatching counts RTSP clients as viewers while they play
+func (m *Manager) RTSPWatching(req *rtsp.Request, delta int) {
+	ch := m.channel(rtspChannel(req))
+	if ch == nil {
+		return
+	}
+	key := "r:" + req.RemoteAddr.String()
+	if delta > 0 {
+		host := viewerHost(req.RemoteAddr.String())
+		ch.viewers.heartbeat(key, hostViewerID(host, req.Header.Get("User-Agent")), host, "rtsp")
+	} else {
+		ch.viewers.leave(key)
+	}
+}
+
+// rtspChannel returns the channel named by the first element of the path
+func rtspChannel(req *rtsp.Request) string {
+	return strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")[0]
+}
+
+// IsLive returns true if the channel is being published to
+func (m *Manager) IsLive(name string) bool {
+	ch := m.channel(name)
+	return ch != nil && ch.isLive()
+}
+
+// viewerHost returns the address of an RTSP viewer
+func viewerHost(remoteAddr string) string {
+	host, _, _ := net.SplitHostPort(remoteAddr)
+	if host == "" {
+		host = remoteAddr
+	}
+	return host
+}
+
+// AdmitViewer returns true if a viewer at addr may start watching a channel
+// capped at max viewers. HLS viewers that are already watching are always let back in.
+// Each address only starts so many HLS sessions, so made-up session IDs can't
+// fill the channel.
+func (m *Manager) AdmitViewer(name string, req *http.Request, addr string, max int) bool {
+	ch := m.channel(name)
+	if ch == nil || max <= 0 {
+		return true
+	}
+	if ch.viewers.has(ch.viewers.hlsKey(viewerID(req, addr), addr)) {
+		return true
+	}
+	return ch.currentViewers() < max
+}
+
 func (m *Manager) PopulateLive(infos []*model.ChannelInfo) {
 	for _, info := range infos {
 		ch := m.channel(info.Name)
@@ -86,6 +172,7 @@ func (m *Manager) PopulateLive(infos []*model.ChannelInfo) {
 		info.Live = ch.isLive()
 		info.Viewers = ch.currentViewers()
 		info.RTC = atomic.LoadUintptr(&ch.rtc) != 0
+		info.Codecs = ch.codecs()
 	}
 }
 
@@ -0,0 +1,204 @@
+package ingest
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mediaPlaylist is a media playlist from the HLS publisher, parsed just enough
+// to add tags of our own in front of its segments
+type mediaPlaylist struct {
+	lines          []string
+	targetDuration time.Duration
+	segments       []playlistSegment
+	// maps are the lines of EXT-X-MAP tags
+	maps []int
+	// inserts are added in front of the line they are keyed by
+	inserts map[int][]string
+}
+
+type playlistSegment struct {
+	// start is the segment's first tag, where tags for it are added
+	start int
+	// uri is empty for the segment still being written, which only has parts
+	uri           string
+	seq           int64
+	duration      time.Duration
+	discontinuity bool
+	// date is the segment's program date and time, once known
+	date time.Time
+	// parts are the URIs of its partial segments and preload hints
+	parts []string
+}
+
+// parseMediaPlaylist returns nil if body isn't a playlist
+func parseMediaPlaylist(body []byte) *mediaPlaylist {
+	if !bytes.HasPrefix(body, []byte("#EXTM3U")) {
+		return nil
+	}
+	p := &mediaPlaylist{lines: strings.Split(string(body), "\n"), inserts: make(map[int][]string)}
+	var seq int64
+	next := playlistSegment{start: -1}
+	for i, line := range p.lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			secs, _ := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+			p.targetDuration = time.Duration(secs) * time.Second
+			continue
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seq, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+			continue
+		case strings.HasPrefix(line, "#EXT-X-SKIP:"):
+			// delta updates leave out the oldest segments
+			skipped, _ := strconv.ParseInt(playlistAttr(line, "SKIPPED-SEGMENTS"), 10, 64)
+			seq += skipped
+			continue
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			p.maps = append(p.maps, i)
+			continue
+		}
+		if next.start < 0 && (strings.HasPrefix(line, "#EXTINF:") || line == "#EXT-X-DISCONTINUITY" || strings.HasPrefix(line, programDateTime) ||
+			strings.HasPrefix(line, "#EXT-X-PART:") || strings.HasPrefix(line, "#EXT-X-PRELOAD-HINT:")) {
+			next.start = i
+		}
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v := strings.TrimPrefix(line, "#EXTINF:")
+			if j := strings.IndexByte(v, ','); j >= 0 {
+				v = v[:j]
+			}
+			secs, _ := strconv.ParseFloat(v, 64)
+			next.duration = time.Duration(secs * float64(time.Second))
+		case line == "#EXT-X-DISCONTINUITY":
+			next.discontinuity = true
+		case strings.HasPrefix(line, programDateTime):
+			next.date, _ = time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, programDateTime))
+		case strings.HasPrefix(line, "#EXT-X-PART:"), strings.HasPrefix(line, "#EXT-X-PRELOAD-HINT:"):
+			if uri := playlistAttr(line, "URI"); uri != "" {
+				next.parts = append(next.parts, uri)
+			}
+		case line != "" && !strings.HasPrefix(line, "#") && next.start >= 0:
+			next.uri, next.seq = line, seq
+			seq++
+			p.segments = append(p.segments, next)
+			next = playlistSegment{start: -1}
+		}
+	}
+	if next.start >= 0 && len(next.parts) != 0 {
+		next.seq = seq
+		p.segments = append(p.segments, next)
+	}
+	return p
+}
+
+// playlistAttr returns an attribute of a tag, with any quotes removed
+func playlistAttr(line, name string) string {
+	i := strings.Index(line, name+"=")
+	if i < 0 {
+		return ""
+	}
+	v := line[i+len(name)+1:]
+	if strings.HasPrefix(v, `"`) {
+		v = v[1:]
+		if j := strings.IndexByte(v, '"'); j >= 0 {
+			return v[:j]
+		}
+		return v
+	}
+	if j := strings.IndexByte(v, ','); j >= 0 {
+		return v[:j]
+	}
+	return v
+}
+
+// insert adds a line in front of line i
+func (p *mediaPlaylist) insert(i int, line string) {
+	p.inserts[i] = append(p.inserts[i], line)
+}
+
+func (p *mediaPlaylist) bytes() []byte {
+	var b strings.Builder
+	for i, line := range p.lines {
+		for _, v := range p.inserts[i] {
+			b.WriteString(v + "\n")
+		}
+		b.WriteString(line)
+		if i != len(p.lines)-1 {
+			b.WriteByte('\n')
+		}
+	}
+	return []byte(b.String())
+}
+
+// resourceName is the file name a playlist URI is requested as
+func resourceName(uri string) string {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+	return path.Base(uri)
+}
+
+// rewritePlaylist adds the channel's own tags to a media playlist from the HLS
+// publisher
+func (ch *channel) rewritePlaylist(body []byte) []byte {
+	p := parseMediaPlaylist(body)
+	if p == nil {
+		return body
+	}
+	ch.mu.Lock()
+	clock, keys, metadata := ch.clock, ch.keys, ch.metadata
+	ch.mu.Unlock()
+	if metadata != nil {
+		metadata.observe(p)
+	}
+	if clock != nil {
+		clock.stamp(p, time.Now())
+	}
+	if metadata != nil {
+		metadata.tag(p)
+	}
+	if keys != nil {
+		keys.tag(p)
+	}
+	return p.bytes()
+}
+
+// playlistWriter holds a media playlist from the HLS publisher so it can be
+// rewritten before it goes out
+type playlistWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *playlistWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *playlistWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.body.Write(p)
+}
+
+// finish sends the playlist on, rewritten if it is one
+func (w *playlistWriter) finish(ch *channel) error {
+	body := w.body.Bytes()
+	if w.code == http.StatusOK || w.code == 0 {
+		body = ch.rewritePlaylist(body)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+	_, err := w.ResponseWriter.Write(body)
+	return err
+}
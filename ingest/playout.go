@@ -0,0 +1,516 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"eaglesong.dev/gunk/internal"
+	"eaglesong.dev/gunk/model"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/format/flv"
+)
+
+// publish kinds used by playlists so that live publishers can take over from
+// them
+const (
+	// playoutKind loops a playlist while nobody is live
+	playoutKind = "playout"
+	// failoverKind covers for a live publisher that dropped until they return
+	failoverKind = "failover"
+)
+
+const (
+	playoutRetry         = 10 * time.Second
+	playoutLookupTimeout = 5 * time.Second
+	failoverStartup      = 15 * time.Second
+	defaultFailoverLimit = 30 * time.Minute
+)
+
+var (
+	errChannelBusy   = errors.New("channel is live")
+	errEmptyPlaylist = errors.New("playlist is empty")
+)
+
+// PlayoutStatus describes what a channel's playlist is currently playing
+type PlayoutStatus struct {
+	Index   int    `json:"index"`
+	Title   string `json:"title"`
+	Started int64  `json:"started"`
+}
+
+type playout struct {
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	status *PlayoutStatus
+}
+
+func (po *playout) setItem(idx int, item *model.PlayoutItem) {
+	po.mu.Lock()
+	po.status = &PlayoutStatus{
+		Index:   idx,
+		Title:   item.Title,
+		Started: time.Now().UnixNano() / 1000000,
+	}
+	po.mu.Unlock()
+}
+
+// StartPlayouts starts the playlists of all channels that have one enabled
+func (m *Manager) StartPlayouts(ctx context.Context) error {
+	names, err := model.EnabledPlayouts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		m.SetPlayout(name, true)
+	}
+	return nil
+}
+
+// SetPlayout stops a channel's playlist and starts it again from the top if
+// it is enabled
+func (m *Manager) SetPlayout(name string, enabled bool) {
+	if v, ok := m.playouts.LoadAndDelete(name); ok {
+		v.(*playout).cancel()
+	}
+	if !enabled {
+		return
+	}
+	po := new(playout)
+	var ctx context.Context
+	ctx, po.cancel = context.WithCancel(context.Background())
+	m.playouts.Store(name, po)
+	go m.runPlayout(ctx, name, po)
+}
+
+// RenamePlayout restarts a renamed channel's playlist under its new name
+func (m *Manager) RenamePlayout(oldName, newName string) {
+	if _, ok := m.playouts.Load(oldName); ok {
+		m.SetPlayout(oldName, false)
+		m.SetPlayout(newName, true)
+	}
+}
+
+// PlayoutStatus returns what a channel's playlist is playing, or nil if it
+// isn't on air
+func (m *Manager) PlayoutStatus(name string) *PlayoutStatus {
+	v, _ := m.playouts.Load(name)
+	if v == nil {
+		return nil
+	}
+	po := v.(*playout)
+	po.mu.Lock()
+	defer po.mu.Unlock()
+	return po.status
+}
+
+// CheckPlayoutSource returns an error if a playlist item can't be played by
+// this server
+func (m *Manager) CheckPlayoutSource(source string) error {
+	_, _, err := m.playoutInput(source)
+	return err
+}
+
+// playoutInput resolves a playlist item to an ffmpeg input and the protocols
+// it is allowed to use. Local files are confined to the playout directory, and
+// URLs must be allowed by the operator and on public addresses.
+func (m *Manager) playoutInput(source string) (input, protocols string, err error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		if !m.PlayoutURLs {
+			return "", "", errors.New("this server doesn't play URLs")
+		}
+		u, err := url.Parse(source)
+		if err != nil || u.Host == "" {
+			return "", "", errors.New("invalid URL")
+		}
+		if err := checkPublicHost(u.Hostname()); err != nil {
+			return "", "", err
+		}
+		return source, "http,https,tcp,tls,crypto", nil
+	}
+	if m.PlayoutDir == "" {
+		if !m.PlayoutURLs {
+			return "", "", errors.New("this server has no playout directory")
+		}
+		return "", "", errors.New("source must be an http or https URL")
+	}
+	name := filepath.Clean(filepath.FromSlash(source))
+	if source == "" || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", "", errors.New("source must be an http or https URL or a file in the playout directory")
+	}
+	return "file:" + filepath.Join(m.PlayoutDir, name), "file", nil
+}
+
+// checkPublicHost refuses URLs whose host isn't on the internet. ffmpeg looks
+// the name up again and follows redirects, so this can't be relied on by
+// itself, which is why URLs are only played if the operator allows it.
+func checkPublicHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), playoutLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !internal.IsPublic(addr.IP) {
+			return errors.New("URL is not on a public address")
+		}
+	}
+	return nil
+}
+
+func (m *Manager) runPlayout(ctx context.Context, name string, po *playout) {
+	for {
+		if !m.waitIdle(ctx, name) {
+			return
+		}
+		err := m.playOnce(ctx, name, po)
+		po.mu.Lock()
+		po.status = nil
+		po.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		} else if err == nil || err == errChannelBusy {
+			// a live publisher took over, so wait for them to finish
+			continue
+		} else if err != nil && err != errEmptyPlaylist {
+			slog.Error("playout failed", "channel", name, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(playoutRetry):
+		}
+	}
+}
+
+// waitIdle blocks until nobody is publishing to the channel
+func (m *Manager) waitIdle(ctx context.Context, name string) bool {
+	for {
+		ch := m.channel(name)
+		if ch == nil {
+			return true
+		}
+		changed := ch.changes()
+		if ch.source(false) == nil {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (m *Manager) playOnce(ctx context.Context, name string, po *playout) error {
+	auth, err := model.GetPlayoutAuth(ctx, name)
+	if err != nil {
+		return err
+	}
+	items, err := model.ListPlayoutItems(ctx, name)
+	if err != nil {
+		return err
+	} else if len(items) == 0 {
+		return errEmptyPlaylist
+	}
+	pl := m.newPlaylist(ctx, name, items, po.setItem)
+	defer pl.Close()
+	return m.Publish(auth, playoutKind, playoutKind, pl)
+}
+
+// failoverItems returns the playlist to switch to if the channel's live
+// publisher drops, if it has one
+func (m *Manager) failoverItems(name string) []*model.PlayoutItem {
+	items, err := model.GetFailoverItems(context.Background(), name)
+	if err != nil {
+		slog.Error("getting failover playlist", "channel", name, "err", err)
+	}
+	return items
+}
+
+// runFailover plays a channel's playlist in place of a live publisher that
+// dropped, until they come back or the failover limit is reached
+func (m *Manager) runFailover(auth model.ChannelAuth, items []*model.PlayoutItem) {
+	limit := m.FailoverLimit
+	if limit <= 0 {
+		limit = defaultFailoverLimit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), limit)
+	defer cancel()
+	pl := m.newPlaylist(ctx, auth.Name, items, nil)
+	defer pl.Close()
+	if err := m.Publish(auth, failoverKind, failoverKind, pl); err != nil && err != errChannelBusy {
+		slog.Error("failover failed", "channel", auth.Name, "err", err)
+	}
+}
+
+func (m *Manager) newPlaylist(ctx context.Context, name string, items []*model.PlayoutItem, onItem func(int, *model.PlayoutItem)) *playlist {
+	return &playlist{
+		ctx:    ctx,
+		m:      m,
+		items:  items,
+		idx:    -1,
+		onItem: onItem,
+		onAir: func() bool {
+			ch := m.channel(name)
+			return ch != nil && ch.isPlayout()
+		},
+	}
+}
+
+// playlist plays items one after another through ffmpeg, looping forever.
+// Every item is transcoded to the same settings so the codecs don't change
+// between items, and timestamps are rebased to carry on from the previous one.
+type playlist struct {
+	ctx    context.Context
+	m      *Manager
+	items  []*model.PlayoutItem
+	onItem func(int, *model.PlayoutItem)
+	// onAir returns false once a live publisher has replaced the playlist
+	onAir func() bool
+
+	idx      int
+	cur      av.Demuxer
+	cmd      *exec.Cmd
+	cancel   context.CancelFunc
+	streams  []av.CodecData
+	failures int
+
+	offset, last time.Duration
+	rebase       bool
+}
+
+func (pl *playlist) Streams() ([]av.CodecData, error) {
+	if pl.cur == nil {
+		if err := pl.next(); err != nil {
+			return nil, err
+		}
+	}
+	return pl.streams, nil
+}
+
+func (pl *playlist) ReadPacket() (av.Packet, error) {
+	for {
+		if !pl.onAir() {
+			return av.Packet{}, io.EOF
+		}
+		if pl.cur == nil {
+			if err := pl.next(); err != nil {
+				return av.Packet{}, err
+			}
+		}
+		pkt, err := pl.cur.ReadPacket()
+		if err != nil {
+			if pl.ctx.Err() != nil {
+				return pkt, io.EOF
+			}
+			pl.stop()
+			pl.rebase = true
+			continue
+		}
+		pl.failures = 0
+		if pl.rebase {
+			pl.offset = pl.last + followGap - pkt.Time
+			pl.rebase = false
+		}
+		pkt.Time += pl.offset
+		pl.last = pkt.Time
+		return pkt, nil
+	}
+}
+
+// next starts the following item, skipping any that fail to open. It gives
+// up once every item in a row has failed.
+func (pl *playlist) next() error {
+	for {
+		if pl.failures >= len(pl.items) {
+			return errors.New("no playable items in playlist")
+		} else if pl.ctx.Err() != nil {
+			return io.EOF
+		}
+		pl.idx = (pl.idx + 1) % len(pl.items)
+		item := pl.items[pl.idx]
+		// counts as a failure until the item yields a packet
+		pl.failures++
+		if err := pl.open(item); err != nil {
+			slog.Error("playout item failed", "source", item.Source, "err", err)
+			pl.stop()
+			continue
+		}
+		if pl.onItem != nil {
+			pl.onItem(pl.idx, item)
+		}
+		return nil
+	}
+}
+
+func (pl *playlist) open(item *model.PlayoutItem) error {
+	input, protocols, err := pl.m.playoutInput(item.Source)
+	if err != nil {
+		return err
+	}
+	var ctx context.Context
+	ctx, pl.cancel = context.WithCancel(pl.ctx)
+	still := isStill(item.Source)
+	audio := !still && hasAudio(ctx, input, protocols)
+	pl.cmd = exec.CommandContext(ctx, "ffmpeg", playoutArgs(input, protocols, still, audio)...)
+	pl.cmd.Stderr = log.Writer()
+	stdout, err := pl.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := pl.cmd.Start(); err != nil {
+		return err
+	}
+	dm := flv.NewDemuxer(bufio.NewReader(stdout))
+	streams, err := dm.Streams()
+	if err != nil {
+		return err
+	}
+	if pl.streams == nil {
+		pl.streams = streams
+	} else if !sameCodecs(pl.streams, streams) {
+		return errors.New("item has different streams to the rest of the playlist")
+	}
+	pl.cur = dm
+	return nil
+}
+
+// stop ends the current item's ffmpeg
+func (pl *playlist) stop() {
+	if pl.cancel != nil {
+		pl.cancel()
+		pl.cancel = nil
+	}
+	if pl.cmd != nil {
+		pl.cmd.Wait()
+		pl.cmd = nil
+	}
+	pl.cur = nil
+}
+
+func (pl *playlist) Close() error {
+	pl.stop()
+	return nil
+}
+
+func sameCodecs(a, b []av.CodecData) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type() != b[i].Type() {
+			return false
+		}
+	}
+	return true
+}
+
+// isStill returns true if a source is an image, which is shown as a slate
+func isStill(source string) bool {
+	if u, err := url.Parse(source); err == nil {
+		source = u.Path
+	}
+	switch strings.ToLower(path.Ext(source)) {
+	case ".png", ".jpg", ".jpeg", ".webp":
+		return true
+	}
+	return false
+}
+
+// silence fills in the audio of images and of videos without any, so that
+// every item has the same streams
+const silence = "anullsrc=channel_layout=stereo:sample_rate=48000"
+
+// hasAudio returns true if an item has an audio track. If it can't be told,
+// the item is assumed to have one.
+func hasAudio(ctx context.Context, input, protocols string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-loglevel", "error",
+		"-protocol_whitelist", protocols,
+		"-select_streams", "a:0",
+		"-show_entries", "stream=index",
+		"-of", "csv=p=0",
+		input,
+	).Output()
+	if err != nil {
+		slog.Warn("probing playout item failed", "err", err)
+		return true
+	}
+	return strings.TrimSpace(string(out)) != ""
+}
+
+// playoutArgs transcodes an item to fixed settings without B-frames so that
+// WebRTC playback keeps working. Images are shown for a minute at a time, and
+// they and videos without audio get silence.
+func playoutArgs(input, protocols string, still, audio bool) []string {
+	args := []string{
+		"-loglevel", "error",
+		"-nostdin",
+		"-re",
+		"-protocol_whitelist", protocols,
+	}
+	if still {
+		args = append(args,
+			"-loop", "1",
+			"-framerate", "30",
+			"-t", "60",
+			"-i", input,
+			"-re",
+			"-f", "lavfi",
+			"-i", silence,
+			"-map", "0:v:0",
+			"-map", "1:a:0",
+			"-shortest",
+		)
+	} else if audio {
+		args = append(args,
+			"-i", input,
+			"-map", "0:v:0",
+			"-map", "0:a:0?",
+		)
+	} else {
+		args = append(args,
+			"-i", input,
+			"-re",
+			"-f", "lavfi",
+			"-i", silence,
+			"-map", "0:v:0",
+			"-map", "1:a:0",
+			"-shortest",
+		)
+	}
+	return append(args,
+		"-vf", "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,fps=30,format=yuv420p",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-level", "4.0",
+		"-b:v", "2500k",
+		"-maxrate", "2500k",
+		"-bufsize", "5000k",
+		"-g", "60",
+		"-bf", "0",
+		"-c:a", "aac",
+		"-ar", "48000",
+		"-ac", "2",
+		"-b:a", "128k",
+		"-f", "flv",
+		"-",
+	)
+}
@@ -0,0 +1,45 @@
+package ingest
+
+import "testing"
+
+// TestPlayoutInput checks that playlist items can't reach files outside the
+// playout directory, and only reach public URLs when the operator allows them
+func TestPlayoutInput(t *testing.T) {
+	files := &Manager{PlayoutDir: "/srv/playout"}
+	urls := &Manager{PlayoutDir: "/srv/playout", PlayoutURLs: true}
+	for _, tc := range []struct {
+		m      *Manager
+		source string
+		input  string
+	}{
+		{files, "show.mp4", "file:/srv/playout/show.mp4"},
+		{files, "shows/one.mp4", "file:/srv/playout/shows/one.mp4"},
+		{files, "shows/../two.mp4", "file:/srv/playout/two.mp4"},
+		{files, "", ""},
+		{files, "..", ""},
+		{files, "../etc/passwd", ""},
+		{files, "shows/../../etc/passwd", ""},
+		{files, "/etc/passwd", ""},
+		{files, "http://93.184.216.34/show.mp4", ""},
+		{&Manager{PlayoutURLs: true}, "show.mp4", ""},
+		{&Manager{}, "show.mp4", ""},
+		{urls, "http://93.184.216.34/show.mp4", "http://93.184.216.34/show.mp4"},
+		{urls, "https://[2606:2800:220:1::1]/show.mp4", "https://[2606:2800:220:1::1]/show.mp4"},
+		{urls, "http://127.0.0.1/show.mp4", ""},
+		{urls, "http://10.1.2.3:8080/show.mp4", ""},
+		{urls, "http://169.254.169.254/latest/meta-data", ""},
+		{urls, "http://[::1]/show.mp4", ""},
+		{urls, "http:///show.mp4", ""},
+	} {
+		input, _, err := tc.m.playoutInput(tc.source)
+		if tc.input == "" {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", tc.source, input)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %s", tc.source, err)
+		} else if input != tc.input {
+			t.Errorf("%q: got %q, want %q", tc.source, input, tc.input)
+		}
+	}
+}
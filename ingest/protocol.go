@@ -0,0 +1,78 @@
+package ingest
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nareix/joy4/av"
+)
+
+// Protocol accepts publishers over one ingest protocol, such as RTMP. Each
+// lives in its own package and only has to authenticate publishers and turn
+// their media into an av.Demuxer for Manager.Publish, so new protocols don't
+// need changes to the channel pipeline.
+type Protocol interface {
+	// Name is the kind the protocol publishes as
+	Name() string
+	// Codecs lists the codecs the protocol can carry, by the names in
+	// SupportedCodecs
+	Codecs() []string
+	// Serve accepts publishers until the listener fails
+	Serve() error
+}
+
+// AddProtocol registers an ingest protocol, which must already be set up to
+// publish to m
+func (m *Manager) AddProtocol(p Protocol) {
+	m.protoMu.Lock()
+	defer m.protoMu.Unlock()
+	m.protocols = append(m.protocols, p)
+}
+
+// Protocols lists the registered ingest protocols
+func (m *Manager) Protocols() []Protocol {
+	m.protoMu.Lock()
+	defer m.protoMu.Unlock()
+	return slices.Clone(m.protocols)
+}
+
+func (m *Manager) protocol(kind string) Protocol {
+	for _, p := range m.Protocols() {
+		if p.Name() == kind {
+			return p
+		}
+	}
+	return nil
+}
+
+// AcceptedCodecs lists the codecs a publisher may use over a protocol: those
+// the protocol can carry that the limits allow
+func (m *Manager) AcceptedCodecs(kind string) []string {
+	allowed := m.Limits.Codecs
+	if len(allowed) == 0 {
+		allowed = SupportedCodecs
+	}
+	p := m.protocol(kind)
+	if p == nil {
+		return allowed
+	}
+	var accepted []string
+	for _, codec := range p.Codecs() {
+		if slices.Contains(allowed, codec) {
+			accepted = append(accepted, codec)
+		}
+	}
+	return accepted
+}
+
+// negotiateCodecs returns an error if a publisher's streams use codecs that
+// can't be accepted over its protocol
+func (m *Manager) negotiateCodecs(kind string, streams []av.CodecData) error {
+	accepted := m.AcceptedCodecs(kind)
+	for _, stream := range streams {
+		if name := codecName(stream.Type()); !slices.Contains(accepted, name) {
+			return fmt.Errorf("codec %s is not allowed over %s, use one of %v", name, kind, accepted)
+		}
+	}
+	return nil
+}
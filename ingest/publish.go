@@ -3,7 +3,8 @@ package ingest
 import (
 	"context"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"sync/atomic"
 	"time"
 
@@ -26,7 +27,37 @@ func (m *Manager) Publish(auth model.ChannelAuth, kind, remote string, src av.De
 	if err != nil {
 		return errors.Wrap(err, "reading streams")
 	}
+	live := kind != playoutKind && kind != failoverKind
+	if live {
+		if m.channel(name).isKicked() {
+			return errKicked
+		}
+		if m.Banned(auth.UserID, net.ParseIP(remote)) {
+			return errBanned
+		}
+		if err := checkChannelAddr(auth, remote); err != nil {
+			return err
+		}
+		if err := m.negotiateCodecs(kind, streams); err != nil {
+			return err
+		}
+		if err := m.Limits.checkStreams(streams); err != nil {
+			return err
+		}
+		if m.PublishHook != nil {
+			if err := m.PublishHook.Check(auth, kind, remote); err != nil {
+				return err
+			}
+		}
+		if m.LivePublisher != nil {
+			m.LivePublisher(auth, kind, remote)
+		}
+	}
 	q := pubsub.NewQueue()
+	if m.TSPrebuffer >= 2 {
+		// keep enough GOPs for delayed viewers plus the one in progress
+		q.SetMaxGopCount(m.TSPrebuffer + 1)
+	}
 	q.WriteHeader(streams)
 	eg, ctx := errgroup.WithContext(context.Background())
 	go func() {
@@ -34,42 +65,115 @@ func (m *Manager) Publish(auth model.ChannelAuth, kind, remote string, src av.De
 		q.Close()
 	}()
 	// grab keyframes for thumbnail
-	grabch, err := grabber.Grab(name, q.Latest())
+	grabch, err := grabber.Grab(name, q.Latest(), m.Thumbs)
 	if err != nil {
 		return errors.Wrap(err, "setting up frame grabber")
 	}
-	aacq := q
-	opusq := q
-	switch audioType(streams) {
-	case opus.OPUS, 0:
-	default:
-		opusq = convertOpus(eg, q, m.OpusBitrate)
-	}
 
 	// go live
 	v, _ := m.channels.LoadOrStore(name, new(channel))
 	ch := v.(*channel)
-	p := ch.setStream(q, aacq, opusq, m.WorkDir)
+	// Opus passes through, while AAC is only converted when someone needs it
+	var conv *opusConverter
+	switch audioType(streams) {
+	case opus.OPUS, 0:
+	default:
+		conv = newOpusConverter(q, m.OpusBitrate, func() bool {
+			return ch.viewers.countKind("rtc", "rtsp") != 0
+		})
+		defer conv.close()
+	}
+	// a failover playlist belongs to the live session it is covering for
+	inSession := kind != playoutKind
+	p, resumed, ok := ch.setStream(q, conv, m.newHLS, kind)
+	if !ok {
+		q.Close()
+		eg.Wait()
+		return errChannelBusy
+	}
+	if live {
+		ch.setPublisher(auth.UserID)
+	}
+	if m.HLSEncrypt {
+		ch.encryptHLS(m.HLSKeyRotation)
+	}
+	if live && !resumed {
+		sessionID, err := model.StartSession(context.Background(), name, kind)
+		if err != nil {
+			slog.Error("recording session", "channel", name, "err", err)
+		}
+		ch.startSession(sessionID)
+	}
+	// UDP protocols can tell how much the publisher lost on the way
+	lossy, _ := src.(interface{ Dropped() int64 })
 	defer func() {
-		log.Printf("[%s] publish of %s stopped", kind, auth.Name)
-		ch.stopStream(q)
-		if m.PublishEvent != nil {
-			m.PublishEvent(auth, false, grabber.Result{})
+		if lossy != nil {
+			ch.addDropped(lossy.Dropped())
+		}
+		slog.Info("publish stopped", "proto", kind, "channel", auth.Name, "user_id", auth.UserID)
+		grace := m.ReconnectGrace
+		var failover []*model.PlayoutItem
+		if live && ch.isKicked() {
+			grace = 0
+		} else if live {
+			failover = m.failoverItems(name)
+			if len(failover) != 0 && grace < failoverStartup {
+				// hold the channel until the playlist is up
+				grace = failoverStartup
+			}
+		}
+		stopping := ch.stopStream(q, grace, func() {
+			slog.Info("channel offline", "proto", kind, "channel", auth.Name)
+			sessionID, peak, unique := ch.session()
+			if inSession && sessionID != 0 {
+				sess, err := model.EndSession(context.Background(), sessionID, peak, unique, ch.sessionTotals())
+				if err != nil {
+					slog.Error("recording session", "channel", name, "err", err)
+				} else if m.SessionEnded != nil {
+					m.SessionEnded(auth, sess)
+				}
+			}
+			if m.PublishEvent != nil {
+				m.PublishEvent(auth, false, grabber.Result{})
+			}
+		})
+		if stopping && len(failover) != 0 {
+			go m.runFailover(auth, failover)
 		}
 	}()
-	// announce
-	log.Printf("[%s] user %s started publishing to %s from %s", kind, auth.UserID, auth.Name, remote)
-	if m.PublishEvent != nil {
-		m.PublishEvent(auth, true, grabber.Result{})
+	if kind == failoverKind {
+		slog.Info("failover playlist started", "proto", kind, "channel", auth.Name)
+	} else if !live {
+		slog.Info("playlist started", "proto", kind, "channel", auth.Name)
+	} else if resumed {
+		// still live from the viewers' point of view so don't announce again
+		slog.Info("publish resumed", "proto", kind, "channel", auth.Name, "user_id", auth.UserID, "remote_addr", remote)
+	} else {
+		slog.Info("publish started", "proto", kind, "channel", auth.Name, "user_id", auth.UserID, "remote_addr", remote)
+		if m.PublishEvent != nil {
+			m.PublishEvent(auth, true, grabber.Result{})
+		}
 	}
 	// start outputs
+	m.startRestreams(ctx, name, q)
+	if live && auth.AutoCaptions && m.Transcriber != nil {
+		track := ch.transcriptTrack()
+		eg.Go(func() error {
+			m.transcribe(ctx, name, q.Latest(), track)
+			return nil
+		})
+	}
 	eg.Go(func() error {
 		return errors.Wrap(avutil.CopyFile(p, q.Latest()), "hls publish")
 	})
 	eg.Go(func() error {
 		// notify ws clients when thumbnail is updated
 		for thumb := range grabch {
-			ch.countHLSViewers()
+			if sessionID, peak, unique := ch.updatePeak(); inSession && sessionID != 0 {
+				if err := model.UpdateSession(context.Background(), sessionID, peak, unique); err != nil {
+					slog.Error("recording session", "channel", name, "err", err)
+				}
+			}
 			if m.PublishEvent != nil {
 				m.PublishEvent(auth, true, thumb)
 			}
@@ -82,10 +186,25 @@ func (m *Manager) Publish(auth model.ChannelAuth, kind, remote string, src av.De
 		return nil
 	})
 	// copy
-	eg.Go(func() error { return ch.copyStream(q, src) })
+	if live {
+		src = &tracer{Demuxer: src, ch: ch, channel: name, protocol: kind, streams: streams}
+	}
+	src = &timeFixer{Demuxer: src, channel: name}
+	if live {
+		src = m.Limits.checkLimits(src, streams)
+	}
+	eg.Go(func() error { return ch.copyStream(q, src, live) })
 	return eg.Wait()
 }
 
+func (m *Manager) newHLS() *hls.Publisher {
+	return &hls.Publisher{
+		WorkDir:         m.WorkDir,
+		InitialDuration: m.HLSTargetDuration,
+		BufferLength:    m.HLSWindow,
+	}
+}
+
 func (m *Manager) Cleanup() {
 	m.channels.Range(func(k, v interface{}) bool {
 		v.(*channel).cleanup()
@@ -93,41 +212,113 @@ func (m *Manager) Cleanup() {
 	})
 }
 
-func (ch *channel) setStream(q, aacq, opusq *pubsub.Queue, workDir string) *hls.Publisher {
+// setStream switches the channel to a new ingest queue. resumed is true if the
+// live session carries on, either because the previous publisher is within its
+// reconnect grace period or because it is being replaced. Playlists never
+// replace a live publisher, except for a failover playlist taking over from one
+// that has dropped. ok is false if the new publisher was refused.
+func (ch *channel) setStream(q *pubsub.Queue, conv *opusConverter, newHLS func() *hls.Publisher, kind string) (p *hls.Publisher, resumed, ok bool) {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
+	switch {
+	case kind == failoverKind:
+		if ch.ingest == nil || ch.playlist != "" || ch.pendingStop == nil {
+			return nil, false, false
+		}
+		resumed = true
+	case kind == playoutKind:
+		if ch.ingest != nil && ch.playlist != playoutKind {
+			return nil, false, false
+		}
+	case ch.ingest != nil:
+		// a live publisher taking over from an idle playlist is a fresh start
+		resumed = ch.playlist != playoutKind
+	}
 	if ch.ingest != nil {
 		ch.ingest.Close()
 	}
+	ch.playlist = ""
+	if kind == playoutKind || kind == failoverKind {
+		ch.playlist = kind
+	}
+	if ch.pendingStop != nil {
+		ch.pendingStop.Stop()
+		ch.pendingStop = nil
+	}
 	ch.ingest = q
-	ch.aac = aacq
-	ch.opus = opusq
-	if ch.hls != nil {
+	ch.peakBitrate = 0
+	ch.aac = q
+	ch.opus = q
+	ch.opusConv = conv
+	if conv != nil {
+		ch.opus = conv.out
+	}
+	restart := ch.hls != nil
+	ch.timestamps = new(timestampMap)
+	if restart {
 		// stream restarted so viewer should reset their decoder
 		ch.hls.Discontinuity()
+		ch.captions.restart(ch.timestamps)
 	} else {
-		ch.hls = &hls.Publisher{WorkDir: workDir}
+		ch.hls = newHLS()
+		ch.captions = newCaptionTrack(SubtitlePlaylist, ch.hls.BufferLength, ch.timestamps)
+		ch.metadata = newMetadataTrack()
+		ch.clock = newSegmentClock()
 	}
+	go ch.mapTimestamps(ch.hls, ch.timestamps, restart)
 	ch.stoppedAt = time.Time{}
 	atomic.StoreUintptr(&ch.live, 1)
-	return ch.hls
+	ch.notify()
+	return ch.hls, resumed, true
 }
 
-func (ch *channel) stopStream(q *pubsub.Queue) {
+// stopStream takes the channel offline after the publisher of q goes away,
+// unless another publisher has already replaced it, in which case it returns
+// false. If grace is set the channel stays live with the last GOP held until
+// the grace period expires without a new publisher. finish is called once the
+// channel is offline.
+func (ch *channel) stopStream(q *pubsub.Queue, grace time.Duration, finish func()) bool {
 	ch.mu.Lock()
-	defer ch.mu.Unlock()
 	if ch.ingest != q {
-		return
+		ch.mu.Unlock()
+		return false
+	}
+	if grace > 0 {
+		ch.pendingStop = time.AfterFunc(grace, func() { ch.stopStream(q, 0, finish) })
+		ch.notify()
+		ch.mu.Unlock()
+		return true
 	}
 	atomic.StoreUintptr(&ch.live, 0)
 	ch.ingest = nil
 	ch.aac = nil
 	ch.opus = nil
+	ch.opusConv = nil
+	ch.playlist = ""
+	ch.pendingStop = nil
 	ch.stoppedAt = time.Now()
+	ch.notify()
+	ch.mu.Unlock()
+	finish()
+	return true
 }
 
-func (ch *channel) copyStream(dest *pubsub.Queue, src av.Demuxer) error {
+// copyStream feeds packets from the publisher to the channel. A live
+// publisher stops if it is kicked.
+func (ch *channel) copyStream(dest *pubsub.Queue, src av.Demuxer, live bool) error {
 	defer dest.Close()
+	meter := bitrateMeter{window: segmentBitrateWindow}
+	ch.mu.Lock()
+	cc, timestamps := ch.captions, ch.timestamps
+	ch.mu.Unlock()
+	video := -1
+	if streams, err := src.Streams(); err == nil {
+		for i, stream := range streams {
+			if stream.Type() == av.H264 {
+				video = i
+			}
+		}
+	}
 	for {
 		pkt, err := src.ReadPacket()
 		if err == io.EOF {
@@ -135,9 +326,21 @@ func (ch *channel) copyStream(dest *pubsub.Queue, src av.Demuxer) error {
 		} else if err != nil {
 			return err
 		}
+		if rate := meter.add(pkt); rate > 0 {
+			ch.notePeakBitrate(rate)
+		}
+		if int(pkt.Idx) == video && pkt.IsKeyFrame {
+			timestamps.start(pkt.Time)
+		}
+		if int(pkt.Idx) == video && cc != nil {
+			cc.add(pkt)
+		}
 		if err := dest.WritePacket(pkt); err != nil {
 			return err
 		}
+		if live && ch.isKicked() {
+			return errKicked
+		}
 	}
 }
 
@@ -146,6 +349,12 @@ func (ch *channel) cleanup() {
 	if ch.hls != nil && !ch.stoppedAt.IsZero() && time.Since(ch.stoppedAt) > hlsExpiry {
 		ch.hls.Close()
 		ch.hls = nil
+		ch.captions = nil
+		ch.transcript = nil
+		ch.metadata = nil
+		ch.clock = nil
+		ch.keys = nil
+		ch.timestamps = nil
 	}
 	ch.mu.Unlock()
 }
@@ -158,15 +367,3 @@ func audioType(streams []av.CodecData) av.CodecType {
 	}
 	return 0
 }
-
-func convertOpus(eg *errgroup.Group, q *pubsub.Queue, bitrate int) *pubsub.Queue {
-	if bitrate == 0 {
-		bitrate = 128000
-	}
-	ret := pubsub.NewQueue()
-	eg.Go(func() error {
-		defer ret.Close()
-		return errors.Wrap(opus.Convert(q.Latest(), ret, bitrate), "opus conversion")
-	})
-	return ret
-}
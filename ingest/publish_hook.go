@@ -0,0 +1,97 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+)
+
+const defaultPublishHookTimeout = 5 * time.Second
+
+// PublishHook asks an external service whether a live publisher may go on
+// air. The service gets a JSON description of the attempt and allows it by
+// responding with any 2xx status.
+type PublishHook struct {
+	URL     string
+	Timeout time.Duration
+	// FailOpen allows publishing when the service can't be reached or fails,
+	// rather than refusing it
+	FailOpen bool
+}
+
+type publishAttempt struct {
+	Channel        string `json:"channel"`
+	UserID         string `json:"user_id"`
+	KeyFingerprint string `json:"key_fingerprint"`
+	PrevKey        bool   `json:"prev_key"`
+	Protocol       string `json:"protocol"`
+	RemoteAddr     string `json:"remote_addr"`
+}
+
+// PublishDeniedError is returned when the hook refuses a publisher
+type PublishDeniedError struct {
+	Reason string
+}
+
+func (e *PublishDeniedError) Error() string {
+	if e.Reason == "" {
+		return "publish denied by hook"
+	}
+	return "publish denied by hook: " + e.Reason
+}
+
+// Check returns nil if the publisher is allowed
+func (h *PublishHook) Check(auth model.ChannelAuth, kind, remote string) error {
+	err := h.check(auth, kind, remote)
+	if _, denied := err.(*PublishDeniedError); err != nil && !denied {
+		if h.FailOpen {
+			slog.Warn("publish hook failed, allowing publish", "channel", auth.Name, "err", err)
+			return nil
+		}
+		slog.Error("publish hook failed, refusing publish", "channel", auth.Name, "err", err)
+	}
+	return err
+}
+
+func (h *PublishHook) check(auth model.ChannelAuth, kind, remote string) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultPublishHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	blob, _ := json.Marshal(publishAttempt{
+		Channel:        auth.Name,
+		UserID:         auth.UserID,
+		KeyFingerprint: auth.KeyFingerprint,
+		PrevKey:        auth.PrevKey,
+		Protocol:       kind,
+		RemoteAddr:     remote,
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", h.URL, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &PublishDeniedError{Reason: strings.TrimSpace(string(body))}
+	}
+	return fmt.Errorf("HTTP %s from publish hook", resp.Status)
+}
@@ -0,0 +1,205 @@
+package ingest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"eaglesong.dev/gunk/internal"
+	"eaglesong.dev/gunk/model"
+	"github.com/nareix/joy4/av/pubsub"
+	"github.com/nareix/joy4/format/rtmp"
+	"github.com/pkg/errors"
+)
+
+const (
+	restreamDialTimeout = 10 * time.Second
+	restreamMinBackoff  = time.Second
+	restreamMaxBackoff  = time.Minute
+)
+
+// Restream states
+const (
+	RestreamConnecting = "connecting"
+	RestreamLive       = "live"
+	RestreamRetrying   = "retrying"
+	RestreamStopped    = "stopped"
+)
+
+// RestreamStatus is the health of a push to a single restream target
+type RestreamStatus struct {
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+	Since   int64  `json:"since"`
+	Retries int    `json:"retries"`
+}
+
+type restream struct {
+	mu     sync.Mutex
+	status RestreamStatus
+	cancel context.CancelFunc
+}
+
+// RestreamStatus returns the health of a restream target, or nil if it hasn't
+// been pushed to since startup
+func (m *Manager) RestreamStatus(targetID int64) *RestreamStatus {
+	v, _ := m.restreams.Load(targetID)
+	if v == nil {
+		return nil
+	}
+	r := v.(*restream)
+	r.mu.Lock()
+	status := r.status
+	r.mu.Unlock()
+	return &status
+}
+
+// startRestreams forks the stream to each of the channel's enabled targets
+// until ctx is cancelled
+func (m *Manager) startRestreams(ctx context.Context, name string, q *pubsub.Queue) {
+	targets, err := model.EnabledRestreamTargets(ctx, name)
+	if err != nil {
+		slog.Error("getting restream targets", "channel", name, "err", err)
+		return
+	}
+	for _, target := range targets {
+		uri, err := m.restreamURL(target)
+		if err != nil {
+			slog.Error("invalid restream target", "channel", name, "target", target.ID, "err", err)
+			continue
+		}
+		r := new(restream)
+		var tctx context.Context
+		tctx, r.cancel = context.WithCancel(ctx)
+		m.restreams.Store(target.ID, r)
+		go r.run(tctx, name, target.Label, uri, q)
+	}
+}
+
+// StopRestream stops pushing to a target that was disabled or deleted while
+// the channel is live
+func (m *Manager) StopRestream(targetID int64) {
+	v, _ := m.restreams.Load(targetID)
+	if v != nil {
+		v.(*restream).cancel()
+	}
+}
+
+// TestRestream checks that a target accepts a connection
+func (m *Manager) TestRestream(target *model.RestreamTarget) error {
+	uri, err := m.restreamURL(target)
+	if err != nil {
+		return err
+	}
+	conn, err := dialRestream(uri)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dialRestream connects to a restream target, which channel owners choose, so
+// it must be on a public address
+func dialRestream(uri string) (*rtmp.Conn, error) {
+	u, err := rtmp.ParseURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{
+		Timeout: restreamDialTimeout,
+		Control: internal.PublicOnly,
+	}
+	netconn, err := dialer.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	conn := rtmp.NewConn(netconn)
+	conn.URL = u
+	return conn, nil
+}
+
+func (m *Manager) restreamURL(target *model.RestreamTarget) (string, error) {
+	key, ok := internal.Open(&m.SecretKey, target.SealedKey)
+	if !ok {
+		return "", errors.New("stream key could not be unsealed")
+	}
+	return strings.TrimSuffix(target.URL, "/") + "/" + string(key), nil
+}
+
+func (r *restream) set(state string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.State = state
+	r.status.Error = ""
+	if err != nil {
+		r.status.Error = err.Error()
+		r.status.Retries++
+	}
+	r.status.Since = time.Now().UnixNano() / 1000000
+}
+
+// run pushes to the target, reconnecting with backoff, until the source ends or
+// ctx is cancelled
+func (r *restream) run(ctx context.Context, name, label, uri string, q *pubsub.Queue) {
+	backoff := restreamMinBackoff
+	for {
+		r.set(RestreamConnecting, nil)
+		started := time.Now()
+		err := r.push(ctx, uri, q)
+		if err == nil || ctx.Err() != nil {
+			r.set(RestreamStopped, nil)
+			return
+		}
+		// the key is part of the URL so only the label is logged
+		slog.Warn("restream failed", "channel", name, "target", label, "err", err)
+		r.set(RestreamRetrying, err)
+		if time.Since(started) > restreamMaxBackoff {
+			backoff = restreamMinBackoff
+		}
+		select {
+		case <-ctx.Done():
+			r.set(RestreamStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > restreamMaxBackoff {
+			backoff = restreamMaxBackoff
+		}
+	}
+}
+
+func (r *restream) push(ctx context.Context, uri string, q *pubsub.Queue) error {
+	conn, err := dialRestream(uri)
+	if err != nil {
+		return errors.Wrap(err, "connecting")
+	}
+	defer conn.Close()
+	src := q.Latest()
+	streams, err := src.Streams()
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteHeader(streams); err != nil {
+		return errors.Wrap(err, "writing header")
+	}
+	r.set(RestreamLive, nil)
+	for {
+		pkt, err := src.ReadPacket()
+		if err == io.EOF || ctx.Err() != nil {
+			// source ended or the push was stopped, so don't retry even if
+			// the trailer fails
+			conn.WriteTrailer()
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := conn.WritePacket(pkt); err != nil {
+			return errors.Wrap(err, "writing packet")
+		}
+	}
+}
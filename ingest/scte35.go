@@ -0,0 +1,228 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scte35Scheme marks event messages that carry a binary SCTE-35
+// splice_info_section, per SCTE 214-3
+const scte35Scheme = "urn:scte:scte35:2013:bin"
+
+// ErrInvalidCue is returned for raw cues that aren't a well-formed section
+var ErrInvalidCue = errors.New("invalid SCTE-35 splice_info_section")
+
+// Cue is a SCTE-35 splice for downstream ad insertion or chaptering. Either
+// Raw is set or the cue is an immediate splice_insert built from the other
+// fields.
+type Cue struct {
+	// Raw is a complete splice_info_section from upstream tooling, sent on
+	// unchanged
+	Raw []byte
+	// Out starts a break, otherwise the cue returns to the program
+	Out bool
+	// Duration is the expected length of a break, if known
+	Duration time.Duration
+	// EventID identifies the break. Zero picks one for a break out, or uses
+	// the channel's last break for a return.
+	EventID uint32
+}
+
+// InsertCue adds a SCTE-35 cue to a channel's live HLS stream, at the start of
+// the newest segment, and marks it in the media playlist with EXT-X-DATERANGE
+// and EXT-X-CUE-OUT or EXT-X-CUE-IN. It returns the splice event ID.
+func (m *Manager) InsertCue(name string, cue Cue) (uint32, error) {
+	t, err := m.liveMetadata(name)
+	if err != nil {
+		return 0, err
+	}
+	if cue.Raw != nil {
+		if !validSpliceInfo(cue.Raw) {
+			return 0, ErrInvalidCue
+		}
+		t.addCue(scte35Scheme, cue.Raw, rawSplice(cue.Raw))
+		return 0, nil
+	}
+	t.mu.Lock()
+	switch {
+	case cue.EventID != 0:
+	case cue.Out || t.lastSplice == 0:
+		cue.EventID = uint32(time.Now().Unix())
+	default:
+		cue.EventID = t.lastSplice
+	}
+	t.lastSplice = cue.EventID
+	t.mu.Unlock()
+	sec := cue.spliceInsert()
+	t.addCue(scte35Scheme, sec, &spliceCue{section: sec, insert: true, out: cue.Out, eventID: cue.EventID, duration: cue.Duration})
+	return cue.EventID, nil
+}
+
+// spliceCue is what the playlist needs to know about a cue
+type spliceCue struct {
+	section []byte
+	// insert is set for splice_insert commands, which are breaks out of or
+	// back to the program. Other commands are passed on as they are.
+	insert   bool
+	out      bool
+	eventID  uint32
+	duration time.Duration
+	// date is when the segment it went in started, once a playlist has said
+	date time.Time
+}
+
+// rawSplice reads enough of a splice_info_section from upstream to tag the
+// playlist with it
+func rawSplice(sec []byte) *spliceCue {
+	cue := &spliceCue{section: sec}
+	// encrypted sections can only be passed on
+	if len(sec) >= 24 && sec[4]&0x80 == 0 && sec[13] == 0x05 && sec[18]&0x80 == 0 {
+		cue.insert = true
+		cue.eventID = binary.BigEndian.Uint32(sec[14:])
+		cue.out = sec[19]&0x80 != 0
+	}
+	return cue
+}
+
+// tag marks the cues in a media playlist's segments. A break is an
+// EXT-X-DATERANGE with SCTE35-OUT, and the return to the program repeats it
+// with SCTE35-IN and the time it ended. Other commands get a DATERANGE of
+// their own with SCTE35-CMD.
+func (t *metadataTrack) tag(p *mediaPlaylist) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, seg := range p.segments {
+		if seg.uri == "" {
+			continue
+		}
+		for _, md := range t.attached[resourceName(seg.uri)] {
+			cue := md.cue
+			if cue == nil {
+				continue
+			}
+			if cue.date.IsZero() {
+				cue.date = seg.date
+			}
+			for _, line := range cue.tags(t.breakStart(cue)) {
+				p.insert(seg.start, line)
+			}
+		}
+	}
+}
+
+// breakStart returns when the break a return to the program ends started, or
+// the return's own date if the break isn't known. The caller holds t.mu.
+func (t *metadataTrack) breakStart(cue *spliceCue) time.Time {
+	if !cue.insert || cue.out {
+		return cue.date
+	}
+	for i := len(t.order) - 1; i >= 0; i-- {
+		for _, md := range t.attached[t.order[i]] {
+			if c := md.cue; c != nil && c.insert && c.out && c.eventID == cue.eventID && !c.date.IsZero() && !c.date.After(cue.date) {
+				return c.date
+			}
+		}
+	}
+	return cue.date
+}
+
+func (cue *spliceCue) tags(start time.Time) []string {
+	var tags []string
+	if !start.IsZero() {
+		attrs := []string{fmt.Sprintf(`ID="splice-%d"`, cue.eventID), `START-DATE="` + start.UTC().Format(dateFormat) + `"`}
+		section := "0x" + strings.ToUpper(hex.EncodeToString(cue.section))
+		switch {
+		case !cue.insert:
+			attrs[0] = fmt.Sprintf(`ID="splice-%x"`, crc32MPEG(cue.section))
+			attrs = append(attrs, "SCTE35-CMD="+section)
+		case cue.out:
+			if cue.duration > 0 {
+				attrs = append(attrs, fmt.Sprintf("PLANNED-DURATION=%.3f", cue.duration.Seconds()))
+			}
+			attrs = append(attrs, "SCTE35-OUT="+section)
+		default:
+			attrs = append(attrs, `END-DATE="`+cue.date.UTC().Format(dateFormat)+`"`, "SCTE35-IN="+section)
+		}
+		tags = append(tags, "#EXT-X-DATERANGE:"+strings.Join(attrs, ","))
+	}
+	switch {
+	case !cue.insert:
+	case cue.out && cue.duration > 0:
+		tags = append(tags, fmt.Sprintf("#EXT-X-CUE-OUT:%.3f", cue.duration.Seconds()))
+	case cue.out:
+		tags = append(tags, "#EXT-X-CUE-OUT")
+	default:
+		tags = append(tags, "#EXT-X-CUE-IN")
+	}
+	return tags
+}
+
+// spliceInsert builds a splice_info_section holding an immediate
+// splice_insert command for the whole program
+func (cue Cue) spliceInsert() []byte {
+	cmd := binary.BigEndian.AppendUint32(nil, cue.EventID)
+	cmd = append(cmd, 0x7f)           // not cancelled
+	flags := byte(0x40 | 0x10 | 0x0f) // program splice, immediate
+	if cue.Out {
+		flags |= 0x80
+	}
+	if cue.Duration > 0 {
+		flags |= 0x20
+	}
+	cmd = append(cmd, flags)
+	if cue.Duration > 0 {
+		ticks := uint64(cue.Duration * 90000 / time.Second)
+		// auto_return, then a 33 bit duration
+		cmd = append(cmd, 0x80|0x7e|byte(ticks>>32&1))
+		cmd = binary.BigEndian.AppendUint32(cmd, uint32(ticks))
+	}
+	cmd = append(cmd, 0, 0, 0, 0) // unique_program_id, avail_num, avails_expected
+
+	sec := []byte{0xfc, 0, 0}
+	sec = append(sec, 0)             // protocol_version
+	sec = append(sec, 0, 0, 0, 0, 0) // not encrypted, no pts_adjustment
+	sec = append(sec, 0)             // cw_index
+	// tier 0xfff and the command length
+	sec = append(sec, 0xff, 0xf0|byte(len(cmd)>>8), byte(len(cmd)))
+	sec = append(sec, 0x05) // splice_insert
+	sec = append(sec, cmd...)
+	sec = append(sec, 0, 0) // no descriptors
+	length := len(sec) - 3 + 4
+	sec[1] = 0x30 | byte(length>>8&0x0f)
+	sec[2] = byte(length)
+	return binary.BigEndian.AppendUint32(sec, crc32MPEG(sec))
+}
+
+// validSpliceInfo checks the table ID, length and CRC of a
+// splice_info_section
+func validSpliceInfo(sec []byte) bool {
+	if len(sec) < 20 || sec[0] != 0xfc {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(sec[1:]) & 0x0fff)
+	if length+3 != len(sec) {
+		return false
+	}
+	return crc32MPEG(sec[:len(sec)-4]) == binary.BigEndian.Uint32(sec[len(sec)-4:])
+}
+
+// crc32MPEG computes the CRC of an MPEG-2 section. hash/crc32 only does the
+// reflected form, so this goes a bit at a time.
+func crc32MPEG(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
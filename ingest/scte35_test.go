@@ -0,0 +1,117 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+// spliceSample is the splice_insert example from section 14.2 of SCTE 35
+const spliceSample = "/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo="
+
+// TestCRC32MPEG checks the CRC against the CRC-32/MPEG-2 check value and the
+// CRC of the sample section
+func TestCRC32MPEG(t *testing.T) {
+	sample, err := base64.StdEncoding.DecodeString(spliceSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		data []byte
+		crc  uint32
+	}{
+		{"check", []byte("123456789"), 0x0376e6e7},
+		{"sample", sample[:len(sample)-4], 0x62dba30a},
+	} {
+		if got := crc32MPEG(tc.data); got != tc.crc {
+			t.Errorf("%s: got %08x, want %08x", tc.name, got, tc.crc)
+		}
+	}
+}
+
+// TestValidSpliceInfo checks that the sample section is accepted and damaged
+// copies of it aren't
+func TestValidSpliceInfo(t *testing.T) {
+	sample, err := base64.StdEncoding.DecodeString(spliceSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(i int) []byte {
+		b := bytes.Clone(sample)
+		b[i] ^= 0x01
+		return b
+	}
+	for _, tc := range []struct {
+		name  string
+		sec   []byte
+		valid bool
+	}{
+		{"sample", sample, true},
+		{"table id", corrupt(0), false},
+		{"length", corrupt(2), false},
+		{"payload", corrupt(20), false},
+		{"crc", corrupt(len(sample) - 1), false},
+		{"truncated", sample[:len(sample)-1], false},
+		{"short", sample[:12], false},
+		{"empty", nil, false},
+	} {
+		if got := validSpliceInfo(tc.sec); got != tc.valid {
+			t.Errorf("%s: got %t, want %t", tc.name, got, tc.valid)
+		}
+	}
+}
+
+// TestRawSplice checks the fields read from the sample section, which is a
+// break out with event ID 0x4800008f
+func TestRawSplice(t *testing.T) {
+	sample, err := base64.StdEncoding.DecodeString(spliceSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cue := rawSplice(sample)
+	if !cue.insert || !cue.out || cue.eventID != 0x4800008f {
+		t.Errorf("got insert=%t out=%t event=%08x, want a break out with event 4800008f", cue.insert, cue.out, cue.eventID)
+	}
+	// other commands, here a time_signal, are passed on without being read
+	other := bytes.Clone(sample)
+	other[13] = 0x06
+	if cue := rawSplice(other); cue.insert {
+		t.Error("time_signal read as a splice_insert")
+	}
+}
+
+// TestSpliceInsert checks built sections byte for byte, and that they read
+// back as what was asked for
+func TestSpliceInsert(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cue  Cue
+		want string
+	}{
+		{
+			"out with duration",
+			Cue{Out: true, Duration: time.Minute, EventID: 0x4800008f},
+			"fc302000000000000000fff00f054800008f7ffffe005265c00000000000001b5945f8",
+		},
+		{
+			"in",
+			Cue{EventID: 0x4800008f},
+			"fc301b00000000000000fff00a054800008f7f5f000000000000778f36f3",
+		},
+	} {
+		sec := tc.cue.spliceInsert()
+		if got := hex.EncodeToString(sec); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+		if !validSpliceInfo(sec) {
+			t.Errorf("%s: built section isn't valid", tc.name)
+		}
+		cue := rawSplice(sec)
+		if !cue.insert || cue.out != tc.cue.Out || cue.eventID != tc.cue.EventID {
+			t.Errorf("%s: read back as insert=%t out=%t event=%08x", tc.name, cue.insert, cue.out, cue.eventID)
+		}
+	}
+}
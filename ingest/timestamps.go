@@ -0,0 +1,67 @@
+package ingest
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+// maxTimeJump is the largest gap between packets that is taken as real rather
+// than as the encoder restarting its clock
+const maxTimeJump = 5 * time.Second
+
+// timeFixer cleans up a publisher's timestamps before they reach the outputs,
+// which all misbehave in their own ways when given broken ones. DTS is made to
+// increase within each stream, jumps in the encoder's clock are closed up, and
+// streams with negative composition offsets have their DTS moved earlier so
+// that no frame is presented before it is decoded.
+type timeFixer struct {
+	av.Demuxer
+	channel string
+
+	offset  time.Duration
+	last    []time.Duration // adjusted DTS of each stream's last packet
+	seen    []bool
+	lead    []time.Duration // how far each stream's DTS is moved earlier
+	latest  time.Duration   // highest adjusted DTS of any stream
+	started bool
+}
+
+func (f *timeFixer) ReadPacket() (av.Packet, error) {
+	pkt, err := f.Demuxer.ReadPacket()
+	if err != nil {
+		return pkt, err
+	}
+	t := pkt.Time + f.offset
+	if d := t - f.latest; f.started && (d > maxTimeJump || d < -maxTimeJump) {
+		// carry on from where the clock was so that all streams stay in step
+		slog.Warn("publisher timestamps jumped", "channel", f.channel, "by", d)
+		f.offset += f.latest + followGap - t
+		t = f.latest + followGap
+	}
+	idx := int(pkt.Idx)
+	for len(f.last) <= idx {
+		f.last = append(f.last, 0)
+		f.seen = append(f.seen, false)
+		f.lead = append(f.lead, 0)
+	}
+	// offsets stay the same relative to each other, which B-frames rely on,
+	// and presentation times don't move
+	if -pkt.CompositionTime > f.lead[idx] {
+		f.lead[idx] = -pkt.CompositionTime
+	}
+	t -= f.lead[idx]
+	pkt.CompositionTime += f.lead[idx]
+	if f.seen[idx] && t <= f.last[idx] {
+		t = f.last[idx] + time.Millisecond
+	}
+	f.last[idx] = t
+	f.seen[idx] = true
+	if t > f.latest {
+		f.latest = t
+	}
+	f.started = true
+	pkt.Time = t
+	return pkt, nil
+}
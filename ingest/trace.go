@@ -0,0 +1,180 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+const (
+	defaultTraceLength = 30 * time.Second
+	maxTraceLength     = 5 * time.Minute
+	// traceBuffer is how many packets may wait to be written before the
+	// trace starts dropping them rather than hold up the publisher
+	traceBuffer = 4096
+)
+
+// traceMagic starts a packet trace. It is followed by records, each a type
+// byte then:
+//
+//	'S': uint32 length and a JSON traceHeader, at the start and whenever the
+//	     publisher reconnects
+//	'P': stream index (1 byte), keyframe flag (1 byte), int64 DTS and int64
+//	     composition offset in nanoseconds, int64 nanoseconds since the trace
+//	     started, uint32 length and the payload
+//
+// All integers are big-endian. The trace holds what the demuxer produced,
+// before timestamps are cleaned up, and nothing from the connection itself
+// such as the stream key or address.
+const traceMagic = "GUNKTRACE1\n"
+
+type traceHeader struct {
+	Channel  string        `json:"channel"`
+	Protocol string        `json:"protocol"`
+	Time     time.Time     `json:"time"`
+	Streams  []traceStream `json:"streams"`
+}
+
+type traceStream struct {
+	Codec string `json:"codec"`
+	// Config is the decoder configuration record for H.264 or the
+	// AudioSpecificConfig for AAC, in hex
+	Config string `json:"config,omitempty"`
+}
+
+// packetTrace collects a publisher's packets for ServeTrace
+type packetTrace struct {
+	records chan []byte
+	started time.Time
+	dropped atomic.Int64
+}
+
+// tracer copies packets from a publisher to the channel's trace, if one is
+// running
+type tracer struct {
+	av.Demuxer
+	ch       *channel
+	channel  string
+	protocol string
+	streams  []av.CodecData
+	current  *packetTrace
+}
+
+func (t *tracer) ReadPacket() (av.Packet, error) {
+	pkt, err := t.Demuxer.ReadPacket()
+	if err != nil {
+		return pkt, err
+	}
+	trace := t.ch.trace.Load()
+	if trace == nil {
+		return pkt, nil
+	}
+	if trace != t.current {
+		t.current = trace
+		trace.send(t.header())
+	}
+	rec := make([]byte, 1+1+1+8+8+8+4, 31+len(pkt.Data))
+	rec[0] = 'P'
+	rec[1] = byte(pkt.Idx)
+	if pkt.IsKeyFrame {
+		rec[2] = 1
+	}
+	binary.BigEndian.PutUint64(rec[3:], uint64(pkt.Time))
+	binary.BigEndian.PutUint64(rec[11:], uint64(pkt.CompositionTime))
+	binary.BigEndian.PutUint64(rec[19:], uint64(time.Since(trace.started)))
+	binary.BigEndian.PutUint32(rec[27:], uint32(len(pkt.Data)))
+	trace.send(append(rec, pkt.Data...))
+	return pkt, nil
+}
+
+func (t *tracer) header() []byte {
+	hdr := traceHeader{Channel: t.channel, Protocol: t.protocol, Time: time.Now().UTC()}
+	for _, stream := range t.streams {
+		ts := traceStream{Codec: codecName(stream.Type())}
+		switch c := stream.(type) {
+		case h264parser.CodecData:
+			ts.Config = hex.EncodeToString(c.AVCDecoderConfRecordBytes())
+		case aacparser.CodecData:
+			ts.Config = hex.EncodeToString(c.MPEG4AudioConfigBytes())
+		}
+		hdr.Streams = append(hdr.Streams, ts)
+	}
+	blob, _ := json.Marshal(hdr)
+	rec := make([]byte, 5, 5+len(blob))
+	rec[0] = 'S'
+	binary.BigEndian.PutUint32(rec[1:], uint32(len(blob)))
+	return append(rec, blob...)
+}
+
+// send queues a record without blocking the publisher
+func (p *packetTrace) send(rec []byte) {
+	select {
+	case p.records <- rec:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// ServeTrace records the packets a channel's live publisher sends for the
+// number of seconds given, up to 5 minutes, and writes them as a download so
+// that problems with odd encoders can be reproduced. It is meant for the
+// operator's debug listener rather than the public site.
+func (m *Manager) ServeTrace(rw http.ResponseWriter, req *http.Request) {
+	name := req.FormValue("channel")
+	ch := m.channel(name)
+	if ch == nil || ch.publisher() == "" {
+		http.Error(rw, "channel is not live", http.StatusNotFound)
+		return
+	}
+	length := defaultTraceLength
+	if v := req.FormValue("seconds"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			http.Error(rw, "invalid seconds", http.StatusBadRequest)
+			return
+		}
+		length = time.Duration(secs) * time.Second
+	}
+	if length > maxTraceLength {
+		length = maxTraceLength
+	}
+	trace := &packetTrace{records: make(chan []byte, traceBuffer), started: time.Now()}
+	if !ch.trace.CompareAndSwap(nil, trace) {
+		http.Error(rw, "a trace of this channel is already running", http.StatusConflict)
+		return
+	}
+	defer func() {
+		ch.trace.Store(nil)
+		slog.Info("ingest trace finished", "channel", name, "dropped_packets", trace.dropped.Load())
+	}()
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.trace", name, trace.started.UTC().Format("20060102T150405Z"))))
+	w := bufio.NewWriter(rw)
+	defer w.Flush()
+	w.WriteString(traceMagic)
+	timer := time.NewTimer(length)
+	defer timer.Stop()
+	for {
+		select {
+		case rec := <-trace.records:
+			if _, err := w.Write(rec); err != nil {
+				return
+			}
+		case <-timer.C:
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
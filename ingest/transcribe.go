@@ -0,0 +1,272 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"eaglesong.dev/gunk/captions"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/format/aac"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// transcribeRate is the sample rate of audio sent to the transcriber,
+	// which is what whisper takes
+	transcribeRate = 16000
+	// transcribeMaxLag is how far behind the transcriber may fall before
+	// chunks are skipped to catch up
+	transcribeMaxLag = 15 * time.Second
+)
+
+// Transcriber turns speech into text for channels that have automatic
+// captions turned on. Audio is given in chunks as 16 kHz mono 16-bit WAV.
+type Transcriber interface {
+	Transcribe(ctx context.Context, wav []byte) (string, error)
+}
+
+// NewTranscriber returns a transcriber for a service URL or a command, such
+// as whisper.cpp's whisper-cli
+func NewTranscriber(v string) Transcriber {
+	if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") {
+		return HTTPTranscriber{URL: v}
+	}
+	return CommandTranscriber{Command: strings.Fields(v)}
+}
+
+// CommandTranscriber runs a program for each chunk with the path of a WAV file
+// appended to its arguments, and takes what it prints as the text, for example
+// "whisper-cli -m ggml-base.en.bin -nt -np -f"
+type CommandTranscriber struct {
+	Command []string
+}
+
+func (t CommandTranscriber) Transcribe(ctx context.Context, wav []byte) (string, error) {
+	f, err := os.CreateTemp("", "gunk-transcribe-*.wav")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(wav); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	args := append(t.Command[1:len(t.Command):len(t.Command)], f.Name())
+	out, err := exec.CommandContext(ctx, t.Command[0], args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", t.Command[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// HTTPTranscriber posts each chunk as the file field of a form, which the
+// whisper.cpp server and OpenAI-style transcription APIs take. The reply may
+// be JSON with a text field, or plain text.
+type HTTPTranscriber struct {
+	URL string
+}
+
+func (t HTTPTranscriber) Transcribe(ctx context.Context, wav []byte) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	w, err := form.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return "", err
+	}
+	w.Write(wav)
+	form.WriteField("response_format", "json")
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	blob, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcriber returned %s", resp.Status)
+	}
+	var reply struct {
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(blob, &reply) != nil {
+		reply.Text = string(blob)
+	}
+	return strings.TrimSpace(reply.Text), nil
+}
+
+type audioChunk struct {
+	start, duration time.Duration
+	wav             []byte
+	cut             time.Time
+}
+
+// transcribe captions a channel's speech into track until src ends. Failures
+// are logged and leave gaps rather than stopping the stream.
+func (m *Manager) transcribe(ctx context.Context, name string, src av.Demuxer, track *captionTrack) {
+	chunks := make(chan audioChunk, 4)
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		defer close(chunks)
+		return decodeChunks(ctx, src, chunks)
+	})
+	eg.Go(func() error {
+		for chunk := range chunks {
+			var cues []captions.Cue
+			if time.Since(chunk.cut) < transcribeMaxLag {
+				text, err := m.Transcriber.Transcribe(ctx, chunk.wav)
+				if err != nil {
+					slog.Warn("transcribing audio", "channel", name, "err", err)
+				} else if text != "" {
+					cues = []captions.Cue{{Start: chunk.start, End: chunk.start + chunk.duration, Text: text}}
+				}
+			}
+			track.addSegment(chunk.duration, cues)
+		}
+		return nil
+	})
+	if err := eg.Wait(); err != nil && ctx.Err() == nil {
+		slog.Error("transcribing audio", "channel", name, "err", err)
+	}
+}
+
+// decodeChunks decodes a stream's AAC audio to mono PCM with ffmpeg and cuts it
+// into chunks the length of a caption segment
+func decodeChunks(ctx context.Context, src av.Demuxer, chunks chan<- audioChunk) error {
+	streams, err := src.Streams()
+	if err != nil {
+		return err
+	}
+	aidx := -1
+	var acodec av.CodecData
+	for i, stream := range streams {
+		if stream.Type() == av.AAC {
+			aidx, acodec = i, stream
+		}
+	}
+	if aidx < 0 {
+		return errors.New("no AAC audio to transcribe")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-loglevel", "warning",
+		"-f", "aac",
+		"-i", "-",
+		"-f", "s16le",
+		"-ac", "1",
+		"-ar", fmt.Sprint(transcribeRate),
+		"-",
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	eg, egctx := errgroup.WithContext(ctx)
+	start := make(chan time.Duration, 1)
+	eg.Go(func() error {
+		defer stdin.Close()
+		muxer := aac.NewMuxer(stdin)
+		if err := muxer.WriteHeader([]av.CodecData{acodec}); err != nil {
+			return err
+		}
+		started := false
+		for egctx.Err() == nil {
+			pkt, err := src.ReadPacket()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if int(pkt.Idx) != aidx {
+				continue
+			}
+			if !started {
+				start <- pkt.Time
+				started = true
+			}
+			if err := muxer.WritePacket(pkt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	eg.Go(func() error {
+		pcm := make([]byte, int(captionSegmentLength/time.Millisecond)*transcribeRate/1000*2)
+		var t time.Duration
+		for first := true; egctx.Err() == nil; first = false {
+			n, err := io.ReadFull(stdout, pcm)
+			if n == 0 {
+				return nil
+			}
+			if first {
+				t = <-start
+			}
+			d := time.Duration(n/2) * time.Second / transcribeRate
+			select {
+			case chunks <- audioChunk{start: t, duration: d, wav: wavFile(pcm[:n]), cut: time.Now()}:
+			case <-egctx.Done():
+				return nil
+			}
+			t += d
+			if err != nil {
+				return nil
+			}
+		}
+		return nil
+	})
+	err = eg.Wait()
+	// ensure ffmpeg is stopped and waited on
+	cancel()
+	cmd.Wait()
+	return err
+}
+
+// wavFile wraps mono 16-bit PCM in a WAV header
+func wavFile(pcm []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(pcm)))
+	b.WriteString("WAVEfmt ")
+	// PCM, mono, 2 bytes per sample
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(transcribeRate), uint32(transcribeRate * 2), uint16(2), uint16(16)} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(pcm)))
+	b.Write(pcm)
+	return b.Bytes()
+}
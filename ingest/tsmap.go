@@ -0,0 +1,230 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"eaglesong.dev/hls"
+)
+
+// timestampMapTimeout is how long to wait for a publisher's first HLS segment
+const timestampMapTimeout = 30 * time.Second
+
+// timestampMap ties the clock of one publisher to the timestamps in the HLS
+// segments made from it, which the segmenter may have moved to follow on from
+// the publisher before. WebVTT segments need it for X-TIMESTAMP-MAP.
+type timestampMap struct {
+	mu sync.Mutex
+	// local is the DTS of the publisher's first video keyframe
+	local   time.Duration
+	started bool
+	// mpegts is the same frame's decode time in the first segment, in 90kHz
+	// units
+	mpegts int64
+	found  bool
+}
+
+// start records the first keyframe
+func (t *timestampMap) start(dts time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.started {
+		t.local, t.started = dts, true
+	}
+}
+
+// get returns the header's values, and false if the first segment hasn't been
+// looked at yet, in which case the segments are assumed to keep the
+// publisher's clock
+func (t *timestampMap) get() (mpegts int64, local time.Duration, ok bool) {
+	if t == nil {
+		return 0, 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.found {
+		return 0, 0, false
+	}
+	return t.mpegts, t.local, true
+}
+
+// mapTimestamps waits for the first HLS segment made from a publisher and
+// reads its decode time. After a restart that is the first segment after the
+// discontinuity.
+func (ch *channel) mapTimestamps(p *hls.Publisher, t *timestampMap, restart bool) {
+	known := make(map[string]bool)
+	if pl := fetchPlaylist(p); pl != nil {
+		for _, seg := range pl.segments {
+			known[seg.uri] = true
+		}
+	}
+	deadline := time.Now().Add(timestampMapTimeout)
+	for time.Now().Before(deadline) && ch.getHLS() == p {
+		time.Sleep(time.Second)
+		pl := fetchPlaylist(p)
+		if pl == nil {
+			continue
+		}
+		for _, seg := range pl.segments {
+			if seg.uri == "" || known[seg.uri] || restart && !seg.discontinuity {
+				continue
+			}
+			init := ""
+			for _, i := range pl.maps {
+				if i < seg.start {
+					init = playlistAttr(pl.lines[i], "URI")
+				}
+			}
+			decodeTime, ok := segmentDecodeTime(fetchHLS(p, init), fetchHLS(p, seg.uri))
+			if !ok {
+				slog.Debug("no decode time in first HLS segment", "segment", seg.uri)
+				return
+			}
+			t.mu.Lock()
+			t.mpegts, t.found = decodeTime, true
+			t.mu.Unlock()
+			return
+		}
+	}
+}
+
+// memoryResponse collects a response from the HLS publisher
+type memoryResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *memoryResponse) Header() http.Header         { return w.header }
+func (w *memoryResponse) WriteHeader(code int)        { w.code = code }
+func (w *memoryResponse) Write(p []byte) (int, error) { return w.body.Write(p) }
+
+// fetchHLS gets one of the HLS publisher's files, or nil if it isn't there
+func fetchHLS(p *hls.Publisher, uri string) []byte {
+	if uri == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, "/"+resourceName(uri), nil)
+	if err != nil {
+		return nil
+	}
+	w := &memoryResponse{header: make(http.Header)}
+	p.ServeHTTP(w, req)
+	if w.code != 0 && w.code != http.StatusOK {
+		return nil
+	}
+	return w.body.Bytes()
+}
+
+func fetchPlaylist(p *hls.Publisher) *mediaPlaylist {
+	return parseMediaPlaylist(fetchHLS(p, "index.m3u8"))
+}
+
+// segmentDecodeTime finds the decode time of the video in a fragmented MP4
+// segment, in 90kHz units
+func segmentDecodeTime(init, segment []byte) (int64, bool) {
+	var trackID, timescale uint32
+	mp4Boxes(init, func(typ string, body []byte) {
+		if typ != "moov" {
+			return
+		}
+		mp4Boxes(body, func(typ string, body []byte) {
+			if typ != "trak" {
+				return
+			}
+			var id, scale uint32
+			var video bool
+			mp4Boxes(body, func(typ string, body []byte) {
+				switch typ {
+				case "tkhd":
+					id = fullBoxField(body, 12, 20)
+				case "mdia":
+					mp4Boxes(body, func(typ string, body []byte) {
+						switch typ {
+						case "mdhd":
+							scale = fullBoxField(body, 12, 20)
+						case "hdlr":
+							video = len(body) >= 12 && string(body[8:12]) == "vide"
+						}
+					})
+				}
+			})
+			if video && trackID == 0 {
+				trackID, timescale = id, scale
+			}
+		})
+	})
+	if trackID == 0 || timescale == 0 {
+		return 0, false
+	}
+	var decodeTime uint64
+	found := false
+	mp4Boxes(segment, func(typ string, body []byte) {
+		if typ != "moof" || found {
+			return
+		}
+		mp4Boxes(body, func(typ string, body []byte) {
+			if typ != "traf" || found {
+				return
+			}
+			var id uint32
+			var t uint64
+			var hasTime bool
+			mp4Boxes(body, func(typ string, body []byte) {
+				switch {
+				case typ == "tfhd" && len(body) >= 8:
+					id = binary.BigEndian.Uint32(body[4:])
+				case typ == "tfdt" && len(body) >= 8:
+					hasTime = true
+					if body[0] == 1 && len(body) >= 12 {
+						t = binary.BigEndian.Uint64(body[4:])
+					} else {
+						t = uint64(binary.BigEndian.Uint32(body[4:]))
+					}
+				}
+			})
+			if id == trackID && hasTime {
+				decodeTime, found = t, true
+			}
+		})
+	})
+	if !found {
+		return 0, false
+	}
+	return int64(decodeTime * 90000 / uint64(timescale)), true
+}
+
+// mp4Boxes calls fn with the type and body of each box in b
+func mp4Boxes(b []byte, fn func(typ string, body []byte)) {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		header := uint64(8)
+		if size == 1 && len(b) >= 16 {
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		} else if size == 0 {
+			size = uint64(len(b))
+		}
+		if size < header || size > uint64(len(b)) {
+			return
+		}
+		fn(string(b[4:8]), b[header:size])
+		b = b[size:]
+	}
+}
+
+// fullBoxField reads a 32-bit field of a full box, which is at off0 in
+// version 0 boxes and off1 in version 1 boxes
+func fullBoxField(body []byte, off0, off1 int) uint32 {
+	off := off0
+	if len(body) > 0 && body[0] == 1 {
+		off = off1
+	}
+	if len(body) < off+4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(body[off:])
+}
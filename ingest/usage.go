@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/nareix/joy4/av"
+)
+
+const usageFlushInterval = time.Minute
+
+type usageKey struct {
+	channel, protocol string
+}
+
+// usageCounter tracks bytes sent to viewers of one channel over one protocol
+type usageCounter struct {
+	// total counts since startup, for metrics
+	total int64
+	// pending is yet to be written to the database
+	pending int64
+}
+
+func (m *Manager) addUsage(channel, protocol string, n int) {
+	if n <= 0 {
+		return
+	}
+	k := usageKey{channel, protocol}
+	v, ok := m.usage.Load(k)
+	if !ok {
+		v, _ = m.usage.LoadOrStore(k, new(usageCounter))
+	}
+	c := v.(*usageCounter)
+	atomic.AddInt64(&c.total, int64(n))
+	atomic.AddInt64(&c.pending, int64(n))
+}
+
+// FlushUsage periodically adds the bytes sent since the last flush to each
+// channel's daily totals
+func (m *Manager) FlushUsage() {
+	for range time.NewTicker(usageFlushInterval).C {
+		m.flushUsage()
+	}
+}
+
+func (m *Manager) flushUsage() {
+	day := time.Now().UTC()
+	m.usage.Range(func(k, v interface{}) bool {
+		key, c := k.(usageKey), v.(*usageCounter)
+		n := atomic.SwapInt64(&c.pending, 0)
+		if n == 0 {
+			return true
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := model.AddUsage(ctx, key.channel, key.protocol, day, n); err != nil {
+			slog.Error("recording bandwidth usage", "channel", key.channel, "err", err)
+			// try again next time
+			atomic.AddInt64(&c.pending, n)
+		}
+		return true
+	})
+}
+
+// WriteMetrics writes egress counters in the Prometheus text format
+func (m *Manager) WriteMetrics(w io.Writer) {
+	type sample struct {
+		usageKey
+		total int64
+	}
+	var samples []sample
+	m.usage.Range(func(k, v interface{}) bool {
+		samples = append(samples, sample{k.(usageKey), atomic.LoadInt64(&v.(*usageCounter).total)})
+		return true
+	})
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].channel != samples[j].channel {
+			return samples[i].channel < samples[j].channel
+		}
+		return samples[i].protocol < samples[j].protocol
+	})
+	fmt.Fprintln(w, "# HELP gunk_egress_bytes_total Bytes sent to viewers since startup.")
+	fmt.Fprintln(w, "# TYPE gunk_egress_bytes_total counter")
+	for _, s := range samples {
+		fmt.Fprintf(w, "gunk_egress_bytes_total{channel=\"%s\",protocol=\"%s\"} %d\n", labelEscaper.Replace(s.channel), s.protocol, s.total)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// meteredWriter counts bytes written to a viewer
+type meteredWriter struct {
+	http.ResponseWriter
+	count func(int)
+}
+
+func (w meteredWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.count(n)
+	return n, err
+}
+
+func (w meteredWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// meteredDemuxer counts the media payload passed to a viewer whose transport
+// can't be measured directly
+type meteredDemuxer struct {
+	av.Demuxer
+	count func(int)
+}
+
+func (d meteredDemuxer) ReadPacket() (av.Packet, error) {
+	pkt, err := d.Demuxer.ReadPacket()
+	d.count(len(pkt.Data))
+	return pkt, err
+}
+
+// usageFunc returns a counter for one channel and protocol
+func (m *Manager) usageFunc(channel, protocol string) func(int) {
+	return func(n int) { m.addUsage(channel, protocol, n) }
+}
@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+)
+
+const (
+	viewerSampleInterval = 10 * time.Second
+	viewerCountsMaxAge   = 90 * 24 * time.Hour
+)
+
+type viewerSamples struct {
+	sum, n, peak int
+}
+
+// RecordViewers samples the viewers of live channels and stores each minute's
+// average and peak
+func (m *Manager) RecordViewers() {
+	samples := make(map[string]*viewerSamples)
+	minute := time.Now().Truncate(time.Minute)
+	var pruned time.Time
+	for now := range time.NewTicker(viewerSampleInterval).C {
+		if cur := now.Truncate(time.Minute); !cur.Equal(minute) {
+			if len(samples) != 0 {
+				m.storeViewerCounts(minute, samples)
+				samples = make(map[string]*viewerSamples)
+			}
+			minute = cur
+		}
+		m.channels.Range(func(k, v interface{}) bool {
+			ch := v.(*channel)
+			if !ch.isLive() {
+				return true
+			}
+			name := k.(string)
+			s := samples[name]
+			if s == nil {
+				s = new(viewerSamples)
+				samples[name] = s
+			}
+			n := ch.currentViewers()
+			s.sum += n
+			s.n++
+			if n > s.peak {
+				s.peak = n
+			}
+			return true
+		})
+		if now.Sub(pruned) > 24*time.Hour {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := model.PruneViewerCounts(ctx, viewerCountsMaxAge); err != nil {
+				slog.Error("pruning viewer counts", "err", err)
+			}
+			cancel()
+			pruned = now
+		}
+	}
+}
+
+func (m *Manager) storeViewerCounts(minute time.Time, samples map[string]*viewerSamples) {
+	counts := make(map[string]model.ViewerCount, len(samples))
+	for name, s := range samples {
+		counts[name] = model.ViewerCount{Average: float64(s.sum) / float64(s.n), Peak: s.peak}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := model.RecordViewerCounts(ctx, minute, counts); err != nil {
+		slog.Error("recording viewer counts", "err", err)
+	}
+}
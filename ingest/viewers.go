@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxViewerID = 64
+
+// maxHostSessions is how many sessions one address may have before the HLS
+// sessions it starts with new IDs are counted as one, so that a client can't
+// fill a channel by making IDs up
+const maxHostSessions = 8
+
+// viewerSession is one viewer watching a channel. TS and WebRTC sessions last
+// as long as their connection, while HLS sessions are kept alive by playlist
+// polls.
+type viewerSession struct {
+	viewer   string
+	host     string
+	kind     string
+	started  time.Time
+	lastSeen time.Time
+}
+
+// viewerSet tracks the sessions watching a channel, and which viewers have
+// watched during the current stream session
+type viewerSet struct {
+	mu     sync.Mutex
+	active map[string]*viewerSession
+	seen   map[string]struct{}
+	// hosts counts the active sessions from each address
+	hosts map[string]int
+}
+
+// heartbeat starts a session or keeps it alive
+func (v *viewerSet) heartbeat(key, viewer, host, kind string) {
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.active == nil {
+		v.active = make(map[string]*viewerSession)
+		v.seen = make(map[string]struct{})
+		v.hosts = make(map[string]int)
+	}
+	if s := v.active[key]; s != nil {
+		s.lastSeen = now
+		return
+	}
+	v.active[key] = &viewerSession{viewer: viewer, host: host, kind: kind, started: now, lastSeen: now}
+	v.seen[viewer] = struct{}{}
+	v.hosts[host]++
+}
+
+func (v *viewerSet) leave(key string) {
+	v.mu.Lock()
+	v.remove(key)
+	v.mu.Unlock()
+}
+
+func (v *viewerSet) remove(key string) {
+	s := v.active[key]
+	if s == nil {
+		return
+	}
+	delete(v.active, key)
+	if v.hosts[s.host]--; v.hosts[s.host] <= 0 {
+		delete(v.hosts, s.host)
+	}
+}
+
+// hlsKey returns the session key for an HLS viewer with the given ID. Once an
+// address has maxHostSessions sessions, any new ones from it share a key.
+func (v *viewerSet) hlsKey(id, host string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.active[id] != nil || v.hosts[host] < maxHostSessions {
+		return id
+	}
+	return hostViewerID(host, "")
+}
+
+func (v *viewerSet) has(key string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.active[key] != nil
+}
+
+// expire ends HLS sessions that stopped polling
+func (v *viewerSet) expire(timeout time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, s := range v.active {
+		if s.kind == "hls" && time.Since(s.lastSeen) > timeout {
+			v.remove(key)
+		}
+	}
+}
+
+// counts returns the number of sessions in progress and of distinct viewers
+// since the stream session started
+func (v *viewerSet) counts() (current, unique int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.active), len(v.seen)
+}
+
+// countKind returns the number of sessions in progress using any of the given
+// kinds of output
+func (v *viewerSet) countKind(kinds ...string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n := 0
+	for _, s := range v.active {
+		for _, kind := range kinds {
+			if s.kind == kind {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// resetUnique starts counting distinct viewers afresh, keeping those already
+// watching
+func (v *viewerSet) resetUnique() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.seen = make(map[string]struct{}, len(v.active))
+	for _, s := range v.active {
+		v.seen[s.viewer] = struct{}{}
+	}
+}
+
+// viewerID identifies the viewer making a request from host. Players may pass
+// a stable ID in the sid parameter; otherwise the address and user agent stand
+// in.
+func viewerID(req *http.Request, host string) string {
+	if sid := req.URL.Query().Get("sid"); sid != "" && len(sid) <= maxViewerID && validViewerID(sid) {
+		return "s:" + sid
+	}
+	return hostViewerID(host, req.UserAgent())
+}
+
+func hostViewerID(host, userAgent string) string {
+	h := sha256.Sum256([]byte(host + "\x00" + userAgent))
+	return "h:" + hex.EncodeToString(h[:12])
+}
+
+func validViewerID(sid string) bool {
+	for _, c := range sid {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// connectionKey makes a session key for a viewer holding a connection open,
+// since one viewer may hold several
+func connectionKey() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "c:" + hex.EncodeToString(b)
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// nonPublic are the networks that can't be reached from the internet, such as
+// loopback, private and link-local ones, which include the server's own
+var nonPublic = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/3",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+var ErrNotPublic = errors.New("address is not public")
+
+func mustParseCIDRs(list ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(list))
+	for i, v := range list {
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipnet
+	}
+	return nets
+}
+
+// IsPublic returns true if ip is on the internet
+func IsPublic(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, ipnet := range nonPublic {
+		if ipnet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicOnly is a net.Dialer Control function that refuses to connect to
+// addresses that aren't public, for connections to places users choose. The
+// address is checked once it has been looked up so that DNS can't be used to
+// get around it.
+func PublicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublic(net.ParseIP(host)) {
+		return ErrNotPublic
+	}
+	return nil
+}
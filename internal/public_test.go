@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"net"
+	"testing"
+)
+
+// TestIsPublic checks addresses on either side of the networks that are
+// refused, including IPv4 written as IPv6
+func TestIsPublic(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"0.0.0.0", false},
+		{"10.0.0.1", false},
+		{"11.0.0.1", true},
+		{"100.64.0.1", false},
+		{"100.128.0.1", true},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"172.32.0.1", true},
+		{"192.168.1.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+	} {
+		if got := IsPublic(net.ParseIP(tc.ip)); got != tc.public {
+			t.Errorf("%s: got %t, want %t", tc.ip, got, tc.public)
+		}
+	}
+	if IsPublic(nil) {
+		t.Error("nil address is public")
+	}
+}
+
+// TestPublicOnly checks the dialer hook on the host:port form it is given
+func TestPublicOnly(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		err     bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1::1]:443", false},
+		{"127.0.0.1:80", true},
+		{"[::1]:80", true},
+		{"example.com:80", true},
+		{"93.184.216.34", true},
+	} {
+		if err := PublicOnly("tcp", tc.address, nil); (err != nil) != tc.err {
+			t.Errorf("%s: got error %v", tc.address, err)
+		}
+	}
+}
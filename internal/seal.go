@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"crypto/rand"
+	"io"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Seal encrypts and authenticates blob with key, prefixing the random nonce
+func Seal(key *[32]byte, blob []byte) []byte {
+	sealed := make([]byte, 24, 24+secretbox.Overhead+len(blob))
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		panic(err)
+	}
+	copy(sealed, nonce[:])
+	return secretbox.Seal(sealed, blob, &nonce, key)
+}
+
+// Open reverses Seal, returning false if the envelope was tampered with or
+// sealed with a different key
+func Open(key *[32]byte, sealed []byte) ([]byte, bool) {
+	if len(sealed) < 24+secretbox.Overhead {
+		return nil, false
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed)
+	return secretbox.Open(nil, sealed[24:], &nonce, key)
+}
@@ -0,0 +1,154 @@
+// Package locale holds the messages the server sends users, such as
+// notifications and announcements, in each language it knows
+package locale
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Fallback is the language every message is written in
+const Fallback = "en"
+
+//go:embed messages/*.toml
+var builtin embed.FS
+
+var funcs = template.FuncMap{"join": strings.Join}
+
+// Catalog is a set of message templates in one or more languages. Each message
+// is Go text/template source, executed with data that depends on the message.
+type Catalog struct {
+	def   string
+	langs map[string]map[string]*template.Template
+	// fallback is the built-in English, for when an override fails
+	fallback map[string]*template.Template
+}
+
+// Builtin returns the messages that ship with the server, with English as the
+// default
+func Builtin() *Catalog {
+	c, err := Load("", "")
+	if err != nil {
+		panic("locale: " + err.Error())
+	}
+	return c
+}
+
+// Load reads the built-in messages and then any overrides in dir, which holds
+// a TOML file per language named like de.toml or pt-BR.toml. Overrides may
+// replace some messages of a built-in language or add a new language, and
+// anything they leave out is sent in English. def is the language for
+// users who haven't chosen one and for announcements, English if empty.
+func Load(dir, def string) (*Catalog, error) {
+	c := &Catalog{def: normalize(def), langs: make(map[string]map[string]*template.Template)}
+	if c.def == "" {
+		c.def = Fallback
+	}
+	sub, _ := fs.Sub(builtin, "messages")
+	if err := c.loadDir(sub, "messages"); err != nil {
+		return nil, err
+	}
+	c.fallback = make(map[string]*template.Template)
+	for key, t := range c.langs[Fallback] {
+		c.fallback[key] = t
+	}
+	if dir != "" {
+		if err := c.loadDir(os.DirFS(dir), dir); err != nil {
+			return nil, err
+		}
+	}
+	if c.langs[c.def] == nil && c.langs[base(c.def)] == nil {
+		return nil, fmt.Errorf("no messages for default language %q", def)
+	}
+	return c, nil
+}
+
+func (c *Catalog) loadDir(fsys fs.FS, dir string) error {
+	names, err := fs.Glob(fsys, "*.toml")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		blob, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		var msgs map[string]string
+		if _, err := toml.Decode(string(blob), &msgs); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Join(dir, name), err)
+		}
+		lang := normalize(strings.TrimSuffix(name, path.Ext(name)))
+		if c.langs[lang] == nil {
+			c.langs[lang] = make(map[string]*template.Template)
+		}
+		for key, src := range msgs {
+			if c.fallback != nil && c.fallback[key] == nil {
+				return fmt.Errorf("%s: unknown message %q", filepath.Join(dir, name), key)
+			}
+			t, err := template.New(key).Funcs(funcs).Parse(src)
+			if err != nil {
+				return fmt.Errorf("%s: %w", filepath.Join(dir, name), err)
+			}
+			c.langs[lang][key] = t
+		}
+	}
+	return nil
+}
+
+// Languages lists the languages that have messages
+func (c *Catalog) Languages() []string {
+	langs := make([]string, 0, len(c.langs))
+	for lang := range c.langs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Has returns true if lang has messages of its own
+func (c *Catalog) Has(lang string) bool {
+	return c.langs[normalize(lang)] != nil
+}
+
+// Render fills in a message in a language, falling back to the language
+// without its region, then the default language, then English. An empty lang
+// means the default.
+func (c *Catalog) Render(lang, key string, data any) string {
+	lang = normalize(lang)
+	for _, msgs := range []map[string]*template.Template{c.langs[lang], c.langs[base(lang)], c.langs[c.def], c.langs[base(c.def)], c.langs[Fallback], c.fallback} {
+		t := msgs[key]
+		if t == nil {
+			continue
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			log.Printf("warning: rendering message %s: %s", key, err)
+			continue
+		}
+		return strings.TrimSpace(b.String())
+	}
+	log.Printf("warning: no message %s", key)
+	return key
+}
+
+func normalize(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(lang, "_", "-"))
+}
+
+// base strips the region from a language tag
+func base(lang string) string {
+	if i := strings.IndexByte(lang, '-'); i >= 0 {
+		return lang[:i]
+	}
+	return lang
+}
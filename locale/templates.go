@@ -0,0 +1,128 @@
+package locale
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Template files an operator may put in the templates directory. The .json
+// ones must produce a JSON document.
+const (
+	// AnnounceLive is the Discord webhook message posted when a channel goes
+	// live, which can carry embeds
+	AnnounceLive = "announce_live.json"
+	// AnnounceScheduled is the Discord webhook message posted ahead of a
+	// scheduled stream
+	AnnounceScheduled = "announce_scheduled.json"
+	// Webhook is the body posted to users' notification webhooks
+	Webhook = "webhook.json"
+	// Email is the body of notification emails
+	Email = "email.txt"
+)
+
+var templateNames = []string{AnnounceLive, AnnounceScheduled, Webhook, Email}
+
+// Channel is what templates are told about the channel a message is about
+type Channel struct {
+	// Name is the channel's name as it appears in URLs
+	Name        string
+	DisplayName string
+	Title       string
+	// URL is the watch page
+	URL string
+	// Thumbnail is the URL of the latest thumbnail, empty if there isn't one
+	// or the channel isn't rated for everyone
+	Thumbnail string
+}
+
+// TemplateData is what each template is executed with. Message is the text
+// that would otherwise be sent, already in the right language.
+type TemplateData struct {
+	Channel Channel
+	Message string
+	// Subject is set for notifications, along with Kind
+	Subject string
+	Kind    string
+	// Title and Start are set for scheduled stream announcements, Start in
+	// Unix seconds
+	Title string
+	Start int64
+}
+
+// Templates replace the layout of the messages the server posts, such as to
+// add a Discord embed with the channel's thumbnail. Any that aren't given
+// keep the built-in plain text. A nil *Templates has none.
+type Templates struct {
+	t map[string]*template.Template
+}
+
+// templateFuncs adds json to escape values for the .json templates
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v any) (string, error) {
+		blob, err := json.Marshal(v)
+		return string(blob), err
+	},
+}
+
+// LoadTemplates reads the templates in dir, which may hold any of the files
+// named above in Go text/template syntax
+func LoadTemplates(dir string) (*Templates, error) {
+	ts := &Templates{t: make(map[string]*template.Template)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		known := false
+		for _, n := range templateNames {
+			known = known || n == name
+		}
+		if !known {
+			return nil, fmt.Errorf("%s: unknown template, expected one of %s", filepath.Join(dir, name), strings.Join(templateNames, ", "))
+		}
+		blob, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		t, err := template.New(name).Funcs(templateFuncs).Parse(string(blob))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Join(dir, name), err)
+		}
+		ts.t[name] = t
+	}
+	return ts, nil
+}
+
+// Has returns true if the operator supplied a template
+func (ts *Templates) Has(name string) bool {
+	return ts != nil && ts.t[name] != nil
+}
+
+// Execute fills in a template. It returns nil if there is no such template or
+// it fails, in which case the caller sends the plain message instead.
+func (ts *Templates) Execute(name string, data TemplateData) []byte {
+	if !ts.Has(name) {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := ts.t[name].Execute(&b, data); err != nil {
+		log.Printf("warning: rendering template %s: %s", name, err)
+		return nil
+	}
+	if filepath.Ext(name) == ".json" && !json.Valid(b.Bytes()) {
+		log.Printf("warning: rendering template %s: result is not valid JSON", name)
+		return nil
+	}
+	return b.Bytes()
+}
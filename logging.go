@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging sends all logging through slog with the given level and format.
+// Messages from the standard log package keep working, with an "error:" or
+// "warning:" prefix setting their level.
+func setupLogging(level, format string) error {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	logger := slog.New(h)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(legacyWriter{logger})
+	return nil
+}
+
+// legacyWriter turns lines from the standard log package into slog records
+type legacyWriter struct {
+	l *slog.Logger
+}
+
+func (w legacyWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	lv := slog.LevelInfo
+	for prefix, v := range map[string]slog.Level{
+		"error: ":   slog.LevelError,
+		"warning: ": slog.LevelWarn,
+	} {
+		if strings.HasPrefix(msg, prefix) {
+			msg, lv = strings.TrimPrefix(msg, prefix), v
+		}
+	}
+	w.l.Log(context.Background(), lv, msg)
+	return len(p), nil
+}
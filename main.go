@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
+	"eaglesong.dev/gunk/ingest"
 	"eaglesong.dev/gunk/ingest/irtmp"
+	"eaglesong.dev/gunk/locale"
 	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
+	"eaglesong.dev/gunk/sinks/grabber"
 	"eaglesong.dev/gunk/sinks/rtsp"
+	"eaglesong.dev/gunk/storage"
 	"eaglesong.dev/gunk/web"
 	"github.com/nareix/joy4/format/rtmp"
 	"golang.org/x/sync/errgroup"
@@ -21,53 +28,207 @@ import (
 )
 
 func main() {
-	base := strings.TrimSuffix(os.Getenv("BASE_URL"), "/")
-	u, err := url.Parse(base)
-	if err != nil {
-		log.Fatalf("error: in BASE_URL: %s", err)
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "migrate":
+		migrate(args)
+	case "user":
+		userCmd(args)
+	case "channel":
+		channelCmd(args)
+	case "key":
+		keyCmd(args)
+	case "moderation":
+		moderationCmd(args)
+	case "app":
+		appCmd(args)
+	case "ban":
+		banCmd(args)
+	case "netsim":
+		netsim(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// serve runs the server. It is the default when no command is given.
+func serve(args []string) {
+	cfg, _ := parseFlags("serve", args, nil)
+	if errs := cfg.validate(); len(errs) != 0 {
+		for _, err := range errs {
+			log.Println("error: config:", err)
+		}
+		os.Exit(1)
+	}
+	if err := setupLogging(cfg.Log.Level, cfg.Log.Format); err != nil {
+		log.Fatalln("error: config:", err)
 	}
+	base := strings.TrimSuffix(cfg.BaseURL, "/")
+	u, _ := url.Parse(base)
 	s := &web.Server{
-		BaseURL: base,
-		Secure:  u.Scheme == "https",
+		BaseURL:               base,
+		Secure:                u.Scheme == "https",
+		UI:                    cfg.UI,
+		APIDocs:               cfg.APIDocs,
+		EmbedAncestors:        cfg.EmbedAncestors,
+		MaxChannels:           cfg.MaxChannels,
+		DeactivateAfterMonths: cfg.Accounts.DeactivateAfterMonths,
+		ReleaseAfterDays:      cfg.Accounts.ReleaseAfterDays,
 	}
 	s.Initialize()
-	s.SetOauth(os.Getenv("CLIENT_ID"), os.Getenv("CLIENT_SECRET"))
-	if k := os.Getenv("COOKIE_SECRET"); k == "" {
-		log.Fatalln("error: COOKIE_SECRET must be set")
-	} else {
-		s.SetSecret(k)
+	messages, err := locale.Load(cfg.Locale.Dir, cfg.Locale.Default)
+	if err != nil {
+		log.Fatalln("error: locale:", err)
 	}
-	if v := os.Getenv("WEBHOOK"); v != "" {
+	s.SetMessages(messages)
+	if cfg.Locale.Templates != "" {
+		templates, err := locale.LoadTemplates(cfg.Locale.Templates)
+		if err != nil {
+			log.Fatalln("error: templates:", err)
+		}
+		s.SetTemplates(templates)
+	}
+	s.SetOauth(cfg.OAuth.ClientID, cfg.OAuth.ClientSecret)
+	s.SetPatreon(cfg.OAuth.PatreonClientID, cfg.OAuth.PatreonClientSecret)
+	s.SetSecret(cfg.CookieSecret)
+	s.SetRateLimits(
+		rateLimit(cfg.RateLimit.Login, web.DefaultLoginLimit),
+		rateLimit(cfg.RateLimit.API, web.DefaultAPILimit),
+		rateLimit(cfg.RateLimit.Playback, web.DefaultPlaybackLimit),
+		rateLimit(cfg.RateLimit.Session, web.DefaultSessionLimit),
+		rateLimit(cfg.RateLimit.Anonymous, web.DefaultAnonymousLimit),
+	)
+	if err := s.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalln("error: trusted_proxies:", err)
+	}
+	if v := cfg.Announce.Webhook; v != "" {
 		if err := s.SetWebhook(v); err != nil {
 			log.Fatalln("error: setting webhook:", err)
 		}
 	}
-	if v := os.Getenv("RTMP_URL"); v != "" {
+	if v := cfg.Notify.SMTPAddr; v != "" {
+		s.SetEmail(&notify.Email{
+			Addr:     v,
+			From:     cfg.Notify.SMTPFrom,
+			Username: cfg.Notify.SMTPUsername,
+			Password: cfg.Notify.SMTPPassword,
+		})
+	}
+	if v := cfg.Notify.DiscordBotToken; v != "" {
+		s.SetDiscordBot(v)
+	}
+	s.SetNSFWHook(web.NSFWHook{
+		URL:       cfg.NSFW.URL,
+		Threshold: cfg.NSFW.Threshold,
+		Interval:  time.Duration(cfg.NSFW.Interval),
+	})
+	s.SetDirectory(web.Directory{
+		Name:     cfg.Directory.Name,
+		ReportTo: cfg.Directory.ReportTo,
+		Serve:    cfg.Directory.Serve,
+	})
+	if v := cfg.Ingest.RTMPURL; v != "" {
 		s.AdvertiseRTMP = strings.TrimSuffix(v, "/") + "/live"
 	} else {
 		s.AdvertiseRTMP = "rtmp://" + u.Hostname() + "/live"
 	}
-	if v := os.Getenv("LIVE_URL"); v != "" {
-		s.AdvertiseLive, err = url.Parse(v)
-		if err != nil {
-			log.Fatalln("LIVE_URL:", err)
-		}
+	if v := cfg.Ingest.LiveURL; v != "" {
+		s.AdvertiseLive, _ = url.Parse(v)
 	} else {
 		s.AdvertiseLive = u
 	}
-	if v := os.Getenv("WORK_DIR"); v != "" {
+	if v := cfg.Ingest.RTSPURL; v != "" {
+		s.AdvertiseRTSP = strings.TrimSuffix(v, "/")
+	} else {
+		port := "8554"
+		if _, p, err := net.SplitHostPort(cfg.Listen.RTSP); err == nil && p != "" {
+			port = p
+		}
+		s.AdvertiseRTSP = "rtsp://" + net.JoinHostPort(u.Hostname(), port)
+	}
+	if v := cfg.WorkDir; v != "" {
 		if err := os.MkdirAll(v, 0700); err != nil {
 			log.Fatalln("error:", err)
 		}
 		s.Channels.WorkDir = v
 	}
-	if v, _ := strconv.Atoi(os.Getenv("OPUS_BITRATE")); v > 0 {
+	s.Channels.PlayoutDir = cfg.PlayoutDir
+	s.Channels.PlayoutURLs = cfg.PlayoutURLs
+	s.Channels.IngestFilter.Allow, _ = ingest.ParseNets(cfg.Ingest.Allow)
+	s.Channels.IngestFilter.Deny, _ = ingest.ParseNets(cfg.Ingest.Deny)
+	s.Channels.FailoverLimit = time.Duration(cfg.Ingest.FailoverLimit)
+	if v := cfg.Ingest.PublishHook; v != "" {
+		s.Channels.PublishHook = &ingest.PublishHook{
+			URL:      v,
+			Timeout:  time.Duration(cfg.Ingest.PublishHookTimeout),
+			FailOpen: cfg.Ingest.PublishHookFailOpen,
+		}
+	}
+	for _, codec := range cfg.Ingest.Codecs {
+		if !slices.Contains(ingest.SupportedCodecs, codec) {
+			log.Fatalf("error: ingest codec %q is not supported, use some of %v", codec, ingest.SupportedCodecs)
+		}
+	}
+	s.Channels.Limits = ingest.PublishLimits{
+		Codecs:              cfg.Ingest.Codecs,
+		MaxBitrate:          cfg.Ingest.MaxBitrate,
+		MaxKeyframeInterval: time.Duration(cfg.Ingest.MaxKeyframeInterval),
+		MaxWidth:            cfg.Ingest.MaxWidth,
+		MaxHeight:           cfg.Ingest.MaxHeight,
+	}
+	s.AnnounceLead = time.Duration(cfg.Announce.Lead)
+	s.Channels.ReconnectGrace = time.Duration(cfg.Ingest.ReconnectGrace)
+	s.Channels.HLSTargetDuration = time.Duration(cfg.HLS.TargetDuration)
+	s.Channels.HLSWindow = time.Duration(cfg.HLS.Window)
+	s.Channels.TSPrebuffer = cfg.HLS.TSPrebufferGOPs
+	s.Channels.HLSEncrypt = cfg.HLS.Encrypt
+	s.Channels.HLSKeyRotation = time.Duration(cfg.HLS.KeyRotation)
+	s.Channels.Thumbs = grabber.Options{
+		Interval: time.Duration(cfg.Thumbs.Interval),
+		Width:    cfg.Thumbs.Width,
+		Quality:  cfg.Thumbs.Quality,
+		Format:   cfg.Thumbs.Format,
+	}
+	if v := cfg.Ingest.OpusBitrate; v > 0 {
 		s.Channels.OpusBitrate = v
 	}
-	if err := model.Connect(); err != nil {
-		log.Fatalln("error: connecting to database:", err)
+	if v := cfg.Captions.Transcriber; v != "" {
+		s.Channels.Transcriber = ingest.NewTranscriber(v)
 	}
-	if v := os.Getenv("METRICS"); v != "" {
+	switch v := cfg.Thumbs.Store; {
+	case strings.HasPrefix(v, "file:"):
+		model.SetThumbStore(storage.FileStore{Dir: strings.TrimPrefix(v, "file:")})
+	case v == "s3":
+		model.SetThumbStore(&storage.S3Store{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+		})
+	}
+	connect(cfg)
+	if err := model.EndStaleSessions(context.Background()); err != nil {
+		log.Fatalln("error: closing stale sessions:", err)
+	}
+	if err := s.Channels.StartPlayouts(context.Background()); err != nil {
+		log.Fatalln("error: starting playlists:", err)
+	}
+	if v := cfg.Listen.Metrics; v != "" {
+		http.HandleFunc("/metrics", func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			s.Channels.WriteMetrics(rw)
+			s.WriteMetrics(rw)
+		})
+		// operator only, like the pprof handlers also on this listener
+		http.HandleFunc("/debug/ingest-trace", s.Channels.ServeTrace)
 		lis, err := net.Listen("tcp", v)
 		if err != nil {
 			log.Fatalln("error:", err)
@@ -78,29 +239,53 @@ func main() {
 	eg := new(errgroup.Group)
 	rs := &irtmp.Server{
 		Server: rtmp.Server{
-			Addr: os.Getenv("LISTEN_RTMP"),
+			Addr: cfg.Listen.RTMP,
 		},
-		CheckUser: model.VerifyRTMP,
-		Publish:   s.Channels.Publish,
+		CheckUser:  model.VerifyRTMP,
+		CheckAddr:  s.Channels.CheckAddr,
+		AuthFailed: s.AuthFailed,
+		Publish:    s.Channels.Publish,
 	}
-	eg.Go(func() error { return rs.ListenAndServe() })
-	rtsps := &rtsp.Server{Source: s.Channels.GetRTSPSource}
-	if err := rtsps.Listen(os.Getenv("LISTEN_RTSP")); err != nil {
+	s.Channels.AddProtocol(rs)
+	rtsps := &rtsp.Server{Source: s.Channels.GetRTSPSource, Watching: s.Channels.RTSPWatching}
+	if err := rtsps.Listen(cfg.Listen.RTSP); err != nil {
 		log.Fatalln("error:", err)
 	}
 	eg.Go(func() error { return rtsps.Serve() })
-	if err := s.Channels.FTL.Listen(os.Getenv("LISTEN_FTL")); err != nil {
+	s.Channels.FTL.MediaAddr = cfg.Ingest.FTLMediaAddr
+	if v := cfg.Ingest.FTLAdvertisePort; v > 0 {
+		s.Channels.FTL.RTPAdvertisePort = v
+	}
+	if v := cfg.Ingest.FTLMediaPorts; v != "" {
+		s.Channels.FTL.MediaPortMin, s.Channels.FTL.MediaPortMax, _ = parsePortRange(v)
+	}
+	if err := s.Channels.FTL.Listen(cfg.Listen.FTL); err != nil {
 		log.Fatalln("error:", err)
 	}
-	eg.Go(func() error { return s.Channels.FTL.Serve() })
+	for _, p := range s.Channels.Protocols() {
+		eg.Go(p.Serve)
+	}
 	eg.Go(func() error {
 		srv := &http.Server{
-			Addr:        ":8009",
+			Addr:        cfg.Listen.HTTP,
 			Handler:     s.Handler(),
 			ReadTimeout: 15 * time.Second,
 		}
 		return srv.ListenAndServe()
 	})
+	go s.AnnounceScheduled()
+	go s.ExpireRooms()
+	go s.ExpireParties()
+	go s.RotateKeys()
+	go s.DeactivateInactive()
+	go s.ReportDirectory()
+	go s.PruneDirectory()
+	go s.Channels.FlushUsage()
+	go s.Channels.RecordViewers()
+	go s.Channels.RefreshBans()
+	if v := cfg.Diagnostics.SoakDir; v != "" {
+		go soak(v, time.Duration(cfg.Diagnostics.SoakInterval))
+	}
 	go func() {
 		for range time.NewTicker(15 * time.Second).C {
 			s.Channels.Cleanup()
@@ -110,3 +295,9 @@ func main() {
 		log.Fatalln("error:", err)
 	}
 }
+
+// rateLimit returns a limit that has already been validated
+func rateLimit(v string, def web.RateLimit) web.RateLimit {
+	l, _ := parseRateLimit(v, def)
+	return l
+}
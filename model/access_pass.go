@@ -0,0 +1,145 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrPassInvalid = errors.New("access code is invalid, expired or already used")
+
+type AccessPass struct {
+	Code       string `json:"code"`
+	Hours      int    `json:"hours"`
+	Created    int64  `json:"created"`
+	RedeemBy   int64  `json:"redeem_by,omitempty"`
+	RedeemedBy string `json:"redeemed_by,omitempty"`
+	RedeemedAt int64  `json:"redeemed_at,omitempty"`
+}
+
+// CreatePasses generates a batch of single-use codes for a channel owned by
+// userID. Each code grants access for validFor once redeemed, and must be
+// redeemed before redeemBy unless it is zero.
+func CreatePasses(ctx context.Context, userID, channelName string, count int, validFor time.Duration, redeemBy time.Time) (codes []string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var deadline *time.Time
+	if !redeemBy.IsZero() {
+		deadline = &redeemBy
+	}
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	var owner string
+	if err := tx.QueryRow(ctx, "SELECT user_id FROM channel_defs WHERE name = $1 FOR UPDATE", channelName).Scan(&owner); err != nil {
+		return nil, err
+	} else if owner != userID {
+		return nil, pgx.ErrNoRows
+	}
+	b := make([]byte, 10)
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(rand.Reader, b); err != nil {
+			return nil, err
+		}
+		code := base32.StdEncoding.EncodeToString(b)
+		if _, err := tx.Exec(ctx, "INSERT INTO access_passes (code, channel_name, duration, redeem_by) VALUES ($1, $2, $3, $4)", code, channelName, validFor, deadline); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, tx.Commit(ctx)
+}
+
+func ListPasses(ctx context.Context, userID, channelName string) (passes []*AccessPass, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT code, duration, access_passes.created, redeem_by, redeemed_by, redeemed_at FROM access_passes JOIN channel_defs ON channel_defs.name = channel_name WHERE user_id = $1 AND channel_name = $2 ORDER BY access_passes.created, code", userID, channelName)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	passes = []*AccessPass{}
+	for rows.Next() {
+		pass := new(AccessPass)
+		var duration time.Duration
+		var created time.Time
+		var redeemBy, redeemedAt *time.Time
+		var redeemedBy *string
+		if err = rows.Scan(&pass.Code, &duration, &created, &redeemBy, &redeemedBy, &redeemedAt); err != nil {
+			return
+		}
+		pass.Hours = int(duration / time.Hour)
+		pass.Created = created.UnixNano() / 1000000
+		if redeemBy != nil {
+			pass.RedeemBy = redeemBy.UnixNano() / 1000000
+		}
+		if redeemedBy != nil {
+			pass.RedeemedBy = *redeemedBy
+		}
+		if redeemedAt != nil {
+			pass.RedeemedAt = redeemedAt.UnixNano() / 1000000
+		}
+		passes = append(passes, pass)
+	}
+	err = rows.Err()
+	return
+}
+
+// RedeemPass consumes an access code and returns the time at which the
+// resulting access expires. viewerID may be empty for anonymous viewers.
+func RedeemPass(ctx context.Context, channelName, code, viewerID string) (expires time.Time, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var redeemedBy *string
+	if viewerID != "" {
+		redeemedBy = &viewerID
+	}
+	row := db.QueryRow(ctx, "UPDATE access_passes SET redeemed_at = now(), redeemed_by = $3 WHERE code = $1 AND channel_name = $2 AND redeemed_at IS NULL AND (redeem_by IS NULL OR redeem_by > now()) RETURNING redeemed_at + duration", code, channelName, redeemedBy)
+	err = row.Scan(&expires)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrPassInvalid
+	}
+	return
+}
+
+// AccessRules describe who may watch a channel
+type AccessRules struct {
+	Owner string
+	// Private channels require an access pass
+	Private bool
+	// PatreonCampaign, if set, admits members of the campaign pledging at least
+	// PatreonMinCents
+	PatreonCampaign string
+	PatreonMinCents int
+	// MembersOnly channels admit only viewers on the owner's override list,
+	// and Patreon members if there is a campaign
+	MembersOnly bool
+	// Rating, if set, requires viewers to acknowledge the content warnings
+	// after ContentUpdated
+	Rating         string
+	Warnings       []string
+	ContentUpdated time.Time
+	// MaxViewers, if set, turns new viewers away once the channel has this
+	// many
+	MaxViewers int
+}
+
+// Gated returns true if viewers must prove access before watching
+func (r AccessRules) Gated() bool {
+	return r.Private || r.PatreonCampaign != "" || r.MembersOnly
+}
+
+func ChannelAccess(ctx context.Context, channelName string) (rules AccessRules, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, "SELECT user_id, private, patreon_campaign, patreon_min_cents, members_only, rating, content_warnings, content_updated, max_viewers FROM channel_defs WHERE name = $1", channelName)
+	err = row.Scan(&rules.Owner, &rules.Private, &rules.PatreonCampaign, &rules.PatreonMinCents, &rules.MembersOnly, &rules.Rating, &rules.Warnings, &rules.ContentUpdated, &rules.MaxViewers)
+	return
+}
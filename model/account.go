@@ -0,0 +1,128 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Account is the activity status of a user
+type Account struct {
+	LastActive int64 `json:"last_active"`
+	// Deactivated is set once the account has been deactivated for
+	// inactivity, until the user reactivates it
+	Deactivated int64 `json:"deactivated,omitempty"`
+}
+
+// AccountEvent is a change in an account's status that the user is told about
+type AccountEvent struct {
+	UserID string
+	// At is when the next step happens: deactivation after a warning, or
+	// the release of channel names after deactivation
+	At time.Time
+	// Channels are the names freed by a release
+	Channels []string
+}
+
+func GetAccount(ctx context.Context, userID string) (a Account, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var lastActive time.Time
+	var deactivated *time.Time
+	if err = db.QueryRow(ctx, "SELECT last_active, deactivated FROM users WHERE user_id = $1", userID).Scan(&lastActive, &deactivated); err != nil {
+		return
+	}
+	a.LastActive = lastActive.UnixNano() / 1000000
+	if deactivated != nil {
+		a.Deactivated = deactivated.UnixNano() / 1000000
+	}
+	return
+}
+
+// ReactivateUser brings back a deactivated account and counts as activity
+func ReactivateUser(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "UPDATE users SET last_active = now(), deactivation_warned = NULL, deactivated = NULL WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimDeactivationWarnings marks the users who will be deactivated within
+// notice and returns them, so that each is only warned once per lapse
+func ClaimDeactivationWarnings(ctx context.Context, months int, notice time.Duration) (events []AccountEvent, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, `UPDATE users SET deactivation_warned = now()
+		WHERE deactivated IS NULL AND deactivation_warned IS NULL
+		AND last_active + make_interval(months => $1) <= now() + $2::interval
+		RETURNING user_id, last_active + make_interval(months => $1)`, months, notice)
+	if err != nil {
+		return
+	}
+	return scanAccountEvents(rows)
+}
+
+// DeactivateInactive deactivates users who have been inactive for the given
+// number of months and were warned at least notice ago. The events say when
+// their channel names will be freed.
+func DeactivateInactive(ctx context.Context, months int, notice time.Duration, releaseDays int) (events []AccountEvent, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, `UPDATE users SET deactivated = now()
+		WHERE deactivated IS NULL AND deactivation_warned <= now() - $2::interval
+		AND last_active + make_interval(months => $1) <= now()
+		RETURNING user_id, now() + make_interval(days => $3)`, months, notice, releaseDays)
+	if err != nil {
+		return
+	}
+	return scanAccountEvents(rows)
+}
+
+func scanAccountEvents(rows pgx.Rows) (events []AccountEvent, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var ev AccountEvent
+		if err = rows.Scan(&ev.UserID, &ev.At); err != nil {
+			return
+		}
+		events = append(events, ev)
+	}
+	err = rows.Err()
+	return
+}
+
+// ReleaseDeactivated deletes the channels of users who have been deactivated
+// for releaseDays so that others can take their names
+func ReleaseDeactivated(ctx context.Context, releaseDays int) (events []AccountEvent, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, `DELETE FROM channel_defs d USING users u
+		WHERE d.user_id = u.user_id AND u.deactivated + make_interval(days => $1) <= now()
+		RETURNING d.user_id, d.name`, releaseDays)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	byUser := make(map[string]int)
+	for rows.Next() {
+		var userID, name string
+		if err = rows.Scan(&userID, &name); err != nil {
+			return
+		}
+		i, ok := byUser[userID]
+		if !ok {
+			i = len(events)
+			byUser[userID] = i
+			events = append(events, AccountEvent{UserID: userID, At: time.Now()})
+		}
+		events[i].Channels = append(events[i].Channels, name)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Functions for administering users and channels from the command line,
+// without the ownership checks the web API makes.
+
+// UserSummary describes a user for administration
+type UserSummary struct {
+	UserID   string
+	Announce bool
+	Channels int
+}
+
+// AddUser creates a user who hasn't logged in yet so that channels can be
+// created for them
+func AddUser(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "INSERT INTO users (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING", userID)
+	return err
+}
+
+func ListUsers(ctx context.Context) (users []UserSummary, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT user_id, announce, (SELECT count(*) FROM channel_defs c WHERE c.user_id = u.user_id) FROM users u ORDER BY user_id")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u UserSummary
+		if err = rows.Scan(&u.UserID, &u.Announce, &u.Channels); err != nil {
+			return
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
+	return
+}
+
+// SetChannelQuota overrides the number of channels a user may have, or
+// restores the configured limit if max is nil
+func SetChannelQuota(ctx context.Context, userID string, max *int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "UPDATE users SET max_channels = $2 WHERE user_id = $1", userID, max)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUser removes a user and all of their channels
+func DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM users WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ChannelOwner pairs a channel with the user it belongs to
+type ChannelOwner struct {
+	Name      string
+	UserID    string
+	Ephemeral bool
+}
+
+// ListAllChannels returns every channel, including rooms
+func ListAllChannels(ctx context.Context) (channels []ChannelOwner, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT name, user_id, ephemeral FROM channel_defs ORDER BY name")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ChannelOwner
+		if err = rows.Scan(&c.Name, &c.UserID, &c.Ephemeral); err != nil {
+			return
+		}
+		channels = append(channels, c)
+	}
+	err = rows.Err()
+	return
+}
+
+// RemoveChannel deletes a channel regardless of who owns it
+func RemoveChannel(ctx context.Context, name string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM channel_defs WHERE name = $1", name)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ChannelKey returns a channel's stream key regardless of who owns it
+func ChannelKey(ctx context.Context, name string) (key string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "SELECT key FROM channel_defs WHERE name = $1", name).Scan(&key)
+	return
+}
+
+// RotateKey gives a channel a new stream key. The old key stops working
+// immediately, but publishers using it are not disconnected.
+func RotateKey(ctx context.Context, name string) (key string, err error) {
+	key, err = newKey()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "UPDATE channel_defs SET key = $2, key_rotated = now(), key_rotation_due = NULL, prev_key = NULL, prev_key_expires = NULL, key_last_used = NULL, key_last_addr = NULL, key_last_protocol = NULL WHERE name = $1", name, key)
+	if err != nil {
+		return "", err
+	} else if tag.RowsAffected() == 0 {
+		return "", pgx.ErrNoRows
+	}
+	return key, nil
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
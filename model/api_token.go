@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrTooManyTokens = errors.New("too many tokens")
+
+// APIToken describes a token without revealing it
+type APIToken struct {
+	ID       int64    `json:"id"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Created  int64    `json:"created"`
+	LastUsed int64    `json:"last_used,omitempty"`
+	// ClientID is set for tokens issued to an app through OAuth2
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// tokenPrefix makes tokens easy to recognize if they are leaked
+const tokenPrefix = "gunk_"
+
+func hashToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+// CreateAPIToken issues a token to a user, who may have at most maxTokens.
+// Only a hash is stored so the token can't be shown again.
+func CreateAPIToken(ctx context.Context, userID, name string, scopes []string, maxTokens int) (token string, t *APIToken, err error) {
+	key, err := newKey()
+	if err != nil {
+		return
+	}
+	token = tokenPrefix + key
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	t = &APIToken{Name: name, Scopes: scopes}
+	var created time.Time
+	row := db.QueryRow(ctx, `INSERT INTO api_tokens (user_id, name, token_hash, scopes)
+		SELECT $1, $2, $3, $4
+		WHERE (SELECT count(*) FROM api_tokens WHERE user_id = $1) < $5
+		RETURNING id, created`, userID, name, hashToken(token), scopes, maxTokens)
+	if err = row.Scan(&t.ID, &created); errors.Is(err, pgx.ErrNoRows) {
+		return "", nil, ErrTooManyTokens
+	} else if err != nil {
+		return "", nil, err
+	}
+	t.Created = created.UnixNano() / 1000000
+	return
+}
+
+func ListAPITokens(ctx context.Context, userID string) (tokens []*APIToken, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT id, name, scopes, created, last_used, coalesce(client_id, '') FROM api_tokens WHERE user_id = $1 ORDER BY created", userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tokens = []*APIToken{}
+	for rows.Next() {
+		t := new(APIToken)
+		var created time.Time
+		var lastUsed *time.Time
+		if err = rows.Scan(&t.ID, &t.Name, &t.Scopes, &created, &lastUsed, &t.ClientID); err != nil {
+			return
+		}
+		t.Created = created.UnixNano() / 1000000
+		if lastUsed != nil {
+			t.LastUsed = lastUsed.UnixNano() / 1000000
+		}
+		tokens = append(tokens, t)
+	}
+	err = rows.Err()
+	return
+}
+
+func DeleteAPIToken(ctx context.Context, userID string, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM api_tokens WHERE user_id = $1 AND id = $2", userID, id)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// VerifyAPIToken returns the owner and scopes of a token and records that it
+// was used. It returns pgx.ErrNoRows if the token is unknown.
+func VerifyAPIToken(ctx context.Context, token string) (userID string, scopes []string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "UPDATE api_tokens SET last_used = now() WHERE token_hash = $1 RETURNING user_id, scopes", hashToken(token)).Scan(&userID, &scopes)
+	return
+}
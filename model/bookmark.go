@@ -0,0 +1,94 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrNotLive          = errors.New("channel is not live")
+	ErrTooManyBookmarks = errors.New("too many bookmarks")
+)
+
+// Bookmark is a moment a viewer saved in a stream. Position counts from the
+// start of the session, which is where it falls in a recording of it.
+type Bookmark struct {
+	ID      int64  `json:"id"`
+	Channel string `json:"channel"`
+	// Position is in seconds from the start of the session
+	Position       int    `json:"position"`
+	Note           string `json:"note"`
+	Created        int64  `json:"created"`
+	SessionStarted int64  `json:"session_started"`
+	SessionEnded   int64  `json:"session_ended,omitempty"`
+}
+
+// AddBookmark saves the current moment of a channel's live session for a
+// user, less delay for how far behind live the viewer is. Users are limited to
+// maxBookmarks.
+func AddBookmark(ctx context.Context, userID, channelName, note string, delay time.Duration, maxBookmarks int) (*Bookmark, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var count int
+	if err := db.QueryRow(ctx, "SELECT count(*) FROM bookmarks WHERE user_id = $1", userID).Scan(&count); err != nil {
+		return nil, err
+	} else if count >= maxBookmarks {
+		return nil, ErrTooManyBookmarks
+	}
+	b := &Bookmark{Channel: channelName, Note: note}
+	var created, started time.Time
+	row := db.QueryRow(ctx, `INSERT INTO bookmarks (user_id, session_id, position, note)
+		SELECT $1, id, greatest(0, extract(epoch FROM now() - started) - $3), $4
+		FROM stream_sessions WHERE channel_name = $2 AND ended IS NULL
+		ORDER BY started DESC LIMIT 1
+		RETURNING id, position, created, (SELECT started FROM stream_sessions s WHERE s.id = session_id)`,
+		userID, channelName, delay.Seconds(), note)
+	if err := row.Scan(&b.ID, &b.Position, &created, &started); errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotLive
+	} else if err != nil {
+		return nil, err
+	}
+	b.Created = created.UnixNano() / 1000000
+	b.SessionStarted = started.UnixNano() / 1000000
+	return b, nil
+}
+
+// ListBookmarks returns a user's bookmarks, newest first
+func ListBookmarks(ctx context.Context, userID string) (bookmarks []*Bookmark, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, `SELECT b.id, s.channel_name, b.position, b.note, b.created, s.started, s.ended
+		FROM bookmarks b JOIN stream_sessions s ON s.id = b.session_id
+		WHERE b.user_id = $1 ORDER BY b.created DESC`, userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	bookmarks = []*Bookmark{}
+	for rows.Next() {
+		b := new(Bookmark)
+		var created, started time.Time
+		var ended *time.Time
+		if err = rows.Scan(&b.ID, &b.Channel, &b.Position, &b.Note, &created, &started, &ended); err != nil {
+			return
+		}
+		b.Created = created.UnixNano() / 1000000
+		b.SessionStarted = started.UnixNano() / 1000000
+		if ended != nil {
+			b.SessionEnded = ended.UnixNano() / 1000000
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	err = rows.Err()
+	return
+}
+
+func DeleteBookmark(ctx context.Context, userID string, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var ok bool
+	return db.QueryRow(ctx, "DELETE FROM bookmarks WHERE user_id = $1 AND id = $2 RETURNING true", userID, id).Scan(&ok)
+}
@@ -1,14 +1,17 @@
 package model
 
 import (
+	"context"
 	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/url"
 	"path"
 
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
 	"golang.org/x/oauth2"
 )
 
@@ -17,22 +20,75 @@ type ChannelAuth struct {
 	Name     string
 	Announce bool
 	Token    *oauth2.Token
+	// IngestAllow lists the IPs and CIDR ranges that may publish, or is empty
+	// to allow any
+	IngestAllow []string
+	// PrevKey is true if the publisher used the previous stream key during
+	// its grace period
+	PrevKey bool
+	// KeyFingerprint identifies the key that was used without revealing it
+	KeyFingerprint string
+	// AutoCaptions asks for the channel's speech to be transcribed
+	AutoCaptions bool
 }
 
-func findChannel(column, value string) (auth ChannelAuth, key string, err error) {
-	row := db.QueryRow("SELECT user_id, channel_defs.name, channel_defs.key, users.refresh_token, COALESCE(channel_defs.announce AND users.announce, false) FROM channel_defs LEFT JOIN users USING (user_id) WHERE "+column+" = $1", value)
-	var blob *string
-	err = row.Scan(&auth.UserID, &auth.Name, &key, &blob, &auth.Announce)
-	if err != nil || blob == nil || *blob == "" {
+// keyFingerprint is a short hash of a stream key
+func keyFingerprint(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:8])
+}
+
+// findChannel looks up a channel matching cond, which refers to value as $1.
+// Channels of deactivated users aren't found.
+// keys holds the current stream key, followed by the previous one if it is
+// still within its grace period.
+func findChannel(ctx context.Context, cond, value string) (auth ChannelAuth, keys []string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, "SELECT user_id, channel_defs.name, channel_defs.key, CASE WHEN prev_key_expires > now() THEN prev_key END, users.refresh_token, COALESCE(channel_defs.announce AND users.announce, false), channel_defs.ingest_allow, channel_defs.auto_captions FROM channel_defs LEFT JOIN users USING (user_id) WHERE users.deactivated IS NULL AND ("+cond+")", value)
+	var key string
+	var prevKey, blob *string
+	err = row.Scan(&auth.UserID, &auth.Name, &key, &prevKey, &blob, &auth.Announce, &auth.IngestAllow, &auth.AutoCaptions)
+	if err != nil {
+		return
+	}
+	keys = []string{key}
+	if prevKey != nil {
+		keys = append(keys, *prevKey)
+	}
+	if blob == nil || *blob == "" {
 		return
 	}
 	err = json.Unmarshal([]byte(*blob), &auth.Token)
 	return
 }
 
-func VerifyRTMP(u *url.URL) (auth ChannelAuth, err error) {
-	var expectKey string
-	auth, expectKey, err = findChannel("name", path.Base(u.Path))
+// keys are long random strings, so anything much shorter is a channel name
+const minPathKey = 32
+
+// VerifyRTMP checks an RTMP publish URL of the form /app/name?key=KEY, or
+// /app/KEY for encoders that can't add a query string
+func VerifyRTMP(ctx context.Context, u *url.URL) (auth ChannelAuth, err error) {
+	if !u.Query().Has("key") {
+		base := path.Base(u.Path)
+		if len(base) < minPathKey {
+			return auth, ErrUserNotFound
+		}
+		var keys []string
+		// each key has its own index, which an OR across both can't use
+		auth, keys, err = findChannel(ctx, `channel_defs.name IN (
+			SELECT name FROM channel_defs WHERE key = $1
+			UNION ALL SELECT name FROM channel_defs WHERE prev_key = $1 AND prev_key_expires > now())`, base)
+		if err == pgx.ErrNoRows {
+			err = ErrUserNotFound
+		} else if err == nil {
+			auth.PrevKey = base != keys[0]
+			auth.KeyFingerprint = keyFingerprint(base)
+		}
+		return
+	}
+	var keys []string
+	auth, keys, err = findChannel(ctx, "channel_defs.name = $1", path.Base(u.Path))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			err = ErrUserNotFound
@@ -40,29 +96,44 @@ func VerifyRTMP(u *url.URL) (auth ChannelAuth, err error) {
 		return
 	}
 	key := u.Query().Get("key")
-	if !hmac.Equal([]byte(key), []byte(expectKey)) {
-		log.Printf("error: key mismatch for RTMP channel %s", auth.Name)
-		err = ErrUserNotFound
+	matched := false
+	for i, expectKey := range keys {
+		if hmac.Equal([]byte(key), []byte(expectKey)) {
+			matched = true
+			auth.PrevKey = i > 0
+			auth.KeyFingerprint = keyFingerprint(expectKey)
+		}
+	}
+	if !matched {
+		slog.Warn("stream key mismatch", "proto", "rtmp", "channel", auth.Name, "user_id", auth.UserID)
+		err = &WrongKeyError{Auth: auth}
 		return
 	}
 	return
 }
 
-func VerifyFTL(channelID string, nonce, hmacProvided []byte) (auth ChannelAuth, err error) {
-	var expectKey string
-	auth, expectKey, err = findChannel("ftl_id", channelID)
+func VerifyFTL(ctx context.Context, channelID string, nonce, hmacProvided []byte) (auth ChannelAuth, err error) {
+	var keys []string
+	auth, keys, err = findChannel(ctx, "ftl_id = $1", channelID)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			err = ErrUserNotFound
 		}
 		return
 	}
-	hm := hmac.New(sha512.New, []byte(expectKey))
-	hm.Write(nonce)
-	expected := hm.Sum(nil)
-	if !hmac.Equal(expected, hmacProvided) {
-		log.Printf("error: hmac digest mismatch for FTL channel %s", auth.Name)
-		err = ErrUserNotFound
+	matched := false
+	for i, expectKey := range keys {
+		hm := hmac.New(sha512.New, []byte(expectKey))
+		hm.Write(nonce)
+		if hmac.Equal(hm.Sum(nil), hmacProvided) {
+			matched = true
+			auth.PrevKey = i > 0
+			auth.KeyFingerprint = keyFingerprint(expectKey)
+		}
+	}
+	if !matched {
+		slog.Warn("stream key mismatch", "proto", "ftl", "channel", auth.Name, "user_id", auth.UserID)
+		err = &WrongKeyError{Auth: auth}
 		return
 	}
 	return
@@ -1,18 +1,39 @@
 package model
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"io"
+	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
 )
 
 type ChannelDef struct {
 	Name     string `json:"name"`
 	Key      string `json:"key"`
 	Announce bool   `json:"announce"`
+	Private  bool   `json:"private"`
+	ChannelMeta
+
+	PatreonCampaign string `json:"patreon_campaign"`
+	PatreonMinCents int    `json:"patreon_min_cents"`
+	// MembersOnly admits only the override list and Patreon members
+	MembersOnly bool `json:"members_only"`
+
+	IngestAllow []string `json:"ingest_allow"`
+	// MaxViewers caps concurrent viewers, or is 0 for no limit
+	MaxViewers int `json:"max_viewers"`
+	// AutoCaptions transcribes the channel's speech into subtitles, if the
+	// server has a transcriber
+	AutoCaptions bool `json:"auto_captions"`
+
+	// when, where from and how the current key was last used to publish
+	KeyLastUsed     int64  `json:"key_last_used,omitempty"`
+	KeyLastAddr     string `json:"key_last_addr,omitempty"`
+	KeyLastProtocol string `json:"key_last_protocol,omitempty"`
 
 	RTMPDir  string `json:"rtmp_dir"`
 	RTMPBase string `json:"rtmp_base"`
@@ -24,16 +45,32 @@ func (d *ChannelDef) SetURL(base string) {
 	d.RTMPBase = url.PathEscape(d.Name) + "?" + v.Encode()
 }
 
-func ListChannelDefs(userID string) (defs []*ChannelDef, err error) {
-	rows, err := db.Query("SELECT name, key, announce FROM channel_defs WHERE user_id = $1", userID)
+const channelDefColumns = "name, key, announce, private, display_name, title, description, category, tags, rating, content_warnings, offline_text, offline_links, trailer_url, patreon_campaign, patreon_min_cents, members_only, ingest_allow, max_viewers, auto_captions, key_last_used, COALESCE(host(key_last_addr), ''), COALESCE(key_last_protocol, '')"
+
+func scanChannelDef(row pgx.Row) (*ChannelDef, error) {
+	def := new(ChannelDef)
+	var lastUsed *time.Time
+	if err := row.Scan(&def.Name, &def.Key, &def.Announce, &def.Private, &def.DisplayName, &def.Title, &def.Description, &def.Category, &def.Tags, &def.Rating, &def.Warnings, &def.OfflineText, &def.OfflineLinks, &def.TrailerURL, &def.PatreonCampaign, &def.PatreonMinCents, &def.MembersOnly, &def.IngestAllow, &def.MaxViewers, &def.AutoCaptions, &lastUsed, &def.KeyLastAddr, &def.KeyLastProtocol); err != nil {
+		return nil, err
+	}
+	if lastUsed != nil {
+		def.KeyLastUsed = lastUsed.UnixNano() / 1000000
+	}
+	return def, nil
+}
+
+func ListChannelDefs(ctx context.Context, userID string) (defs []*ChannelDef, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT "+channelDefColumns+" FROM channel_defs WHERE user_id = $1 AND NOT ephemeral", userID)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	defs = []*ChannelDef{}
 	for rows.Next() {
-		def := new(ChannelDef)
-		if err = rows.Scan(&def.Name, &def.Key, &def.Announce); err != nil {
+		var def *ChannelDef
+		if def, err = scanChannelDef(rows); err != nil {
 			return
 		}
 		defs = append(defs, def)
@@ -42,21 +79,184 @@ func ListChannelDefs(userID string) (defs []*ChannelDef, err error) {
 	return
 }
 
-func CreateChannel(userID, name string) (def *ChannelDef, err error) {
-	b := make([]byte, 24)
-	if _, err = io.ReadFull(rand.Reader, b); err != nil {
+// GetChannelDef returns one of a user's channels
+func GetChannelDef(ctx context.Context, userID, name string) (*ChannelDef, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	return scanChannelDef(db.QueryRow(ctx, "SELECT "+channelDefColumns+" FROM channel_defs WHERE user_id = $1 AND name = $2", userID, name))
+}
+
+var ErrTooManyChannels = errors.New("too many channels")
+
+// CreateChannel adds a channel for a user, who may have at most maxChannels
+// besides rooms unless the user has their own limit. Zero means no limit.
+func CreateChannel(ctx context.Context, userID, name string, maxChannels int) (def *ChannelDef, err error) {
+	key, err := newKey()
+	if err != nil {
 		return
 	}
-	key := hex.EncodeToString(b)
-	_, err = db.Exec("INSERT INTO channel_defs (user_id, name, key, announce) VALUES ($1, $2, $3, true)", userID, name, key)
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, `WITH quota AS (SELECT COALESCE((SELECT max_channels FROM users WHERE user_id = $1), $4) AS n)
+		INSERT INTO channel_defs (user_id, name, key, announce)
+		SELECT $1, $2, $3, true FROM quota
+		WHERE n <= 0 OR (SELECT count(*) FROM channel_defs WHERE user_id = $1 AND NOT ephemeral) < n`,
+		userID, name, key, maxChannels)
 	if err != nil {
 		return
+	} else if tag.RowsAffected() == 0 {
+		return nil, ErrTooManyChannels
 	}
-	return &ChannelDef{Name: name, Key: key, Announce: true}, nil
+	return &ChannelDef{Name: name, Key: key, Announce: true, ChannelMeta: ChannelMeta{Tags: []string{}, Warnings: []string{}, OfflineLinks: []ChannelLink{}}, IngestAllow: []string{}}, nil
 }
 
-func UpdateChannel(userID, name string, announce bool) error {
-	tag, err := db.Exec("UPDATE channel_defs SET announce = $1 WHERE user_id = $2 AND name = $3", announce, userID, name)
+// ChannelUpdate holds changes to a channel's settings. Nil fields are left as
+// they are.
+type ChannelUpdate struct {
+	// Name renames the channel, keeping its key and everything attached to it
+	Name        *string   `json:"name"`
+	Announce    *bool     `json:"announce"`
+	Private     *bool     `json:"private"`
+	DisplayName *string   `json:"display_name"`
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Category    *string   `json:"category"`
+	Tags        *[]string `json:"tags"`
+	Rating      *string   `json:"rating"`
+	Warnings    *[]string `json:"content_warnings"`
+
+	OfflineText  *string        `json:"offline_text"`
+	OfflineLinks *[]ChannelLink `json:"offline_links"`
+	TrailerURL   *string        `json:"trailer_url"`
+
+	PatreonCampaign *string `json:"patreon_campaign"`
+	PatreonMinCents *int    `json:"patreon_min_cents"`
+	MembersOnly     *bool   `json:"members_only"`
+
+	IngestAllow *[]string `json:"ingest_allow"`
+	MaxViewers  *int      `json:"max_viewers"`
+
+	AutoCaptions *bool `json:"auto_captions"`
+}
+
+func UpdateChannel(ctx context.Context, userID, name string, u ChannelUpdate) error {
+	args := []interface{}{userID, name}
+	sets := []string{"name = name"}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	cond := ""
+	if u.Name != nil && *u.Name != name {
+		set("name", *u.Name)
+		// rooms keep their generated names
+		cond = " AND NOT ephemeral"
+	}
+	if u.Announce != nil {
+		set("announce", *u.Announce)
+	}
+	if u.Private != nil {
+		set("private", *u.Private)
+	}
+	if u.DisplayName != nil {
+		set("display_name", *u.DisplayName)
+	}
+	if u.Title != nil {
+		set("title", *u.Title)
+	}
+	if u.Description != nil {
+		set("description", *u.Description)
+	}
+	if u.Category != nil {
+		set("category", *u.Category)
+	}
+	if u.Tags != nil {
+		tags := *u.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		set("tags", tags)
+	}
+	if u.Rating != nil {
+		set("rating", *u.Rating)
+	}
+	if u.Warnings != nil {
+		warnings := *u.Warnings
+		if warnings == nil {
+			warnings = []string{}
+		}
+		set("content_warnings", warnings)
+	}
+	if u.Rating != nil || u.Warnings != nil {
+		// viewers must acknowledge the new warnings
+		sets = append(sets, "content_updated = now()")
+	}
+	if u.OfflineText != nil {
+		set("offline_text", *u.OfflineText)
+	}
+	if u.OfflineLinks != nil {
+		links := *u.OfflineLinks
+		if links == nil {
+			links = []ChannelLink{}
+		}
+		set("offline_links", links)
+	}
+	if u.TrailerURL != nil {
+		set("trailer_url", *u.TrailerURL)
+	}
+	if u.PatreonCampaign != nil {
+		set("patreon_campaign", *u.PatreonCampaign)
+	}
+	if u.PatreonMinCents != nil {
+		set("patreon_min_cents", *u.PatreonMinCents)
+	}
+	if u.MembersOnly != nil {
+		set("members_only", *u.MembersOnly)
+	}
+	if u.IngestAllow != nil {
+		allow := *u.IngestAllow
+		if allow == nil {
+			allow = []string{}
+		}
+		set("ingest_allow", allow)
+	}
+	if u.MaxViewers != nil {
+		set("max_viewers", *u.MaxViewers)
+	}
+	if u.AutoCaptions != nil {
+		set("auto_captions", *u.AutoCaptions)
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, "UPDATE channel_defs SET "+strings.Join(sets, ", ")+" WHERE user_id = $1 AND name = $2"+cond, args...)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if cond != "" {
+		// thumbs aren't tied to the channel so one may be left over from a
+		// deleted channel of the same name
+		if _, err := tx.Exec(ctx, "DELETE FROM thumbs WHERE name = $1", *u.Name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE thumbs SET name = $2 WHERE name = $1", name, *u.Name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// SetMaxViewers caps a channel's concurrent viewers regardless of its owner
+func SetMaxViewers(ctx context.Context, name string, max int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "UPDATE channel_defs SET max_viewers = $2 WHERE name = $1", name, max)
 	if err != nil {
 		return err
 	} else if tag.RowsAffected() == 0 {
@@ -65,7 +265,33 @@ func UpdateChannel(userID, name string, announce bool) error {
 	return nil
 }
 
-func DeleteChannel(userID, name string) error {
-	_, err := db.Exec("DELETE FROM channel_defs WHERE user_id = $1 AND name = $2", userID, name)
+// checkOwner returns pgx.ErrNoRows if the channel doesn't exist or belongs to
+// someone else
+func checkOwner(ctx context.Context, userID, name string) error {
+	var ok bool
+	return db.QueryRow(ctx, "SELECT true FROM channel_defs WHERE user_id = $1 AND name = $2", userID, name).Scan(&ok)
+}
+
+// GetChannelOwner returns the ID of the user who owns a channel
+func GetChannelOwner(ctx context.Context, name string) (userID string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "SELECT user_id FROM channel_defs WHERE name = $1", name).Scan(&userID)
+	return
+}
+
+func DeleteChannel(ctx context.Context, userID, name string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "DELETE FROM channel_defs WHERE user_id = $1 AND name = $2", userID, name)
 	return err
 }
+
+// GetChannelKey returns the stream key of one of the user's channels, and its
+// FTL channel ID if it has been assigned one
+func GetChannelKey(ctx context.Context, userID, name string) (key, ftlID string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "SELECT key, COALESCE(ftl_id, '') FROM channel_defs WHERE user_id = $1 AND name = $2", userID, name).Scan(&key, &ftlID)
+	return
+}
@@ -1,32 +1,110 @@
 package model
 
-import "time"
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Content ratings. Anything other than RatingGeneral requires viewers to
+// acknowledge the channel's content warnings before watching.
+const (
+	RatingGeneral = ""
+	RatingMature  = "mature"
+	RatingAdult   = "adult"
+)
+
+// ChannelMeta is the owner-provided description of a channel
+type ChannelMeta struct {
+	// DisplayName is shown in place of the name if set
+	DisplayName string   `json:"display_name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Category    string   `json:"category"`
+	Tags        []string `json:"tags"`
+	Rating      string   `json:"rating"`
+	Warnings    []string `json:"content_warnings"`
+	// shown on the watch page while the channel is offline
+	OfflineText  string        `json:"offline_text"`
+	OfflineLinks []ChannelLink `json:"offline_links"`
+	TrailerURL   string        `json:"trailer_url"`
+}
+
+type ChannelLink struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
 
 type ChannelInfo struct {
 	Name    string `json:"name"`
 	Live    bool   `json:"live"`
 	Last    int64  `json:"last"`
 	Thumb   string `json:"thumb"`
+	Preview string `json:"preview,omitempty"`
 	LiveURL string `json:"live_url"`
+	// RTSPURL is only set for channels that RTSP clients may watch
+	RTSPURL string `json:"rtsp_url,omitempty"`
 	Viewers int    `json:"viewers"`
 	RTC     bool   `json:"rtc"`
+	// Codecs are those of the live stream, such as h264 and aac
+	Codecs  []string `json:"codecs,omitempty"`
+	Private bool     `json:"private"`
+	ChannelMeta
+	// ContentUpdated is when the rating or warnings last changed, after which
+	// they must be acknowledged again
+	ContentUpdated int64              `json:"content_updated"`
+	Upcoming       []*ScheduledStream `json:"upcoming,omitempty"`
+
+	// ThumbUpdated and PreviewUpdated are used to build cache-busting image URLs
+	ThumbUpdated   int64 `json:"-"`
+	PreviewUpdated int64 `json:"-"`
 }
 
-func ListChannelInfo() (ret []*ChannelInfo, err error) {
-	rows, err := db.Query("SELECT name, updated FROM thumbs ORDER BY greatest(now() - updated, '1 minute'::interval) ASC, 1 ASC")
+// last live is taken from the most recent publish session, which is still open
+// if the channel is live now. Channels with no sessions recorded fall back to
+// their last thumbnail, which was the last live time before sessions were.
+const lastLiveJoin = " LEFT JOIN LATERAL (SELECT max(COALESCE(ended, now())) AS last_live FROM stream_sessions WHERE channel_name = name) sessions ON true"
+
+const channelInfoColumns = "name, COALESCE(last_live, updated, 'epoch'), COALESCE(updated, 'epoch'), COALESCE(preview_updated, 'epoch'), COALESCE(private, false), COALESCE(display_name, ''), COALESCE(title, ''), COALESCE(description, ''), COALESCE(category, ''), COALESCE(tags, '{}'), COALESCE(rating, ''), COALESCE(content_warnings, '{}'), COALESCE(offline_text, ''), COALESCE(offline_links, '[]'), COALESCE(trailer_url, ''), COALESCE(content_updated, 'epoch')"
+
+func scanChannelInfo(row pgx.Row) (*ChannelInfo, error) {
+	info := new(ChannelInfo)
+	var last, thumbUpdated, previewUpdated, contentUpdated time.Time
+	if err := row.Scan(&info.Name, &last, &thumbUpdated, &previewUpdated, &info.Private, &info.DisplayName, &info.Title, &info.Description, &info.Category, &info.Tags, &info.Rating, &info.Warnings, &info.OfflineText, &info.OfflineLinks, &info.TrailerURL, &contentUpdated); err != nil {
+		return nil, err
+	}
+	info.ContentUpdated = contentUpdated.UnixNano() / 1000000
+	info.Last = last.UnixNano() / 1000000
+	info.ThumbUpdated = thumbUpdated.UnixNano() / 1000000
+	info.PreviewUpdated = previewUpdated.UnixNano() / 1000000
+	return info, nil
+}
+
+func ListChannelInfo(ctx context.Context) (ret []*ChannelInfo, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT "+channelInfoColumns+" FROM thumbs LEFT JOIN channel_defs USING (name)"+lastLiveJoin+" WHERE NOT COALESCE(ephemeral, false) AND NOT EXISTS (SELECT 1 FROM users u WHERE u.user_id = channel_defs.user_id AND u.deactivated IS NOT NULL) ORDER BY greatest(now() - COALESCE(last_live, updated, 'epoch'), '1 minute'::interval) ASC, 1 ASC")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		info := new(ChannelInfo)
-		var last time.Time
-		if err := rows.Scan(&info.Name, &last); err != nil {
+		info, err := scanChannelInfo(rows)
+		if err != nil {
 			return nil, err
 		}
-		info.Last = last.UnixNano() / 1000000
 		ret = append(ret, info)
 	}
 	err = rows.Err()
 	return
 }
+
+// GetChannelInfo returns the public description of a single channel, which
+// need not have gone live yet
+func GetChannelInfo(ctx context.Context, name string) (*ChannelInfo, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, "SELECT "+channelInfoColumns+" FROM channel_defs LEFT JOIN thumbs USING (name)"+lastLiveJoin+" WHERE name = $1", name)
+	return scanChannelInfo(row)
+}
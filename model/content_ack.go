@@ -0,0 +1,25 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// AckContent records that a viewer has acknowledged a channel's content
+// warnings
+func AckContent(ctx context.Context, userID, channelName string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "INSERT INTO content_acks (user_id, channel_name) VALUES ($1, $2) ON CONFLICT (user_id, channel_name) DO UPDATE SET acked = now()", userID, channelName)
+	return err
+}
+
+// ContentAcked returns true if the viewer acknowledged the channel's content
+// warnings since they last changed
+func ContentAcked(ctx context.Context, userID, channelName string, since time.Time) (acked bool, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM content_acks WHERE user_id = $1 AND channel_name = $2 AND acked >= $3)", userID, channelName, since)
+	err = row.Scan(&acked)
+	return
+}
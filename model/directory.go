@@ -0,0 +1,57 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// DirectoryEntry is another gunk instance as it last described itself
+type DirectoryEntry struct {
+	URL          string `json:"url"`
+	Name         string `json:"name"`
+	Channels     int    `json:"channels"`
+	LiveChannels int    `json:"live_channels"`
+	Updated      int64  `json:"updated"`
+}
+
+// PutDirectoryEntry adds an instance to the directory or refreshes it
+func PutDirectoryEntry(ctx context.Context, e DirectoryEntry) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, `INSERT INTO directory_instances (url, name, channels, live_channels) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (url) DO UPDATE SET name = EXCLUDED.name, channels = EXCLUDED.channels, live_channels = EXCLUDED.live_channels, updated = now()`,
+		e.URL, e.Name, e.Channels, e.LiveChannels)
+	return err
+}
+
+// ListDirectory returns the instances that have reported within maxAge, the
+// busiest first
+func ListDirectory(ctx context.Context, maxAge time.Duration) (entries []*DirectoryEntry, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT url, name, channels, live_channels, updated FROM directory_instances WHERE updated > now() - $1::interval ORDER BY live_channels DESC, channels DESC, name", maxAge)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	entries = []*DirectoryEntry{}
+	for rows.Next() {
+		e := new(DirectoryEntry)
+		var updated time.Time
+		if err = rows.Scan(&e.URL, &e.Name, &e.Channels, &e.LiveChannels, &updated); err != nil {
+			return
+		}
+		e.Updated = updated.UnixNano() / 1000000
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	return
+}
+
+// PruneDirectory forgets instances that haven't reported within maxAge
+func PruneDirectory(ctx context.Context, maxAge time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "DELETE FROM directory_instances WHERE updated < now() - $1::interval", maxAge)
+	return err
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GlobalBan keeps a user or an address range off every channel. Exactly one of
+// UserID and Addr is set.
+type GlobalBan struct {
+	ID     int64
+	UserID string
+	// Addr is an IP or CIDR range
+	Addr    string
+	Reason  string
+	Created time.Time
+	// Expires is nil for a permanent ban
+	Expires *time.Time
+}
+
+// AddGlobalBan stores a ban and returns its ID
+func AddGlobalBan(ctx context.Context, b GlobalBan) (id int64, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "INSERT INTO global_bans (user_id, addr, reason, expires) VALUES (NULLIF($1, ''), NULLIF($2, '')::cidr, $3, $4) RETURNING id",
+		b.UserID, b.Addr, b.Reason, b.Expires).Scan(&id)
+	return
+}
+
+// ListGlobalBans returns the bans that haven't expired, oldest first
+func ListGlobalBans(ctx context.Context) (bans []*GlobalBan, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT id, COALESCE(user_id, ''), COALESCE(addr::text, ''), reason, created, expires FROM global_bans WHERE expires IS NULL OR expires > now() ORDER BY created")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		b := new(GlobalBan)
+		if err = rows.Scan(&b.ID, &b.UserID, &b.Addr, &b.Reason, &b.Created, &b.Expires); err != nil {
+			return
+		}
+		bans = append(bans, b)
+	}
+	err = rows.Err()
+	return
+}
+
+// RemoveGlobalBan lifts a ban, also clearing out any that have expired
+func RemoveGlobalBan(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM global_bans WHERE id = $1", id)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	_, err = db.Exec(ctx, "DELETE FROM global_bans WHERE expires < now()")
+	return err
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// KeyUse is one time a channel's stream key was used to publish
+type KeyUse struct {
+	Addr     string `json:"addr"`
+	Protocol string `json:"protocol"`
+	PrevKey  bool   `json:"prev_key"`
+	Used     int64  `json:"used"`
+}
+
+// maxKeyUses is how many uses are kept per channel
+const maxKeyUses = 100
+
+// RecordKeyUse notes that a channel's stream key was used to publish from ip.
+// Uses of the previous key are only logged so that the last use shown is
+// always of the current key.
+func RecordKeyUse(ctx context.Context, channelName, ip, protocol string, prevKey bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if !prevKey {
+		if _, err := tx.Exec(ctx, "UPDATE channel_defs SET key_last_used = now(), key_last_addr = $2::inet, key_last_protocol = $3 WHERE name = $1", channelName, ip, protocol); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO ingest_audit (channel_name, addr, protocol, prev_key) VALUES ($1, $2::inet, $3, $4)", channelName, ip, protocol, prevKey); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM ingest_audit WHERE channel_name = $1 AND id NOT IN (SELECT id FROM ingest_audit WHERE channel_name = $1 ORDER BY used DESC LIMIT $2)", channelName, maxKeyUses); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListKeyUses returns the recent uses of one of a user's channel keys, newest
+// first
+func ListKeyUses(ctx context.Context, userID, channelName string) (uses []*KeyUse, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if err = checkOwner(ctx, userID, channelName); err != nil {
+		return
+	}
+	rows, err := db.Query(ctx, "SELECT host(addr), protocol, prev_key, used FROM ingest_audit WHERE channel_name = $1 ORDER BY used DESC", channelName)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	uses = []*KeyUse{}
+	for rows.Next() {
+		u := new(KeyUse)
+		var used time.Time
+		if err = rows.Scan(&u.Addr, &u.Protocol, &u.PrevKey, &used); err != nil {
+			return
+		}
+		u.Used = used.UnixNano() / 1000000
+		uses = append(uses, u)
+	}
+	err = rows.Err()
+	return
+}
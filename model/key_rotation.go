@@ -0,0 +1,130 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// KeyRotation is a user's schedule for replacing their channels' stream keys.
+// Days is zero if keys are never rotated automatically.
+type KeyRotation struct {
+	Days       int `json:"days"`
+	GraceHours int `json:"grace_hours"`
+}
+
+func GetKeyRotation(ctx context.Context, userID string) (kr KeyRotation, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "SELECT key_rotation_days, key_grace_hours FROM users WHERE user_id = $1", userID).Scan(&kr.Days, &kr.GraceHours)
+	return
+}
+
+// SetKeyRotation changes a user's rotation schedule. Rotations that were
+// already announced are rescheduled.
+func SetKeyRotation(ctx context.Context, userID string, kr KeyRotation) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, "UPDATE users SET key_rotation_days = $2, key_grace_hours = $3 WHERE user_id = $1", userID, kr.Days, kr.GraceHours)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	if _, err := tx.Exec(ctx, "UPDATE channel_defs SET key_rotation_due = NULL WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// KeyRotationEvent describes a scheduled or completed key rotation
+type KeyRotationEvent struct {
+	UserID  string
+	Channel string
+	// At is when the key will be or was replaced
+	At time.Time
+	// GraceUntil is when the previous key stops working
+	GraceUntil time.Time
+}
+
+// ClaimKeyRotationNotices schedules the next rotation of channels whose keys
+// are due to be replaced within notice. Each channel is returned once per
+// rotation, and is never rotated sooner than notice after it was returned.
+func ClaimKeyRotationNotices(ctx context.Context, notice time.Duration) (events []KeyRotationEvent, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, `UPDATE channel_defs d
+		SET key_rotation_due = greatest(d.key_rotated + make_interval(days => u.key_rotation_days), now() + $1::interval)
+		FROM users u
+		WHERE d.user_id = u.user_id AND NOT d.ephemeral AND u.key_rotation_days > 0 AND d.key_rotation_due IS NULL
+		AND d.key_rotated + make_interval(days => u.key_rotation_days) <= now() + $1::interval
+		RETURNING d.user_id, d.name, d.key_rotation_due`, notice)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ev KeyRotationEvent
+		if err = rows.Scan(&ev.UserID, &ev.Channel, &ev.At); err != nil {
+			return
+		}
+		events = append(events, ev)
+	}
+	err = rows.Err()
+	return
+}
+
+// RotateDueKeys replaces the keys of channels whose scheduled rotation has
+// arrived. The previous key keeps working for the owner's grace period.
+func RotateDueKeys(ctx context.Context) (events []KeyRotationEvent, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+	rows, err := tx.Query(ctx, `SELECT d.user_id, d.name, u.key_grace_hours
+		FROM channel_defs d JOIN users u USING (user_id)
+		WHERE u.key_rotation_days > 0 AND d.key_rotation_due <= now()
+		FOR UPDATE OF d SKIP LOCKED`)
+	if err != nil {
+		return
+	}
+	var graceHours []int
+	for rows.Next() {
+		var ev KeyRotationEvent
+		var grace int
+		if err = rows.Scan(&ev.UserID, &ev.Channel, &grace); err != nil {
+			rows.Close()
+			return
+		}
+		events = append(events, ev)
+		graceHours = append(graceHours, grace)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	for i := range events {
+		var key string
+		key, err = newKey()
+		if err != nil {
+			return
+		}
+		row := tx.QueryRow(ctx, `UPDATE channel_defs
+			SET prev_key = key, prev_key_expires = now() + make_interval(hours => $3), key = $2, key_rotated = now(), key_rotation_due = NULL, key_last_used = NULL, key_last_addr = NULL, key_last_protocol = NULL
+			WHERE name = $1 RETURNING key_rotated, prev_key_expires`, events[i].Channel, key, graceHours[i])
+		if err = row.Scan(&events[i].At, &events[i].GraceUntil); err != nil {
+			return
+		}
+	}
+	err = tx.Commit(ctx)
+	return
+}
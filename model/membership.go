@@ -0,0 +1,122 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/oauth2"
+)
+
+// PatreonMembership is a viewer's pledge to a single campaign
+type PatreonMembership struct {
+	CampaignID    string
+	PatronStatus  string
+	EntitledCents int
+}
+
+// SetPatreonLink stores a viewer's Patreon token and replaces their cached
+// memberships
+func SetPatreonLink(ctx context.Context, userID string, token *oauth2.Token, memberships []PatreonMembership) error {
+	blob, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "INSERT INTO patreon_links (user_id, token) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET token = EXCLUDED.token, checked = now()", userID, string(blob)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM patreon_memberships WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, m := range memberships {
+		if _, err := tx.Exec(ctx, "INSERT INTO patreon_memberships (user_id, campaign_id, patron_status, entitled_cents) VALUES ($1, $2, $3, $4)", userID, m.CampaignID, m.PatronStatus, m.EntitledCents); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetPatreonLink returns a viewer's stored Patreon token and when their
+// memberships were last refreshed
+func GetPatreonLink(ctx context.Context, userID string) (token *oauth2.Token, checked time.Time, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var blob string
+	row := db.QueryRow(ctx, "SELECT token, checked FROM patreon_links WHERE user_id = $1", userID)
+	if err = row.Scan(&blob, &checked); err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(blob), &token)
+	return
+}
+
+func DeletePatreonLink(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "DELETE FROM patreon_links WHERE user_id = $1", userID)
+	return err
+}
+
+// HasMembership returns true if the viewer is on the channel's override list
+// or has an active pledge that satisfies the channel's rules, if it has a
+// Patreon campaign
+func HasMembership(ctx context.Context, channelName, userID string, rules AccessRules) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var ok bool
+	row := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM membership_overrides WHERE channel_name = $1 AND user_id = $2)
+		OR $3 <> '' AND EXISTS (SELECT 1 FROM patreon_memberships WHERE user_id = $2 AND campaign_id = $3 AND patron_status = 'active_patron' AND entitled_cents >= $4)`,
+		channelName, userID, rules.PatreonCampaign, rules.PatreonMinCents)
+	err := row.Scan(&ok)
+	return ok, err
+}
+
+func ListMembershipOverrides(ctx context.Context, ownerID, channelName string) (userIDs []string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT membership_overrides.user_id FROM membership_overrides JOIN channel_defs ON channel_defs.name = channel_name WHERE channel_defs.user_id = $1 AND channel_name = $2 ORDER BY 1", ownerID, channelName)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	userIDs = []string{}
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return
+		}
+		userIDs = append(userIDs, userID)
+	}
+	err = rows.Err()
+	return
+}
+
+func AddMembershipOverride(ctx context.Context, ownerID, channelName, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if err := checkOwner(ctx, ownerID, channelName); err != nil {
+		return err
+	}
+	_, err := db.Exec(ctx, "INSERT INTO membership_overrides (channel_name, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", channelName, userID)
+	return err
+}
+
+func DeleteMembershipOverride(ctx context.Context, ownerID, channelName, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM membership_overrides USING channel_defs WHERE channel_defs.name = channel_name AND channel_defs.user_id = $1 AND channel_name = $2 AND membership_overrides.user_id = $3", ownerID, channelName, userID)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
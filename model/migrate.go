@@ -0,0 +1,79 @@
+package model
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrate applies any migrations that haven't been yet, each in its own
+// transaction, and returns the names of those it ran. Migrations numbered up
+// to baseline are recorded as applied without running them, for databases
+// that were migrated by hand before migrations were tracked.
+func Migrate(ctx context.Context, baseline int) (ran []string, err error) {
+	if _, err := db.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version int PRIMARY KEY, name text NOT NULL, applied timestamptz NOT NULL DEFAULT now())"); err != nil {
+		return nil, err
+	}
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, fname := range names {
+		name := strings.TrimSuffix(strings.TrimPrefix(fname, "migrations/"), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return ran, fmt.Errorf("migration %s: name must start with its number", name)
+		}
+		script, err := migrations.ReadFile(fname)
+		if err != nil {
+			return ran, err
+		}
+		applied, err := applyMigration(ctx, version, name, string(script), version <= baseline)
+		if err != nil {
+			return ran, fmt.Errorf("migration %s: %w", name, err)
+		} else if applied {
+			ran = append(ran, name)
+		}
+	}
+	return ran, nil
+}
+
+func applyMigration(ctx context.Context, version int, name, script string, skip bool) (bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+	// serialize concurrent migrators
+	if _, err := tx.Exec(ctx, "LOCK TABLE schema_migrations"); err != nil {
+		return false, err
+	}
+	var done bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&done); err != nil {
+		return false, err
+	} else if done {
+		return false, nil
+	}
+	if !skip {
+		// schema changes can take longer than the usual query timeout
+		if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+			return false, err
+		}
+		if _, err := tx.Exec(ctx, script); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", version, name); err != nil {
+		return false, err
+	}
+	return !skip, tx.Commit(ctx)
+}
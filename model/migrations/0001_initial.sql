@@ -0,0 +1,19 @@
+CREATE TABLE users (
+    user_id text PRIMARY KEY,
+    refresh_token text,
+    announce boolean NOT NULL DEFAULT false
+);
+
+CREATE TABLE channel_defs (
+    name text PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    key text NOT NULL,
+    announce boolean NOT NULL DEFAULT true,
+    ftl_id text UNIQUE
+);
+
+CREATE TABLE thumbs (
+    name text PRIMARY KEY,
+    thumb bytea NOT NULL,
+    updated timestamptz NOT NULL DEFAULT now()
+);
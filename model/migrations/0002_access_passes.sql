@@ -0,0 +1,12 @@
+ALTER TABLE channel_defs ADD COLUMN private boolean NOT NULL DEFAULT false;
+
+CREATE TABLE access_passes (
+    code text PRIMARY KEY,
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    duration interval NOT NULL,
+    created timestamptz NOT NULL DEFAULT now(),
+    redeem_by timestamptz,
+    redeemed_by text,
+    redeemed_at timestamptz
+);
+CREATE INDEX ON access_passes (channel_name);
@@ -0,0 +1,6 @@
+ALTER TABLE channel_defs
+    ADD COLUMN title text NOT NULL DEFAULT '',
+    ADD COLUMN description text NOT NULL DEFAULT '',
+    ADD COLUMN category text NOT NULL DEFAULT '',
+    ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+CREATE INDEX ON channel_defs (category);
@@ -0,0 +1,23 @@
+ALTER TABLE channel_defs
+    ADD COLUMN patreon_campaign text NOT NULL DEFAULT '',
+    ADD COLUMN patreon_min_cents integer NOT NULL DEFAULT 0;
+
+CREATE TABLE patreon_links (
+    user_id text PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    token text NOT NULL,
+    checked timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE patreon_memberships (
+    user_id text NOT NULL REFERENCES patreon_links ON DELETE CASCADE,
+    campaign_id text NOT NULL,
+    patron_status text NOT NULL,
+    entitled_cents integer NOT NULL,
+    PRIMARY KEY (user_id, campaign_id)
+);
+
+CREATE TABLE membership_overrides (
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    user_id text NOT NULL,
+    PRIMARY KEY (channel_name, user_id)
+);
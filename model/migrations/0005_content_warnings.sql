@@ -0,0 +1,11 @@
+ALTER TABLE channel_defs
+    ADD COLUMN rating text NOT NULL DEFAULT '',
+    ADD COLUMN content_warnings text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN content_updated timestamptz NOT NULL DEFAULT now();
+
+CREATE TABLE content_acks (
+    user_id text NOT NULL,
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    acked timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, channel_name)
+);
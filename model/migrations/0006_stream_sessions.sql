@@ -0,0 +1,10 @@
+CREATE TABLE stream_sessions (
+    id bigserial PRIMARY KEY,
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    protocol text NOT NULL,
+    started timestamptz NOT NULL DEFAULT now(),
+    updated timestamptz NOT NULL DEFAULT now(),
+    ended timestamptz,
+    peak_viewers integer NOT NULL DEFAULT 0
+);
+CREATE INDEX ON stream_sessions (channel_name, started DESC);
@@ -0,0 +1,11 @@
+CREATE TABLE scheduled_streams (
+    id bigserial PRIMARY KEY,
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    title text NOT NULL DEFAULT '',
+    starts timestamptz NOT NULL,
+    duration interval NOT NULL,
+    announce boolean NOT NULL DEFAULT false,
+    announced timestamptz
+);
+CREATE INDEX ON scheduled_streams (channel_name, starts);
+CREATE INDEX ON scheduled_streams (starts);
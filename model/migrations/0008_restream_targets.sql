@@ -0,0 +1,11 @@
+CREATE TABLE restream_targets (
+    id bigserial PRIMARY KEY,
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    label text NOT NULL DEFAULT '',
+    url text NOT NULL,
+    -- stream key sealed with the server secret
+    sealed_key bytea NOT NULL,
+    enabled boolean NOT NULL DEFAULT true,
+    created timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX ON restream_targets (channel_name);
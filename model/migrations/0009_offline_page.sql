@@ -0,0 +1,4 @@
+ALTER TABLE channel_defs
+    ADD COLUMN offline_text text NOT NULL DEFAULT '',
+    ADD COLUMN offline_links jsonb NOT NULL DEFAULT '[]',
+    ADD COLUMN trailer_url text NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE thumbs
+    ADD COLUMN preview bytea,
+    ADD COLUMN preview_updated timestamptz;
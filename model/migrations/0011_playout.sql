@@ -0,0 +1,12 @@
+ALTER TABLE channel_defs
+    ADD COLUMN playout boolean NOT NULL DEFAULT false;
+
+CREATE TABLE playout_items (
+    id bigserial PRIMARY KEY,
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    position integer NOT NULL,
+    title text NOT NULL DEFAULT '',
+    -- http(s) URL or a file name under the server's playout directory
+    source text NOT NULL
+);
+CREATE INDEX ON playout_items (channel_name, position);
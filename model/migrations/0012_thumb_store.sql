@@ -0,0 +1,3 @@
+-- images may live in external storage, leaving only the timestamps here
+ALTER TABLE thumbs
+    ALTER COLUMN thumb DROP NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE channel_defs
+    ADD COLUMN playout_failover boolean NOT NULL DEFAULT false;
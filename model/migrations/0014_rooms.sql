@@ -0,0 +1,6 @@
+ALTER TABLE channel_defs
+    ADD COLUMN created timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN ephemeral boolean NOT NULL DEFAULT false,
+    ADD COLUMN expires timestamptz,
+    ADD COLUMN idle_expiry interval;
+CREATE INDEX ON channel_defs (expires) WHERE ephemeral;
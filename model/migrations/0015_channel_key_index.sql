@@ -0,0 +1,2 @@
+-- RTMP publishers may identify their channel by stream key alone
+CREATE INDEX ON channel_defs (key);
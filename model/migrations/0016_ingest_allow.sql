@@ -0,0 +1,2 @@
+-- addresses allowed to publish to the channel, or empty for anywhere
+ALTER TABLE channel_defs ADD COLUMN ingest_allow text[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,12 @@
+-- channels flagged for a moderator to review
+CREATE TABLE moderation_queue (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL REFERENCES channel_defs ON DELETE CASCADE,
+    reason text NOT NULL,
+    score real,
+    image bytea,
+    created timestamptz NOT NULL DEFAULT now(),
+    resolved timestamptz
+);
+-- a channel has at most one open flag for each reason
+CREATE UNIQUE INDEX ON moderation_queue (name, reason) WHERE resolved IS NULL;
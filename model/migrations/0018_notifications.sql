@@ -0,0 +1,11 @@
+-- messages for users, shown in the UI until dismissed
+CREATE TABLE notifications (
+    id bigserial PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL,
+    channel text NOT NULL DEFAULT '',
+    message text NOT NULL,
+    created timestamptz NOT NULL DEFAULT now(),
+    seen boolean NOT NULL DEFAULT false
+);
+CREATE INDEX ON notifications (user_id, created);
@@ -0,0 +1,11 @@
+-- stream keys can be replaced on a schedule chosen by the owner. The previous
+-- key keeps working for a grace period so encoders can be updated.
+ALTER TABLE users
+    ADD COLUMN key_rotation_days integer NOT NULL DEFAULT 0,
+    ADD COLUMN key_grace_hours integer NOT NULL DEFAULT 24;
+ALTER TABLE channel_defs
+    ADD COLUMN key_rotated timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN key_rotation_due timestamptz,
+    ADD COLUMN prev_key text,
+    ADD COLUMN prev_key_expires timestamptz;
+CREATE INDEX ON channel_defs (prev_key) WHERE prev_key IS NOT NULL;
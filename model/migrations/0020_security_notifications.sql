@@ -0,0 +1,15 @@
+-- where and which notifications each user wants
+ALTER TABLE users
+    ADD COLUMN notify_muted text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN notify_email text NOT NULL DEFAULT '',
+    ADD COLUMN notify_webhook text NOT NULL DEFAULT '',
+    ADD COLUMN notify_discord boolean NOT NULL DEFAULT false;
+
+-- addresses each channel has been published from, to spot unfamiliar ones
+CREATE TABLE publish_addrs (
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    addr inet NOT NULL,
+    first_seen timestamptz NOT NULL DEFAULT now(),
+    last_seen timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (channel_name, addr)
+);
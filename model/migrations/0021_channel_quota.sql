@@ -0,0 +1,2 @@
+-- overrides the configured limit on channels per user, 0 for no limit
+ALTER TABLE users ADD COLUMN max_channels integer;
@@ -0,0 +1,4 @@
+-- let channels be renamed with a flag open
+ALTER TABLE moderation_queue
+    DROP CONSTRAINT moderation_queue_name_fkey,
+    ADD FOREIGN KEY (name) REFERENCES channel_defs ON DELETE CASCADE ON UPDATE CASCADE;
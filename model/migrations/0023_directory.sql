@@ -0,0 +1,9 @@
+-- gunk instances listed in this server's directory
+CREATE TABLE directory_instances (
+    url text PRIMARY KEY,
+    name text NOT NULL,
+    channels integer NOT NULL,
+    live_channels integer NOT NULL,
+    updated timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX ON directory_instances (updated);
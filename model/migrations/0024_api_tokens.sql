@@ -0,0 +1,11 @@
+-- credentials for bots and dashboards, limited to the scopes they were given
+CREATE TABLE api_tokens (
+    id bigserial PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    token_hash bytea NOT NULL UNIQUE,
+    scopes text[] NOT NULL,
+    created timestamptz NOT NULL DEFAULT now(),
+    last_used timestamptz
+);
+CREATE INDEX ON api_tokens (user_id);
@@ -0,0 +1,2 @@
+-- shown instead of the name, which stays a URL-safe slug
+ALTER TABLE channel_defs ADD COLUMN display_name text NOT NULL DEFAULT '';
@@ -0,0 +1,16 @@
+-- when and where each channel's stream key was last used
+ALTER TABLE channel_defs
+    ADD COLUMN key_last_used timestamptz,
+    ADD COLUMN key_last_addr inet,
+    ADD COLUMN key_last_protocol text;
+
+-- recent uses of each channel's stream key
+CREATE TABLE ingest_audit (
+    id bigserial PRIMARY KEY,
+    channel_name text NOT NULL REFERENCES channel_defs (name) ON DELETE CASCADE ON UPDATE CASCADE,
+    addr inet NOT NULL,
+    protocol text NOT NULL,
+    prev_key boolean NOT NULL DEFAULT false,
+    used timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX ON ingest_audit (channel_name, used DESC);
@@ -0,0 +1,23 @@
+-- companion apps that can ask users for API tokens through OAuth2
+CREATE TABLE oauth_clients (
+    client_id text PRIMARY KEY,
+    name text NOT NULL,
+    -- NULL for public clients, which must use PKCE instead
+    secret_hash bytea,
+    redirect_uris text[] NOT NULL,
+    created timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE oauth_codes (
+    code_hash bytea PRIMARY KEY,
+    client_id text NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    scopes text[] NOT NULL,
+    redirect_uri text NOT NULL,
+    code_challenge text NOT NULL DEFAULT '',
+    expires timestamptz NOT NULL
+);
+
+-- tokens issued to an app are revoked along with it
+ALTER TABLE api_tokens ADD COLUMN client_id text REFERENCES oauth_clients ON DELETE CASCADE;
+CREATE UNIQUE INDEX ON api_tokens (user_id, client_id) WHERE client_id IS NOT NULL;
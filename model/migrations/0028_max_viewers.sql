@@ -0,0 +1,2 @@
+-- cap on concurrent viewers, 0 for no limit
+ALTER TABLE channel_defs ADD COLUMN max_viewers integer NOT NULL DEFAULT 0;
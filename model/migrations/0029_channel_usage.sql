@@ -0,0 +1,8 @@
+-- bytes sent to viewers per channel, day and playback protocol
+CREATE TABLE channel_usage (
+    name text NOT NULL REFERENCES channel_defs (name) ON UPDATE CASCADE ON DELETE CASCADE,
+    day date NOT NULL,
+    protocol text NOT NULL,
+    bytes bigint NOT NULL,
+    PRIMARY KEY (name, day, protocol)
+);
@@ -0,0 +1 @@
+ALTER TABLE stream_sessions ADD COLUMN unique_viewers integer NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- viewers of live channels sampled each minute
+CREATE TABLE viewer_counts (
+    name text NOT NULL REFERENCES channel_defs (name) ON UPDATE CASCADE ON DELETE CASCADE,
+    minute timestamptz NOT NULL,
+    average real NOT NULL,
+    peak integer NOT NULL,
+    PRIMARY KEY (name, minute)
+);
@@ -0,0 +1,10 @@
+-- instance-wide bans of a user or an address range
+CREATE TABLE global_bans (
+    id bigserial PRIMARY KEY,
+    user_id text,
+    addr cidr,
+    reason text NOT NULL DEFAULT '',
+    created timestamptz NOT NULL DEFAULT now(),
+    expires timestamptz,
+    CHECK ((user_id IS NULL) <> (addr IS NULL))
+);
@@ -0,0 +1,6 @@
+-- when each user last logged in or went live, so that abandoned accounts can
+-- be deactivated and their channel names freed
+ALTER TABLE users
+    ADD COLUMN last_active timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN deactivation_warned timestamptz,
+    ADD COLUMN deactivated timestamptz;
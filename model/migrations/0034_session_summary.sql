@@ -0,0 +1,6 @@
+-- end-of-stream summaries; average_viewers is filled in from viewer_counts
+-- when the session ends
+ALTER TABLE stream_sessions
+    ADD COLUMN average_viewers real NOT NULL DEFAULT 0,
+    ADD COLUMN peak_bitrate integer NOT NULL DEFAULT 0,
+    ADD COLUMN dropped_packets bigint NOT NULL DEFAULT 0;
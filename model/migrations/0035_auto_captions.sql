@@ -0,0 +1 @@
+ALTER TABLE channel_defs ADD COLUMN auto_captions boolean NOT NULL DEFAULT false;
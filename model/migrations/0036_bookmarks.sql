@@ -0,0 +1,11 @@
+-- moments viewers saved while watching, as an offset into the session so that
+-- they still point at the same place in a recording
+CREATE TABLE bookmarks (
+    id bigserial PRIMARY KEY,
+    user_id text NOT NULL REFERENCES users ON DELETE CASCADE,
+    session_id bigint NOT NULL REFERENCES stream_sessions ON DELETE CASCADE,
+    position integer NOT NULL,
+    note text NOT NULL DEFAULT '',
+    created timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX ON bookmarks (user_id, created);
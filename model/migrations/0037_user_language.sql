@@ -0,0 +1,2 @@
+-- the language of messages sent to the user, or empty for the default
+ALTER TABLE users ADD COLUMN language text NOT NULL DEFAULT '';
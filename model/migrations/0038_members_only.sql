@@ -0,0 +1,2 @@
+-- admit only members, for channels without a Patreon campaign
+ALTER TABLE channel_defs ADD COLUMN members_only boolean NOT NULL DEFAULT false;
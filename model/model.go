@@ -1,23 +1,50 @@
 package model
 
 import (
+	"context"
 	"errors"
+	"strconv"
+	"time"
 
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var db *pgx.ConnPool
+var (
+	db           *pgxpool.Pool
+	queryTimeout = 10 * time.Second
+)
 
-func Connect() error {
-	conf, err := pgx.ParseEnvLibpq()
+// Connect opens the database pool. An empty connString uses libpq-style
+// environment variables. Queries are aborted server-side after timeout and
+// client-side after the same interval has elapsed on the caller's context. A
+// zero timeout keeps the default.
+func Connect(ctx context.Context, connString string, timeout time.Duration) error {
+	conf, err := pgxpool.ParseConfig(connString)
 	if err != nil {
 		return err
 	}
-	db, err = pgx.NewConnPool(pgx.ConnPoolConfig{
-		ConnConfig:     conf,
-		MaxConnections: 10,
-	})
+	if timeout > 0 {
+		queryTimeout = timeout
+	}
+	conf.MaxConns = 10
+	conf.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(queryTimeout.Milliseconds(), 10)
+	db, err = pgxpool.NewWithConfig(ctx, conf)
 	return err
 }
 
+// withTimeout bounds a query by the configured timeout in addition to any
+// deadline the caller's context already carries
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
 var ErrUserNotFound = errors.New("user not found or wrong key")
+
+// WrongKeyError is returned when a channel exists but the publisher's key
+// doesn't match it. It is reported to publishers as ErrUserNotFound.
+type WrongKeyError struct {
+	Auth ChannelAuth
+}
+
+func (e *WrongKeyError) Error() string        { return ErrUserNotFound.Error() }
+func (e *WrongKeyError) Is(target error) bool { return target == ErrUserNotFound }
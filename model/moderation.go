@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// ModerationFlag is a channel waiting for a moderator to review it
+type ModerationFlag struct {
+	ID      int64
+	Name    string
+	Reason  string
+	Score   float64
+	Created time.Time
+}
+
+// FlagChannel adds a channel to the moderation queue along with the image
+// that triggered it. It returns false if the channel already has an open flag
+// for the same reason.
+func FlagChannel(ctx context.Context, name, reason string, score float64, image []byte) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "INSERT INTO moderation_queue (name, reason, score, image) VALUES ($1, $2, $3, $4) ON CONFLICT (name, reason) WHERE resolved IS NULL DO NOTHING", name, reason, score, image)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() != 0, nil
+}
+
+// ListFlags returns the open flags, oldest first
+func ListFlags(ctx context.Context) (flags []ModerationFlag, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT id, name, reason, COALESCE(score, 0), created FROM moderation_queue WHERE resolved IS NULL ORDER BY created")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f ModerationFlag
+		if err = rows.Scan(&f.ID, &f.Name, &f.Reason, &f.Score, &f.Created); err != nil {
+			return
+		}
+		flags = append(flags, f)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetFlagImage returns the image that triggered a flag
+func GetFlagImage(ctx context.Context, id int64) (image []byte, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "SELECT image FROM moderation_queue WHERE id = $1 AND image IS NOT NULL", id).Scan(&image)
+	return
+}
+
+// ResolveFlag closes a flag once it has been reviewed and returns the name of
+// the flagged channel
+func ResolveFlag(ctx context.Context, id int64) (name string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "UPDATE moderation_queue SET resolved = now() WHERE id = $1 AND resolved IS NULL RETURNING name", id).Scan(&name)
+	return
+}
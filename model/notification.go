@@ -0,0 +1,84 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type Notification struct {
+	ID      int64  `json:"id"`
+	Kind    string `json:"kind"`
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+	Created int64  `json:"created"`
+	Seen    bool   `json:"seen"`
+}
+
+// AddNotification stores a notification in a user's inbox and returns it
+func AddNotification(ctx context.Context, userID, kind, channel, message string) (*Notification, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	n := &Notification{Kind: kind, Channel: channel, Message: message}
+	var created time.Time
+	if err := db.QueryRow(ctx, "INSERT INTO notifications (user_id, kind, channel, message) VALUES ($1, $2, $3, $4) RETURNING id, created", userID, kind, channel, message).Scan(&n.ID, &created); err != nil {
+		return nil, err
+	}
+	n.Created = created.UnixNano() / 1000000
+	return n, nil
+}
+
+// ListNotifications returns a user's most recent notifications, newest first
+func ListNotifications(ctx context.Context, userID string, limit int) (notes []*Notification, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT id, kind, channel, message, created, seen FROM notifications WHERE user_id = $1 ORDER BY created DESC LIMIT $2", userID, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	notes = []*Notification{}
+	for rows.Next() {
+		n := new(Notification)
+		var created time.Time
+		if err = rows.Scan(&n.ID, &n.Kind, &n.Channel, &n.Message, &created, &n.Seen); err != nil {
+			return
+		}
+		n.Created = created.UnixNano() / 1000000
+		notes = append(notes, n)
+	}
+	err = rows.Err()
+	return
+}
+
+// MarkNotificationsSeen marks all of a user's notifications as seen
+func MarkNotificationsSeen(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "UPDATE notifications SET seen = true WHERE user_id = $1 AND NOT seen", userID)
+	return err
+}
+
+// MarkNotificationSeen marks one of a user's notifications as seen. It returns
+// pgx.ErrNoRows if the notification doesn't exist or belongs to someone else.
+func MarkNotificationSeen(ctx context.Context, userID string, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "UPDATE notifications SET seen = true WHERE user_id = $1 AND id = $2", userID, id)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// CountUnreadNotifications returns how many of a user's notifications haven't
+// been seen
+func CountUnreadNotifications(ctx context.Context, userID string) (count int, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "SELECT count(*) FROM notifications WHERE user_id = $1 AND NOT seen", userID).Scan(&count)
+	return
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"context"
+
+	"eaglesong.dev/gunk/notify"
+	"github.com/jackc/pgx/v5"
+)
+
+func GetNotifyPrefs(ctx context.Context, userID string) (p notify.Prefs, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "SELECT notify_muted, notify_email, notify_webhook, notify_discord, language FROM users WHERE user_id = $1", userID).Scan(&p.Muted, &p.Email, &p.Webhook, &p.Discord, &p.Language)
+	return
+}
+
+func SetNotifyPrefs(ctx context.Context, userID string, p notify.Prefs) error {
+	if p.Muted == nil {
+		p.Muted = []string{}
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "UPDATE users SET notify_muted = $2, notify_email = $3, notify_webhook = $4, notify_discord = $5, language = $6 WHERE user_id = $1", userID, p.Muted, p.Email, p.Webhook, p.Discord, p.Language)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"context"
+	"crypto/hmac"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// OAuthClient is a companion app that users can grant API tokens to
+type OAuthClient struct {
+	ClientID     string
+	Name         string
+	RedirectURIs []string
+	Created      time.Time
+
+	secretHash []byte
+}
+
+// Public returns true if the client has no secret and must prove itself with
+// PKCE instead
+func (c *OAuthClient) Public() bool {
+	return c.secretHash == nil
+}
+
+func (c *OAuthClient) CheckSecret(secret string) bool {
+	return c.secretHash != nil && hmac.Equal(c.secretHash, hashToken(secret))
+}
+
+// CreateOAuthClient registers an app. Public clients get no secret.
+func CreateOAuthClient(ctx context.Context, name string, redirectURIs []string, public bool) (c *OAuthClient, secret string, err error) {
+	clientID, err := newKey()
+	if err != nil {
+		return
+	}
+	c = &OAuthClient{ClientID: clientID[:24], Name: name, RedirectURIs: redirectURIs}
+	if !public {
+		if secret, err = newKey(); err != nil {
+			return nil, "", err
+		}
+		c.secretHash = hashToken(secret)
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.QueryRow(ctx, "INSERT INTO oauth_clients (client_id, name, secret_hash, redirect_uris) VALUES ($1, $2, $3, $4) RETURNING created",
+		c.ClientID, c.Name, c.secretHash, c.RedirectURIs).Scan(&c.Created)
+	if err != nil {
+		return nil, "", err
+	}
+	return
+}
+
+const oauthClientColumns = "client_id, name, secret_hash, redirect_uris, created"
+
+func scanOAuthClient(row pgx.Row) (*OAuthClient, error) {
+	c := new(OAuthClient)
+	if err := row.Scan(&c.ClientID, &c.Name, &c.secretHash, &c.RedirectURIs, &c.Created); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetOAuthClient returns pgx.ErrNoRows if the client is unknown
+func GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	return scanOAuthClient(db.QueryRow(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients WHERE client_id = $1", clientID))
+}
+
+func ListOAuthClients(ctx context.Context) (clients []*OAuthClient, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT "+oauthClientColumns+" FROM oauth_clients ORDER BY created")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	err = rows.Err()
+	return
+}
+
+// DeleteOAuthClient removes an app along with every token issued to it
+func DeleteOAuthClient(ctx context.Context, clientID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM oauth_clients WHERE client_id = $1", clientID)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// OAuthGrant is what a user agreed to give an app, held by an authorization
+// code until the app redeems it
+type OAuthGrant struct {
+	ClientID      string
+	UserID        string
+	Scopes        []string
+	RedirectURI   string
+	CodeChallenge string
+}
+
+// CreateOAuthCode stores a grant under a new single-use code
+func CreateOAuthCode(ctx context.Context, g OAuthGrant, ttl time.Duration) (code string, err error) {
+	if code, err = newKey(); err != nil {
+		return
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if _, err = db.Exec(ctx, "DELETE FROM oauth_codes WHERE expires < now()"); err != nil {
+		return "", err
+	}
+	_, err = db.Exec(ctx, `INSERT INTO oauth_codes (code_hash, client_id, user_id, scopes, redirect_uri, code_challenge, expires)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		hashToken(code), g.ClientID, g.UserID, g.Scopes, g.RedirectURI, g.CodeChallenge, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return
+}
+
+// RedeemOAuthCode consumes a code, returning pgx.ErrNoRows if it is unknown,
+// expired or already used
+func RedeemOAuthCode(ctx context.Context, code string) (*OAuthGrant, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	g := new(OAuthGrant)
+	err := db.QueryRow(ctx, `DELETE FROM oauth_codes WHERE code_hash = $1 AND expires > now()
+		RETURNING client_id, user_id, scopes, redirect_uri, code_challenge`, hashToken(code)).Scan(
+		&g.ClientID, &g.UserID, &g.Scopes, &g.RedirectURI, &g.CodeChallenge)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// IssueClientToken creates the API token for a redeemed grant. A user holds at
+// most one token per app, so authorizing it again replaces the old one.
+func IssueClientToken(ctx context.Context, c *OAuthClient, g *OAuthGrant) (token string, err error) {
+	key, err := newKey()
+	if err != nil {
+		return
+	}
+	token = tokenPrefix + key
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err = db.Exec(ctx, `INSERT INTO api_tokens (user_id, name, token_hash, scopes, client_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, client_id) WHERE client_id IS NOT NULL DO UPDATE
+		SET name = excluded.name, token_hash = excluded.token_hash, scopes = excluded.scopes, created = now(), last_used = NULL`,
+		g.UserID, c.Name, hashToken(token), g.Scopes, c.ClientID)
+	if err != nil {
+		return "", err
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package model
+
+import "context"
+
+// PlayoutItem is one entry in a channel's looping playlist
+type PlayoutItem struct {
+	Title  string `json:"title"`
+	Source string `json:"source"`
+}
+
+// Playout is a channel's playlist, whether it is played when nobody is live,
+// and whether it covers for a live publisher that drops
+type Playout struct {
+	Enabled  bool           `json:"enabled"`
+	Failover bool           `json:"failover"`
+	Items    []*PlayoutItem `json:"items"`
+}
+
+// ListPlayoutItems returns a channel's playlist in order
+func ListPlayoutItems(ctx context.Context, channelName string) (items []*PlayoutItem, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT title, source FROM playout_items WHERE channel_name = $1 ORDER BY position", channelName)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	items = []*PlayoutItem{}
+	for rows.Next() {
+		item := new(PlayoutItem)
+		if err = rows.Scan(&item.Title, &item.Source); err != nil {
+			return
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	return
+}
+
+func GetPlayout(ctx context.Context, userID, channelName string) (*Playout, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	p := new(Playout)
+	row := db.QueryRow(ctx, "SELECT playout, playout_failover FROM channel_defs WHERE user_id = $1 AND name = $2", userID, channelName)
+	if err := row.Scan(&p.Enabled, &p.Failover); err != nil {
+		return nil, err
+	}
+	var err error
+	p.Items, err = ListPlayoutItems(ctx, channelName)
+	return p, err
+}
+
+// SetPlayout replaces a channel's playlist
+func SetPlayout(ctx context.Context, userID, channelName string, p *Playout) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var ok bool
+	if err := tx.QueryRow(ctx, "UPDATE channel_defs SET playout = $3, playout_failover = $4 WHERE user_id = $1 AND name = $2 RETURNING true", userID, channelName, p.Enabled, p.Failover).Scan(&ok); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM playout_items WHERE channel_name = $1", channelName); err != nil {
+		return err
+	}
+	for i, item := range p.Items {
+		if _, err := tx.Exec(ctx, "INSERT INTO playout_items (channel_name, position, title, source) VALUES ($1, $2, $3, $4)", channelName, i, item.Title, item.Source); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// EnabledPlayouts returns the names of channels that should be running their
+// playlist
+func EnabledPlayouts(ctx context.Context) (names []string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT name FROM channel_defs WHERE playout ORDER BY name")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return
+		}
+		names = append(names, name)
+	}
+	err = rows.Err()
+	return
+}
+
+// GetFailoverItems returns the playlist to fall back to when a channel's live
+// publisher drops, or nil if failover is off
+func GetFailoverItems(ctx context.Context, channelName string) ([]*PlayoutItem, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var failover bool
+	row := db.QueryRow(ctx, "SELECT playout_failover FROM channel_defs WHERE name = $1", channelName)
+	if err := row.Scan(&failover); err != nil || !failover {
+		return nil, err
+	}
+	return ListPlayoutItems(ctx, channelName)
+}
+
+// GetPlayoutAuth returns the publishing identity of a channel for the playout
+// engine
+func GetPlayoutAuth(ctx context.Context, channelName string) (auth ChannelAuth, err error) {
+	auth, _, err = findChannel(ctx, "channel_defs.name = $1", channelName)
+	return
+}
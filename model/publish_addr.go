@@ -0,0 +1,23 @@
+package model
+
+import "context"
+
+// RecordPublishAddr remembers that a channel was published from ip. It
+// returns true if the address is new and the channel has been published from
+// elsewhere before.
+func RecordPublishAddr(ctx context.Context, channelName, ip string) (unfamiliar bool, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var known bool
+	if err = db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM publish_addrs WHERE channel_name = $1)", channelName).Scan(&known); err != nil {
+		return
+	}
+	tag, err := db.Exec(ctx, "INSERT INTO publish_addrs (channel_name, addr) VALUES ($1, $2::inet) ON CONFLICT (channel_name, addr) DO NOTHING", channelName, ip)
+	if err != nil {
+		return
+	} else if tag.RowsAffected() != 0 {
+		return known, nil
+	}
+	_, err = db.Exec(ctx, "UPDATE publish_addrs SET last_seen = now() WHERE channel_name = $1 AND addr = $2::inet", channelName, ip)
+	return
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RestreamTarget is an external RTMP destination that a channel is forwarded
+// to while live. The stream key is stored sealed and never returned to
+// clients.
+type RestreamTarget struct {
+	ID      int64  `json:"id"`
+	Label   string `json:"label"`
+	URL     string `json:"url"`
+	Enabled bool   `json:"enabled"`
+
+	SealedKey []byte `json:"-"`
+}
+
+func ListRestreamTargets(ctx context.Context, userID, channelName string) (targets []*RestreamTarget, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT id, label, url, enabled FROM restream_targets JOIN channel_defs ON channel_defs.name = channel_name WHERE user_id = $1 AND channel_name = $2 ORDER BY id", userID, channelName)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	targets = []*RestreamTarget{}
+	for rows.Next() {
+		target := new(RestreamTarget)
+		if err = rows.Scan(&target.ID, &target.Label, &target.URL, &target.Enabled); err != nil {
+			return
+		}
+		targets = append(targets, target)
+	}
+	err = rows.Err()
+	return
+}
+
+// EnabledRestreamTargets returns the targets to push to when a channel goes
+// live, including their sealed keys
+func EnabledRestreamTargets(ctx context.Context, channelName string) (targets []*RestreamTarget, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT id, label, url, sealed_key FROM restream_targets WHERE channel_name = $1 AND enabled ORDER BY id", channelName)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		target := &RestreamTarget{Enabled: true}
+		if err = rows.Scan(&target.ID, &target.Label, &target.URL, &target.SealedKey); err != nil {
+			return
+		}
+		targets = append(targets, target)
+	}
+	err = rows.Err()
+	return
+}
+
+func CreateRestreamTarget(ctx context.Context, userID, channelName string, target *RestreamTarget) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if err := checkOwner(ctx, userID, channelName); err != nil {
+		return err
+	}
+	row := db.QueryRow(ctx, "INSERT INTO restream_targets (channel_name, label, url, sealed_key, enabled) VALUES ($1, $2, $3, $4, $5) RETURNING id", channelName, target.Label, target.URL, target.SealedKey, target.Enabled)
+	return row.Scan(&target.ID)
+}
+
+// GetRestreamTarget returns a single target including its sealed key
+func GetRestreamTarget(ctx context.Context, userID, channelName string, id int64) (*RestreamTarget, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	target := new(RestreamTarget)
+	row := db.QueryRow(ctx, "SELECT id, label, url, enabled, sealed_key FROM restream_targets JOIN channel_defs ON channel_defs.name = channel_name WHERE user_id = $1 AND channel_name = $2 AND id = $3", userID, channelName, id)
+	if err := row.Scan(&target.ID, &target.Label, &target.URL, &target.Enabled, &target.SealedKey); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// RestreamTargetUpdate holds the fields to change on a target. Nil fields are
+// left alone.
+type RestreamTargetUpdate struct {
+	Label     *string
+	URL       *string
+	SealedKey []byte
+	Enabled   *bool
+}
+
+func UpdateRestreamTarget(ctx context.Context, userID, channelName string, id int64, u RestreamTargetUpdate) error {
+	args := []interface{}{userID, channelName, id}
+	sets := []string{"id = id"}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if u.Label != nil {
+		set("label", *u.Label)
+	}
+	if u.URL != nil {
+		set("url", *u.URL)
+	}
+	if u.SealedKey != nil {
+		set("sealed_key", u.SealedKey)
+	}
+	if u.Enabled != nil {
+		set("enabled", *u.Enabled)
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "UPDATE restream_targets SET "+strings.Join(sets, ", ")+" FROM channel_defs WHERE channel_defs.name = channel_name AND user_id = $1 AND channel_name = $2 AND id = $3", args...)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func DeleteRestreamTarget(ctx context.Context, userID, channelName string, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM restream_targets USING channel_defs WHERE channel_defs.name = channel_name AND user_id = $1 AND channel_name = $2 AND id = $3", userID, channelName, id)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
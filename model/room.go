@@ -0,0 +1,140 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrTooManyRooms = errors.New("too many rooms")
+
+// Room is an ephemeral private channel with a generated name. It is deleted
+// once it expires or sits idle, and is kept out of channel listings.
+type Room struct {
+	Name        string `json:"name"`
+	Key         string `json:"key"`
+	Created     int64  `json:"created"`
+	Expires     int64  `json:"expires"`
+	IdleMinutes int    `json:"idle_minutes"`
+
+	RTMPDir  string `json:"rtmp_dir"`
+	RTMPBase string `json:"rtmp_base"`
+}
+
+func (r *Room) SetURL(base string) {
+	d := ChannelDef{Name: r.Name, Key: r.Key}
+	d.SetURL(base)
+	r.RTMPDir, r.RTMPBase = d.RTMPDir, d.RTMPBase
+}
+
+// CreateRoom makes a new room that lasts at most ttl, or until it has been
+// idle for idle. Users are limited to maxRooms at a time.
+func CreateRoom(ctx context.Context, userID string, ttl, idle time.Duration, maxRooms int) (*Room, error) {
+	b := make([]byte, 30)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+	room := &Room{
+		Name:        "room-" + hex.EncodeToString(b[:6]),
+		Key:         hex.EncodeToString(b[6:]),
+		IdleMinutes: int(idle / time.Minute),
+	}
+	var idleExpiry *time.Duration
+	if idle > 0 {
+		idleExpiry = &idle
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	// holding the user's row makes concurrent requests count one at a time
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM users WHERE user_id = $1 FOR UPDATE", userID); err != nil {
+		return nil, err
+	}
+	var created, expires time.Time
+	row := tx.QueryRow(ctx, `INSERT INTO channel_defs (user_id, name, key, announce, private, ephemeral, expires, idle_expiry)
+		SELECT $1, $2, $3, false, true, true, now() + $4::interval, $5
+		WHERE (SELECT count(*) FROM channel_defs WHERE user_id = $1 AND ephemeral) < $6
+		RETURNING created, expires`, userID, room.Name, room.Key, ttl, idleExpiry, maxRooms)
+	if err := row.Scan(&created, &expires); errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTooManyRooms
+	} else if err != nil {
+		return nil, err
+	}
+	room.Created = created.UnixNano() / 1000000
+	room.Expires = expires.UnixNano() / 1000000
+	return room, tx.Commit(ctx)
+}
+
+func ListRooms(ctx context.Context, userID string) (rooms []*Room, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT name, key, created, expires, idle_expiry FROM channel_defs WHERE user_id = $1 AND ephemeral ORDER BY created", userID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	rooms = []*Room{}
+	for rows.Next() {
+		room := new(Room)
+		var created, expires time.Time
+		var idle *time.Duration
+		if err = rows.Scan(&room.Name, &room.Key, &created, &expires, &idle); err != nil {
+			return
+		}
+		room.Created = created.UnixNano() / 1000000
+		room.Expires = expires.UnixNano() / 1000000
+		if idle != nil {
+			room.IdleMinutes = int(*idle / time.Minute)
+		}
+		rooms = append(rooms, room)
+	}
+	err = rows.Err()
+	return
+}
+
+func DeleteRoom(ctx context.Context, userID, name string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var ok bool
+	if err := db.QueryRow(ctx, "DELETE FROM channel_defs WHERE user_id = $1 AND name = $2 AND ephemeral RETURNING true", userID, name).Scan(&ok); err != nil {
+		return err
+	}
+	_, err := db.Exec(ctx, "DELETE FROM thumbs WHERE name = $1", name)
+	return err
+}
+
+// ExpireRooms deletes rooms that are past their expiry or have been idle for
+// too long. Rooms that are live are left until their broadcast ends.
+func ExpireRooms(ctx context.Context) (names []string, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, `DELETE FROM channel_defs d WHERE ephemeral
+		AND NOT EXISTS (SELECT 1 FROM stream_sessions WHERE channel_name = d.name AND ended IS NULL)
+		AND (expires < now() OR COALESCE((SELECT max(ended) FROM stream_sessions WHERE channel_name = d.name), created) + idle_expiry < now())
+		RETURNING name`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil || len(names) == 0 {
+		return
+	}
+	_, err = db.Exec(ctx, "DELETE FROM thumbs WHERE name = ANY($1)", names)
+	return
+}
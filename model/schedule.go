@@ -0,0 +1,105 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ScheduledStream is an upcoming stream announced by the channel owner
+type ScheduledStream struct {
+	ID       int64  `json:"id"`
+	Channel  string `json:"channel"`
+	Title    string `json:"title"`
+	Start    int64  `json:"start"`
+	Minutes  int    `json:"minutes"`
+	Announce bool   `json:"announce"`
+}
+
+// scheduleColumns are qualified so they can be returned from queries that join
+// channel_defs, which has columns of the same names
+const scheduleColumns = "scheduled_streams.id, scheduled_streams.channel_name, scheduled_streams.title, scheduled_streams.starts, scheduled_streams.duration, scheduled_streams.announce"
+
+func scanSchedule(rows pgx.Rows) (entries []*ScheduledStream, err error) {
+	defer rows.Close()
+	entries = []*ScheduledStream{}
+	for rows.Next() {
+		entry := new(ScheduledStream)
+		var starts time.Time
+		var duration time.Duration
+		if err = rows.Scan(&entry.ID, &entry.Channel, &entry.Title, &starts, &duration, &entry.Announce); err != nil {
+			return
+		}
+		entry.Start = starts.UnixNano() / 1000000
+		entry.Minutes = int(duration / time.Minute)
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	return
+}
+
+// ListSchedule returns a channel's scheduled streams that have not yet ended
+func ListSchedule(ctx context.Context, channelName string) ([]*ScheduledStream, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT "+scheduleColumns+" FROM scheduled_streams WHERE channel_name = $1 AND starts + duration > now() ORDER BY starts, id", channelName)
+	if err != nil {
+		return nil, err
+	}
+	return scanSchedule(rows)
+}
+
+// ListUpcoming returns scheduled streams across all channels that have not yet
+// ended, soonest first
+func ListUpcoming(ctx context.Context, limit int) ([]*ScheduledStream, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT "+scheduleColumns+" FROM scheduled_streams WHERE starts + duration > now() ORDER BY starts, id LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanSchedule(rows)
+}
+
+func CreateScheduled(ctx context.Context, userID, channelName string, entry *ScheduledStream) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if err := checkOwner(ctx, userID, channelName); err != nil {
+		return err
+	}
+	entry.Channel = channelName
+	starts := time.Unix(0, entry.Start*1000000)
+	duration := time.Duration(entry.Minutes) * time.Minute
+	row := db.QueryRow(ctx, "INSERT INTO scheduled_streams (channel_name, title, starts, duration, announce) VALUES ($1, $2, $3, $4, $5) RETURNING id", channelName, entry.Title, starts, duration, entry.Announce)
+	return row.Scan(&entry.ID)
+}
+
+func DeleteScheduled(ctx context.Context, userID, channelName string, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tag, err := db.Exec(ctx, "DELETE FROM scheduled_streams USING channel_defs WHERE channel_defs.name = channel_name AND channel_defs.user_id = $1 AND channel_name = $2 AND id = $3", userID, channelName, id)
+	if err != nil {
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ClaimAnnouncements marks scheduled streams starting within lead as announced
+// and returns them. Channels that would not be announced on going live
+// are skipped.
+func ClaimAnnouncements(ctx context.Context, lead time.Duration) ([]*ScheduledStream, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, `UPDATE scheduled_streams SET announced = now()
+		FROM channel_defs JOIN users USING (user_id)
+		WHERE channel_defs.name = channel_name AND channel_defs.announce AND users.announce
+		AND scheduled_streams.announce AND announced IS NULL AND starts > now() AND starts <= now() + $1
+		RETURNING `+scheduleColumns, lead)
+	if err != nil {
+		return nil, err
+	}
+	return scanSchedule(rows)
+}
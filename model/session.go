@@ -0,0 +1,146 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+type StreamSession struct {
+	Started     int64 `json:"started"`
+	Ended       int64 `json:"ended,omitempty"`
+	Duration    int64 `json:"duration"`
+	PeakViewers int   `json:"peak_viewers"`
+	// UniqueViewers counts distinct viewers over the session
+	UniqueViewers  int     `json:"unique_viewers"`
+	AverageViewers float64 `json:"average_viewers"`
+	// PeakBitrate is the highest bitrate over roughly an HLS segment, in bits
+	// per second
+	PeakBitrate int `json:"peak_bitrate"`
+	// DroppedPackets were lost between the publisher and the server, which
+	// only UDP protocols can tell
+	DroppedPackets int64  `json:"dropped_packets"`
+	Protocol       string `json:"protocol"`
+}
+
+// SessionTotals are what a session measured that aren't viewer counts
+type SessionTotals struct {
+	PeakBitrate    int
+	DroppedPackets int64
+}
+
+// StartSession records the start of a publish and returns its ID
+func StartSession(ctx context.Context, channelName, protocol string) (id int64, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, "INSERT INTO stream_sessions (channel_name, protocol) VALUES ($1, $2) RETURNING id", channelName, protocol)
+	if err = row.Scan(&id); err != nil {
+		return
+	}
+	// going live counts as activity
+	_, err = db.Exec(ctx, "UPDATE users SET last_active = now(), deactivation_warned = NULL WHERE user_id = (SELECT user_id FROM channel_defs WHERE name = $1)", channelName)
+	return
+}
+
+// UpdateSession records the viewer counts of a session in progress so that a
+// crash doesn't lose them
+func UpdateSession(ctx context.Context, id int64, peakViewers, uniqueViewers int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "UPDATE stream_sessions SET updated = now(), peak_viewers = greatest(peak_viewers, $2), unique_viewers = greatest(unique_viewers, $3) WHERE id = $1", id, peakViewers, uniqueViewers)
+	return err
+}
+
+// EndSession records the end of a publish and returns its summary. The average
+// viewer count comes from the per-minute counts recorded while it was live.
+func EndSession(ctx context.Context, id int64, peakViewers, uniqueViewers int, totals SessionTotals) (*StreamSession, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, `UPDATE stream_sessions s SET updated = now(), ended = now(),
+			peak_viewers = greatest(peak_viewers, $2), unique_viewers = greatest(unique_viewers, $3),
+			peak_bitrate = greatest(peak_bitrate, $4), dropped_packets = greatest(dropped_packets, $5),
+			average_viewers = coalesce((SELECT avg(average) FROM viewer_counts v WHERE v.name = s.channel_name AND v.minute >= date_trunc('minute', s.started)), 0)
+		WHERE id = $1
+		RETURNING started, ended, peak_viewers, unique_viewers, average_viewers, peak_bitrate, dropped_packets, protocol`,
+		id, peakViewers, uniqueViewers, totals.PeakBitrate, totals.DroppedPackets)
+	sess := new(StreamSession)
+	var started, ended time.Time
+	if err := row.Scan(&started, &ended, &sess.PeakViewers, &sess.UniqueViewers, &sess.AverageViewers, &sess.PeakBitrate, &sess.DroppedPackets, &sess.Protocol); err != nil {
+		return nil, err
+	}
+	sess.Started = started.UnixNano() / 1000000
+	sess.Ended = ended.UnixNano() / 1000000
+	sess.Duration = int64(ended.Sub(started) / time.Second)
+	return sess, nil
+}
+
+// EndStaleSessions closes sessions left open by a previous run, using the last
+// time they were updated as the end time
+func EndStaleSessions(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "UPDATE stream_sessions SET ended = updated WHERE ended IS NULL")
+	return err
+}
+
+// ListSessions returns the most recent publish sessions of a channel, newest
+// first
+func ListSessions(ctx context.Context, channelName string, limit int) (sessions []*StreamSession, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT started, ended, peak_viewers, unique_viewers, average_viewers, peak_bitrate, dropped_packets, protocol FROM stream_sessions WHERE channel_name = $1 ORDER BY started DESC LIMIT $2", channelName, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	sessions = []*StreamSession{}
+	for rows.Next() {
+		sess := new(StreamSession)
+		var started time.Time
+		var ended *time.Time
+		if err = rows.Scan(&started, &ended, &sess.PeakViewers, &sess.UniqueViewers, &sess.AverageViewers, &sess.PeakBitrate, &sess.DroppedPackets, &sess.Protocol); err != nil {
+			return
+		}
+		sess.Started = started.UnixNano() / 1000000
+		end := time.Now()
+		if ended != nil {
+			end = *ended
+			sess.Ended = end.UnixNano() / 1000000
+		}
+		sess.Duration = int64(end.Sub(started) / time.Second)
+		sessions = append(sessions, sess)
+	}
+	err = rows.Err()
+	return
+}
+
+// LastSessions returns the most recent publish session of every channel that
+// has one, keyed by channel name
+func LastSessions(ctx context.Context) (sessions map[string]*StreamSession, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := db.Query(ctx, "SELECT DISTINCT ON (channel_name) channel_name, started, ended, peak_viewers, unique_viewers, average_viewers, peak_bitrate, dropped_packets, protocol FROM stream_sessions ORDER BY channel_name, started DESC")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	sessions = make(map[string]*StreamSession)
+	for rows.Next() {
+		sess := new(StreamSession)
+		var name string
+		var started time.Time
+		var ended *time.Time
+		if err = rows.Scan(&name, &started, &ended, &sess.PeakViewers, &sess.UniqueViewers, &sess.AverageViewers, &sess.PeakBitrate, &sess.DroppedPackets, &sess.Protocol); err != nil {
+			return
+		}
+		sess.Started = started.UnixNano() / 1000000
+		end := time.Now()
+		if ended != nil {
+			end = *ended
+			sess.Ended = end.UnixNano() / 1000000
+		}
+		sess.Duration = int64(end.Sub(started) / time.Second)
+		sessions[name] = sess
+	}
+	err = rows.Err()
+	return
+}
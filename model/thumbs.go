@@ -1,12 +1,106 @@
 package model
 
-func GetThumb(channelName string) (d []byte, err error) {
-	row := db.QueryRow("SELECT thumb FROM thumbs WHERE name = $1", channelName)
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"eaglesong.dev/gunk/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+// thumbStore holds thumbnails and previews outside the database if set
+var thumbStore storage.Store
+
+// SetThumbStore moves thumbnail and preview storage out of the database.
+// Existing images are not migrated and will be replaced as channels go live.
+func SetThumbStore(s storage.Store) {
+	thumbStore = s
+}
+
+func getBlob(ctx context.Context, key string) ([]byte, error) {
+	d, err := thumbStore.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		err = pgx.ErrNoRows
+	}
+	return d, err
+}
+
+func thumbKey(channelName string) string {
+	return "thumbs/" + url.PathEscape(channelName)
+}
+
+func previewKey(channelName string) string {
+	return "previews/" + url.PathEscape(channelName)
+}
+
+// RenameThumbs copies a renamed channel's images in external storage to its
+// new name. The database side is handled by UpdateChannel.
+func RenameThumbs(ctx context.Context, oldName, newName string) error {
+	if thumbStore == nil {
+		return nil
+	}
+	for _, key := range []func(string) string{thumbKey, previewKey} {
+		d, err := thumbStore.Get(ctx, key(oldName))
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := thumbStore.Put(ctx, key(newName), http.DetectContentType(d), d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetThumb(ctx context.Context, channelName string) (d []byte, err error) {
+	if thumbStore != nil {
+		return getBlob(ctx, thumbKey(channelName))
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, "SELECT thumb FROM thumbs WHERE name = $1 AND thumb IS NOT NULL", channelName)
+	err = row.Scan(&d)
+	return
+}
+
+func PutThumb(ctx context.Context, channelName, contentType string, d []byte) error {
+	if thumbStore != nil {
+		if err := thumbStore.Put(ctx, thumbKey(channelName), contentType, d); err != nil {
+			return err
+		}
+		// keep the row for its timestamp
+		d = nil
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "INSERT INTO thumbs (name, thumb) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET thumb = EXCLUDED.thumb, updated = now()", channelName, d)
+	return err
+}
+
+func GetPreview(ctx context.Context, channelName string) (d []byte, err error) {
+	if thumbStore != nil {
+		return getBlob(ctx, previewKey(channelName))
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	row := db.QueryRow(ctx, "SELECT preview FROM thumbs WHERE name = $1 AND preview IS NOT NULL", channelName)
 	err = row.Scan(&d)
 	return
 }
 
-func PutThumb(channelName string, d []byte) error {
-	_, err := db.Exec("INSERT INTO thumbs (name, thumb) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET thumb = EXCLUDED.thumb, updated = now()", channelName, d)
+// PutPreview stores a short animated preview alongside an existing thumbnail
+func PutPreview(ctx context.Context, channelName string, d []byte) error {
+	if thumbStore != nil {
+		if err := thumbStore.Put(ctx, previewKey(channelName), "video/mp4", d); err != nil {
+			return err
+		}
+		d = nil
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "UPDATE thumbs SET preview = $2, preview_updated = now() WHERE name = $1", channelName, d)
 	return err
 }
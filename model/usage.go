@@ -0,0 +1,51 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// Usage is how much was sent to a channel's viewers over one protocol in a
+// day
+type Usage struct {
+	Day      string `json:"day"`
+	Protocol string `json:"protocol"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// AddUsage adds to a channel's total for the UTC day containing t. Usage of
+// channels that no longer exist is dropped.
+func AddUsage(ctx context.Context, channelName, protocol string, t time.Time, bytes int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, `INSERT INTO channel_usage (name, day, protocol, bytes)
+		SELECT name, $2::date, $3::text, $4::bigint FROM channel_defs WHERE name = $1
+		ON CONFLICT (name, day, protocol) DO UPDATE SET bytes = channel_usage.bytes + excluded.bytes`,
+		channelName, t.UTC().Format("2006-01-02"), protocol, bytes)
+	return err
+}
+
+// ListUsage returns the daily usage of one of a user's channels over the
+// given number of days, newest first
+func ListUsage(ctx context.Context, userID, channelName string, days int) (usage []*Usage, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if err = checkOwner(ctx, userID, channelName); err != nil {
+		return
+	}
+	rows, err := db.Query(ctx, "SELECT to_char(day, 'YYYY-MM-DD'), protocol, bytes FROM channel_usage WHERE name = $1 AND day > (now() AT TIME ZONE 'UTC')::date - $2::int ORDER BY day DESC, protocol", channelName, days)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	usage = []*Usage{}
+	for rows.Next() {
+		u := new(Usage)
+		if err = rows.Scan(&u.Day, &u.Protocol, &u.Bytes); err != nil {
+			return
+		}
+		usage = append(usage, u)
+	}
+	err = rows.Err()
+	return
+}
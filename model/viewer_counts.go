@@ -0,0 +1,65 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// ViewerCount is how many viewers a channel had over one minute
+type ViewerCount struct {
+	Minute  int64   `json:"minute"`
+	Average float64 `json:"average"`
+	Peak    int     `json:"peak"`
+}
+
+// RecordViewerCounts stores a minute's viewer counts of each live channel,
+// dropping those of channels that no longer exist
+func RecordViewerCounts(ctx context.Context, minute time.Time, counts map[string]ViewerCount) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	for name, c := range counts {
+		_, err := db.Exec(ctx, `INSERT INTO viewer_counts (name, minute, average, peak)
+			SELECT name, $2::timestamptz, $3::real, $4::integer FROM channel_defs WHERE name = $1
+			ON CONFLICT (name, minute) DO UPDATE SET average = excluded.average, peak = excluded.peak`,
+			name, minute, c.Average, c.Peak)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListViewerCounts returns the viewer counts of one of a user's channels
+// between two times, oldest first
+func ListViewerCounts(ctx context.Context, userID, channelName string, from, to time.Time) (counts []*ViewerCount, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	if err = checkOwner(ctx, userID, channelName); err != nil {
+		return
+	}
+	rows, err := db.Query(ctx, "SELECT minute, average, peak FROM viewer_counts WHERE name = $1 AND minute >= $2 AND minute < $3 ORDER BY minute", channelName, from, to)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	counts = []*ViewerCount{}
+	for rows.Next() {
+		c := new(ViewerCount)
+		var minute time.Time
+		if err = rows.Scan(&minute, &c.Average, &c.Peak); err != nil {
+			return
+		}
+		c.Minute = minute.UnixNano() / 1000000
+		counts = append(counts, c)
+	}
+	err = rows.Err()
+	return
+}
+
+// PruneViewerCounts forgets counts older than maxAge
+func PruneViewerCounts(ctx context.Context, maxAge time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err := db.Exec(ctx, "DELETE FROM viewer_counts WHERE minute < $1", time.Now().Add(-maxAge))
+	return err
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"eaglesong.dev/gunk/ingest/ftl"
+)
+
+// netsim publishes a synthetic test stream to an FTL server while dropping
+// and delaying its UDP media, then reports what was injected and whether the
+// session survived. Watch the channel while it runs to judge playback.
+func netsim(args []string) {
+	var (
+		server, channelID, key string
+		loss                   float64
+		delay, jitter, length  time.Duration
+		bitrate                string
+	)
+	parseFlags("netsim", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&server, "server", "localhost:8084", "FTL server address")
+		fs.StringVar(&channelID, "channel", "", "FTL channel ID")
+		fs.StringVar(&key, "key", "", "stream key")
+		fs.Float64Var(&loss, "loss", 0.01, "fraction of media packets to drop")
+		fs.DurationVar(&delay, "delay", 0, "latency added to every media packet")
+		fs.DurationVar(&jitter, "jitter", 20*time.Millisecond, "random extra latency of up to this much, which also reorders packets")
+		fs.DurationVar(&length, "duration", time.Minute, "how long to stream for")
+		fs.StringVar(&bitrate, "bitrate", "2500k", "video bitrate")
+	})
+	if channelID == "" || key == "" {
+		log.Fatalln("error: -channel and -key are required")
+	} else if loss < 0 || loss >= 1 {
+		log.Fatalln("error: -loss must be at least 0 and less than 1")
+	}
+	rand.Seed(time.Now().UnixNano())
+	vssrc, assrc := rand.Uint32()|1, rand.Uint32()|1
+	client, err := ftl.Dial(server, channelID, key, vssrc, assrc)
+	if err != nil {
+		log.Fatalln("error: connecting to FTL server:", err)
+	}
+	defer client.Close()
+	sim, err := newImpairment(client.MediaAddr, loss, delay, jitter)
+	if err != nil {
+		log.Fatalln("error:", err)
+	}
+	go sim.run()
+	log.Printf("streaming to %s for %s with %.1f%% loss, %s delay and %s jitter", client.MediaAddr, length, loss*100, delay, jitter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), length)
+	defer cancel()
+	sessionErr := make(chan error, 1)
+	go func() {
+		t := time.NewTicker(5 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := client.Ping(); err != nil {
+					sessionErr <- err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	started := time.Now()
+	cmd := exec.CommandContext(ctx, "ffmpeg", netsimArgs(sim.LocalAddr().(*net.UDPAddr).Port, vssrc, bitrate)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		log.Println("error: ffmpeg:", err)
+	}
+	elapsed := time.Since(started)
+	sim.Close()
+
+	st := sim.stats()
+	fmt.Printf("packets sent  %d\n", st.sent)
+	if st.sent > 0 {
+		fmt.Printf("dropped       %d (%.2f%%)\n", st.dropped, 100*float64(st.dropped)/float64(st.sent))
+	}
+	if n := st.sent - st.dropped; n > 0 {
+		fmt.Printf("delay         mean %s, max %s\n", st.totalDelay/time.Duration(n), st.maxDelay)
+	}
+	fmt.Printf("reordered     %d\n", st.reordered)
+	select {
+	case err := <-sessionErr:
+		fmt.Printf("session       ended after %s: %s\n", elapsed.Round(time.Second), err)
+		os.Exit(1)
+	default:
+		fmt.Printf("session       stayed up for %s\n", elapsed.Round(time.Second))
+	}
+}
+
+// netsimArgs encodes a test pattern to RTP in the form FTL expects
+func netsimArgs(port int, ssrc uint32, bitrate string) []string {
+	dest := "rtp://127.0.0.1:" + strconv.Itoa(port) + "?pkt_size=1200&rtcpport=" + strconv.Itoa(port)
+	return []string{
+		"-loglevel", "error",
+		"-nostdin",
+		"-re",
+		"-f", "lavfi",
+		"-i", "testsrc2=size=1280x720:rate=30",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-tune", "zerolatency",
+		"-profile:v", "main",
+		"-pix_fmt", "yuv420p",
+		"-b:v", bitrate,
+		"-g", "60",
+		"-bf", "0",
+		"-f", "rtp",
+		"-payload_type", strconv.Itoa(ftl.ClientVideoPT),
+		"-ssrc", strconv.FormatUint(uint64(ssrc), 10),
+		dest,
+	}
+}
+
+// impairment relays UDP packets to a destination, dropping and delaying them
+type impairment struct {
+	*net.UDPConn
+	out           *net.UDPConn
+	loss          float64
+	delay, jitter time.Duration
+
+	mu       sync.Mutex
+	st       impairmentStats
+	lastSend time.Time
+}
+
+type impairmentStats struct {
+	sent, dropped, reordered int
+	totalDelay, maxDelay     time.Duration
+}
+
+func newImpairment(dest *net.UDPAddr, loss float64, delay, jitter time.Duration) (*impairment, error) {
+	in, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	out, err := net.DialUDP("udp", nil, dest)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &impairment{UDPConn: in, out: out, loss: loss, delay: delay, jitter: jitter}, nil
+}
+
+func (im *impairment) run() {
+	for {
+		d := make([]byte, 1500)
+		n, err := im.Read(d)
+		if err != nil {
+			return
+		}
+		d = d[:n]
+		im.mu.Lock()
+		im.st.sent++
+		if rand.Float64() < im.loss {
+			im.st.dropped++
+			im.mu.Unlock()
+			continue
+		}
+		wait := im.delay
+		if im.jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(im.jitter)))
+		}
+		at := time.Now().Add(wait)
+		if at.Before(im.lastSend) {
+			im.st.reordered++
+		} else {
+			im.lastSend = at
+		}
+		im.st.totalDelay += wait
+		if wait > im.st.maxDelay {
+			im.st.maxDelay = wait
+		}
+		im.mu.Unlock()
+		time.AfterFunc(wait, func() { im.out.Write(d) })
+	}
+}
+
+func (im *impairment) stats() impairmentStats {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	return im.st
+}
+
+func (im *impairment) Close() error {
+	im.UDPConn.Close()
+	return im.out.Close()
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// DiscordDM sends notifications as direct messages from a bot. Discord user
+// IDs are the same as gunk user IDs. The user must share a server with the
+// bot and allow messages from its members.
+type DiscordDM struct {
+	Token string
+}
+
+func (d *DiscordDM) Deliver(ctx context.Context, n Notification, p Prefs) error {
+	if !p.Discord {
+		return nil
+	}
+	var dm struct {
+		ID string `json:"id"`
+	}
+	if err := d.post(ctx, "/users/@me/channels", map[string]string{"recipient_id": n.UserID}, &dm); err != nil {
+		return err
+	}
+	return d.post(ctx, "/channels/"+dm.ID+"/messages", map[string]string{"content": n.Message}, nil)
+}
+
+func (d *DiscordDM) post(ctx context.Context, path string, body, result interface{}) error {
+	blob, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://discord.com/api/v10"+path, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+d.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	blob, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	} else if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %s on %s: %s", resp.Status, path, string(blob))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(blob, result)
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email sends notifications to the address in each user's preferences
+type Email struct {
+	Addr     string // SMTP server host:port
+	From     string
+	Username string
+	Password string
+}
+
+func (e *Email) Deliver(ctx context.Context, n Notification, p Prefs) error {
+	if p.Email == "" {
+		return nil
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, _ := net.SplitHostPort(e.Addr)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	subject := n.Subject
+	if subject == "" {
+		subject = "Stream notification"
+		if n.Channel != "" {
+			subject += " for " + n.Channel
+		}
+	}
+	body := n.EmailBody
+	if body == "" {
+		body = n.Message
+	}
+	msg := strings.Join([]string{
+		"From: " + e.From,
+		"To: " + p.Email,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+		"",
+	}, "\r\n")
+	// net/smtp doesn't take a context, so just bound the wait for it
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(e.Addr, auth, e.From, []string{p.Email}, []byte(msg)) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("sending mail: %w", ctx.Err())
+	}
+}
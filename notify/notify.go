@@ -0,0 +1,135 @@
+// Package notify delivers messages about a user's channels to wherever the
+// user will see them
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// kinds of notification
+const (
+	KeyRotationScheduled = "key_rotation_scheduled"
+	KeyRotated           = "key_rotated"
+	WrongKey             = "wrong_key"
+	NewPublishAddr       = "new_publish_addr"
+	KeyRevealed          = "key_revealed"
+	ModerationFlagged    = "moderation_flagged"
+	ModerationResolved   = "moderation_resolved"
+	AccountInactive      = "account_inactive"
+	StreamSummary        = "stream_summary"
+)
+
+// Kinds lists every kind of notification, for users to choose from
+var Kinds = []string{KeyRotationScheduled, KeyRotated, WrongKey, NewPublishAddr, KeyRevealed, ModerationFlagged, ModerationResolved, AccountInactive, StreamSummary}
+
+type Notification struct {
+	UserID  string
+	Kind    string
+	Channel string
+	Message string
+	// Key names a message to send in the user's language, filled in with
+	// Data. The bus then sets Message and Subject before delivery.
+	Key  string
+	Data any
+	// Subject is a short summary for email
+	Subject string
+	// EmailBody and WebhookBody replace Message in emails and the JSON posted
+	// to webhooks, if the operator has templates for them
+	EmailBody   string
+	WebhookBody []byte
+}
+
+// Prefs are a user's choices of what to be notified about and how. The UI
+// inbox always receives notifications that aren't muted.
+type Prefs struct {
+	// Muted lists kinds the user doesn't want
+	Muted   []string `json:"muted"`
+	Email   string   `json:"email"`
+	Webhook string   `json:"webhook"`
+	// Discord sends a direct message from the instance's bot
+	Discord bool `json:"discord"`
+	// Language is what messages are written in, or empty for the
+	// instance's default
+	Language string `json:"language"`
+}
+
+func (p Prefs) Wants(kind string) bool {
+	for _, m := range p.Muted {
+		if m == kind {
+			return false
+		}
+	}
+	return true
+}
+
+// Sink is one way of getting notifications to users
+type Sink interface {
+	Deliver(ctx context.Context, n Notification, p Prefs) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(ctx context.Context, n Notification, p Prefs) error
+
+func (f SinkFunc) Deliver(ctx context.Context, n Notification, p Prefs) error {
+	return f(ctx, n, p)
+}
+
+// Bus fans notifications out to every subscribed sink
+type Bus struct {
+	// Lookup returns a user's preferences. If unset, everything is delivered
+	// with zero Prefs.
+	Lookup func(ctx context.Context, userID string) (Prefs, error)
+	// Render fills in a notification's Message and Subject from its Key in
+	// a language, and any bodies the operator has templates for. If unset,
+	// Message is delivered as given.
+	Render func(lang string, n *Notification)
+
+	mu    sync.Mutex
+	sinks []Sink
+}
+
+func (b *Bus) Subscribe(s Sink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, s)
+}
+
+// Publish delivers a notification to each sink in the background. Failures
+// are logged and not retried.
+func (b *Bus) Publish(n Notification) {
+	b.mu.Lock()
+	sinks := b.sinks
+	b.mu.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var prefs Prefs
+		if b.Lookup != nil {
+			var err error
+			prefs, err = b.Lookup(ctx, n.UserID)
+			if err != nil {
+				log.Printf("warning: looking up notification preferences of %s: %s", n.UserID, err)
+			}
+		}
+		if !prefs.Wants(n.Kind) {
+			return
+		}
+		if n.Key != "" && b.Render != nil {
+			b.Render(prefs.Language, &n)
+		}
+		var wg sync.WaitGroup
+		for _, s := range sinks {
+			wg.Add(1)
+			go func(s Sink) {
+				defer wg.Done()
+				if err := s.Deliver(ctx, n, prefs); err != nil {
+					log.Printf("warning: delivering %s notification to %s: %s", n.Kind, n.UserID, err)
+				}
+			}(s)
+		}
+		wg.Wait()
+	}()
+}
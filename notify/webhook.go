@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/internal"
+)
+
+// webhookClient posts to URLs that users choose, so it refuses to connect to
+// anything that isn't on the internet
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: internal.PublicOnly,
+		}).DialContext,
+	},
+}
+
+// Webhook posts notifications to the URL in each user's preferences. The
+// message is sent as both "content" for Discord and "text" for Slack, unless
+// the notification comes with a body of its own.
+var Webhook = SinkFunc(func(ctx context.Context, n Notification, p Prefs) error {
+	if p.Webhook == "" {
+		return nil
+	}
+	blob := n.WebhookBody
+	if blob == nil {
+		blob, _ = json.Marshal(map[string]string{"content": n.Message, "text": n.Message})
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.Webhook, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	blob, _ = io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %s on webhook: %s", resp.Status, string(blob))
+	}
+	return nil
+})
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"os/exec"
+	"strconv"
 	"time"
 
 	"eaglesong.dev/gunk/h264util"
@@ -17,16 +18,60 @@ import (
 )
 
 const (
-	targetWidth  = 400
-	grabInterval = 10 * time.Second
+	defaultWidth    = 400
+	defaultInterval = 10 * time.Second
+	defaultQuality  = 80
 )
 
+// Options control how thumbnails are captured
+type Options struct {
+	// Interval between thumbnails
+	Interval time.Duration
+	// Width of the thumbnail in pixels, height follows the aspect ratio
+	Width int
+	// Quality from 1 to 100
+	Quality int
+	// Format is "jpeg" or "webp"
+	Format string
+}
+
+func (o Options) withDefaults() Options {
+	if o.Interval <= 0 {
+		o.Interval = defaultInterval
+	}
+	if o.Width <= 0 {
+		o.Width = defaultWidth
+	}
+	if o.Quality <= 0 || o.Quality > 100 {
+		o.Quality = defaultQuality
+	}
+	if o.Format != "webp" {
+		o.Format = "jpeg"
+	}
+	return o
+}
+
+// Ext returns the file extension for thumbnails in the configured format
+func (o Options) Ext() string {
+	if o.withDefaults().Format == "webp" {
+		return "webp"
+	}
+	return "jpg"
+}
+
+// ThumbWidth returns the configured thumbnail width in pixels
+func (o Options) ThumbWidth() int {
+	return o.withDefaults().Width
+}
+
 type Result struct {
 	Time       time.Time
+	Preview    time.Time
 	HasBframes bool
 }
 
-func Grab(channelName string, dm av.Demuxer) (<-chan Result, error) {
+func Grab(channelName string, dm av.Demuxer, opts Options) (<-chan Result, error) {
+	opts = opts.withDefaults()
 	streams, err := dm.Streams()
 	if err != nil {
 		return nil, err
@@ -49,6 +94,7 @@ func Grab(channelName string, dm av.Demuxer) (<-chan Result, error) {
 		var keyTime time.Duration
 		var lastGrab time.Time
 		var lastBframe time.Duration
+		preview := &previewer{channelName: channelName, cd: vidCodec}
 		for {
 			pkt, err := dm.ReadPacket()
 			if err == io.EOF {
@@ -60,15 +106,20 @@ func Grab(channelName string, dm av.Demuxer) (<-chan Result, error) {
 			if int(pkt.Idx) != vidIdx {
 				continue
 			}
+			if !lastGrab.IsZero() {
+				// wait for the first thumbnail so the preview has a row to go in
+				preview.packet(pkt)
+			}
 			if buf.Len() != 0 && (!pkt.IsKeyFrame || pkt.Time != keyTime) {
-				if time.Since(lastGrab) >= grabInterval {
-					if err := makeFrame(channelName, vidCodec, buf.Bytes()); err != nil {
+				if time.Since(lastGrab) >= opts.Interval {
+					if err := makeFrame(channelName, vidCodec, buf.Bytes(), opts); err != nil {
 						log.Println("error: making thumbnail:", err)
 					}
 					lastGrab = time.Now()
 					select {
 					case grabch <- Result{
 						Time:       lastGrab,
+						Preview:    preview.updated(),
 						HasBframes: lastBframe != 0,
 					}:
 					default:
@@ -99,24 +150,36 @@ func Grab(channelName string, dm av.Demuxer) (<-chan Result, error) {
 	return grabch, nil
 }
 
-func makeFrame(channelName string, cd h264parser.CodecData, raw []byte) error {
+func makeFrame(channelName string, cd h264parser.CodecData, raw []byte, opts Options) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	height := targetWidth * cd.Height() / cd.Width()
-	var jpeg bytes.Buffer
+	height := opts.Width * cd.Height() / cd.Width()
+	var out bytes.Buffer
 	var errmsg bytes.Buffer
-	cmd := exec.CommandContext(ctx, "ffmpeg",
+	args := []string{
 		"-loglevel", "warning",
 		"-f", "h264",
 		"-i", "-",
 		"-frames", "1",
-		"-s", fmt.Sprintf("%dx%d", targetWidth, height),
-		"-f", "singlejpeg", "-")
+		"-s", fmt.Sprintf("%dx%d", opts.Width, height),
+	}
+	if opts.Format == "webp" {
+		args = append(args, "-c:v", "libwebp", "-quality", strconv.Itoa(opts.Quality), "-f", "webp", "-")
+	} else {
+		// mjpeg qscale runs from 2 (best) to 31 (worst)
+		qscale := 2 + (100-opts.Quality)*29/99
+		args = append(args, "-q:v", strconv.Itoa(qscale), "-f", "singlejpeg", "-")
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	cmd.Stdin = bytes.NewReader(raw)
-	cmd.Stdout = &jpeg
+	cmd.Stdout = &out
 	cmd.Stderr = &errmsg
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("%s\n%s", err.Error(), errmsg.String())
 	}
-	return model.PutThumb(channelName, jpeg.Bytes())
+	contentType := "image/jpeg"
+	if opts.Format == "webp" {
+		contentType = "image/webp"
+	}
+	return model.PutThumb(context.Background(), channelName, contentType, out.Bytes())
 }
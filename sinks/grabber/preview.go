@@ -0,0 +1,101 @@
+package grabber
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"eaglesong.dev/gunk/h264util"
+	"eaglesong.dev/gunk/model"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+const (
+	previewWidth    = 320
+	previewLength   = 3 * time.Second
+	previewInterval = 60 * time.Second
+	previewBitrate  = "150k"
+)
+
+// previewer collects a few seconds of video starting at a keyframe and
+// transcodes it to a small MP4 for hover previews
+type previewer struct {
+	channelName string
+	cd          h264parser.CodecData
+
+	buf      bytes.Buffer
+	start    time.Duration
+	frames   int
+	last     time.Time
+	encoding uint32
+	done     int64
+}
+
+// packet feeds a video packet to the previewer, starting a new capture on a
+// keyframe once the previous preview is old enough
+func (p *previewer) packet(pkt av.Packet) {
+	if p.buf.Len() == 0 {
+		if !pkt.IsKeyFrame || time.Since(p.last) < previewInterval || atomic.LoadUint32(&p.encoding) != 0 {
+			return
+		}
+		p.start = pkt.Time
+		p.frames = 0
+	}
+	h264util.WriteAnnexBPacket(&p.buf, pkt, p.cd)
+	p.frames++
+	if elapsed := pkt.Time - p.start; elapsed >= previewLength {
+		raw := append([]byte(nil), p.buf.Bytes()...)
+		fps := float64(p.frames) / elapsed.Seconds()
+		p.buf.Reset()
+		p.last = time.Now()
+		atomic.StoreUint32(&p.encoding, 1)
+		go func() {
+			defer atomic.StoreUint32(&p.encoding, 0)
+			if err := makePreview(p.channelName, p.cd, raw, fps); err != nil {
+				log.Println("error: making preview:", err)
+				return
+			}
+			atomic.StoreInt64(&p.done, time.Now().UnixNano())
+		}()
+	}
+}
+
+// updated returns when the last preview was stored, or zero if there is none yet
+func (p *previewer) updated() time.Time {
+	if t := atomic.LoadInt64(&p.done); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+func makePreview(channelName string, cd h264parser.CodecData, raw []byte, fps float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	height := previewWidth * cd.Height() / cd.Width() &^ 1
+	var mp4 bytes.Buffer
+	var errmsg bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-loglevel", "warning",
+		"-f", "h264",
+		"-r", fmt.Sprintf("%.3f", fps),
+		"-i", "-",
+		"-an",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-b:v", previewBitrate,
+		"-s", fmt.Sprintf("%dx%d", previewWidth, height),
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "-")
+	cmd.Stdin = bytes.NewReader(raw)
+	cmd.Stdout = &mp4
+	cmd.Stderr = &errmsg
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s\n%s", err.Error(), errmsg.String())
+	}
+	return model.PutPreview(context.Background(), channelName, mp4.Bytes())
+}
@@ -42,9 +42,14 @@ func (f *framer) repack(pkt av.Packet, info av.CodecData) []byte {
 	return pkt.Data
 }
 
+// PacketWriter sends RTP packets to a client
+type PacketWriter interface {
+	WriteTo(p []byte, addr net.Addr) (int, error)
+}
+
 type RTPFramer struct {
 	framer
-	Conn       net.PacketConn
+	Conn       PacketWriter
 	Addr       net.Addr
 	Packetizer rtp.Packetizer
 	Codec      *webrtc.RTPCodec
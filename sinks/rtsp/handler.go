@@ -27,6 +27,7 @@ var (
 
 type track struct {
 	framer *RTPFramer
+	ready  bool
 }
 
 func (c *Conn) handleDescribe(req *Request) error {
@@ -64,6 +65,7 @@ func (c *Conn) handleDescribe(req *Request) error {
 			},
 		}
 		media.WithCodec(codec.PayloadType, codec.Name, codec.ClockRate, codec.Channels, codec.SDPFmtpLine)
+		media.Attributes = append(media.Attributes, sdp.Attribute{Key: "control", Value: "trackID=" + strconv.Itoa(i)})
 		ses.WithMedia(media)
 
 		packetizer := rtp.NewPacketizer(1400, codec.PayloadType, c.ssrc, codec.Payloader, rtp.NewRandomSequencer(), codec.ClockRate)
@@ -81,15 +83,52 @@ func (c *Conn) handleDescribe(req *Request) error {
 	return c.WriteResponse(req, 200, hdr, []byte(blob))
 }
 
+// setupTracks returns the tracks a SETUP request is for. Clients that ignore
+// the control attributes set up every track at once.
+func (c *Conn) setupTracks(req *Request) []*track {
+	_, id, ok := strings.Cut(req.URL.Path, "/trackID=")
+	if !ok {
+		return c.tracks
+	}
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 0 || i >= len(c.tracks) {
+		return nil
+	}
+	return c.tracks[i : i+1]
+}
+
 func (c *Conn) handleSetup(req *Request) error {
+	tracks := c.setupTracks(req)
+	if len(tracks) == 0 {
+		return c.WriteResponse(req, 404, nil, nil)
+	}
 	transport := req.Header.Get("Transport")
 	words := strings.Split(transport, ";")
-	var portRange string
+	var portRange, interleaved string
 	for _, word := range words {
 		if strings.HasPrefix(word, "client_port=") {
 			portRange = word[12:]
+		} else if strings.HasPrefix(word, "interleaved=") {
+			interleaved = word[12:]
 		}
 	}
+	if interleaved != "" || strings.HasPrefix(transport, "RTP/AVP/TCP") {
+		// RTP goes over the RTSP connection, which gets through NAT
+		channel, _ := strconv.Atoi(strings.Split(interleaved, "-")[0])
+		if channel < 0 || channel > 255 {
+			return fmt.Errorf("invalid interleaved channel in transport %q", transport)
+		}
+		for _, t := range tracks {
+			t.framer.Conn = interleavedWriter{c, byte(channel)}
+			t.ready = true
+		}
+		c.setUp = true
+		transport = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%08X", channel, channel+1, c.ssrc)
+		hdr := make(textproto.MIMEHeader)
+		hdr.Set("Transport", transport)
+		hdr.Set("Session", strconv.FormatUint(uint64(c.ssrc), 10))
+		return c.WriteResponse(req, 200, hdr, nil)
+	}
 	if portRange == "" {
 		return fmt.Errorf("missing client_port in transport %q", transport)
 	}
@@ -99,10 +138,13 @@ func (c *Conn) handleSetup(req *Request) error {
 	}
 	srcPort := c.s.RTPSocket.LocalAddr().(*net.UDPAddr).Port
 	remoteAddr := c.conn.RemoteAddr().(*net.TCPAddr)
-	c.destAddr = &net.UDPAddr{IP: remoteAddr.IP, Port: destPort}
-	for _, t := range c.tracks {
-		t.framer.Addr = c.destAddr
+	destAddr := &net.UDPAddr{IP: remoteAddr.IP, Port: destPort}
+	for _, t := range tracks {
+		t.framer.Conn = c.s.RTPSocket
+		t.framer.Addr = destAddr
+		t.ready = true
 	}
+	c.setUp = true
 
 	transport = fmt.Sprintf("%s;server_port=%d;ssrc=%08X", transport, srcPort, c.ssrc)
 	hdr := make(textproto.MIMEHeader)
@@ -112,18 +154,38 @@ func (c *Conn) handleSetup(req *Request) error {
 }
 
 func (c *Conn) handlePlay(req *Request) error {
-	if c.destAddr == nil {
+	if !c.setUp {
 		return errors.New("SETUP not called")
+	} else if c.playing {
+		// already sending, e.g. resuming after a pause we don't support
+		hdr := make(textproto.MIMEHeader)
+		hdr.Set("Session", strconv.FormatUint(uint64(c.ssrc), 10))
+		return c.WriteResponse(req, 200, hdr, nil)
 	}
 	demux, err := c.s.Source(req)
 	if err == ErrNotFound {
 		return c.WriteResponse(req, 404, nil, nil)
+	} else if err == ErrFull {
+		return c.WriteResponse(req, 453, nil, nil)
 	} else if err != nil {
 		return err
 	}
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("Session", strconv.FormatUint(uint64(c.ssrc), 10))
+	// answer before any interleaved RTP follows
+	if err := c.WriteResponse(req, 200, hdr, nil); err != nil {
+		return err
+	}
+	c.playing = true
+	if c.s.Watching != nil {
+		c.s.Watching(req, 1)
+	}
 	go func() {
 		log.Printf("[rtsp] started sending to %s", c.conn.RemoteAddr())
 		defer log.Printf("[rtsp] stopped sending to %s", c.conn.RemoteAddr())
+		if c.s.Watching != nil {
+			defer c.s.Watching(req, -1)
+		}
 		for c.ctx.Err() == nil {
 			pkt, err := demux.ReadPacket()
 			if err == io.EOF {
@@ -133,7 +195,7 @@ func (c *Conn) handlePlay(req *Request) error {
 				break
 			}
 			track := c.tracks[int(pkt.Idx)]
-			if track == nil {
+			if track == nil || !track.ready {
 				continue
 			}
 			if err := track.framer.WritePacket(pkt); err != nil {
@@ -146,7 +208,27 @@ func (c *Conn) handlePlay(req *Request) error {
 			}
 		}
 	}()
-	hdr := make(textproto.MIMEHeader)
-	hdr.Set("Session", strconv.FormatUint(uint64(c.ssrc), 10))
-	return c.WriteResponse(req, 200, nil, nil)
+	return nil
+}
+
+// interleavedWriter frames RTP packets onto the RTSP connection
+type interleavedWriter struct {
+	c       *Conn
+	channel byte
+}
+
+func (w interleavedWriter) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if len(p) > 0xffff {
+		return 0, fmt.Errorf("RTP packet of %d bytes is too large to interleave", len(p))
+	}
+	w.c.wmu.Lock()
+	defer w.c.wmu.Unlock()
+	hdr := []byte{'$', w.channel, byte(len(p) >> 8), byte(len(p))}
+	if _, err := w.c.tpc.W.Write(hdr); err != nil {
+		return 0, err
+	}
+	if _, err := w.c.tpc.W.Write(p); err != nil {
+		return 0, err
+	}
+	return len(p), w.c.tpc.W.Flush()
 }
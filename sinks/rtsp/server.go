@@ -12,17 +12,25 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nareix/joy4/av"
 )
 
-var ErrNotFound = errors.New("stream not found")
+var (
+	ErrNotFound = errors.New("stream not found")
+	// ErrFull is returned by a source that can't take any more viewers
+	ErrFull = errors.New("stream has too many viewers")
+)
 
 type Server struct {
 	Source    SourceFunc
 	Listener  net.Listener
 	RTPSocket net.PacketConn
+	// Watching, if set, is called with 1 when a client starts playing and -1
+	// when it stops
+	Watching func(req *Request, delta int)
 }
 
 type SourceFunc func(*Request) (av.Demuxer, error)
@@ -65,9 +73,12 @@ type Conn struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	ssrc     uint32
-	tracks   []*track
-	destAddr net.Addr
+	ssrc    uint32
+	tracks  []*track
+	setUp   bool
+	playing bool
+	// wmu serializes responses with interleaved RTP
+	wmu sync.Mutex
 }
 
 func (c *Conn) serve() {
@@ -99,6 +110,7 @@ var responseText = map[int]string{
 	400: "Bad Request",
 	404: "Not Found",
 	405: "Method Not Allowed",
+	453: "Not Enough Bandwidth",
 	500: "Internal Server Error",
 }
 
@@ -110,6 +122,8 @@ func (c *Conn) WriteResponse(req *Request, code int, headers textproto.MIMEHeade
 	if headers == nil {
 		headers = make(textproto.MIMEHeader)
 	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 	headers.Set("Cseq", req.CSeq)
 	if len(body) != 0 {
 		headers.Set("Content-Length", strconv.Itoa(len(body)))
@@ -138,9 +152,34 @@ type Request struct {
 	URL    *url.URL
 	Header textproto.MIMEHeader
 	CSeq   string
+	// RemoteAddr is the client's address
+	RemoteAddr net.Addr
+}
+
+// skipInterleaved discards RTCP reports that clients send over the RTSP
+// connection when RTP is interleaved
+func (c *Conn) skipInterleaved() error {
+	for {
+		b, err := c.tpc.R.Peek(1)
+		if err != nil {
+			return err
+		} else if b[0] != '$' {
+			return nil
+		}
+		var hdr [4]byte
+		if _, err := io.ReadFull(c.tpc.R, hdr[:]); err != nil {
+			return err
+		}
+		if _, err := c.tpc.R.Discard(int(hdr[2])<<8 | int(hdr[3])); err != nil {
+			return err
+		}
+	}
 }
 
 func (c *Conn) handleRequest() error {
+	if err := c.skipInterleaved(); err != nil {
+		return err
+	}
 	line, err := c.tpc.ReadLine()
 	if err != nil {
 		return err
@@ -158,16 +197,20 @@ func (c *Conn) handleRequest() error {
 		return err
 	}
 	req := &Request{
-		Method: words[0],
-		URL:    u,
-		Header: header,
-		CSeq:   header.Get("Cseq"),
+		Method:     words[0],
+		URL:        u,
+		Header:     header,
+		CSeq:       header.Get("Cseq"),
+		RemoteAddr: c.conn.RemoteAddr(),
 	}
 	switch req.Method {
 	case "OPTIONS":
 		resp := make(textproto.MIMEHeader)
-		resp.Set("Public", "DESCRIBE, SETUP, PLAY, TEARDOWN")
+		resp.Set("Public", "DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER")
 		err = c.WriteResponse(req, 200, resp, nil)
+	case "GET_PARAMETER":
+		// keepalive
+		err = c.WriteResponse(req, 200, nil, nil)
 	case "DESCRIBE":
 		err = c.handleDescribe(req)
 	case "SETUP":
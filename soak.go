@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+)
+
+// soakTopSites is how many of the fastest growing sites the report lists
+const soakTopSites = 20
+
+// soakSnapshot is the heap and goroutines at one point in a long run,
+// grouped by the site that allocated or started them
+type soakSnapshot struct {
+	taken      time.Time
+	heap       map[string]int64 // in-use bytes
+	goroutines map[string]int64
+	heapTotal  int64
+	numG       int
+}
+
+// soak snapshots the heap and goroutines every interval while the server
+// runs, keeping the profiles in dir for go tool pprof and writing a report of
+// what has grown since the first snapshot. Memory or goroutines that keep
+// growing over days are the leaks that take down long-running instances.
+func soak(dir string, interval time.Duration) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		slog.Error("soak test", "err", err)
+		return
+	}
+	slog.Info("soak test started", "dir", dir, "interval", interval)
+	base := takeSoakSnapshot(dir)
+	for range time.NewTicker(interval).C {
+		cur := takeSoakSnapshot(dir)
+		if err := writeSoakReport(filepath.Join(dir, "leak-report.txt"), base, cur); err != nil {
+			slog.Error("soak test", "err", err)
+		}
+		slog.Info("soak test snapshot", "heap_growth", cur.heapTotal-base.heapTotal, "goroutine_growth", cur.numG-base.numG)
+	}
+}
+
+func takeSoakSnapshot(dir string) *soakSnapshot {
+	runtime.GC()
+	snap := &soakSnapshot{taken: time.Now(), heap: make(map[string]int64), goroutines: make(map[string]int64)}
+	stamp := snap.taken.UTC().Format("20060102T150405Z")
+	for _, name := range []string{"heap", "goroutine"} {
+		if err := writeProfile(filepath.Join(dir, name+"-"+stamp+".pb.gz"), name); err != nil {
+			slog.Error("soak test", "profile", name, "err", err)
+		}
+	}
+	// heap, scaled up from the sampled records as pprof does
+	var records []runtime.MemProfileRecord
+	n, _ := runtime.MemProfile(nil, false)
+	for {
+		records = make([]runtime.MemProfileRecord, n+50)
+		var ok bool
+		if n, ok = runtime.MemProfile(records, false); ok {
+			records = records[:n]
+			break
+		}
+	}
+	rate := float64(runtime.MemProfileRate)
+	for _, r := range records {
+		inUse := r.InUseBytes()
+		if inUse <= 0 || r.AllocObjects == 0 {
+			continue
+		}
+		avg := float64(r.AllocBytes) / float64(r.AllocObjects)
+		scaled := int64(float64(inUse) / (1 - math.Exp(-avg/rate)))
+		snap.heap[stackSite(r.Stack())] += scaled
+		snap.heapTotal += scaled
+	}
+	// goroutines
+	var stacks []runtime.StackRecord
+	n, _ = runtime.GoroutineProfile(nil)
+	for {
+		stacks = make([]runtime.StackRecord, n+50)
+		var ok bool
+		if n, ok = runtime.GoroutineProfile(stacks); ok {
+			stacks = stacks[:n]
+			break
+		}
+	}
+	for _, r := range stacks {
+		snap.goroutines[stackSite(r.Stack())]++
+	}
+	snap.numG = len(stacks)
+	return snap
+}
+
+func writeProfile(path, name string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stackSite names the innermost frame of a stack that is our code or a
+// library's rather than the runtime's
+func stackSite(pcs []uintptr) string {
+	frames := runtime.CallersFrames(pcs)
+	first := ""
+	for {
+		frame, more := frames.Next()
+		site := fmt.Sprintf("%s (%s:%d)", frame.Function, filepath.Base(frame.File), frame.Line)
+		if first == "" {
+			first = site
+		}
+		if !strings.HasPrefix(frame.Function, "runtime.") && !strings.HasPrefix(frame.Function, "sync.") {
+			return site
+		}
+		if !more {
+			return first
+		}
+	}
+}
+
+func writeSoakReport(path string, base, cur *soakSnapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "Soak test report, %s to %s (%s)\n\n", base.taken.UTC().Format(time.RFC3339), cur.taken.UTC().Format(time.RFC3339), cur.taken.Sub(base.taken).Round(time.Second))
+	fmt.Fprintf(f, "Heap in use: %d -> %d bytes (%+d)\n", base.heapTotal, cur.heapTotal, cur.heapTotal-base.heapTotal)
+	fmt.Fprintf(f, "Goroutines: %d -> %d (%+d)\n", base.numG, cur.numG, cur.numG-base.numG)
+	fmt.Fprintln(f, "\nHeap growth by allocation site (bytes):")
+	writeGrowth(f, base.heap, cur.heap)
+	fmt.Fprintln(f, "\nGoroutine growth by site:")
+	writeGrowth(f, base.goroutines, cur.goroutines)
+	fmt.Fprintln(f, "\nCompare profiles with go tool pprof -base <first> <latest>")
+	return f.Close()
+}
+
+// writeGrowth lists the sites that grew the most between two snapshots
+func writeGrowth(w io.Writer, base, cur map[string]int64) {
+	type growth struct {
+		site        string
+		from, delta int64
+	}
+	var grown []growth
+	for site, v := range cur {
+		if d := v - base[site]; d > 0 {
+			grown = append(grown, growth{site, base[site], d})
+		}
+	}
+	sort.Slice(grown, func(i, j int) bool { return grown[i].delta > grown[j].delta })
+	if len(grown) > soakTopSites {
+		grown = grown[:soakTopSites]
+	}
+	if len(grown) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, g := range grown {
+		fmt.Fprintf(w, "  %+12d  from %-12d %s\n", g.delta, g.from, g.site)
+	}
+}
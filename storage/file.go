@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps blobs as files under a directory
+type FileStore struct {
+	Dir string
+}
+
+func (s FileStore) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
+
+func (s FileStore) Put(ctx context.Context, key, contentType string, d []byte) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so readers never see a partial blob
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(d); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (s FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	d, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return d, err
+}
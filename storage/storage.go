@@ -0,0 +1,16 @@
+// Package storage holds blobs such as thumbnails outside of the database
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store is a flat key-value blob store. Keys may contain slashes.
+type Store interface {
+	Put(ctx context.Context, key, contentType string, d []byte) error
+	// Get returns ErrNotFound if the key does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+}
@@ -139,6 +139,9 @@ func Convert(src av.Demuxer, dest *pubsub.Queue, bitrate int) error {
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
+	// output audio starts at the time of the first input audio, so that it
+	// lines up with video when converting from the middle of a stream
+	start := make(chan time.Duration, 1)
 	// remux audio and send to ffmpeg
 	eg.Go(func() error {
 		asrcMux := aac.NewMuxer(stdin)
@@ -146,6 +149,7 @@ func Convert(src av.Demuxer, dest *pubsub.Queue, bitrate int) error {
 		if err := asrcMux.WriteHeader([]av.CodecData{asrcCodec}); err != nil {
 			return err
 		}
+		started := false
 		for ctx.Err() == nil {
 			pkt, err := src.ReadPacket()
 			if err == io.EOF {
@@ -154,6 +158,10 @@ func Convert(src av.Demuxer, dest *pubsub.Queue, bitrate int) error {
 				return err
 			}
 			if int(pkt.Idx) == aidx {
+				if !started {
+					start <- pkt.Time
+					started = true
+				}
 				if err := asrcMux.WritePacket(pkt); err != nil {
 					return err
 				}
@@ -184,6 +192,10 @@ func Convert(src av.Demuxer, dest *pubsub.Queue, bitrate int) error {
 			} else if err != nil {
 				return err
 			}
+			select {
+			case ts = <-start:
+			default:
+			}
 			for i := range samples {
 				samples[i] = int16(sbuf[2*i]) | int16(sbuf[2*i+1])<<8
 			}
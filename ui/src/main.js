@@ -14,6 +14,8 @@ import VueTimeago from 'vue-timeago'
 Vue.use(VueTimeago, {locale: 'en'})
 
 Vue.config.productionTip = false
+// the server refuses state-changing requests without this header
+axios.defaults.headers.common["X-Requested-With"] = "XMLHttpRequest"
 Vue.config.ignoredElements = ["video-js"]
 
 let initialPlayerType = localStorage.getItem("playerType");
@@ -0,0 +1,12 @@
+// viewerID identifies this browser to the server's viewer counts so that
+// reloads and reconnects aren't counted as new viewers
+export function viewerID() {
+  let id = localStorage.getItem("viewerID")
+  if (!id) {
+    let b = new Uint8Array(12)
+    crypto.getRandomValues(b)
+    id = Array.from(b, v => v.toString(16).padStart(2, "0")).join("")
+    localStorage.setItem("viewerID", id)
+  }
+  return id
+}
@@ -0,0 +1,189 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	passCookie   = "passes"
+	maxPassBatch = 500
+)
+
+// passGrants maps channel names to the unix time at which access expires
+type passGrants map[string]int64
+
+func (g passGrants) valid(channel string) bool {
+	return time.Now().Unix() < g[channel]
+}
+
+func (s *Server) viewPasses(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	passes, err := model.ListPasses(req.Context(), userID, name)
+	if err != nil {
+		log.Printf("error: listing passes for %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, passes)
+}
+
+type passRequest struct {
+	Count    int   `json:"count"`
+	Hours    int   `json:"hours"`
+	RedeemBy int64 `json:"redeem_by"`
+}
+
+func (s *Server) viewPassesCreate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var pr passRequest
+	if !parseRequest(rw, req, &pr) {
+		return
+	}
+	if pr.Count < 1 || pr.Count > maxPassBatch || pr.Hours < 1 {
+		http.Error(rw, "count and hours must be positive and count at most 500", 400)
+		return
+	}
+	var redeemBy time.Time
+	if pr.RedeemBy != 0 {
+		redeemBy = time.Unix(0, pr.RedeemBy*1000000)
+	}
+	name := mux.Vars(req)["name"]
+	codes, err := model.CreatePasses(req.Context(), userID, name, pr.Count, time.Duration(pr.Hours)*time.Hour, redeemBy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: creating passes for %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, codes)
+}
+
+type redeemRequest struct {
+	Code string `json:"code"`
+}
+
+func (s *Server) viewRedeem(rw http.ResponseWriter, req *http.Request) {
+	var rr redeemRequest
+	if !parseRequest(rw, req, &rr) {
+		return
+	}
+	name := mux.Vars(req)["channel"]
+	expires, err := model.RedeemPass(req.Context(), name, rr.Code, s.loggedInUser(req))
+	if err == model.ErrPassInvalid {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Printf("error: redeeming pass for %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	// merge with any passes already held, dropping expired ones
+	var grants passGrants
+	if err := s.unseal(req, passCookie, &grants); err != nil || grants == nil {
+		grants = make(passGrants)
+	}
+	grants[name] = expires.Unix()
+	var maxAge int64
+	now := time.Now().Unix()
+	for channel, exp := range grants {
+		if exp <= now {
+			delete(grants, channel)
+		} else if exp-now > maxAge {
+			maxAge = exp - now
+		}
+	}
+	if err := s.setCookie(rw, passCookie, grants, int(maxAge)); err != nil {
+		log.Printf("error: persisting pass: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, map[string]int64{"expires": expires.UnixNano() / 1000000})
+}
+
+// checkAccess returns true if the viewer may start a playback session on the
+// channel. Otherwise an error is written to the client.
+func (s *Server) checkAccess(rw http.ResponseWriter, req *http.Request, chname string) bool {
+	rules, owner, ok := s.checkRules(rw, req, chname)
+	if !ok {
+		return false
+	} else if owner {
+		return true
+	}
+	if !s.Channels.AdmitViewer(chname, req, s.clientIP(req), rules.MaxViewers) {
+		rw.Header().Set("Retry-After", "30")
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(rw).Encode(channelFull{
+			Error:      "channel_full",
+			Message:    "This channel has as many viewers as it can take right now. Try again in a little while.",
+			MaxViewers: rules.MaxViewers,
+		})
+		return false
+	}
+	return true
+}
+
+// checkRules returns true if the viewer may watch the channel, without
+// counting them against its viewer cap, and whether they are its owner.
+// Otherwise an error is written to the client.
+func (s *Server) checkRules(rw http.ResponseWriter, req *http.Request, chname string) (rules model.AccessRules, owner, ok bool) {
+	if s.banned(req) {
+		http.Error(rw, "you are banned from this site", http.StatusForbidden)
+		return
+	}
+	rules, err := model.ChannelAccess(req.Context(), chname)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rules, false, true
+	} else if err != nil {
+		log.Printf("error: checking access to %q: %s", chname, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	userID := s.loggedInUser(req)
+	if userID != "" && userID == rules.Owner {
+		return rules, true, true
+	}
+	if rules.Gated() && !s.hasAccess(req, chname, userID, rules) {
+		http.Error(rw, "an access pass or membership is required to watch this channel", http.StatusForbidden)
+		return
+	}
+	if rules.Rating != model.RatingGeneral && !s.contentAcked(req, chname, userID, rules) {
+		http.Error(rw, "content warnings must be acknowledged before watching this channel", http.StatusForbidden)
+		return
+	}
+	return rules, false, true
+}
+
+type channelFull struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	MaxViewers int    `json:"max_viewers"`
+}
+
+func (s *Server) hasAccess(req *http.Request, chname, userID string, rules model.AccessRules) bool {
+	if rules.Private {
+		var grants passGrants
+		if err := s.unseal(req, passCookie, &grants); err == nil && grants.valid(chname) {
+			return true
+		}
+	}
+	// the override list counts whatever else gates the channel
+	return userID != "" && s.checkMembership(req.Context(), chname, userID, rules)
+}
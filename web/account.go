@@ -0,0 +1,124 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	deactivationNotice   = 14 * 24 * time.Hour
+	accountCheckInterval = time.Hour
+)
+
+type accountStatus struct {
+	model.Account
+	// Release is when the channels of a deactivated account will be deleted
+	Release int64 `json:"release,omitempty"`
+}
+
+func (s *Server) viewAccount(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	a, err := model.GetAccount(req.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting account of %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	status := accountStatus{Account: a}
+	if a.Deactivated != 0 {
+		status.Release = a.Deactivated + int64(s.ReleaseAfterDays)*24*60*60*1000
+	}
+	writeJSON(rw, status)
+}
+
+func (s *Server) viewAccountReactivate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	if err := model.ReactivateUser(req.Context(), userID); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: reactivating %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+// DeactivateInactive periodically warns users who are about to be deactivated
+// for inactivity, deactivates them once the warning has run out, and frees
+// their channel names if they don't come back
+func (s *Server) DeactivateInactive() {
+	if s.DeactivateAfterMonths <= 0 {
+		return
+	}
+	for range time.NewTicker(accountCheckInterval).C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		s.checkInactive(ctx)
+		cancel()
+	}
+}
+
+func (s *Server) checkInactive(ctx context.Context) {
+	warned, err := model.ClaimDeactivationWarnings(ctx, s.DeactivateAfterMonths, deactivationNotice)
+	if err != nil {
+		log.Printf("error: finding inactive users: %s", err)
+		return
+	}
+	for _, ev := range warned {
+		at := ev.At
+		if earliest := time.Now().Add(deactivationNotice); at.Before(earliest) {
+			at = earliest
+		}
+		s.Notify.Publish(notify.Notification{
+			UserID: ev.UserID,
+			Kind:   notify.AccountInactive,
+			Key:    "account_inactive",
+			Data:   map[string]any{"Months": s.DeactivateAfterMonths, "At": at.UTC()},
+		})
+	}
+	deactivated, err := model.DeactivateInactive(ctx, s.DeactivateAfterMonths, deactivationNotice, s.ReleaseAfterDays)
+	if err != nil {
+		log.Printf("error: deactivating inactive users: %s", err)
+		return
+	}
+	for _, ev := range deactivated {
+		log.Printf("deactivated inactive user %s", ev.UserID)
+		s.Notify.Publish(notify.Notification{
+			UserID: ev.UserID,
+			Kind:   notify.AccountInactive,
+			Key:    "account_deactivated",
+			Data:   map[string]any{"At": ev.At.UTC()},
+		})
+	}
+	released, err := model.ReleaseDeactivated(ctx, s.ReleaseAfterDays)
+	if err != nil {
+		log.Printf("error: releasing channels of deactivated users: %s", err)
+		return
+	}
+	for _, ev := range released {
+		log.Printf("released channels of deactivated user %s: %s", ev.UserID, strings.Join(ev.Channels, ", "))
+		s.Notify.Publish(notify.Notification{
+			UserID: ev.UserID,
+			Kind:   notify.AccountInactive,
+			Key:    "account_released",
+			Data:   map[string]any{"Channels": ev.Channels},
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultAnalyticsRange = 24 * time.Hour
+	maxAnalyticsRange     = 31 * 24 * time.Hour
+)
+
+type analytics struct {
+	From    int64                `json:"from"`
+	To      int64                `json:"to"`
+	Average float64              `json:"average"`
+	Peak    int                  `json:"peak"`
+	Minutes []*model.ViewerCount `json:"minutes"`
+}
+
+// parseMillis parses a Unix time in milliseconds, returning def if v is empty
+func parseMillis(v string, def time.Time) (time.Time, bool) {
+	if v == "" {
+		return def, true
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*1000000), true
+}
+
+// viewAnalytics shows how many viewers a channel had over a period. The
+// average only counts minutes the channel was live.
+func (s *Server) viewAnalytics(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	to, ok := parseMillis(req.FormValue("to"), time.Now())
+	if !ok {
+		http.Error(rw, "to must be a Unix time in milliseconds", 400)
+		return
+	}
+	from, ok := parseMillis(req.FormValue("from"), to.Add(-defaultAnalyticsRange))
+	if !ok {
+		http.Error(rw, "from must be a Unix time in milliseconds", 400)
+		return
+	}
+	if !from.Before(to) || to.Sub(from) > maxAnalyticsRange {
+		http.Error(rw, "from must be before to and at most 31 days earlier", 400)
+		return
+	}
+	name := mux.Vars(req)["channel"]
+	counts, err := model.ListViewerCounts(req.Context(), userID, name, from, to)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: listing viewer counts of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	a := analytics{From: from.UnixNano() / 1000000, To: to.UnixNano() / 1000000, Minutes: counts}
+	for _, c := range counts {
+		a.Average += c.Average
+		if c.Peak > a.Peak {
+			a.Peak = c.Peak
+		}
+	}
+	if len(counts) != 0 {
+		a.Average /= float64(len(counts))
+	}
+	writeJSON(rw, a)
+}
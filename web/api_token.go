@@ -0,0 +1,188 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// token scopes
+const (
+	ScopeReadChannels      = "read:channels"
+	ScopeWriteChannels     = "write:channels"
+	ScopeReadNotifications = "read:notifications"
+	ScopeReadStats         = "read:stats"
+)
+
+// Scopes lists every scope a token can be given
+var Scopes = []string{ScopeReadChannels, ScopeWriteChannels, ScopeReadNotifications, ScopeReadStats}
+
+// scopeDescriptions are shown when an app asks for a scope
+var scopeDescriptions = map[string]string{
+	ScopeReadChannels:      "See your channels and rooms, including their stream keys",
+	ScopeWriteChannels:     "Create, change and delete your channels and rooms",
+	ScopeReadNotifications: "Read your notifications",
+	ScopeReadStats:         "See your channels' viewer and bandwidth statistics",
+}
+
+const (
+	maxTokens    = 20
+	maxTokenName = 64
+)
+
+type tokenUserKey struct{}
+
+// tokenScope returns the scope a token needs for a request, or "" if tokens
+// can't be used for it at all. Managing tokens and settings always takes a
+// login.
+func tokenScope(req *http.Request) string {
+	p := req.URL.Path
+	read := req.Method == http.MethodGet || req.Method == http.MethodHead
+	switch {
+	case p == "/api/mychannels", strings.HasPrefix(p, "/api/mychannels/"),
+		p == "/api/rooms", strings.HasPrefix(p, "/api/rooms/"):
+		if read {
+			return ScopeReadChannels
+		}
+		return ScopeWriteChannels
+	case req.Method == http.MethodDelete && strings.HasPrefix(p, "/api/channels/") && strings.HasSuffix(p, "/live"),
+		req.Method == http.MethodPost && strings.HasPrefix(p, "/api/channels/") && (strings.HasSuffix(p, "/metadata") || strings.HasSuffix(p, "/cues")):
+		return ScopeWriteChannels
+	case read && strings.HasPrefix(p, "/api/channels/") && (strings.HasSuffix(p, "/usage") || strings.HasSuffix(p, "/analytics")):
+		// statistics without the stream keys that come with read:channels
+		return ScopeReadStats
+	case p == "/api/notifications", strings.HasPrefix(p, "/api/notifications/"):
+		// marking notifications as seen comes with reading them
+		return ScopeReadNotifications
+	}
+	return ""
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, v := range scopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// checkToken authenticates requests that carry a bearer token, refusing them
+// if the token lacks the scope the request needs
+func (s *Server) checkToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == req.Header.Get("Authorization") {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		scope := tokenScope(req)
+		if scope == "" {
+			http.Error(rw, "API tokens can't be used here", http.StatusForbidden)
+			return
+		}
+		userID, scopes, err := model.VerifyAPIToken(req.Context(), token)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(rw, "invalid token", 401)
+			return
+		} else if err != nil {
+			log.Printf("error: verifying API token: %s", err)
+			http.Error(rw, "", 500)
+			return
+		}
+		if s.Channels.Banned(userID, nil) {
+			http.Error(rw, "account is banned", http.StatusForbidden)
+			return
+		}
+		if !hasScope(scopes, scope) {
+			http.Error(rw, fmt.Sprintf("token lacks the %s scope", scope), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), tokenUserKey{}, userID)))
+	})
+}
+
+type tokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type tokenResponse struct {
+	*model.APIToken
+	// Token is only ever shown here
+	Token string `json:"token"`
+}
+
+func (s *Server) viewTokens(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	tokens, err := model.ListAPITokens(req.Context(), userID)
+	if err != nil {
+		log.Printf("error: listing tokens for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, tokens)
+}
+
+func (s *Server) viewTokensCreate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var tr tokenRequest
+	if !parseRequest(rw, req, &tr) {
+		return
+	}
+	tr.Name = strings.TrimSpace(tr.Name)
+	if tr.Name == "" || len(tr.Name) > maxTokenName {
+		http.Error(rw, fmt.Sprintf("name must be 1 to %d bytes", maxTokenName), 400)
+		return
+	} else if len(tr.Scopes) == 0 {
+		http.Error(rw, "at least one scope is required", 400)
+		return
+	}
+	for _, scope := range tr.Scopes {
+		if !hasScope(Scopes, scope) {
+			http.Error(rw, fmt.Sprintf("unknown scope %q", scope), 400)
+			return
+		}
+	}
+	token, t, err := model.CreateAPIToken(req.Context(), userID, tr.Name, tr.Scopes, maxTokens)
+	if errors.Is(err, model.ErrTooManyTokens) {
+		http.Error(rw, fmt.Sprintf("tokens are limited to %d", maxTokens), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("error: creating token for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, tokenResponse{APIToken: t, Token: token})
+}
+
+func (s *Server) viewTokensDelete(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err := model.DeleteAPIToken(req.Context(), userID, id); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: deleting token for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
@@ -0,0 +1,38 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTokenScope checks the scope each request needs, where requests outside
+// of every scope can't be made with a token at all
+func TestTokenScope(t *testing.T) {
+	for _, tc := range []struct {
+		method, path, scope string
+	}{
+		{"GET", "/api/mychannels", ScopeReadChannels},
+		{"HEAD", "/api/mychannels/alice", ScopeReadChannels},
+		{"GET", "/api/rooms", ScopeReadChannels},
+		{"POST", "/api/mychannels", ScopeWriteChannels},
+		{"PUT", "/api/mychannels/alice", ScopeWriteChannels},
+		{"DELETE", "/api/rooms/room-1", ScopeWriteChannels},
+		{"DELETE", "/api/channels/alice/live", ScopeWriteChannels},
+		{"POST", "/api/channels/alice/metadata", ScopeWriteChannels},
+		{"POST", "/api/channels/alice/cues", ScopeWriteChannels},
+		{"GET", "/api/channels/alice/usage", ScopeReadStats},
+		{"GET", "/api/channels/alice/analytics", ScopeReadStats},
+		{"POST", "/api/channels/alice/usage", ""},
+		{"GET", "/api/notifications", ScopeReadNotifications},
+		{"POST", "/api/notifications/seen", ScopeReadNotifications},
+		{"GET", "/api/channels/alice/metadata", ""},
+		{"GET", "/api/mychannelsx", ""},
+		{"GET", "/api/account", ""},
+		{"POST", "/api/tokens", ""},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if got := tokenScope(req); got != tc.scope {
+			t.Errorf("%s %s: got %q, want %q", tc.method, tc.path, got, tc.scope)
+		}
+	}
+}
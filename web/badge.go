@@ -0,0 +1,114 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// badges are cached briefly so that busy pages don't poll the server
+const badgeMaxAge = "max-age=30, public"
+
+type channelStatus struct {
+	Name    string `json:"name"`
+	Live    bool   `json:"live"`
+	Viewers int    `json:"viewers"`
+	// Started and Uptime are only set while live
+	Started int64 `json:"started,omitempty"`
+	Uptime  int64 `json:"uptime,omitempty"`
+}
+
+// channelStatus looks up whether a channel is live and for how long. It
+// writes an error response and returns nil if that isn't possible.
+func (s *Server) channelStatus(rw http.ResponseWriter, req *http.Request) (*model.ChannelInfo, *channelStatus) {
+	chname := mux.Vars(req)["channel"]
+	info, err := model.GetChannelInfo(req.Context(), chname)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return nil, nil
+	} else if err != nil {
+		log.Printf("error: getting channel %q: %s", chname, err)
+		http.Error(rw, "", 500)
+		return nil, nil
+	}
+	s.Channels.PopulateLive([]*model.ChannelInfo{info})
+	status := &channelStatus{Name: info.Name, Live: info.Live, Viewers: info.Viewers}
+	if info.Live {
+		sessions, err := model.ListSessions(req.Context(), chname, 1)
+		if err != nil {
+			log.Printf("error: listing sessions of %q: %s", chname, err)
+			http.Error(rw, "", 500)
+			return nil, nil
+		}
+		if len(sessions) != 0 && sessions[0].Ended == 0 {
+			status.Started = sessions[0].Started
+			status.Uptime = sessions[0].Duration
+		}
+	}
+	return info, status
+}
+
+// viewStatus is a small summary of a channel for widgets on other sites
+func (s *Server) viewStatus(rw http.ResponseWriter, req *http.Request) {
+	_, status := s.channelStatus(rw, req)
+	if status == nil {
+		return
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	rw.Header().Set("Cache-Control", badgeMaxAge)
+	writeJSON(rw, status)
+}
+
+// viewBadge draws a live badge that streamers can put on their sites
+func (s *Server) viewBadge(rw http.ResponseWriter, req *http.Request) {
+	info, status := s.channelStatus(rw, req)
+	if status == nil {
+		return
+	}
+	label := channelLabel(info)
+	value, color := "offline", "#9f9f9f"
+	if status.Live {
+		value, color = "live", "#e05d44"
+		if status.Uptime > 0 {
+			value += " " + formatUptime(time.Duration(status.Uptime)*time.Second)
+		}
+	}
+	rw.Header().Set("Content-Type", "image/svg+xml")
+	rw.Header().Set("Cache-Control", badgeMaxAge)
+	rw.Write(badgeSVG(label, value, color))
+}
+
+// formatUptime shortens a duration to hours and minutes
+func formatUptime(d time.Duration) string {
+	h, m := int(d/time.Hour), int(d%time.Hour/time.Minute)
+	if h == 0 {
+		return fmt.Sprintf("%dm", m)
+	}
+	return fmt.Sprintf("%dh%02dm", h, m)
+}
+
+// badgeSVG lays out a two-part badge in the usual flat style. Text widths are
+// estimated as the font isn't known until the browser renders it.
+func badgeSVG(label, value, color string) []byte {
+	lw := 10 + 7*utf8.RuneCountInString(label)
+	vw := 10 + 7*utf8.RuneCountInString(value)
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<rect width="%[2]d" height="20" fill="#555"/>
+<rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, lw+vw, lw, vw, label, value, color, lw/2, lw+vw/2))
+}
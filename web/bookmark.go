@@ -0,0 +1,97 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxBookmarks     = 500
+	maxBookmarkNote  = 200
+	maxBookmarkDelay = 5 * time.Minute
+)
+
+type bookmarkRequest struct {
+	Channel string `json:"channel"`
+	Note    string `json:"note"`
+	// Delay is how many seconds behind live the viewer's player is
+	Delay float64 `json:"delay"`
+}
+
+func (s *Server) viewBookmarks(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	bookmarks, err := model.ListBookmarks(req.Context(), userID)
+	if err != nil {
+		log.Printf("error: listing bookmarks for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, bookmarks)
+}
+
+// viewBookmarksCreate saves the moment a viewer is watching in a live stream
+func (s *Server) viewBookmarksCreate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var br bookmarkRequest
+	if !parseRequest(rw, req, &br) {
+		return
+	}
+	delay := time.Duration(br.Delay * float64(time.Second))
+	switch {
+	case br.Channel == "":
+		http.Error(rw, "channel is required", 400)
+		return
+	case len(br.Note) > maxBookmarkNote || !utf8.ValidString(br.Note):
+		http.Error(rw, fmt.Sprintf("note is limited to %d bytes", maxBookmarkNote), 400)
+		return
+	case delay < 0 || delay > maxBookmarkDelay:
+		http.Error(rw, fmt.Sprintf("delay must be between 0 and %d seconds", int(maxBookmarkDelay/time.Second)), 400)
+		return
+	}
+	bookmark, err := model.AddBookmark(req.Context(), userID, br.Channel, br.Note, delay, maxBookmarks)
+	switch {
+	case errors.Is(err, model.ErrNotLive):
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, model.ErrTooManyBookmarks):
+		http.Error(rw, fmt.Sprintf("bookmarks are limited to %d", maxBookmarks), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("error: adding bookmark for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, bookmark)
+}
+
+func (s *Server) viewBookmarksDelete(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err := model.DeleteBookmark(req.Context(), userID, id); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: deleting bookmark %d: %s", id, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
@@ -1,12 +1,20 @@
 package web
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
 
 	"eaglesong.dev/gunk/model"
 	"github.com/gorilla/mux"
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func (s *Server) viewDefs(rw http.ResponseWriter, req *http.Request) {
@@ -14,7 +22,7 @@ func (s *Server) viewDefs(rw http.ResponseWriter, req *http.Request) {
 	if userID == "" {
 		return
 	}
-	defs, err := model.ListChannelDefs(userID)
+	defs, err := model.ListChannelDefs(req.Context(), userID)
 	if err != nil {
 		log.Println("error:", err)
 		http.Error(rw, "", 500)
@@ -38,11 +46,19 @@ func (s *Server) viewDefsCreate(rw http.ResponseWriter, req *http.Request) {
 	if !parseRequest(rw, req, &dr) {
 		return
 	}
-	def, err := model.CreateChannel(userID, dr.Name)
+	if msg := checkChannelName(dr.Name); msg != "" {
+		http.Error(rw, msg, 400)
+		return
+	}
+	def, err := model.CreateChannel(req.Context(), userID, dr.Name, s.MaxChannels)
 	if err != nil {
-		if pge, ok := err.(pgx.PgError); ok && pge.Code == "23505" {
+		var pge *pgconn.PgError
+		if errors.As(err, &pge) && pge.Code == "23505" {
 			http.Error(rw, "channel name already in use", http.StatusConflict)
 			return
+		} else if errors.Is(err, model.ErrTooManyChannels) {
+			http.Error(rw, "channel limit reached", http.StatusConflict)
+			return
 		}
 		log.Printf("error: creating channel %q for %s: %s", dr.Name, req.RemoteAddr, err)
 		http.Error(rw, "", 500)
@@ -52,8 +68,137 @@ func (s *Server) viewDefsCreate(rw http.ResponseWriter, req *http.Request) {
 	writeJSON(rw, def)
 }
 
-type defUpdate struct {
-	Announce bool `json:"announce"`
+const (
+	maxTitle       = 140
+	maxDescription = 5000
+	maxCategory    = 64
+	maxTags        = 10
+	maxTag         = 32
+	maxWarnings    = 10
+	maxWarning     = 64
+	maxOfflineText = 2000
+	maxLinks       = 10
+	maxLinkLabel   = 64
+	maxURL         = 1024
+	maxIngestAllow = 32
+	maxViewerCap   = 100000
+)
+
+// validWebURL returns true for absolute http and https URLs
+func validWebURL(v string) bool {
+	if len(v) > maxURL {
+		return false
+	}
+	u, err := url.Parse(v)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+const (
+	maxChannelName = 64
+	maxDisplayName = 64
+)
+
+var channelNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// reservedNames can't be used for channels because they would be confused
+// with pages and endpoints of the site
+var reservedNames = []string{
+	"admin", "api", "avatars", "directory", "embed", "feed", "hls", "instance",
+	"live", "mychannels", "oauth2", "oembed", "previews", "rooms", "sdp",
+	"settings", "thumbs", "watch", "ws",
+}
+
+// checkChannelName returns a message explaining what's wrong with a channel
+// name, or "" if it's acceptable
+func checkChannelName(name string) string {
+	switch {
+	case name == "":
+		return "name is required"
+	case len(name) > maxChannelName:
+		return fmt.Sprintf("name is limited to %d characters", maxChannelName)
+	case !channelNameRe.MatchString(name):
+		return "name may only contain letters, digits, - and _, and must start with a letter or digit"
+	case strings.HasPrefix(strings.ToLower(name), "room-"):
+		return "names starting with room- are reserved"
+	}
+	for _, v := range reservedNames {
+		if strings.EqualFold(name, v) {
+			return fmt.Sprintf("%q is reserved", name)
+		}
+	}
+	return ""
+}
+
+func validateUpdate(du model.ChannelUpdate) string {
+	if du.Name != nil {
+		if msg := checkChannelName(*du.Name); msg != "" {
+			return msg
+		}
+	}
+	switch {
+	case du.DisplayName != nil && len(*du.DisplayName) > maxDisplayName:
+		return fmt.Sprintf("display name is limited to %d bytes", maxDisplayName)
+	case du.DisplayName != nil && strings.IndexFunc(*du.DisplayName, unicode.IsControl) >= 0:
+		return "display name must not contain control characters"
+	case du.Title != nil && len(*du.Title) > maxTitle:
+		return fmt.Sprintf("title is limited to %d bytes", maxTitle)
+	case du.Description != nil && len(*du.Description) > maxDescription:
+		return fmt.Sprintf("description is limited to %d bytes", maxDescription)
+	case du.Category != nil && len(*du.Category) > maxCategory:
+		return fmt.Sprintf("category is limited to %d bytes", maxCategory)
+	case du.Tags != nil && len(*du.Tags) > maxTags:
+		return fmt.Sprintf("at most %d tags are allowed", maxTags)
+	case du.Warnings != nil && len(*du.Warnings) > maxWarnings:
+		return fmt.Sprintf("at most %d content warnings are allowed", maxWarnings)
+	case du.OfflineText != nil && len(*du.OfflineText) > maxOfflineText:
+		return fmt.Sprintf("offline text is limited to %d bytes", maxOfflineText)
+	case du.OfflineLinks != nil && len(*du.OfflineLinks) > maxLinks:
+		return fmt.Sprintf("at most %d offline links are allowed", maxLinks)
+	case du.TrailerURL != nil && *du.TrailerURL != "" && !validWebURL(*du.TrailerURL):
+		return "trailer URL must be an http or https URL"
+	case du.IngestAllow != nil && len(*du.IngestAllow) > maxIngestAllow:
+		return fmt.Sprintf("at most %d ingest addresses are allowed", maxIngestAllow)
+	case du.MaxViewers != nil && (*du.MaxViewers < 0 || *du.MaxViewers > maxViewerCap):
+		return fmt.Sprintf("max viewers must be 0 to %d", maxViewerCap)
+	}
+	if du.IngestAllow != nil {
+		for _, v := range *du.IngestAllow {
+			if _, _, err := net.ParseCIDR(v); err != nil && net.ParseIP(v) == nil {
+				return fmt.Sprintf("invalid ingest address %q: expected an IP or CIDR range", v)
+			}
+		}
+	}
+	if du.OfflineLinks != nil {
+		for _, link := range *du.OfflineLinks {
+			if link.Label == "" || len(link.Label) > maxLinkLabel {
+				return fmt.Sprintf("link labels must be between 1 and %d bytes", maxLinkLabel)
+			} else if !validWebURL(link.URL) {
+				return "links must be http or https URLs"
+			}
+		}
+	}
+	if du.Rating != nil {
+		switch *du.Rating {
+		case model.RatingGeneral, model.RatingMature, model.RatingAdult:
+		default:
+			return "rating must be one of \"\", \"mature\" or \"adult\""
+		}
+	}
+	if du.Warnings != nil {
+		for _, warning := range *du.Warnings {
+			if warning == "" || len(warning) > maxWarning {
+				return fmt.Sprintf("content warnings must be between 1 and %d bytes", maxWarning)
+			}
+		}
+	}
+	if du.Tags != nil {
+		for _, tag := range *du.Tags {
+			if tag == "" || len(tag) > maxTag {
+				return fmt.Sprintf("tags must be between 1 and %d bytes", maxTag)
+			}
+		}
+	}
+	return ""
 }
 
 func (s *Server) viewDefsUpdate(rw http.ResponseWriter, req *http.Request) {
@@ -61,17 +206,55 @@ func (s *Server) viewDefsUpdate(rw http.ResponseWriter, req *http.Request) {
 	if userID == "" {
 		return
 	}
-	var du defUpdate
+	var du model.ChannelUpdate
 	if !parseRequest(rw, req, &du) {
 		return
 	}
 	name := mux.Vars(req)["name"]
-	if err := model.UpdateChannel(userID, name, du.Announce); err != nil {
+	if du.Name != nil && *du.Name == name {
+		// the UI sends back the whole channel
+		du.Name = nil
+	}
+	if msg := validateUpdate(du); msg != "" {
+		http.Error(rw, msg, 400)
+		return
+	}
+	renamed := du.Name != nil
+	if renamed && s.Channels.IsLive(name) {
+		http.Error(rw, "channel can't be renamed while live", http.StatusConflict)
+		return
+	}
+	err := model.UpdateChannel(req.Context(), userID, name, du)
+	var pge *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if errors.As(err, &pge) && pge.Code == "23505" {
+		http.Error(rw, "channel name already in use", http.StatusConflict)
+		return
+	} else if err != nil {
 		log.Printf("error: updating channel %q for %s: %s", name, req.RemoteAddr, err)
 		http.Error(rw, "", 500)
 		return
 	}
-	writeJSON(rw, nil)
+	if renamed {
+		oldName := name
+		name = *du.Name
+		if err := model.RenameThumbs(req.Context(), oldName, name); err != nil {
+			log.Printf("warning: moving thumbnails of %q to %q: %s", oldName, name, err)
+		}
+		s.Channels.RenamePlayout(oldName, name)
+	}
+	// send back the channel as it is now, including the ingest URL under
+	// its new name
+	def, err := model.GetChannelDef(req.Context(), userID, name)
+	if err != nil {
+		log.Printf("error: getting channel %q for %s: %s", name, req.RemoteAddr, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	def.SetURL(s.AdvertiseRTMP)
+	writeJSON(rw, def)
 }
 
 func (s *Server) viewDefsDelete(rw http.ResponseWriter, req *http.Request) {
@@ -80,7 +263,7 @@ func (s *Server) viewDefsDelete(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	name := mux.Vars(req)["name"]
-	if err := model.DeleteChannel(userID, name); err != nil {
+	if err := model.DeleteChannel(req.Context(), userID, name); err != nil {
 		log.Printf("error: deleting channel %q for %s: %s", name, req.RemoteAddr, err)
 		return
 	}
@@ -0,0 +1,40 @@
+package web
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCheckChannelName checks that names which would clash with the site's own
+// paths, or couldn't be used in one, are refused
+func TestCheckChannelName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ok   bool
+	}{
+		{"alice", true},
+		{"Alice_2-b", true},
+		{"9lives", true},
+		{strings.Repeat("a", maxChannelName), true},
+		{strings.Repeat("a", maxChannelName+1), false},
+		{"", false},
+		{"-alice", false},
+		{"_alice", false},
+		{"al ice", false},
+		{"al.ice", false},
+		{"../alice", false},
+		{"alice/status", false},
+		{"alïce", false},
+		{"alice\n", false},
+		{"api", false},
+		{"HLS", false},
+		{"Settings", false},
+		{"room-1", false},
+		{"Room-x", false},
+		{"roomy", true},
+	} {
+		if msg := checkChannelName(tc.name); (msg == "") != tc.ok {
+			t.Errorf("%q: got %q", tc.name, msg)
+		}
+	}
+}
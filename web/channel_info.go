@@ -1,17 +1,20 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"eaglesong.dev/gunk/model"
 	"github.com/gorilla/mux"
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
 )
 
-func (s *Server) listChannels() ([]*model.ChannelInfo, error) {
-	infos, err := model.ListChannelInfo()
+func (s *Server) listChannels(ctx context.Context) ([]*model.ChannelInfo, error) {
+	infos, err := model.ListChannelInfo(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -19,21 +22,40 @@ func (s *Server) listChannels() ([]*model.ChannelInfo, error) {
 		s.populateChannel(info)
 	}
 	s.Channels.PopulateLive(infos)
+	if err := attachSchedule(ctx, infos); err != nil {
+		return nil, err
+	}
 	return infos, nil
 }
 
+// channelLabel is what a channel is called when shown to viewers
+func channelLabel(info *model.ChannelInfo) string {
+	if info.DisplayName != "" {
+		return info.DisplayName
+	}
+	return info.Name
+}
+
 func (s *Server) populateChannel(info *model.ChannelInfo) {
-	u, _ := s.router.Get("thumbs").URL("channel", info.Name, "timestamp", strconv.FormatInt(info.Last, 10))
+	u, _ := s.router.Get("thumbs").URL("channel", info.Name, "timestamp", strconv.FormatInt(info.ThumbUpdated, 10), "ext", s.Channels.Thumbs.Ext())
 	info.Thumb = u.String()
-	liveU, _ := s.router.Get("live").URL("channel", info.Name)
+	if info.PreviewUpdated > 0 {
+		u, _ := s.router.Get("previews").URL("channel", info.Name, "timestamp", strconv.FormatInt(info.PreviewUpdated, 10))
+		info.Preview = u.String()
+	}
+	liveU, _ := s.router.Get("live").URL("channel", info.Name, "ext", "ts")
 	if s.AdvertiseLive != nil {
 		liveU = s.AdvertiseLive.ResolveReference(liveU)
 	}
 	info.LiveURL = liveU.String()
+	// RTSP clients can't get past access or content checks
+	if s.AdvertiseRTSP != "" && !info.Private && info.Rating == model.RatingGeneral {
+		info.RTSPURL = s.AdvertiseRTSP + "/" + url.PathEscape(info.Name)
+	}
 }
 
 func (s *Server) viewChannelInfo(rw http.ResponseWriter, req *http.Request) {
-	infos, err := s.listChannels()
+	infos, err := s.listChannels(req.Context())
 	if err != nil {
 		log.Printf("error: listing channels: %s", err)
 		http.Error(rw, "", 500)
@@ -42,10 +64,65 @@ func (s *Server) viewChannelInfo(rw http.ResponseWriter, req *http.Request) {
 	writeJSON(rw, infos)
 }
 
+func (s *Server) viewChannel(rw http.ResponseWriter, req *http.Request) {
+	chname := mux.Vars(req)["channel"]
+	info, err := model.GetChannelInfo(req.Context(), chname)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting channel %q: %s", chname, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.populateChannel(info)
+	s.Channels.PopulateLive([]*model.ChannelInfo{info})
+	info.Upcoming, err = model.ListSchedule(req.Context(), chname)
+	if err != nil {
+		log.Printf("error: getting schedule of %q: %s", chname, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, info)
+}
+
+const maxSessions = 100
+
+// viewSessions lists a channel's recent sessions with their viewer stats. Only
+// the owner may see those of private or member-only channels and rooms, as
+// with usage and analytics.
+func (s *Server) viewSessions(rw http.ResponseWriter, req *http.Request) {
+	chname := mux.Vars(req)["channel"]
+	rules, err := model.ChannelAccess(req.Context(), chname)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: checking access to %q: %s", chname, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	if rules.Gated() {
+		if userID := s.loggedInUser(req); userID == "" || userID != rules.Owner {
+			http.NotFound(rw, req)
+			return
+		}
+	}
+	sessions, err := model.ListSessions(req.Context(), chname, maxSessions)
+	if err != nil {
+		log.Printf("error: listing sessions of %q: %s", chname, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, sessions)
+}
+
 func (s *Server) viewThumb(rw http.ResponseWriter, req *http.Request) {
 	chname := mux.Vars(req)["channel"]
-	jpeg, err := model.GetThumb(chname)
-	if err == pgx.ErrNoRows {
+	thumb, err := model.GetThumb(req.Context(), chname)
+	if errors.Is(err, pgx.ErrNoRows) {
 		log.Printf("not found: %s", req.URL)
 		http.NotFound(rw, req)
 		return
@@ -55,6 +132,23 @@ func (s *Server) viewThumb(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	rw.Header().Set("Cache-Control", "max-age=86400, public, immutable")
-	rw.Header().Set("Content-Type", "image/jpeg")
-	rw.Write(jpeg)
+	// the format may have changed since this thumbnail was stored
+	rw.Header().Set("Content-Type", http.DetectContentType(thumb))
+	rw.Write(thumb)
+}
+
+func (s *Server) viewPreview(rw http.ResponseWriter, req *http.Request) {
+	chname := mux.Vars(req)["channel"]
+	mp4, err := model.GetPreview(req.Context(), chname)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting preview: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Cache-Control", "max-age=86400, public, immutable")
+	rw.Header().Set("Content-Type", "video/mp4")
+	rw.Write(mp4)
 }
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+)
+
+const (
+	ackCookie        = "acks"
+	ackCookieExpires = 365 * 24 * 60 * 60
+)
+
+// contentAcks maps channel names to the unix time an anonymous viewer
+// acknowledged its content warnings
+type contentAcks map[string]int64
+
+func (s *Server) viewAckContent(rw http.ResponseWriter, req *http.Request) {
+	chname := mux.Vars(req)["channel"]
+	if userID := s.loggedInUser(req); userID != "" {
+		if err := model.AckContent(req.Context(), userID, chname); err != nil {
+			log.Printf("error: acknowledging content warnings of %q: %s", chname, err)
+			http.Error(rw, "", 500)
+			return
+		}
+		writeJSON(rw, nil)
+		return
+	}
+	var acks contentAcks
+	if err := s.unseal(req, ackCookie, &acks); err != nil || acks == nil {
+		acks = make(contentAcks)
+	}
+	acks[chname] = time.Now().Unix()
+	if err := s.setCookie(rw, ackCookie, acks, ackCookieExpires); err != nil {
+		log.Printf("error: persisting acknowledgment: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+func (s *Server) contentAcked(req *http.Request, chname, userID string, rules model.AccessRules) bool {
+	if userID != "" {
+		acked, err := model.ContentAcked(req.Context(), userID, chname, rules.ContentUpdated)
+		if err != nil {
+			log.Printf("error: checking acknowledgment of %q: %s", chname, err)
+		}
+		return acked
+	}
+	var acks contentAcks
+	if err := s.unseal(req, ackCookie, &acks); err != nil {
+		return false
+	}
+	return acks[chname] >= rules.ContentUpdated.Unix()
+}
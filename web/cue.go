@@ -0,0 +1,91 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxCueDuration = 24 * time.Hour
+	maxCueSize     = 4096
+)
+
+type cueRequest struct {
+	Type     string  `json:"type"`
+	Duration float64 `json:"duration"`
+	EventID  uint32  `json:"event_id"`
+	// Data is a raw splice_info_section, base64 encoded by encoding/json
+	Data []byte `json:"data"`
+}
+
+func (cr *cueRequest) cue() (ingest.Cue, error) {
+	var cue ingest.Cue
+	switch cr.Type {
+	case "out":
+		cue.Out = true
+	case "in":
+	case "raw":
+		if len(cr.Data) == 0 || len(cr.Data) > maxCueSize {
+			return cue, errors.New("data must be a splice_info_section of at most 4096 bytes")
+		}
+		cue.Raw = cr.Data
+		return cue, nil
+	default:
+		return cue, errors.New("type must be out, in or raw")
+	}
+	d := time.Duration(cr.Duration * float64(time.Second))
+	if d < 0 || d > maxCueDuration {
+		return cue, errors.New("duration is out of range")
+	}
+	cue.Duration = d
+	cue.EventID = cr.EventID
+	return cue, nil
+}
+
+type cueResponse struct {
+	EventID uint32 `json:"event_id,omitempty"`
+}
+
+// viewCue adds a SCTE-35 splice cue to a live channel's stream, for downstream
+// ad insertion or chaptering
+func (s *Server) viewCue(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["channel"]
+	owner, err := model.GetChannelOwner(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && owner != userID) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting owner of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	var cr cueRequest
+	if !parseRequest(rw, req, &cr) {
+		return
+	}
+	cue, err := cr.cue()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	eventID, err := s.Channels.InsertCue(name, cue)
+	if errors.Is(err, ingest.ErrNotPublishing) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	} else if errors.Is(err, ingest.ErrInvalidCue) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(rw, cueResponse{EventID: eventID})
+}
@@ -0,0 +1,234 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"eaglesong.dev/gunk/internal"
+	"eaglesong.dev/gunk/model"
+)
+
+const (
+	directoryReportInterval = 15 * time.Minute
+	// directoryMaxAge is how long an instance stays listed after it last
+	// reported
+	directoryMaxAge   = time.Hour
+	directoryPruneAge = 30 * 24 * time.Hour
+	maxInstanceName   = 100
+	maxInstanceInfo   = 64 * 1024
+)
+
+// Directory configures listing this instance in a directory of gunk servers,
+// and running such a directory for others
+type Directory struct {
+	// Name is shown in directory listings
+	Name string
+	// ReportTo is the base URL of a directory to be listed in, if any
+	ReportTo string
+	// Serve accepts listings from other instances at /api/directory
+	Serve bool
+}
+
+func (s *Server) SetDirectory(d Directory) {
+	d.ReportTo = strings.TrimSuffix(d.ReportTo, "/")
+	if d.Name == "" {
+		u, _ := url.Parse(s.BaseURL)
+		d.Name = u.Hostname()
+	}
+	s.directory = d
+}
+
+// instanceInfo is what an instance says about itself, and what directories
+// list it with
+type instanceInfo struct {
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	Channels     int    `json:"channels"`
+	LiveChannels int    `json:"live_channels"`
+	// Listed is true if the instance has opted in to directories
+	Listed bool `json:"listed"`
+}
+
+func (s *Server) viewInstance(rw http.ResponseWriter, req *http.Request) {
+	infos, err := s.listChannels(req.Context())
+	if err != nil {
+		log.Printf("error: listing channels: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	info := instanceInfo{
+		Name:     s.directory.Name,
+		URL:      s.BaseURL,
+		Channels: len(infos),
+		Listed:   s.directory.ReportTo != "",
+	}
+	for _, ch := range infos {
+		if ch.Live {
+			info.LiveChannels++
+		}
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, info)
+}
+
+// ReportDirectory periodically asks the configured directory to list this
+// instance
+func (s *Server) ReportDirectory() {
+	if s.directory.ReportTo == "" {
+		return
+	}
+	for {
+		if err := s.reportDirectory(); err != nil {
+			log.Printf("warning: reporting to directory %s: %s", s.directory.ReportTo, err)
+		}
+		time.Sleep(directoryReportInterval)
+	}
+}
+
+func (s *Server) reportDirectory() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	blob, _ := json.Marshal(directoryRequest{URL: s.BaseURL})
+	req, err := http.NewRequestWithContext(ctx, "POST", s.directory.ReportTo+"/api/directory", bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(csrfHeader, "gunk")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("HTTP %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// directoryClient fetches instance info on behalf of whoever asks for an
+// instance to be listed, so it refuses to connect to non-public addresses
+var directoryClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: internal.PublicOnly,
+		}).DialContext,
+	},
+}
+
+type directoryRequest struct {
+	URL string `json:"url"`
+}
+
+// viewDirectoryReport lists the instance at the given URL. Rather than trust
+// the caller, the instance is asked to describe itself and must have opted in.
+func (s *Server) viewDirectoryReport(rw http.ResponseWriter, req *http.Request) {
+	if !s.directory.Serve {
+		http.NotFound(rw, req)
+		return
+	}
+	var dr directoryRequest
+	if !parseRequest(rw, req, &dr) {
+		return
+	}
+	base := strings.TrimSuffix(dr.URL, "/")
+	if u, err := url.Parse(base); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		http.Error(rw, "url must be an http or https base URL", 400)
+		return
+	}
+	info, err := fetchInstance(req.Context(), base)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("fetching %s/api/instance: %s", base, err), http.StatusBadGateway)
+		return
+	} else if !info.Listed {
+		http.Error(rw, "instance has not opted in to directories", http.StatusForbidden)
+		return
+	} else if strings.TrimSuffix(info.URL, "/") != base {
+		http.Error(rw, "instance reports a different url", 400)
+		return
+	}
+	name := strings.TrimSpace(info.Name)
+	if len(name) > maxInstanceName {
+		name = strings.ToValidUTF8(name[:maxInstanceName], "")
+	}
+	if name == "" {
+		name = base
+	}
+	err = model.PutDirectoryEntry(req.Context(), model.DirectoryEntry{
+		URL:          base,
+		Name:         name,
+		Channels:     info.Channels,
+		LiveChannels: info.LiveChannels,
+	})
+	if err != nil {
+		log.Printf("error: listing instance %s: %s", base, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+func fetchInstance(ctx context.Context, base string) (*instanceInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/api/instance", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := directoryClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	info := new(instanceInfo)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxInstanceInfo)).Decode(info); err != nil {
+		return nil, err
+	}
+	if info.Channels < 0 || info.LiveChannels < 0 || info.LiveChannels > info.Channels {
+		return nil, errors.New("invalid channel counts")
+	}
+	return info, nil
+}
+
+func (s *Server) viewDirectory(rw http.ResponseWriter, req *http.Request) {
+	if !s.directory.Serve {
+		http.NotFound(rw, req)
+		return
+	}
+	entries, err := model.ListDirectory(req.Context(), directoryMaxAge)
+	if err != nil {
+		log.Printf("error: listing directory: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, entries)
+}
+
+// PruneDirectory forgets instances that stopped reporting long ago
+func (s *Server) PruneDirectory() {
+	if !s.directory.Serve {
+		return
+	}
+	for range time.NewTicker(24 * time.Hour).C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := model.PruneDirectory(ctx, directoryPruneAge); err != nil {
+			log.Printf("error: pruning directory: %s", err)
+		}
+		cancel()
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"time"
 
+	"eaglesong.dev/gunk/locale"
 	"eaglesong.dev/gunk/model"
 	"golang.org/x/oauth2"
 )
@@ -64,7 +65,7 @@ func (s *Server) lookupUser(ctx context.Context, token *oauth2.Token) (user disc
 	if err != nil {
 		return
 	}
-	err = model.SetUser(user.ID, newToken, announce)
+	err = model.SetUser(ctx, user.ID, newToken, announce)
 	return
 }
 
@@ -101,12 +102,31 @@ func (s *Server) doWebhook(auth model.ChannelAuth) error {
 	if auth.Token != nil && (auth.Token.Valid() || auth.Token.RefreshToken != "") {
 		userInfo, err := s.lookupUser(ctx, auth.Token)
 		if err != nil {
-			log.Printf("warning: failed to refresh user %d info: %s", auth.UserID, err)
+			log.Printf("warning: failed to refresh user %s info: %s", auth.UserID, err)
 		}
 		displayName = userInfo.Username
 	}
-	msg := fmt.Sprintf("**%s** is now live at %s/watch/%s", displayName, s.BaseURL, url.PathEscape(auth.Name))
-	blob, _ := json.Marshal(webhookMessage{Content: msg})
+	data := locale.TemplateData{Message: s.messages.Render("", "announce_live", map[string]any{
+		"Name": displayName,
+		"URL":  s.BaseURL + "/watch/" + url.PathEscape(auth.Name),
+	})}
+	if s.templates.Has(locale.AnnounceLive) {
+		data.Channel = s.templateChannel(ctx, auth.Name)
+	}
+	return s.postWebhook(ctx, s.announcement(locale.AnnounceLive, data))
+}
+
+// announcement builds a webhook message from the operator's template, or just
+// the text if there isn't one
+func (s *Server) announcement(name string, data locale.TemplateData) []byte {
+	if blob := s.templates.Execute(name, data); blob != nil {
+		return blob
+	}
+	blob, _ := json.Marshal(webhookMessage{Content: data.Message})
+	return blob
+}
+
+func (s *Server) postWebhook(ctx context.Context, blob []byte) error {
 	req, err := http.NewRequest("POST", s.webhookURL, bytes.NewReader(blob))
 	if err != nil {
 		return err
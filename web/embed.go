@@ -0,0 +1,145 @@
+package web
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const hlsjsURL = "https://cdn.jsdelivr.net/npm/hls.js@1.5.15/dist/hls.min.js"
+
+var embedPage = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; color: #ccc; font-family: sans-serif; }
+video { width: 100%; height: 100%; display: block; }
+.msg { position: absolute; inset: 0; display: flex; align-items: center; justify-content: center; text-align: center; padding: 1em; }
+a { color: #fff; }
+</style>
+</head>
+<body>
+{{if .Restricted}}
+<div class="msg"><a href="{{.WatchURL}}" target="_blank" rel="noopener">Watch {{.Name}} on {{.Site}}</a></div>
+{{else}}
+<video id="v" controls playsinline{{if .Autoplay}} autoplay{{end}}{{if .Muted}} muted{{end}} poster="{{.Thumb}}"></video>
+<div class="msg" id="offline" hidden><a href="{{.WatchURL}}" target="_blank" rel="noopener">{{.Name}} is offline</a></div>
+<script src="{{.HLSJS}}"></script>
+<script>
+(function() {
+  var src = {{.HLSURL}};
+  var video = document.getElementById("v");
+  var offline = document.getElementById("offline");
+  function start() {
+    offline.hidden = true;
+    if (window.Hls && Hls.isSupported()) {
+      var hls = new Hls({ liveDurationInfinity: true });
+      hls.on(Hls.Events.ERROR, function(ev, data) {
+        if (!data.fatal) return;
+        hls.destroy();
+        offline.hidden = false;
+        setTimeout(start, 10000);
+      });
+      hls.loadSource(src);
+      hls.attachMedia(video);
+    } else {
+      video.src = src;
+      video.onerror = function() {
+        offline.hidden = false;
+        setTimeout(function() { video.load(); offline.hidden = true; }, 10000);
+      };
+    }
+  }
+  start();
+})();
+</script>
+{{end}}
+</body>
+</html>
+`))
+
+type embedInfo struct {
+	Name, Title, Site string
+	WatchURL, Thumb   string
+	HLSURL, HLSJS     string
+	Autoplay, Muted   bool
+	Restricted        bool
+}
+
+// queryFlag reads a 1/0 or true/false query parameter
+func queryFlag(req *http.Request, name string, def bool) bool {
+	switch strings.ToLower(req.URL.Query().Get(name)) {
+	case "1", "true", "yes":
+		return true
+	case "0", "false", "no":
+		return false
+	}
+	return def
+}
+
+// viewEmbed serves a bare player page for embedding a channel on other sites.
+// Channels that need a pass, membership or content acknowledgment link to
+// the watch page instead, since third-party frames don't get our cookies.
+func (s *Server) viewEmbed(rw http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["channel"]
+	info, err := model.GetChannelInfo(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting channel %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rules, err := model.ChannelAccess(req.Context(), name)
+	if err != nil {
+		log.Printf("error: checking access to %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.populateChannel(info)
+	title := info.Title
+	if title == "" {
+		title = channelLabel(info)
+	}
+	base, _ := url.Parse(s.BaseURL)
+	// browsers only allow autoplay with sound after user interaction, so
+	// autoplay implies muted unless asked otherwise
+	autoplay := queryFlag(req, "autoplay", true)
+	data := embedInfo{
+		Name:       channelLabel(info),
+		Title:      title,
+		Site:       base.Host,
+		WatchURL:   s.BaseURL + "/watch/" + url.PathEscape(name),
+		Thumb:      info.Thumb,
+		HLSURL:     "/hls/" + url.PathEscape(name) + "/index.m3u8",
+		HLSJS:      hlsjsURL,
+		Autoplay:   autoplay,
+		Muted:      queryFlag(req, "muted", autoplay),
+		Restricted: rules.Gated() || rules.Rating != model.RatingGeneral,
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; style-src 'unsafe-inline'; media-src 'self' blob:; img-src 'self'; frame-ancestors "+s.frameAncestors())
+	if err := embedPage.Execute(rw, data); err != nil {
+		log.Printf("error: rendering embed of %q: %s", name, err)
+	}
+}
+
+// frameAncestors returns the CSP source list of sites that may embed the
+// player
+func (s *Server) frameAncestors() string {
+	if len(s.EmbedAncestors) == 0 {
+		return "*"
+	}
+	return strings.Join(s.EmbedAncestors, " ")
+}
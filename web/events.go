@@ -1,10 +1,12 @@
 package web
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
 	"eaglesong.dev/gunk/sinks/grabber"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
@@ -23,14 +25,42 @@ func (s *Server) PublishEvent(auth model.ChannelAuth, live bool, thumb grabber.R
 			Name: auth.Name,
 			Live: live,
 			Last: thumb.Time.UnixNano() / 1000000,
+
+			ThumbUpdated: thumb.Time.UnixNano() / 1000000,
+		}
+		if !thumb.Preview.IsZero() {
+			ch.PreviewUpdated = thumb.Preview.UnixNano() / 1000000
 		}
 		s.populateChannel(ch)
 		s.ws.Broadcast(channelWS(ch))
 	}
+	if live && !thumb.Time.IsZero() {
+		s.checkNSFW(auth.Name)
+	}
+}
+
+// sessionEnded sends the streamer a summary of the session that just ended,
+// which also stays on the channel's session list
+func (s *Server) sessionEnded(auth model.ChannelAuth, sess *model.StreamSession) {
+	s.Notify.Publish(notify.Notification{
+		UserID:  auth.UserID,
+		Kind:    notify.StreamSummary,
+		Channel: auth.Name,
+		Key:     "stream_summary",
+		Data: map[string]any{
+			"Channel":        auth.Name,
+			"Duration":       formatUptime(time.Duration(sess.Duration) * time.Second),
+			"PeakViewers":    sess.PeakViewers,
+			"AverageViewers": sess.AverageViewers,
+			"UniqueViewers":  sess.UniqueViewers,
+			"PeakBitrate":    sess.PeakBitrate / 1000,
+			"DroppedPackets": sess.DroppedPackets,
+		},
+	})
 }
 
 func (s *Server) onWebsocket(conn *websocket.Conn) error {
-	channels, err := s.listChannels()
+	channels, err := s.listChannels(context.Background())
 	if err != nil {
 		return errors.Wrap(err, "listing channels")
 	}
@@ -0,0 +1,160 @@
+package web
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+)
+
+const feedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Author  string     `xml:"author>name"`
+	Links   []atomLink `xml:"link"`
+	Content atomText   `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func feedTime(ms int64) string {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
+
+// viewFeed lists live channels and the most recent broadcast of the rest as
+// an Atom feed. Entries are keyed by session start so that readers show each
+// broadcast anew.
+func (s *Server) viewFeed(rw http.ResponseWriter, req *http.Request) {
+	infos, err := s.listChannels(req.Context())
+	if err != nil {
+		log.Printf("error: listing channels: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	sessions, err := model.LastSessions(req.Context())
+	if err != nil {
+		log.Printf("error: listing sessions: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	var shown []*model.ChannelInfo
+	for _, info := range infos {
+		if !info.Private && sessions[info.Name] != nil {
+			shown = append(shown, info)
+		}
+	}
+	// live channels first, then by when they were last on
+	sort.SliceStable(shown, func(i, j int) bool {
+		if shown[i].Live != shown[j].Live {
+			return shown[i].Live
+		}
+		return shown[i].Last > shown[j].Last
+	})
+	if len(shown) > feedEntries {
+		shown = shown[:feedEntries]
+	}
+	feed := atomFeed{
+		ID:    s.BaseURL + "/feed.xml",
+		Title: "gunk",
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: s.BaseURL + "/feed.xml"},
+			{Rel: "alternate", Type: "text/html", Href: s.BaseURL + "/"},
+		},
+		Updated: feedTime(0),
+	}
+	var updated int64
+	for _, info := range shown {
+		if info.Last > updated {
+			updated = info.Last
+		}
+		feed.Entries = append(feed.Entries, s.feedEntry(info, sessions[info.Name]))
+	}
+	if updated > 0 {
+		feed.Updated = feedTime(updated)
+	}
+	blob, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("error: encoding feed: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	rw.Write([]byte(xml.Header))
+	rw.Write(blob)
+}
+
+func (s *Server) feedEntry(info *model.ChannelInfo, sess *model.StreamSession) atomEntry {
+	watchURL := s.BaseURL + "/watch/" + url.PathEscape(info.Name)
+	label := channelLabel(info)
+	title := info.Title
+	if title == "" {
+		title = label
+	}
+	updated := sess.Ended
+	if info.Live {
+		title = fmt.Sprintf("%s is live: %s", label, title)
+		updated = sess.Started
+	} else {
+		title = fmt.Sprintf("%s was live: %s", label, title)
+	}
+	if updated == 0 {
+		updated = info.Last
+	}
+	entry := atomEntry{
+		ID:      fmt.Sprintf("%s#%d", watchURL, sess.Started),
+		Title:   title,
+		Updated: feedTime(updated),
+		Author:  label,
+		Links:   []atomLink{{Rel: "alternate", Type: "text/html", Href: watchURL}},
+		Content: atomText{Type: "html"},
+	}
+	var body string
+	if info.ThumbUpdated > 0 {
+		thumb := s.BaseURL + info.Thumb
+		entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Type: "image/" + thumbType(s.Channels.Thumbs.Ext()), Href: thumb})
+		body += fmt.Sprintf(`<p><a href="%s"><img src="%s" alt=""></a></p>`, html.EscapeString(watchURL), html.EscapeString(thumb))
+	}
+	if info.Description != "" {
+		body += "<p>" + html.EscapeString(info.Description) + "</p>"
+	}
+	if !info.Live {
+		body += fmt.Sprintf("<p>Streamed for %s</p>", time.Duration(sess.Duration)*time.Second)
+	}
+	entry.Content.Body = body
+	return entry
+}
+
+func thumbType(ext string) string {
+	if ext == "jpg" {
+		return "jpeg"
+	}
+	return ext
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// ingest option statuses
+const (
+	ingestAvailable    = "available"
+	ingestUnconfigured = "unconfigured" // supported, but not set up for this channel
+	ingestUnsupported  = "unsupported"  // this server doesn't accept the protocol
+)
+
+type ingestOption struct {
+	Protocol    string `json:"protocol"`
+	Status      string `json:"status"`
+	Recommended bool   `json:"recommended,omitempty"`
+	Server      string `json:"server,omitempty"`
+	StreamKey   string `json:"stream_key,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Note        string `json:"note,omitempty"`
+	// Codecs the server will accept over the protocol
+	Codecs   []string         `json:"codecs,omitempty"`
+	Settings *encoderSettings `json:"settings,omitempty"`
+}
+
+// encoderSettings are what WebRTC and HLS playback work best with
+type encoderSettings struct {
+	VideoCodec       string `json:"video_codec"`
+	AudioCodec       string `json:"audio_codec"`
+	KeyframeInterval int    `json:"keyframe_interval"`
+	BFrames          int    `json:"b_frames"`
+	MaxBitrate       int    `json:"max_bitrate,omitempty"`
+}
+
+// viewIngestOptions lists every way of publishing to a channel and whether
+// it can be used
+func (s *Server) viewIngestOptions(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	key, ftlID, err := model.GetChannelKey(req.Context(), userID, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting key of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.keyRevealed(req, userID, name)
+	def := &model.ChannelDef{Name: name, Key: key}
+	def.SetURL(s.AdvertiseRTMP)
+	opts := []ingestOption{
+		{
+			Protocol:    "rtmp",
+			Status:      ingestAvailable,
+			Recommended: true,
+			Server:      def.RTMPDir,
+			StreamKey:   def.RTMPBase,
+			URL:         s.AdvertiseRTMP + "/" + key,
+			Note:        "HEVC and AV1 from enhanced RTMP are not accepted yet",
+			Codecs:      s.Channels.AcceptedCodecs("rtmp"),
+			Settings:    &encoderSettings{VideoCodec: "h264", AudioCodec: "aac", KeyframeInterval: 1, MaxBitrate: s.Channels.Limits.MaxBitrate},
+		},
+		{Protocol: "rtmps", Status: ingestUnsupported},
+		{Protocol: "srt", Status: ingestUnsupported},
+		{Protocol: "whip", Status: ingestUnsupported},
+	}
+	ftl := ingestOption{
+		Protocol: "ftl",
+		Status:   ingestUnconfigured,
+		Note:     "ask the operator to assign an FTL channel ID",
+	}
+	if ftlID != "" {
+		ftl.Status = ingestAvailable
+		ftl.Note = "lowest latency, but video only as FTL audio is not received yet"
+		if u, err := url.Parse(s.AdvertiseRTMP); err == nil {
+			ftl.Server = u.Hostname()
+		}
+		ftl.StreamKey = ftlID + "-" + key
+		ftl.Codecs = s.Channels.AcceptedCodecs("ftl")
+		ftl.Settings = &encoderSettings{VideoCodec: "h264", AudioCodec: "opus", KeyframeInterval: 1, MaxBitrate: s.Channels.Limits.MaxBitrate}
+	}
+	opts = append(opts, ftl)
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, opts)
+}
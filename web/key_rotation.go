@@ -0,0 +1,103 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	minKeyRotationDays  = 7
+	maxKeyRotationDays  = 365
+	maxKeyGraceHours    = 30 * 24
+	keyRotationNotice   = 7 * 24 * time.Hour
+	keyRotationInterval = 10 * time.Minute
+)
+
+func (s *Server) viewKeyRotation(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	kr, err := model.GetKeyRotation(req.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting key rotation for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, kr)
+}
+
+func (s *Server) viewKeyRotationUpdate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var kr model.KeyRotation
+	if !parseRequest(rw, req, &kr) {
+		return
+	}
+	if kr.Days != 0 && (kr.Days < minKeyRotationDays || kr.Days > maxKeyRotationDays) {
+		http.Error(rw, fmt.Sprintf("days must be 0 to disable rotation, or between %d and %d", minKeyRotationDays, maxKeyRotationDays), 400)
+		return
+	} else if kr.GraceHours < 0 || kr.GraceHours > maxKeyGraceHours {
+		http.Error(rw, fmt.Sprintf("grace_hours must be between 0 and %d", maxKeyGraceHours), 400)
+		return
+	}
+	if err := model.SetKeyRotation(req.Context(), userID, kr); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: setting key rotation for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+// RotateKeys periodically warns owners of upcoming key rotations and then
+// replaces their channels' keys when the time comes
+func (s *Server) RotateKeys() {
+	for range time.NewTicker(keyRotationInterval).C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		notices, err := model.ClaimKeyRotationNotices(ctx, keyRotationNotice)
+		if err != nil {
+			log.Printf("error: scheduling key rotations: %s", err)
+		}
+		for _, ev := range notices {
+			s.Notify.Publish(notify.Notification{
+				UserID:  ev.UserID,
+				Kind:    notify.KeyRotationScheduled,
+				Channel: ev.Channel,
+				Key:     "key_rotation_scheduled",
+				Data:    map[string]any{"Channel": ev.Channel, "At": ev.At.UTC()},
+			})
+		}
+		rotated, err := model.RotateDueKeys(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("error: rotating stream keys: %s", err)
+			continue
+		}
+		for _, ev := range rotated {
+			log.Printf("rotated stream key of %s", ev.Channel)
+			s.Notify.Publish(notify.Notification{
+				UserID:  ev.UserID,
+				Kind:    notify.KeyRotated,
+				Channel: ev.Channel,
+				Key:     "key_rotated",
+				Data:    map[string]any{"Channel": ev.Channel, "GraceUntil": ev.GraceUntil.UTC()},
+			})
+		}
+	}
+}
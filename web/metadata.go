@@ -0,0 +1,80 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxMetadataText   = 1000
+	maxMetadataFields = 16
+)
+
+type metadataRequest struct {
+	Title string            `json:"title"`
+	URL   string            `json:"url"`
+	Data  map[string]string `json:"data"`
+}
+
+func (mr *metadataRequest) validate() error {
+	if mr.Title == "" && mr.URL == "" && len(mr.Data) == 0 {
+		return errors.New("metadata is empty")
+	}
+	if len(mr.Data) > maxMetadataFields {
+		return fmt.Errorf("data is limited to %d fields", maxMetadataFields)
+	}
+	values := []string{mr.Title, mr.URL}
+	for k, v := range mr.Data {
+		if k == "" {
+			return errors.New("data keys can't be empty")
+		}
+		values = append(values, k, v)
+	}
+	for _, v := range values {
+		if len(v) > maxMetadataText || !utf8.ValidString(v) {
+			return fmt.Errorf("metadata values must be valid text of at most %d bytes", maxMetadataText)
+		}
+	}
+	return nil
+}
+
+// viewMetadata adds timed metadata to a live channel's stream for players to
+// pick up, such as the song playing
+func (s *Server) viewMetadata(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["channel"]
+	owner, err := model.GetChannelOwner(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && owner != userID) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting owner of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	var mr metadataRequest
+	if !parseRequest(rw, req, &mr) {
+		return
+	}
+	if err := mr.validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	md := ingest.Metadata{Title: mr.Title, URL: mr.URL, Data: mr.Data}
+	if err := s.Channels.InsertMetadata(name, md); errors.Is(err, ingest.ErrNotPublishing) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(rw, nil)
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+const qrSize = 320
+
+// mobileSetup holds links that configure a mobile encoder to stream to a
+// channel
+type mobileSetup struct {
+	// URL is the full publish URL with the key in the path, which apps such
+	// as IRL Pro accept as a single field
+	URL string `json:"url"`
+	// Larix is a Larix Broadcaster deep link that adds the connection
+	Larix string `json:"larix"`
+}
+
+func (s *Server) mobileSetup(rw http.ResponseWriter, req *http.Request) *mobileSetup {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return nil
+	}
+	name := mux.Vars(req)["name"]
+	key, _, err := model.GetChannelKey(req.Context(), userID, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return nil
+	} else if err != nil {
+		log.Printf("error: getting key of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return nil
+	}
+	s.keyRevealed(req, userID, name)
+	publish := s.AdvertiseRTMP + "/" + key
+	// Larix expects PHP-style array parameters, so build the query by hand to
+	// keep the brackets unescaped
+	larix := "larix://set/v1?conn[][url]=" + url.QueryEscape(publish) +
+		"&conn[][name]=" + url.QueryEscape(name) +
+		"&conn[][overwrite]=on"
+	return &mobileSetup{URL: publish, Larix: larix}
+}
+
+// viewMobile returns the links for setting up a mobile encoder
+func (s *Server) viewMobile(rw http.ResponseWriter, req *http.Request) {
+	setup := s.mobileSetup(rw, req)
+	if setup == nil {
+		return
+	}
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, setup)
+}
+
+// viewMobileQR renders one of the setup links as a QR code to scan with the
+// phone. ?app=larix selects the deep link, otherwise it is the plain URL.
+func (s *Server) viewMobileQR(rw http.ResponseWriter, req *http.Request) {
+	setup := s.mobileSetup(rw, req)
+	if setup == nil {
+		return
+	}
+	content := setup.URL
+	if req.URL.Query().Get("app") == "larix" {
+		content = setup.Larix
+	}
+	png, err := qrcode.Encode(content, qrcode.Medium, qrSize)
+	if err != nil {
+		log.Printf("error: encoding QR code: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Content-Type", "image/png")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Write(png)
+}
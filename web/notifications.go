@@ -0,0 +1,231 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"eaglesong.dev/gunk/locale"
+	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxNotifications = 50
+	// inboxKeepalive is how often an idle event stream gets a comment so that
+	// proxies don't time it out
+	inboxKeepalive = 30 * time.Second
+)
+
+// inboxEvent is pushed to a user's open event streams when a notification
+// arrives or the unread count changes
+type inboxEvent struct {
+	Notification *model.Notification `json:"notification,omitempty"`
+	Unread       int                 `json:"unread"`
+}
+
+// inboxListeners tracks the open event streams of each user
+type inboxListeners struct {
+	mu    sync.Mutex
+	users map[string]map[chan inboxEvent]struct{}
+}
+
+func (l *inboxListeners) listen(userID string) chan inboxEvent {
+	ch := make(chan inboxEvent, 10)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.users == nil {
+		l.users = make(map[string]map[chan inboxEvent]struct{})
+	}
+	if l.users[userID] == nil {
+		l.users[userID] = make(map[chan inboxEvent]struct{})
+	}
+	l.users[userID][ch] = struct{}{}
+	return ch
+}
+
+func (l *inboxListeners) stop(userID string, ch chan inboxEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users[userID], ch)
+	if len(l.users[userID]) == 0 {
+		delete(l.users, userID)
+	}
+}
+
+func (l *inboxListeners) push(userID string, ev inboxEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.users[userID] {
+		select {
+		case ch <- ev:
+		default:
+			// a stalled client will catch up on the unread count next time
+		}
+	}
+}
+
+// pushUnread sends the current unread count to a user's event streams
+func (s *Server) pushUnread(ctx context.Context, userID string, n *model.Notification) {
+	unread, err := model.CountUnreadNotifications(ctx, userID)
+	if err != nil {
+		log.Printf("warning: counting notifications for %s: %s", userID, err)
+		return
+	}
+	s.inbox.push(userID, inboxEvent{Notification: n, Unread: unread})
+}
+
+// SetMessages replaces the built-in messages with a catalog that includes the
+// operator's overrides
+func (s *Server) SetMessages(c *locale.Catalog) {
+	s.messages = c
+}
+
+// renderNotification writes a notification in the user's language, laid out
+// by the operator's templates if there are any
+func (s *Server) renderNotification(lang string, n *notify.Notification) {
+	n.Message = s.messages.Render(lang, n.Key, n.Data)
+	n.Subject = s.messages.Render(lang, "email_subject", n)
+	if !s.templates.Has(locale.Webhook) && !s.templates.Has(locale.Email) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	data := locale.TemplateData{Message: n.Message, Subject: n.Subject, Kind: n.Kind}
+	if n.Channel != "" {
+		data.Channel = s.templateChannel(ctx, n.Channel)
+	}
+	n.WebhookBody = s.templates.Execute(locale.Webhook, data)
+	n.EmailBody = string(s.templates.Execute(locale.Email, data))
+}
+
+// inboxSink keeps notifications in the database for the UI to show
+func (s *Server) inboxSink(ctx context.Context, n notify.Notification, p notify.Prefs) error {
+	note, err := model.AddNotification(ctx, n.UserID, n.Kind, n.Channel, n.Message)
+	if err != nil {
+		return err
+	}
+	s.pushUnread(ctx, n.UserID, note)
+	return nil
+}
+
+func (s *Server) viewNotifications(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	notes, err := model.ListNotifications(req.Context(), userID, maxNotifications)
+	if err != nil {
+		log.Printf("error: listing notifications for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, notes)
+}
+
+func (s *Server) viewNotificationsUnread(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	unread, err := model.CountUnreadNotifications(req.Context(), userID)
+	if err != nil {
+		log.Printf("error: counting notifications for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, inboxEvent{Unread: unread})
+}
+
+func (s *Server) viewNotificationsSeen(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	if err := model.MarkNotificationsSeen(req.Context(), userID); err != nil {
+		log.Printf("error: marking notifications seen for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.inbox.push(userID, inboxEvent{})
+	writeJSON(rw, nil)
+}
+
+func (s *Server) viewNotificationSeen(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	err := model.MarkNotificationSeen(req.Context(), userID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: marking notification %d seen for %s: %s", id, userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.pushUnread(req.Context(), userID, nil)
+	writeJSON(rw, nil)
+}
+
+// viewNotificationEvents streams new notifications and unread counts to the
+// UI as server-sent events, starting with the current count
+func (s *Server) viewNotificationEvents(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming not supported", 500)
+		return
+	}
+	ctx := req.Context()
+	ch := s.inbox.listen(userID)
+	defer s.inbox.stop(userID, ch)
+	unread, err := model.CountUnreadNotifications(ctx, userID)
+	if err != nil {
+		log.Printf("error: counting notifications for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	send := func(ev inboxEvent) error {
+		blob, _ := json.Marshal(ev)
+		_, err := fmt.Fprintf(rw, "data: %s\n\n", blob)
+		flusher.Flush()
+		return err
+	}
+	if send(inboxEvent{Unread: unread}) != nil {
+		return
+	}
+	t := time.NewTicker(inboxKeepalive)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-ch:
+			if send(ev) != nil {
+				return
+			}
+		case <-t.C:
+			if _, err := fmt.Fprint(rw, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
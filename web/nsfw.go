@@ -0,0 +1,115 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
+)
+
+const (
+	defaultNSFWThreshold = 0.8
+	defaultNSFWInterval  = 5 * time.Minute
+)
+
+// NSFWHook sends live thumbnails to a classification service and flags
+// channels that score at or above the threshold for moderation. The service
+// receives the image as the request body and must respond with JSON like
+// {"score": 0.93}, where score is the probability the image is explicit.
+type NSFWHook struct {
+	URL       string
+	Threshold float64
+	// Interval is how often each live channel is checked
+	Interval time.Duration
+}
+
+func (s *Server) SetNSFWHook(h NSFWHook) {
+	if h.Threshold <= 0 {
+		h.Threshold = defaultNSFWThreshold
+	}
+	if h.Interval <= 0 {
+		h.Interval = defaultNSFWInterval
+	}
+	s.nsfw = h
+}
+
+// checkNSFW classifies a channel's new thumbnail if it is due for a check
+func (s *Server) checkNSFW(name string) {
+	if s.nsfw.URL == "" {
+		return
+	}
+	now := time.Now()
+	if v, ok := s.nsfwChecked.Load(name); ok && now.Sub(v.(time.Time)) < s.nsfw.Interval {
+		return
+	}
+	s.nsfwChecked.Store(name, now)
+	go func() {
+		if err := s.classifyThumb(name); err != nil {
+			log.Printf("warning: classifying thumbnail of %s: %s", name, err)
+		}
+	}()
+}
+
+func (s *Server) classifyThumb(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rules, err := model.ChannelAccess(ctx, name)
+	if err != nil {
+		return err
+	} else if rules.Rating == model.RatingAdult {
+		// already marked as explicit
+		return nil
+	}
+	thumb, err := model.GetThumb(ctx, name)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.nsfw.URL, bytes.NewReader(thumb))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(thumb))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	blob, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	} else if resp.StatusCode != 200 {
+		return fmt.Errorf("HTTP %s from classifier:\n%s", resp.Status, string(blob))
+	}
+	var result struct {
+		Score float64 `json:"score"`
+	}
+	if err := json.Unmarshal(blob, &result); err != nil {
+		return err
+	}
+	if result.Score < s.nsfw.Threshold {
+		return nil
+	}
+	flagged, err := model.FlagChannel(ctx, name, "nsfw", result.Score, thumb)
+	if err != nil {
+		return err
+	} else if flagged {
+		log.Printf("warning: flagged %s for moderation with NSFW score %.2f", name, result.Score)
+		if userID, err := model.GetChannelOwner(ctx, name); err == nil {
+			s.Notify.Publish(notify.Notification{
+				UserID:  userID,
+				Kind:    notify.ModerationFlagged,
+				Channel: name,
+				Key:     "moderation_flagged",
+				Data:    map[string]any{"Channel": name},
+			})
+		}
+	}
+	return nil
+}
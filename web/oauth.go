@@ -6,8 +6,10 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
 
 	"golang.org/x/oauth2"
 )
@@ -55,6 +57,9 @@ func (s *Server) viewOauthLogin(rw http.ResponseWriter, req *http.Request) {
 	}
 	state := base64.RawURLEncoding.EncodeToString(sb)
 	s.setCookie(rw, stateCookie, state, stateCookieExpires)
+	if next := req.FormValue("next"); localPath(next) {
+		s.setCookie(rw, nextCookie, next, stateCookieExpires)
+	}
 	http.Redirect(rw, req, s.oauth.AuthCodeURL(state), http.StatusFound)
 }
 
@@ -65,22 +70,39 @@ func (s *Server) viewOauthCB(rw http.ResponseWriter, req *http.Request) {
 	}
 	token, err := s.tokenExchange(rw, req)
 	if err != nil {
-		log.Printf("[oauth] error: %s: %s", req.RemoteAddr, err)
+		slog.Warn("oauth token exchange failed", "remote_addr", req.RemoteAddr, "err", err)
 		http.Error(rw, "oauth failure", 400)
 		return
 	}
 	user, err := s.lookupUser(req.Context(), token)
 	if err != nil {
-		log.Printf("[oauth] error: %s: %s", req.RemoteAddr, err)
+		slog.Error("getting user info from discord", "remote_addr", req.RemoteAddr, "err", err)
 		http.Error(rw, "error getting user info from discord", 400)
 		return
 	}
+	if s.Channels.Banned(user.ID, net.ParseIP(s.clientIP(req))) {
+		slog.Warn("banned user tried to log in", "user_id", user.ID, "remote_addr", s.clientIP(req))
+		http.Error(rw, "you are banned from this site", http.StatusForbidden)
+		return
+	}
 	if err := s.setCookie(rw, loginCookie, user, loginCookieExpires); err != nil {
-		log.Printf("[oauth] error: persisting login: %s", err)
+		slog.Error("persisting login", "user_id", user.ID, "err", err)
 		http.Error(rw, "error setting login cookie", 500)
 		return
 	}
-	http.Redirect(rw, req, "/", http.StatusFound)
+	var next string
+	s.unseal(req, nextCookie, &next)
+	s.setCookie(rw, nextCookie, nil, -1)
+	if !localPath(next) {
+		next = "/"
+	}
+	http.Redirect(rw, req, next, http.StatusFound)
+}
+
+// localPath returns true if p is a path on this site, so that it is safe to
+// send the user to after they log in
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
 }
 
 func (s *Server) tokenExchange(rw http.ResponseWriter, req *http.Request) (*oauth2.Token, error) {
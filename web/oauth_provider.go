@@ -0,0 +1,285 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// consentExpires is how long the user has to make up their mind
+	consentExpires = 15 * time.Minute
+	oauthCodeTTL   = 10 * time.Minute
+)
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width,initial-scale=1">
+<title>Authorize {{.App}}</title>
+<style>
+body { max-width: 32em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; background: #222; color: #ddd; }
+li { margin: .5em 0; }
+button { font-size: 1em; padding: .4em 1.2em; margin-right: .5em; }
+.host { color: #999; }
+</style>
+</head>
+<body>
+{{if .Error}}
+<h2>Can't authorize this app</h2>
+<p>{{.Error}}</p>
+{{else}}
+<h2>{{.App}} wants to access your {{.Site}} account</h2>
+<p>If you allow it, it will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<p class="host">You will be sent back to {{.RedirectHost}}. You can revoke access at any time by deleting its token.</p>
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="consent" value="{{.Consent}}">
+<button name="decision" value="allow">Allow</button>
+<button name="decision" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type consentVars struct {
+	Error        string
+	App          string
+	Site         string
+	Scopes       []string
+	RedirectHost string
+	Consent      string
+}
+
+// oauthConsent is carried through the consent form sealed, so that the grant
+// can't be altered and only the user it was shown to can approve it
+type oauthConsent struct {
+	model.OAuthGrant
+	State   string `json:"state"`
+	Expires int64  `json:"expires"`
+}
+
+func (s *Server) renderConsent(rw http.ResponseWriter, status int, v consentVars) {
+	u, _ := url.Parse(s.BaseURL)
+	v.Site = u.Hostname()
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	rw.Header().Set("X-Frame-Options", "DENY")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	if err := consentPage.Execute(rw, v); err != nil {
+		log.Printf("error: rendering consent page: %s", err)
+	}
+}
+
+// redirectGrant sends the user back to the app with the outcome
+func redirectGrant(rw http.ResponseWriter, req *http.Request, redirectURI, state string, values url.Values) {
+	u, _ := url.Parse(redirectURI)
+	q := u.Query()
+	for k, v := range values {
+		q[k] = v
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(rw, req, u.String(), http.StatusFound)
+}
+
+func grantError(code, description string) url.Values {
+	return url.Values{"error": {code}, "error_description": {description}}
+}
+
+// viewAuthorize shows an app's request for access. Problems with the client or
+// redirect URI are shown to the user, since the app can't be trusted to
+// receive them; anything else goes back to the app.
+func (s *Server) viewAuthorize(rw http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	client, err := model.GetOAuthClient(req.Context(), q.Get("client_id"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.renderConsent(rw, 400, consentVars{Error: "The app is not registered with this site."})
+		return
+	} else if err != nil {
+		log.Printf("error: getting OAuth client: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		s.renderConsent(rw, 400, consentVars{Error: "The app asked to send you somewhere it is not registered for."})
+		return
+	}
+	state := q.Get("state")
+	if q.Get("response_type") != "code" {
+		redirectGrant(rw, req, redirectURI, state, grantError("unsupported_response_type", "only the code flow is supported"))
+		return
+	}
+	scopes := strings.Fields(q.Get("scope"))
+	if len(scopes) == 0 {
+		redirectGrant(rw, req, redirectURI, state, grantError("invalid_scope", "at least one scope is required"))
+		return
+	}
+	var descriptions []string
+	for _, scope := range scopes {
+		if !hasScope(Scopes, scope) {
+			redirectGrant(rw, req, redirectURI, state, grantError("invalid_scope", "unknown scope "+scope))
+			return
+		}
+		descriptions = append(descriptions, scopeDescriptions[scope])
+	}
+	challenge := q.Get("code_challenge")
+	if challenge != "" && (q.Get("code_challenge_method") != "S256" || len(challenge) != 43) {
+		redirectGrant(rw, req, redirectURI, state, grantError("invalid_request", "code_challenge must be S256"))
+		return
+	} else if challenge == "" && client.Public() {
+		redirectGrant(rw, req, redirectURI, state, grantError("invalid_request", "code_challenge is required"))
+		return
+	}
+	userID := s.loggedInUser(req)
+	if userID == "" {
+		http.Redirect(rw, req, "/oauth2/initiate?next="+url.QueryEscape(req.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	consent, err := s.seal(oauthConsent{
+		OAuthGrant: model.OAuthGrant{
+			ClientID:      client.ClientID,
+			UserID:        userID,
+			Scopes:        scopes,
+			RedirectURI:   redirectURI,
+			CodeChallenge: challenge,
+		},
+		State:   state,
+		Expires: time.Now().Add(consentExpires).Unix(),
+	})
+	if err != nil {
+		log.Printf("error: sealing consent: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	redirectHost := redirectURI
+	if u, err := url.Parse(redirectURI); err == nil && u.Host != "" {
+		redirectHost = u.Host
+	}
+	s.renderConsent(rw, 200, consentVars{
+		App:          client.Name,
+		Scopes:       descriptions,
+		RedirectHost: redirectHost,
+		Consent:      consent,
+	})
+}
+
+func (s *Server) viewAuthorizeDecide(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var c oauthConsent
+	if err := s.open(req.PostFormValue("consent"), &c); err != nil || c.UserID != userID || time.Now().Unix() > c.Expires {
+		s.renderConsent(rw, 400, consentVars{Error: "The request has expired. Go back to the app and try again."})
+		return
+	}
+	if req.PostFormValue("decision") != "allow" {
+		redirectGrant(rw, req, c.RedirectURI, c.State, grantError("access_denied", "the user denied access"))
+		return
+	}
+	code, err := model.CreateOAuthCode(req.Context(), c.OAuthGrant, oauthCodeTTL)
+	if err != nil {
+		log.Printf("error: creating OAuth code for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	redirectGrant(rw, req, c.RedirectURI, c.State, url.Values{"code": {code}})
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+}
+
+func writeOAuthError(rw http.ResponseWriter, status int, code, description string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// viewOauthToken redeems an authorization code for an API token. Apps with a
+// secret authenticate with it, public apps with the PKCE verifier.
+func (s *Server) viewOauthToken(rw http.ResponseWriter, req *http.Request) {
+	if req.PostFormValue("grant_type") != "authorization_code" {
+		writeOAuthError(rw, 400, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	clientID, secret, basic := req.BasicAuth()
+	if !basic {
+		clientID, secret = req.PostFormValue("client_id"), req.PostFormValue("client_secret")
+	}
+	client, err := model.GetOAuthClient(req.Context(), clientID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeOAuthError(rw, 401, "invalid_client", "unknown client")
+		return
+	} else if err != nil {
+		log.Printf("error: getting OAuth client: %s", err)
+		http.Error(rw, "", 500)
+		return
+	} else if !client.Public() && !client.CheckSecret(secret) {
+		writeOAuthError(rw, 401, "invalid_client", "wrong client secret")
+		return
+	}
+	grant, err := model.RedeemOAuthCode(req.Context(), req.PostFormValue("code"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeOAuthError(rw, 400, "invalid_grant", "code is invalid, expired or already used")
+		return
+	} else if err != nil {
+		log.Printf("error: redeeming OAuth code: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	if grant.ClientID != client.ClientID {
+		writeOAuthError(rw, 400, "invalid_grant", "code was issued to another client")
+		return
+	} else if ru := req.PostFormValue("redirect_uri"); ru != "" && ru != grant.RedirectURI {
+		writeOAuthError(rw, 400, "invalid_grant", "redirect_uri does not match")
+		return
+	} else if grant.CodeChallenge != "" && !checkVerifier(req.PostFormValue("code_verifier"), grant.CodeChallenge) {
+		writeOAuthError(rw, 400, "invalid_grant", "code_verifier does not match")
+		return
+	}
+	token, err := model.IssueClientToken(req.Context(), client, grant)
+	if err != nil {
+		log.Printf("error: issuing token to %s for %s: %s", client.ClientID, grant.UserID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, oauthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		Scope:       strings.Join(grant.Scopes, " "),
+	})
+}
+
+func checkVerifier(verifier, challenge string) bool {
+	d := sha256.Sum256([]byte(verifier))
+	return hmac.Equal([]byte(base64.RawURLEncoding.EncodeToString(d[:])), []byte(challenge))
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultEmbedWidth = 1280
+	minEmbedWidth     = 200
+)
+
+type oembedResponse struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	ProviderName    string `json:"provider_name"`
+	ProviderURL     string `json:"provider_url"`
+	Title           string `json:"title"`
+	AuthorName      string `json:"author_name"`
+	AuthorURL       string `json:"author_url"`
+	HTML            string `json:"html"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	ThumbnailURL    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+}
+
+// watchChannel returns the channel name from a watch page URL on this site
+func (s *Server) watchChannel(v string) string {
+	u, err := url.Parse(v)
+	if err != nil {
+		return ""
+	}
+	base, _ := url.Parse(s.BaseURL)
+	if u.Host != "" && !strings.EqualFold(u.Host, base.Host) {
+		return ""
+	}
+	name := strings.TrimPrefix(u.EscapedPath(), "/watch/")
+	if name == u.EscapedPath() || name == "" || strings.Contains(name, "/") {
+		return ""
+	}
+	name, err = url.PathUnescape(name)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// embedSize fits a 16:9 player within the consumer's limits
+func embedSize(req *http.Request) (width, height int) {
+	width = defaultEmbedWidth
+	if v, _ := strconv.Atoi(req.FormValue("maxwidth")); v > 0 && v < width {
+		width = v
+	}
+	if v, _ := strconv.Atoi(req.FormValue("maxheight")); v > 0 && v*16/9 < width {
+		width = v * 16 / 9
+	}
+	if width < minEmbedWidth {
+		width = minEmbedWidth
+	}
+	return width, width * 9 / 16
+}
+
+// viewOEmbed describes a watch page so that chat apps and social sites can
+// embed the player when a link is posted
+func (s *Server) viewOEmbed(rw http.ResponseWriter, req *http.Request) {
+	if f := req.FormValue("format"); f != "" && f != "json" {
+		http.Error(rw, "only json is supported", http.StatusNotImplemented)
+		return
+	}
+	name := s.watchChannel(req.FormValue("url"))
+	if name == "" {
+		http.NotFound(rw, req)
+		return
+	}
+	info, err := model.GetChannelInfo(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting channel %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	if info.Private {
+		http.Error(rw, "channel is private", http.StatusUnauthorized)
+		return
+	}
+	s.populateChannel(info)
+	watchURL := s.BaseURL + "/watch/" + url.PathEscape(name)
+	width, height := embedSize(req)
+	title := info.Title
+	if title == "" {
+		title = channelLabel(info)
+	}
+	embedURL := s.BaseURL + "/embed/" + url.PathEscape(name)
+	resp := oembedResponse{
+		Version:      "1.0",
+		Type:         "video",
+		ProviderName: "gunk",
+		ProviderURL:  s.BaseURL,
+		Title:        title,
+		AuthorName:   channelLabel(info),
+		AuthorURL:    watchURL,
+		HTML:         fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="autoplay; fullscreen" allowfullscreen></iframe>`, html.EscapeString(embedURL), width, height),
+		Width:        width,
+		Height:       height,
+	}
+	if info.ThumbUpdated > 0 {
+		resp.ThumbnailURL = s.BaseURL + info.Thumb
+		resp.ThumbnailWidth = s.Channels.Thumbs.ThumbWidth()
+		resp.ThumbnailHeight = resp.ThumbnailWidth * 9 / 16
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, resp)
+}
+
+// oembedDiscovery adds a link to the oEmbed endpoint to watch pages
+func (s *Server) oembedDiscovery(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		watchURL := s.BaseURL + "/watch/" + url.PathEscape(mux.Vars(req)["channel"])
+		endpoint := s.BaseURL + "/oembed?" + url.Values{"url": {watchURL}, "format": {"json"}}.Encode()
+		rw.Header().Add("Link", fmt.Sprintf(`<%s>; rel="alternate"; type="application/json+oembed"`, endpoint))
+		// buffer the page so the link can also go in its head, which is where
+		// most consumers look for it
+		req.Header.Del("Accept-Encoding")
+		buf := &bufferedResponse{header: rw.Header()}
+		h.ServeHTTP(buf, req)
+		body := buf.body.Bytes()
+		if strings.HasPrefix(rw.Header().Get("Content-Type"), "text/html") {
+			tag := fmt.Sprintf(`<link rel="alternate" type="application/json+oembed" href="%s">`, html.EscapeString(endpoint))
+			if !wantsStatic(req) {
+				// browsers with scripts turned off get the plain page
+				tag += `<noscript><meta http-equiv="refresh" content="0; url=?nojs=1"></noscript>`
+			}
+			body = bytes.Replace(body, []byte("</head>"), []byte(tag+"</head>"), 1)
+			rw.Header().Del("Content-Length")
+		}
+		if buf.status != 0 {
+			rw.WriteHeader(buf.status)
+		}
+		rw.Write(body)
+	})
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
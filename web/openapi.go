@@ -0,0 +1,51 @@
+package web
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openapiSpec describes the HTTP API. Update it along with the routes in
+// Handler.
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+func viewOpenAPI(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	rw.Write(openapiSpec)
+}
+
+const swaggerVersion = "5.17.14"
+
+// the X-Requested-With header lets "try it out" requests pass checkCSRF
+const swaggerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gunk API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerVersion + `/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@` + swaggerVersion + `/swagger-ui-bundle.js"></script>
+<script>
+SwaggerUIBundle({
+  url: "/api/openapi.json",
+  dom_id: "#swagger-ui",
+  requestInterceptor: function(req) {
+    req.headers["X-Requested-With"] = "XMLHttpRequest";
+    return req;
+  },
+});
+</script>
+</body>
+</html>
+`
+
+// viewAPIDocs serves Swagger UI for browsing the API
+func viewAPIDocs(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write([]byte(swaggerPage))
+}
@@ -0,0 +1,387 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	maxParties          = 1000
+	maxPartyMembers     = 50
+	maxPartyMemberName  = 32
+	partyIdleExpiry     = 10 * time.Minute
+	partyExpiryInterval = time.Minute
+	// partyLead is how far past now a position may be, to allow for members'
+	// clocks being off
+	partyLead = time.Minute
+)
+
+// partyState is the shared playback clock. While playing, the position
+// advances in step with the server's clock from the time it was set.
+type partyState struct {
+	Paused bool `json:"paused"`
+	// Position is the EXT-X-PROGRAM-DATE-TIME being watched in Unix ms, or 0
+	// to follow the live edge
+	Position int64 `json:"position"`
+	// Updated is when the state was set, in server Unix ms
+	Updated int64 `json:"updated"`
+	// By is the ID of the member who set it
+	By string `json:"by,omitempty"`
+}
+
+// at returns the position the party has reached by now
+func (st partyState) at(now int64) int64 {
+	if st.Paused || st.Position == 0 {
+		return st.Position
+	}
+	return st.Position + now - st.Updated
+}
+
+type partyMember struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	send chan partyMsg
+}
+
+type partyInfo struct {
+	ID      string         `json:"id"`
+	Channel string         `json:"channel"`
+	State   partyState     `json:"state"`
+	Members []*partyMember `json:"members"`
+}
+
+// partyMsg is sent both ways over a party's websocket. Clients send ping,
+// play, pause, seek and live; the server sends hello, state, members, pong and
+// error.
+type partyMsg struct {
+	Type string `json:"type"`
+	// Member is the ID the server gave this connection, in hello
+	Member     string         `json:"member,omitempty"`
+	Party      *partyInfo     `json:"party,omitempty"`
+	State      *partyState    `json:"state,omitempty"`
+	Members    []*partyMember `json:"members,omitempty"`
+	Position   int64          `json:"position,omitempty"`
+	ClientTime int64          `json:"client_time,omitempty"`
+	ServerTime int64          `json:"server_time,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// watchParty is a group of viewers watching a channel in lockstep. Parties
+// live in memory and go away once they have been empty for a while.
+type watchParty struct {
+	id      string
+	channel string
+
+	mu         sync.Mutex
+	state      partyState
+	members    map[string]*partyMember
+	emptySince time.Time
+}
+
+type watchParties struct {
+	mu      sync.Mutex
+	parties map[string]*watchParty
+}
+
+func (wp *watchParties) get(id string) *watchParty {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return wp.parties[id]
+}
+
+// create starts a party for a channel, or returns nil if there are too many
+func (wp *watchParties) create(channel string) *watchParty {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if len(wp.parties) >= maxParties {
+		return nil
+	}
+	if wp.parties == nil {
+		wp.parties = make(map[string]*watchParty)
+	}
+	p := &watchParty{
+		id:         partyID(),
+		channel:    channel,
+		state:      partyState{Updated: unixMilli(time.Now())},
+		members:    make(map[string]*partyMember),
+		emptySince: time.Now(),
+	}
+	wp.parties[p.id] = p
+	return p
+}
+
+// expire removes parties that have been empty for too long
+func (wp *watchParties) expire() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for id, p := range wp.parties {
+		p.mu.Lock()
+		idle := len(p.members) == 0 && time.Since(p.emptySince) > partyIdleExpiry
+		p.mu.Unlock()
+		if idle {
+			delete(wp.parties, id)
+		}
+	}
+}
+
+func partyID() string {
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / 1000000
+}
+
+func (p *watchParty) info() *partyInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &partyInfo{ID: p.id, Channel: p.channel, State: p.state, Members: p.memberList()}
+}
+
+// memberList returns the members in order of ID. The caller holds p.mu.
+func (p *watchParty) memberList() []*partyMember {
+	members := make([]*partyMember, 0, len(p.members))
+	for _, m := range p.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// broadcast queues a message for every member. The caller holds p.mu.
+func (p *watchParty) broadcast(msg partyMsg) {
+	for id, m := range p.members {
+		select {
+		case m.send <- msg:
+		default:
+			// on overflow force the client to reconnect
+			delete(p.members, id)
+			close(m.send)
+		}
+	}
+}
+
+func (p *watchParty) join(name string) (*partyMember, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.members) >= maxPartyMembers {
+		return nil, false
+	}
+	m := &partyMember{ID: partyID()[:8], Name: name, send: make(chan partyMsg, 16)}
+	p.members[m.ID] = m
+	m.send <- partyMsg{
+		Type:       "hello",
+		Member:     m.ID,
+		Party:      &partyInfo{ID: p.id, Channel: p.channel, State: p.state, Members: p.memberList()},
+		ServerTime: unixMilli(time.Now()),
+	}
+	p.broadcast(partyMsg{Type: "members", Members: p.memberList()})
+	return m, true
+}
+
+func (p *watchParty) leave(m *partyMember) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.members[m.ID] != m {
+		return
+	}
+	delete(p.members, m.ID)
+	if len(p.members) == 0 {
+		p.emptySince = time.Now()
+	}
+	p.broadcast(partyMsg{Type: "members", Members: p.memberList()})
+}
+
+// control changes the playback clock on behalf of a member
+func (p *watchParty) control(m *partyMember, msg partyMsg) error {
+	now := unixMilli(time.Now())
+	if msg.Position < 0 || msg.Position > now+partyLead.Milliseconds() {
+		return errors.New("position is out of range")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.state
+	pos := msg.Position
+	if pos == 0 {
+		pos = st.at(now)
+	}
+	switch msg.Type {
+	case "play":
+		st.Paused, st.Position = false, pos
+	case "pause":
+		if pos == 0 {
+			// pausing the live edge needs a moment to hold on to
+			return errors.New("position is required to pause at the live edge")
+		}
+		st.Paused, st.Position = true, pos
+	case "seek":
+		if msg.Position == 0 {
+			return errors.New("position is required")
+		}
+		st.Position = msg.Position
+	case "live":
+		st.Paused, st.Position = false, 0
+	default:
+		return errors.New("unknown message type")
+	}
+	st.Updated, st.By = now, m.ID
+	p.state = st
+	p.broadcast(partyMsg{Type: "state", State: &st, ServerTime: now})
+	return nil
+}
+
+// ExpireParties periodically removes watch parties that nobody is in
+func (s *Server) ExpireParties() {
+	for range time.NewTicker(partyExpiryInterval).C {
+		s.parties.expire()
+	}
+}
+
+type partyRequest struct {
+	Channel string `json:"channel"`
+}
+
+// viewPartiesCreate starts a watch party for a channel
+func (s *Server) viewPartiesCreate(rw http.ResponseWriter, req *http.Request) {
+	var pr partyRequest
+	if !parseRequest(rw, req, &pr) {
+		return
+	}
+	if _, err := model.GetChannelOwner(req.Context(), pr.Channel); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting owner of %q: %s", pr.Channel, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	if !s.checkAccess(rw, req, pr.Channel) {
+		return
+	}
+	p := s.parties.create(pr.Channel)
+	if p == nil {
+		rw.Header().Set("Retry-After", "60")
+		http.Error(rw, "too many watch parties", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(rw, p.info())
+}
+
+func (s *Server) viewParty(rw http.ResponseWriter, req *http.Request) {
+	p := s.parties.get(mux.Vars(req)["id"])
+	if p == nil {
+		http.NotFound(rw, req)
+		return
+	}
+	writeJSON(rw, p.info())
+}
+
+// viewPartyWS joins a watch party. Members get the playback clock and the
+// member list as they change, and can change the clock themselves.
+func (s *Server) viewPartyWS(rw http.ResponseWriter, req *http.Request) {
+	p := s.parties.get(mux.Vars(req)["id"])
+	if p == nil {
+		http.NotFound(rw, req)
+		return
+	}
+	if !s.checkAccess(rw, req, p.channel) {
+		return
+	}
+	name := strings.TrimSpace(req.FormValue("name"))
+	if utf8.RuneCountInString(name) > maxPartyMemberName || !utf8.ValidString(name) {
+		http.Error(rw, "name is too long", http.StatusBadRequest)
+		return
+	} else if name == "" {
+		name = "Guest"
+	}
+	conn, err := wsu.Upgrade(rw, req, nil)
+	if err != nil {
+		log.Println("error: websocket upgrade:", err)
+		return
+	}
+	m, ok := p.join(name)
+	if !ok {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "party is full"), time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+	defer p.leave(m)
+	eg, ctx := errgroup.WithContext(req.Context())
+	eg.Go(func() error { return p.readLoop(ctx, conn, m) })
+	eg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg, ok := <-m.send:
+				if !ok {
+					return io.EOF
+				}
+				if err := conn.WriteJSON(msg); err != nil {
+					return err
+				}
+			}
+		}
+	})
+	eg.Go(func() error {
+		<-ctx.Done()
+		conn.Close()
+		return nil
+	})
+	if err := eg.Wait(); err != nil && err != io.EOF {
+		log.Printf("error: watch party websocket %s: %s", conn.RemoteAddr(), err)
+	}
+}
+
+// readLoop handles a member's messages until they disconnect
+func (p *watchParty) readLoop(ctx context.Context, conn *websocket.Conn, m *partyMember) error {
+	conn.SetReadLimit(4096)
+	for ctx.Err() == nil {
+		var msg partyMsg
+		if err := conn.ReadJSON(&msg); err != nil {
+			if _, ok := err.(*websocket.CloseError); ok || ctx.Err() != nil {
+				return io.EOF
+			}
+			return err
+		}
+		var reply *partyMsg
+		if msg.Type == "ping" {
+			// lets the client work out how far its clock is from the server's
+			reply = &partyMsg{Type: "pong", ClientTime: msg.ClientTime, ServerTime: unixMilli(time.Now())}
+		} else if err := p.control(m, msg); err != nil {
+			reply = &partyMsg{Type: "error", Error: err.Error()}
+		}
+		if reply != nil {
+			p.mu.Lock()
+			if p.members[m.ID] == m {
+				select {
+				case m.send <- *reply:
+				default:
+				}
+			}
+			p.mu.Unlock()
+		}
+	}
+	return io.EOF
+}
@@ -0,0 +1,239 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	patreonStateCookie = "pstate"
+	// how long cached memberships are trusted before being checked again
+	patreonRecheck = 6 * time.Hour
+)
+
+var patreonEndpoint = oauth2.Endpoint{
+	AuthURL:   "https://www.patreon.com/oauth2/authorize",
+	TokenURL:  "https://www.patreon.com/api/oauth2/token",
+	AuthStyle: oauth2.AuthStyleInParams,
+}
+
+func (s *Server) SetPatreon(clientID, clientSecret string) {
+	s.patreon = oauth2.Config{
+		RedirectURL:  s.BaseURL + "/oauth2/patreon/cb",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint:     patreonEndpoint,
+		Scopes:       []string{"identity", "identity.memberships"},
+	}
+}
+
+type patreonIdentity struct {
+	Included []struct {
+		Type       string `json:"type"`
+		Attributes struct {
+			PatronStatus  string `json:"patron_status"`
+			EntitledCents int    `json:"currently_entitled_amount_cents"`
+		} `json:"attributes"`
+		Relationships struct {
+			Campaign struct {
+				Data struct {
+					ID string `json:"id"`
+				} `json:"data"`
+			} `json:"campaign"`
+		} `json:"relationships"`
+	} `json:"included"`
+}
+
+// syncPatreon fetches the viewer's current pledges and caches them
+func (s *Server) syncPatreon(ctx context.Context, userID string, token *oauth2.Token) error {
+	tsrc := s.patreon.TokenSource(ctx, token)
+	cli := oauth2.NewClient(ctx, tsrc)
+	var ident patreonIdentity
+	if err := httpGet(ctx, cli, "https://www.patreon.com/api/oauth2/v2/identity?include=memberships.campaign&fields%5Bmember%5D=patron_status,currently_entitled_amount_cents", &ident); err != nil {
+		return err
+	}
+	var memberships []model.PatreonMembership
+	for _, inc := range ident.Included {
+		if inc.Type != "member" || inc.Relationships.Campaign.Data.ID == "" {
+			continue
+		}
+		memberships = append(memberships, model.PatreonMembership{
+			CampaignID:    inc.Relationships.Campaign.Data.ID,
+			PatronStatus:  inc.Attributes.PatronStatus,
+			EntitledCents: inc.Attributes.EntitledCents,
+		})
+	}
+	newToken, err := tsrc.Token()
+	if err != nil {
+		return err
+	}
+	return model.SetPatreonLink(ctx, userID, newToken, memberships)
+}
+
+// refreshPatreon re-checks a viewer's pledges in the background if the cached
+// copy is stale
+func (s *Server) refreshPatreon(userID string) {
+	if _, busy := s.patreonBusy.LoadOrStore(userID, true); busy {
+		return
+	}
+	go func() {
+		defer s.patreonBusy.Delete(userID)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		token, checked, err := model.GetPatreonLink(ctx, userID)
+		if errors.Is(err, pgx.ErrNoRows) || time.Since(checked) < patreonRecheck {
+			return
+		} else if err != nil {
+			log.Printf("error: refreshing patreon memberships for %s: %s", userID, err)
+			return
+		}
+		if err := s.syncPatreon(ctx, userID, token); err != nil {
+			log.Printf("error: refreshing patreon memberships for %s: %s", userID, err)
+		}
+	}()
+}
+
+func (s *Server) checkMembership(ctx context.Context, chname, userID string, rules model.AccessRules) bool {
+	ok, err := model.HasMembership(ctx, chname, userID, rules)
+	if err != nil {
+		log.Printf("error: checking membership of %s in %q: %s", userID, chname, err)
+		return false
+	}
+	if s.patreon.ClientID != "" && rules.PatreonCampaign != "" {
+		s.refreshPatreon(userID)
+	}
+	return ok
+}
+
+func (s *Server) viewPatreonLogin(rw http.ResponseWriter, req *http.Request) {
+	if s.patreon.ClientID == "" {
+		http.Error(rw, "patreon not configured", 400)
+		return
+	}
+	if s.checkAuth(rw, req) == "" {
+		return
+	}
+	sb := make([]byte, 9)
+	if _, err := io.ReadFull(rand.Reader, sb); err != nil {
+		panic(err)
+	}
+	state := base64.RawURLEncoding.EncodeToString(sb)
+	s.setCookie(rw, patreonStateCookie, state, stateCookieExpires)
+	http.Redirect(rw, req, s.patreon.AuthCodeURL(state), http.StatusFound)
+}
+
+func (s *Server) viewPatreonCB(rw http.ResponseWriter, req *http.Request) {
+	if s.patreon.ClientID == "" {
+		http.Error(rw, "patreon not configured", 400)
+		return
+	}
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var state string
+	err := s.unseal(req, patreonStateCookie, &state)
+	s.setCookie(rw, patreonStateCookie, nil, -1)
+	if err != nil || req.FormValue("code") == "" || !hmac.Equal([]byte(state), []byte(req.FormValue("state"))) {
+		log.Printf("[patreon] error: %s: state mismatch", req.RemoteAddr)
+		http.Error(rw, "oauth failure", 400)
+		return
+	}
+	token, err := s.patreon.Exchange(req.Context(), req.FormValue("code"))
+	if err != nil {
+		log.Printf("[patreon] error: %s: %s", req.RemoteAddr, err)
+		http.Error(rw, "oauth failure", 400)
+		return
+	}
+	if err := s.syncPatreon(req.Context(), userID, token); err != nil {
+		log.Printf("[patreon] error: %s: %s", req.RemoteAddr, err)
+		http.Error(rw, "error getting memberships from patreon", 400)
+		return
+	}
+	http.Redirect(rw, req, "/", http.StatusFound)
+}
+
+func (s *Server) viewPatreonUnlink(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	if err := model.DeletePatreonLink(req.Context(), userID); err != nil {
+		log.Printf("error: unlinking patreon for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+func (s *Server) viewMembers(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	members, err := model.ListMembershipOverrides(req.Context(), userID, name)
+	if err != nil {
+		log.Printf("error: listing members of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, members)
+}
+
+type memberRequest struct {
+	UserID string `json:"user_id"`
+}
+
+func (s *Server) viewMembersAdd(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var mr memberRequest
+	if !parseRequest(rw, req, &mr) {
+		return
+	} else if mr.UserID == "" {
+		http.Error(rw, "user_id is required", 400)
+		return
+	}
+	name := mux.Vars(req)["name"]
+	if err := model.AddMembershipOverride(req.Context(), userID, name, mr.UserID); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: adding member to %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+func (s *Server) viewMembersDelete(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	if err := model.DeleteMembershipOverride(req.Context(), userID, name, mux.Vars(req)["user"]); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: removing member from %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
@@ -3,6 +3,7 @@ package web
 import (
 	"log"
 	"net/http"
+	"strings"
 
 	"eaglesong.dev/gunk/ingest"
 	"github.com/gorilla/mux"
@@ -10,7 +11,16 @@ import (
 
 func (s *Server) viewPlayHLS(rw http.ResponseWriter, req *http.Request) {
 	chname := mux.Vars(req)["channel"]
-	err := s.Channels.ServeHLS(rw, req, chname)
+	// sessions start with the playlist, so only playlist polls count against
+	// the viewer cap, but everything else needs access too
+	if strings.HasSuffix(mux.Vars(req)["filename"], ".m3u8") {
+		if !s.checkAccess(rw, req, chname) {
+			return
+		}
+	} else if _, _, ok := s.checkRules(rw, req, chname); !ok {
+		return
+	}
+	err := s.Channels.ServeHLS(rw, req, chname, s.clientIP(req))
 	if err == ingest.ErrNoChannel {
 		http.NotFound(rw, req)
 	} else if err != nil {
@@ -18,11 +28,18 @@ func (s *Server) viewPlayHLS(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (s *Server) viewPlayTS(rw http.ResponseWriter, req *http.Request) {
+// viewPlayStream serves any of the single-response formats registered with
+// the ingest package, such as MPEG-TS
+func (s *Server) viewPlayStream(rw http.ResponseWriter, req *http.Request) {
 	chname := mux.Vars(req)["channel"]
-	err := s.Channels.ServeTS(rw, req, chname)
-	if err == ingest.ErrNoChannel {
+	if !s.checkAccess(rw, req, chname) {
+		return
+	}
+	err := s.Channels.ServeStream(rw, req, chname, mux.Vars(req)["ext"], s.clientIP(req))
+	if err == ingest.ErrNoChannel || err == ingest.ErrNoFormat {
 		http.NotFound(rw, req)
+	} else if err == ingest.ErrNoAAC {
+		http.Error(rw, "this channel's audio isn't available on its own", http.StatusNotFound)
 	} else if err != nil {
 		log.Println("error:", err)
 	}
@@ -30,11 +47,14 @@ func (s *Server) viewPlayTS(rw http.ResponseWriter, req *http.Request) {
 
 func (s *Server) viewPlaySDP(rw http.ResponseWriter, req *http.Request) {
 	chname := mux.Vars(req)["channel"]
-	err := s.Channels.ServeSDP(rw, req, chname)
+	if !s.checkAccess(rw, req, chname) {
+		return
+	}
+	err := s.Channels.ServeSDP(rw, req, chname, s.clientIP(req))
 	if err == ingest.ErrNoChannel {
 		http.NotFound(rw, req)
 	} else if err != nil {
-		log.Printf("error: failed to start webrtc session to %s: %s", req.RemoteAddr, err)
+		log.Printf("error: failed to start webrtc session to %s: %s", s.clientIP(req), err)
 		http.Error(rw, "failed to start webrtc session", 500)
 	}
 }
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// playback option statuses
+const (
+	playbackAvailable   = "available"
+	playbackUnavailable = "unavailable" // supported, but not for this stream right now
+	playbackUnsupported = "unsupported" // this server doesn't offer the format
+)
+
+// rough latency classes, from lowest to highest
+const (
+	latencyRealtime = "realtime" // under a second
+	latencyLow      = "low"      // a few seconds
+	latencyStandard = "standard" // several segments behind
+)
+
+type playbackOption struct {
+	Format   string   `json:"format"`
+	Status   string   `json:"status"`
+	URL      string   `json:"url,omitempty"`
+	Method   string   `json:"method,omitempty"`
+	MIMEType string   `json:"mime_type,omitempty"`
+	Codecs   []string `json:"codecs,omitempty"`
+	Latency  string   `json:"latency,omitempty"`
+	Note     string   `json:"note,omitempty"`
+}
+
+// viewPlayback lists every way of watching a channel so that players don't
+// have to know our paths
+func (s *Server) viewPlayback(rw http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["channel"]
+	info, err := model.GetChannelInfo(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting channel %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.populateChannel(info)
+	s.Channels.PopulateLive([]*model.ChannelInfo{info})
+	esc := url.PathEscape(name)
+	liveURL := info.LiveURL
+	if u, err := url.Parse(liveURL); err == nil && u.Host == "" {
+		liveURL = s.BaseURL + liveURL
+	}
+	// live streams are offered whether or not the channel is on air, so that
+	// players can wait for it
+	hlsOpt := playbackOption{
+		Format:   "hls",
+		Status:   playbackAvailable,
+		URL:      s.BaseURL + "/hls/" + esc + "/" + ingest.MasterPlaylist,
+		MIMEType: "application/vnd.apple.mpegurl",
+		Codecs:   info.Codecs,
+		Latency:  latencyStandard,
+	}
+	tsOpt := playbackOption{
+		Format:   "ts",
+		Status:   playbackAvailable,
+		URL:      liveURL,
+		MIMEType: "video/mp2t",
+		Codecs:   info.Codecs,
+		Latency:  latencyLow,
+	}
+	rtcCodecs := opusCodecs(info.Codecs)
+	rtcOpt := playbackOption{
+		Format:   "webrtc",
+		Status:   playbackAvailable,
+		URL:      s.BaseURL + "/sdp/" + esc,
+		Method:   "POST",
+		MIMEType: "application/sdp",
+		Codecs:   rtcCodecs,
+		Latency:  latencyRealtime,
+		Note:     "POST an SDP offer to get the answer",
+	}
+	if info.Live && !info.RTC {
+		rtcOpt.Status = playbackUnavailable
+		rtcOpt.Note = "the stream has B-frames, which WebRTC can't play"
+	}
+	aacOpt := playbackOption{
+		Format:   "aac",
+		Status:   playbackAvailable,
+		URL:      s.BaseURL + "/live/" + esc + ".aac",
+		MIMEType: "audio/aac",
+		Codecs:   []string{"aac"},
+		Latency:  latencyLow,
+		Note:     "audio only",
+	}
+	if info.Live && !slices.Contains(info.Codecs, "aac") {
+		aacOpt.Status = playbackUnavailable
+		aacOpt.Codecs = nil
+		aacOpt.Note = "the stream's audio isn't AAC"
+	}
+	rtspOpt := playbackOption{
+		Format:  "rtsp",
+		Status:  playbackUnavailable,
+		Note:    "not offered for private or rated channels",
+		Latency: latencyRealtime,
+	}
+	if info.RTSPURL != "" {
+		rtspOpt.Status = playbackAvailable
+		rtspOpt.URL = info.RTSPURL
+		rtspOpt.Codecs = rtcCodecs
+		rtspOpt.Note = ""
+	} else if s.AdvertiseRTSP == "" {
+		rtspOpt = playbackOption{Format: "rtsp", Status: playbackUnsupported}
+	}
+	opts := []playbackOption{
+		hlsOpt,
+		tsOpt,
+		rtcOpt,
+		aacOpt,
+		rtspOpt,
+		{Format: "ll-hls", Status: playbackUnsupported},
+		{Format: "dash", Status: playbackUnsupported},
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, opts)
+}
+
+// opusCodecs lists the codecs WebRTC and RTSP viewers get, whose audio is
+// always Opus
+func opusCodecs(codecs []string) []string {
+	var ret []string
+	for _, codec := range codecs {
+		if codec == "aac" {
+			codec = "opus"
+		}
+		ret = append(ret, codec)
+	}
+	return ret
+}
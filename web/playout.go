@@ -0,0 +1,103 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxPlayoutItems  = 200
+	maxPlayoutTitle  = 200
+	maxPlayoutSource = 1024
+)
+
+type playoutSchedule struct {
+	Titles  []string              `json:"titles"`
+	Playing *ingest.PlayoutStatus `json:"playing"`
+}
+
+// viewPlayoutSchedule shows a channel's playlist titles and what is on air
+func (s *Server) viewPlayoutSchedule(rw http.ResponseWriter, req *http.Request) {
+	chname := mux.Vars(req)["channel"]
+	items, err := model.ListPlayoutItems(req.Context(), chname)
+	if err != nil {
+		log.Printf("error: listing playlist of %q: %s", chname, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	sched := playoutSchedule{
+		Titles:  make([]string, len(items)),
+		Playing: s.Channels.PlayoutStatus(chname),
+	}
+	for i, item := range items {
+		sched.Titles[i] = item.Title
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, sched)
+}
+
+func (s *Server) viewPlayout(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	p, err := model.GetPlayout(req.Context(), userID, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting playlist of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, p)
+}
+
+func (s *Server) viewPlayoutUpdate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var p model.Playout
+	if !parseRequest(rw, req, &p) {
+		return
+	}
+	if len(p.Items) > maxPlayoutItems {
+		http.Error(rw, fmt.Sprintf("playlist is limited to %d items", maxPlayoutItems), 400)
+		return
+	}
+	for _, item := range p.Items {
+		if item == nil {
+			http.Error(rw, "invalid item", 400)
+			return
+		} else if len(item.Title) > maxPlayoutTitle {
+			http.Error(rw, fmt.Sprintf("title is limited to %d bytes", maxPlayoutTitle), 400)
+			return
+		} else if len(item.Source) > maxPlayoutSource {
+			http.Error(rw, fmt.Sprintf("source is limited to %d bytes", maxPlayoutSource), 400)
+			return
+		} else if err := s.Channels.CheckPlayoutSource(item.Source); err != nil {
+			http.Error(rw, err.Error(), 400)
+			return
+		}
+	}
+	name := mux.Vars(req)["name"]
+	if err := model.SetPlayout(req.Context(), userID, name, &p); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: updating playlist of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.Channels.SetPlayout(name, p.Enabled)
+	writeJSON(rw, nil)
+}
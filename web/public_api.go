@@ -0,0 +1,159 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// publicCacheTTL is how long responses to anonymous public API requests are
+// reused. Widgets on other sites poll these, so a few seconds saves a lot of
+// work without making "live" noticeably late.
+const publicCacheTTL = 5 * time.Second
+
+// maxPublicCacheEntries bounds the cache, which is keyed by URL
+const maxPublicCacheEntries = 1000
+
+// publicChannelAPI lists the per-channel endpoints under /api/channels/{channel}
+// that anonymous clients may read
+var publicChannelAPI = []string{"", "/status", "/playback", "/sessions", "/playout", "/badge.svg"}
+
+// publicAPI returns true for the read-only endpoints open to anonymous
+// clients: the channel list, channel status and playback discovery
+func publicAPI(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	switch p := req.URL.Path; p {
+	case "/channels.json", "/api/instance", "/api/schedule":
+		return true
+	default:
+		rest, ok := strings.CutPrefix(p, "/api/channels/")
+		if !ok || rest == "" {
+			return false
+		}
+		name, sub, _ := strings.Cut(rest, "/")
+		if name == "" {
+			return false
+		}
+		if sub != "" {
+			sub = "/" + sub
+		}
+		for _, v := range publicChannelAPI {
+			if sub == v {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// anonymous returns true for requests with neither a login nor an API token
+func (s *Server) anonymous(req *http.Request) bool {
+	if req.Header.Get("Authorization") != "" {
+		return false
+	}
+	_, err := req.Cookie(s.cookieName(loginCookie))
+	return err != nil
+}
+
+type cachedResponse struct {
+	expires time.Time
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+type publicCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+func (c *publicCache) get(key string, now time.Time) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r := c.entries[key]; r != nil && now.Before(r.expires) {
+		return r
+	}
+	return nil
+}
+
+func (c *publicCache) put(key string, r *cachedResponse, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*cachedResponse)
+	}
+	if len(c.entries) >= maxPublicCacheEntries {
+		for k, v := range c.entries {
+			if !now.Before(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxPublicCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = r
+}
+
+// responseRecorder keeps a response so that it can be cached
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+
+// cachePublic serves anonymous requests to the public API from a short-lived
+// cache, and lets browsers and other sites cache them for as long
+func (s *Server) cachePublic(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !publicAPI(req) || !s.anonymous(req) {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		now := time.Now()
+		key := req.Method + " " + req.URL.RequestURI()
+		cached := s.publicCache.get(key, now)
+		if cached == nil {
+			rec := &responseRecorder{header: rw.Header().Clone()}
+			h.ServeHTTP(rec, req)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			cached = &cachedResponse{expires: now.Add(publicCacheTTL), status: rec.status, header: rec.header, body: rec.body.Bytes()}
+			// the default set by middleware means the handler had no
+			// opinion
+			if v := cached.header.Get("Cache-Control"); v == "" || v == rw.Header().Get("Cache-Control") {
+				cached.header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(publicCacheTTL/time.Second)))
+			}
+			cached.header.Set("Access-Control-Allow-Origin", "*")
+			if cached.status == http.StatusOK {
+				s.publicCache.put(key, cached, now)
+			}
+		}
+		for k, v := range cached.header {
+			rw.Header()[k] = v
+		}
+		rw.WriteHeader(cached.status)
+		if req.Method != http.MethodHead {
+			rw.Write(cached.body)
+		}
+	})
+}
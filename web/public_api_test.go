@@ -0,0 +1,39 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPublicAPI checks which requests anonymous clients can make
+func TestPublicAPI(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		public       bool
+	}{
+		{"GET", "/channels.json", true},
+		{"HEAD", "/api/instance", true},
+		{"GET", "/api/schedule", true},
+		{"GET", "/api/channels/alice", true},
+		{"GET", "/api/channels/alice/status", true},
+		{"GET", "/api/channels/alice/playback", true},
+		{"GET", "/api/channels/alice/sessions", true},
+		{"GET", "/api/channels/alice/playout", true},
+		{"GET", "/api/channels/alice/badge.svg", true},
+		{"POST", "/api/channels/alice/status", false},
+		{"DELETE", "/api/channels/alice/live", false},
+		{"GET", "/api/channels/", false},
+		{"GET", "/api/channels//status", false},
+		{"GET", "/api/channels/alice/usage", false},
+		{"GET", "/api/channels/alice/analytics", false},
+		{"GET", "/api/channels/alice/status/extra", false},
+		{"GET", "/api/mychannels", false},
+		{"GET", "/api/notifications", false},
+		{"GET", "/api/instance/extra", false},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if got := publicAPI(req); got != tc.public {
+			t.Errorf("%s %s: got %t, want %t", tc.method, tc.path, got, tc.public)
+		}
+	}
+}
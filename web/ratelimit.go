@@ -0,0 +1,242 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Rate requests per second from each client on average, with
+// bursts of up to Burst. The zero value does not limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+var (
+	DefaultLoginLimit = RateLimit{Rate: 0.2, Burst: 10}
+	DefaultAPILimit   = RateLimit{Rate: 5, Burst: 50}
+	// DefaultPlaybackLimit covers HLS segments as well as playlists, so it
+	// leaves room for a few viewers sharing an address
+	DefaultPlaybackLimit = RateLimit{Rate: 20, Burst: 100}
+	DefaultSessionLimit  = RateLimit{Rate: 4, Burst: 20}
+	// DefaultAnonymousLimit is stricter than the API limit, as anonymous
+	// clients only need the public endpoints and get cached responses
+	DefaultAnonymousLimit = RateLimit{Rate: 1, Burst: 20}
+)
+
+const bucketSweepInterval = time.Minute
+
+// maxSessionID is the longest sid accepted as a session key, beyond which the
+// address is used instead
+const maxSessionID = 64
+
+// limiter keeps a token bucket per client
+type limiter struct {
+	limit RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// rejected counts refused requests since startup, for metrics
+	rejected int64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) allow(client string, now time.Time) bool {
+	if l.limit.Rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	if now.Sub(l.lastSweep) > bucketSweepInterval {
+		l.sweep(now)
+	}
+	b := l.buckets[client]
+	if b == nil {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		l.rejected++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.limit.Rate
+	if burst := float64(l.limit.Burst); tokens > burst {
+		tokens = burst
+	}
+	return tokens
+}
+
+// sweep forgets clients whose buckets have refilled, since a new bucket would
+// be the same
+func (l *limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *limiter) rejectedCount() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rejected
+}
+
+type rateLimits struct {
+	login, api, playback limiter
+	// session limits each viewer session's playback requests, so that one
+	// misbehaving player doesn't use up the limit of everyone sharing its
+	// address
+	session limiter
+	// anonymous limits requests to the public API without credentials, in
+	// place of the API limit
+	anonymous limiter
+}
+
+// SetRateLimits configures per-client limits for login, API, playback and
+// anonymous public API requests, and per-session limits for playback
+func (s *Server) SetRateLimits(login, api, playback, session, anonymous RateLimit) {
+	s.limits.login.limit = login
+	s.limits.api.limit = api
+	s.limits.playback.limit = playback
+	s.limits.session.limit = session
+	s.limits.anonymous.limit = anonymous
+}
+
+// WriteMetrics writes the number of requests refused by each rate limit in
+// Prometheus text format
+func (s *Server) WriteMetrics(w io.Writer) {
+	fmt.Fprintln(w, "# HELP gunk_rate_limited_requests_total Requests refused by rate limits since startup.")
+	fmt.Fprintln(w, "# TYPE gunk_rate_limited_requests_total counter")
+	for _, l := range []struct {
+		name string
+		l    *limiter
+	}{
+		{"login", &s.limits.login},
+		{"api", &s.limits.api},
+		{"playback", &s.limits.playback},
+		{"session", &s.limits.session},
+		{"anonymous", &s.limits.anonymous},
+	} {
+		fmt.Fprintf(w, "gunk_rate_limited_requests_total{limit=\"%s\"} %d\n", l.name, l.l.rejectedCount())
+	}
+}
+
+// sessionKey identifies the viewer session making a playback request, by the
+// ID the player passes in sid or else by its address and user agent
+func (s *Server) sessionKey(req *http.Request) string {
+	if sid := req.URL.Query().Get("sid"); sid != "" && len(sid) <= maxSessionID {
+		return "s:" + sid
+	}
+	return "h:" + s.clientIP(req) + "\x00" + req.UserAgent()
+}
+
+// SetTrustedProxies sets the addresses allowed to supply the client address in
+// X-Forwarded-For. Entries are IPs or CIDR ranges.
+func (s *Server) SetTrustedProxies(proxies []string) error {
+	s.trustedProxies = nil
+	for _, v := range proxies {
+		if !strings.Contains(v, "/") {
+			if strings.Contains(v, ":") {
+				v += "/128"
+			} else {
+				v += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			return err
+		}
+		s.trustedProxies = append(s.trustedProxies, ipnet)
+	}
+	return nil
+}
+
+func (s *Server) trusted(ip net.IP) bool {
+	for _, ipnet := range s.trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client, taken from X-Forwarded-For if
+// the request came through trusted proxies
+func (s *Server) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.trusted(ip) {
+		return host
+	}
+	// walk back from the nearest hop until one isn't a trusted proxy
+	var hops []string
+	for _, v := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		hopIP := net.ParseIP(hop)
+		if hopIP == nil {
+			break
+		}
+		host = hop
+		if !s.trusted(hopIP) {
+			break
+		}
+	}
+	return host
+}
+
+// rateLimit rejects clients that exceed the limit for the kind of endpoint
+// they are requesting
+func (s *Server) rateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var l, session *limiter
+		switch p := req.URL.Path; {
+		case strings.HasPrefix(p, "/oauth2/"), strings.HasPrefix(p, "/oauth/"):
+			l = &s.limits.login
+		case publicAPI(req) && s.anonymous(req):
+			l = &s.limits.anonymous
+		case strings.HasPrefix(p, "/api/"), p == "/channels.json":
+			l = &s.limits.api
+		case strings.HasPrefix(p, "/hls/"),
+			strings.HasPrefix(p, "/live/"),
+			strings.HasPrefix(p, "/sdp/"):
+			// players choose their own sid, so the address is limited too
+			l = &s.limits.playback
+			session = &s.limits.session
+		}
+		now := time.Now()
+		if l != nil && !l.allow(s.clientIP(req), now) || session != nil && !session.allow(s.sessionKey(req), now) {
+			rw.Header().Set("Retry-After", "1")
+			http.Error(rw, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
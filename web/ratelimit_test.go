@@ -0,0 +1,44 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// TestClientIP checks that X-Forwarded-For is only believed as far back as
+// the hops are trusted proxies
+func TestClientIP(t *testing.T) {
+	s := new(Server)
+	if err := s.SetTrustedProxies([]string{"10.0.0.0/8", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted forwarder", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted ipv6 proxy", "[::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"proxy chain", "10.0.0.2:1234", []string{"198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"spoofed first hop", "10.0.0.2:1234", []string{"192.0.2.9, 198.51.100.1"}, "198.51.100.1"},
+		{"repeated header", "10.0.0.2:1234", []string{"192.0.2.9", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage hop", "10.0.0.2:1234", []string{"nonsense"}, "10.0.0.2"},
+		{"no header", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"all trusted", "10.0.0.2:1234", []string{"10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		for _, v := range tc.forwarded {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if got := s.clientIP(req); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+	if err := s.SetTrustedProxies([]string{"not an address"}); err == nil {
+		t.Error("bad proxy address accepted")
+	}
+}
@@ -0,0 +1,199 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/internal"
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxTargetLabel = 64
+	maxTargetURL   = 1024
+	maxTargetKey   = 1024
+)
+
+type targetInfo struct {
+	*model.RestreamTarget
+	Status *ingest.RestreamStatus `json:"status"`
+}
+
+func (s *Server) viewTargets(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	targets, err := model.ListRestreamTargets(req.Context(), userID, name)
+	if err != nil {
+		log.Printf("error: listing restream targets of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	infos := make([]targetInfo, len(targets))
+	for i, target := range targets {
+		infos[i] = targetInfo{target, s.Channels.RestreamStatus(target.ID)}
+	}
+	writeJSON(rw, infos)
+}
+
+type targetRequest struct {
+	Label   *string `json:"label"`
+	URL     *string `json:"url"`
+	Key     *string `json:"key"`
+	Enabled *bool   `json:"enabled"`
+}
+
+func validateTargetURL(v string) string {
+	if len(v) > maxTargetURL {
+		return fmt.Sprintf("url is limited to %d bytes", maxTargetURL)
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Scheme != "rtmp" || u.Host == "" {
+		return "url must be an rtmp:// URL"
+	}
+	return ""
+}
+
+func (s *Server) viewTargetsCreate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var tr targetRequest
+	if !parseRequest(rw, req, &tr) {
+		return
+	}
+	if tr.URL == nil || tr.Key == nil {
+		http.Error(rw, "url and key are required", 400)
+		return
+	}
+	var label string
+	if tr.Label != nil {
+		label = *tr.Label
+	}
+	if msg := validateTargetURL(*tr.URL); msg != "" {
+		http.Error(rw, msg, 400)
+		return
+	} else if len(label) > maxTargetLabel {
+		http.Error(rw, fmt.Sprintf("label is limited to %d bytes", maxTargetLabel), 400)
+		return
+	} else if *tr.Key == "" || len(*tr.Key) > maxTargetKey {
+		http.Error(rw, fmt.Sprintf("key must be between 1 and %d bytes", maxTargetKey), 400)
+		return
+	}
+	target := &model.RestreamTarget{
+		Label:     label,
+		URL:       *tr.URL,
+		Enabled:   tr.Enabled == nil || *tr.Enabled,
+		SealedKey: internal.Seal(&s.key, []byte(*tr.Key)),
+	}
+	name := mux.Vars(req)["name"]
+	if err := model.CreateRestreamTarget(req.Context(), userID, name, target); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: adding restream target to %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, targetInfo{target, nil})
+}
+
+func (s *Server) viewTargetsUpdate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var tr targetRequest
+	if !parseRequest(rw, req, &tr) {
+		return
+	}
+	var u model.RestreamTargetUpdate
+	if tr.Label != nil {
+		if len(*tr.Label) > maxTargetLabel {
+			http.Error(rw, fmt.Sprintf("label is limited to %d bytes", maxTargetLabel), 400)
+			return
+		}
+		u.Label = tr.Label
+	}
+	if tr.URL != nil {
+		if msg := validateTargetURL(*tr.URL); msg != "" {
+			http.Error(rw, msg, 400)
+			return
+		}
+		u.URL = tr.URL
+	}
+	if tr.Key != nil {
+		if *tr.Key == "" || len(*tr.Key) > maxTargetKey {
+			http.Error(rw, fmt.Sprintf("key must be between 1 and %d bytes", maxTargetKey), 400)
+			return
+		}
+		u.SealedKey = internal.Seal(&s.key, []byte(*tr.Key))
+	}
+	u.Enabled = tr.Enabled
+	name := mux.Vars(req)["name"]
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err := model.UpdateRestreamTarget(req.Context(), userID, name, id, u); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: updating restream target %d of %q: %s", id, name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	if tr.Enabled != nil && !*tr.Enabled {
+		s.Channels.StopRestream(id)
+	}
+	writeJSON(rw, nil)
+}
+
+func (s *Server) viewTargetsDelete(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err := model.DeleteRestreamTarget(req.Context(), userID, name, id); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: deleting restream target %d of %q: %s", id, name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.Channels.StopRestream(id)
+	writeJSON(rw, nil)
+}
+
+func (s *Server) viewTargetsTest(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	target, err := model.GetRestreamTarget(req.Context(), userID, name, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting restream target %d of %q: %s", id, name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	result := map[string]string{}
+	if err := s.Channels.TestRestream(target); err != nil {
+		result["error"] = err.Error()
+	}
+	writeJSON(rw, result)
+}
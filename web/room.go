@@ -0,0 +1,116 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxRooms           = 5
+	maxRoomTTL         = 7 * 24 * time.Hour
+	defaultRoomTTL     = 24 * time.Hour
+	defaultRoomIdle    = 30 * time.Minute
+	roomExpiryInterval = time.Minute
+)
+
+type roomRequest struct {
+	TTLMinutes  int  `json:"ttl_minutes"`
+	IdleMinutes *int `json:"idle_minutes"`
+}
+
+func (s *Server) viewRooms(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	rooms, err := model.ListRooms(req.Context(), userID)
+	if err != nil {
+		log.Printf("error: listing rooms for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	for _, room := range rooms {
+		room.SetURL(s.AdvertiseRTMP)
+	}
+	writeJSON(rw, rooms)
+}
+
+func (s *Server) viewRoomsCreate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var rr roomRequest
+	if !parseRequest(rw, req, &rr) {
+		return
+	}
+	ttl := time.Duration(rr.TTLMinutes) * time.Minute
+	if ttl == 0 {
+		ttl = defaultRoomTTL
+	}
+	idle := defaultRoomIdle
+	if rr.IdleMinutes != nil {
+		// zero keeps the room until it expires
+		idle = time.Duration(*rr.IdleMinutes) * time.Minute
+	}
+	if ttl < 0 || ttl > maxRoomTTL || idle < 0 {
+		http.Error(rw, fmt.Sprintf("ttl_minutes must be at most %d and idle_minutes must not be negative", int(maxRoomTTL/time.Minute)), 400)
+		return
+	}
+	room, err := model.CreateRoom(req.Context(), userID, ttl, idle, maxRooms)
+	if err == model.ErrTooManyRooms {
+		http.Error(rw, fmt.Sprintf("rooms are limited to %d at a time", maxRooms), http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Printf("error: creating room for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	room.SetURL(s.AdvertiseRTMP)
+	writeJSON(rw, room)
+}
+
+func (s *Server) viewRoomsDelete(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	if err := model.DeleteRoom(req.Context(), userID, name); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: deleting room %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	// the key is gone, so the publisher can't come back once kicked
+	if err := s.Channels.Kick(name); err != nil && !errors.Is(err, ingest.ErrNotPublishing) {
+		log.Printf("error: disconnecting deleted room %q: %s", name, err)
+	}
+	writeJSON(rw, nil)
+}
+
+// ExpireRooms periodically deletes rooms that have expired or gone idle
+func (s *Server) ExpireRooms() {
+	for range time.NewTicker(roomExpiryInterval).C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		names, err := model.ExpireRooms(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("error: expiring rooms: %s", err)
+		}
+		for _, name := range names {
+			log.Printf("room %s expired", name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"eaglesong.dev/gunk/locale"
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	maxUpcoming          = 500
+	maxScheduleMinutes   = 24 * 60
+	defaultAnnounceLead  = 15 * time.Minute
+	announceScanInterval = time.Minute
+)
+
+// attachSchedule fills in upcoming streams for each channel in the list
+func attachSchedule(ctx context.Context, infos []*model.ChannelInfo) error {
+	entries, err := model.ListUpcoming(ctx, maxUpcoming)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*model.ChannelInfo, len(infos))
+	for _, info := range infos {
+		byName[info.Name] = info
+	}
+	for _, entry := range entries {
+		if info := byName[entry.Channel]; info != nil {
+			info.Upcoming = append(info.Upcoming, entry)
+		}
+	}
+	return nil
+}
+
+func (s *Server) viewUpcoming(rw http.ResponseWriter, req *http.Request) {
+	entries, err := model.ListUpcoming(req.Context(), maxUpcoming)
+	if err != nil {
+		log.Printf("error: listing scheduled streams: %s", err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rw.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(rw, entries)
+}
+
+func (s *Server) viewScheduleCreate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var entry model.ScheduledStream
+	if !parseRequest(rw, req, &entry) {
+		return
+	}
+	switch {
+	case len(entry.Title) > maxTitle:
+		http.Error(rw, fmt.Sprintf("title is limited to %d bytes", maxTitle), 400)
+		return
+	case entry.Minutes < 1 || entry.Minutes > maxScheduleMinutes:
+		http.Error(rw, fmt.Sprintf("minutes must be between 1 and %d", maxScheduleMinutes), 400)
+		return
+	case time.Unix(0, entry.Start*1000000).Before(time.Now()):
+		http.Error(rw, "start must be in the future", 400)
+		return
+	}
+	name := mux.Vars(req)["name"]
+	if err := model.CreateScheduled(req.Context(), userID, name, &entry); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: scheduling stream on %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, entry)
+}
+
+func (s *Server) viewScheduleDelete(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err := model.DeleteScheduled(req.Context(), userID, name, id); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: deleting scheduled stream on %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+// AnnounceScheduled periodically posts scheduled streams that are about to
+// start to the Discord webhook
+func (s *Server) AnnounceScheduled() {
+	lead := s.AnnounceLead
+	if lead <= 0 {
+		lead = defaultAnnounceLead
+	}
+	for range time.NewTicker(announceScanInterval).C {
+		if s.webhookURL == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		entries, err := model.ClaimAnnouncements(ctx, lead)
+		if err != nil {
+			log.Printf("error: finding scheduled streams to announce: %s", err)
+		}
+		for _, entry := range entries {
+			data := locale.TemplateData{
+				Message: s.messages.Render("", "announce_scheduled", map[string]any{
+					"Channel": entry.Channel,
+					"Start":   entry.Start / 1000,
+					"URL":     s.BaseURL + "/watch/" + url.PathEscape(entry.Channel),
+					"Title":   entry.Title,
+				}),
+				Title: entry.Title,
+				Start: entry.Start / 1000,
+			}
+			if s.templates.Has(locale.AnnounceScheduled) {
+				data.Channel = s.templateChannel(ctx, entry.Channel)
+			}
+			if err := s.postWebhook(ctx, s.announcement(locale.AnnounceScheduled, data)); err != nil {
+				log.Printf("warning: announcing scheduled stream on %s: %s", entry.Channel, err)
+			}
+		}
+		cancel()
+	}
+}
@@ -1,77 +1,85 @@
 package web
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
 
-	"golang.org/x/crypto/nacl/secretbox"
+	"eaglesong.dev/gunk/internal"
 )
 
 const (
 	stateCookie = "ostate"
 	loginCookie = "login"
+	nextCookie  = "onext"
 )
 
 func (s *Server) SetSecret(secret string) {
 	d := sha256.New()
 	d.Write([]byte(secret))
 	copy(s.key[:], d.Sum(nil))
+	s.Channels.SecretKey = s.key
+}
+
+// cookieName returns the name a cookie is actually stored under, which is
+// locked to this host when serving over HTTPS
+func (s *Server) cookieName(name string) string {
+	if s.Secure {
+		return "__Host-" + name
+	}
+	return name
 }
 
 func (s *Server) setCookie(rw http.ResponseWriter, name string, value interface{}, maxAge int) error {
 	var cvalue string
 	if value != nil {
-		blob, err := json.Marshal(value)
-		if err != nil {
+		var err error
+		if cvalue, err = s.seal(value); err != nil {
 			return err
 		}
-		sealed := make([]byte, 24, 24+secretbox.Overhead+len(blob))
-		var nonce [24]byte
-		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
-			panic(err)
-		}
-		copy(sealed, nonce[:])
-		sealed = secretbox.Seal(sealed, blob, &nonce, &s.key)
-		cvalue = base64.RawURLEncoding.EncodeToString(sealed)
 	}
 
 	cookie := &http.Cookie{
-		Name:     name,
+		Name:     s.cookieName(name),
 		Value:    cvalue,
 		MaxAge:   maxAge,
 		Path:     "/",
 		Secure:   s.Secure,
 		HttpOnly: true,
-	}
-	if s.Secure {
-		cookie.Name = "__Host-" + cookie.Name
+		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(rw, cookie)
 	return nil
 }
 
 func (s *Server) unseal(req *http.Request, name string, value interface{}) error {
-	if s.Secure {
-		name = "__Host-" + name
-	}
-	cookie, err := req.Cookie(name)
+	cookie, err := req.Cookie(s.cookieName(name))
 	if err != nil {
 		return err
 	} else if cookie == nil || cookie.Value == "" {
 		return http.ErrNoCookie
 	}
-	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	return s.open(cookie.Value, value)
+}
+
+// seal encodes a value so that it can be handed to the client and trusted when
+// it comes back
+func (s *Server) seal(value interface{}) (string, error) {
+	blob, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(internal.Seal(&s.key, blob)), nil
+}
+
+func (s *Server) open(sealed string, value interface{}) error {
+	envelope, err := base64.RawURLEncoding.DecodeString(sealed)
 	if err != nil {
 		return err
 	}
-	var nonce [24]byte
-	copy(nonce[:], sealed)
-	blob, ok := secretbox.Open(nil, sealed[24:], &nonce, &s.key)
+	blob, ok := internal.Open(&s.key, envelope)
 	if !ok {
 		return errors.New("bad envelope")
 	}
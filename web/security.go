@@ -0,0 +1,228 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// securityNoticeInterval limits how often an owner hears about the same kind
+// of event on a channel
+const securityNoticeInterval = time.Hour
+
+// securityNotice notifies a channel's owner of a security event unless they
+// were told about the same kind of event recently. The message is the one
+// named after the kind, given the channel and remote address.
+func (s *Server) securityNotice(auth model.ChannelAuth, kind, remote string) {
+	k := kind + "/" + auth.Name
+	now := time.Now()
+	if v, ok := s.securityNoticed.Load(k); ok && now.Sub(v.(time.Time)) < securityNoticeInterval {
+		return
+	}
+	s.securityNoticed.Store(k, now)
+	s.Notify.Publish(notify.Notification{
+		UserID:  auth.UserID,
+		Kind:    kind,
+		Channel: auth.Name,
+		Key:     kind,
+		Data:    map[string]any{"Channel": auth.Name, "Remote": remote},
+	})
+}
+
+// AuthFailed is called by the ingest servers when a publisher is rejected
+func (s *Server) AuthFailed(err error, remote string) {
+	var wk *model.WrongKeyError
+	if !errors.As(err, &wk) {
+		return
+	}
+	s.securityNotice(wk.Auth, notify.WrongKey, remote)
+}
+
+// livePublisher logs each use of a channel's key and notifies owners when
+// their channel is published from an address it hasn't been published from
+// before
+func (s *Server) livePublisher(auth model.ChannelAuth, kind, remote string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := model.RecordKeyUse(ctx, auth.Name, remote, kind, auth.PrevKey); err != nil {
+			log.Printf("warning: recording key use of %s: %s", auth.Name, err)
+		}
+		unfamiliar, err := model.RecordPublishAddr(ctx, auth.Name, remote)
+		if err != nil {
+			log.Printf("warning: recording publisher address of %s: %s", auth.Name, err)
+		} else if unfamiliar {
+			s.securityNotice(auth, notify.NewPublishAddr, remote)
+		}
+	}()
+}
+
+// viewKeyUses lists when and where a channel's stream key has been used, so
+// that owners can spot a leaked key
+func (s *Server) viewKeyUses(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	uses, err := model.ListKeyUses(req.Context(), userID, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: listing key uses of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, uses)
+}
+
+// viewKick disconnects a channel's publisher, for when a key has leaked or a
+// stream has to stop now
+func (s *Server) viewKick(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	name := mux.Vars(req)["channel"]
+	owner, err := model.GetChannelOwner(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && owner != userID) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting owner of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	if err := s.Channels.Kick(name); errors.Is(err, ingest.ErrNotPublishing) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.Info("publisher kicked", "channel", name, "user_id", userID, "remote_addr", s.clientIP(req))
+	writeJSON(rw, nil)
+}
+
+// keyRevealed notifies an owner that a channel's stream key was shown
+func (s *Server) keyRevealed(req *http.Request, userID, name string) {
+	auth := model.ChannelAuth{UserID: userID, Name: name}
+	s.securityNotice(auth, notify.KeyRevealed, s.clientIP(req))
+}
+
+const (
+	maxEmail   = 254
+	maxWebhook = 1024
+)
+
+type notifySettings struct {
+	notify.Prefs
+	// Kinds lists everything that can be muted
+	Kinds []string `json:"kinds"`
+	// Languages lists what messages can be written in
+	Languages []string `json:"languages"`
+	// EmailEnabled and DiscordEnabled are true if the instance can deliver
+	// that way
+	EmailEnabled   bool `json:"email_enabled"`
+	DiscordEnabled bool `json:"discord_enabled"`
+}
+
+func (s *Server) viewNotifySettings(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	prefs, err := model.GetNotifyPrefs(req.Context(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting notification settings for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, notifySettings{
+		Prefs:          prefs,
+		Kinds:          notify.Kinds,
+		Languages:      s.messages.Languages(),
+		EmailEnabled:   s.notifyEmail,
+		DiscordEnabled: s.notifyDiscord,
+	})
+}
+
+func (s *Server) validatePrefs(p notify.Prefs) string {
+	for _, m := range p.Muted {
+		known := false
+		for _, kind := range notify.Kinds {
+			if m == kind {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Sprintf("unknown notification kind %q", m)
+		}
+	}
+	if p.Email != "" {
+		if a, err := mail.ParseAddress(p.Email); err != nil || a.Address != p.Email || len(p.Email) > maxEmail {
+			return "invalid email address"
+		}
+	}
+	if p.Webhook != "" && !validWebURL(p.Webhook) {
+		return "webhook must be an http or https URL"
+	}
+	if p.Language != "" && !s.messages.Has(p.Language) {
+		return fmt.Sprintf("unknown language %q", p.Language)
+	}
+	return ""
+}
+
+func (s *Server) viewNotifySettingsUpdate(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	var prefs notify.Prefs
+	if !parseRequest(rw, req, &prefs) {
+		return
+	}
+	if msg := s.validatePrefs(prefs); msg != "" {
+		http.Error(rw, msg, 400)
+		return
+	}
+	if err := model.SetNotifyPrefs(req.Context(), userID, prefs); errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: setting notification settings for %s: %s", userID, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, nil)
+}
+
+// SetEmail enables notifications by email through an SMTP server
+func (s *Server) SetEmail(e *notify.Email) {
+	s.Notify.Subscribe(e)
+	s.notifyEmail = true
+}
+
+// SetDiscordBot enables notifications by Discord direct message
+func (s *Server) SetDiscordBot(token string) {
+	s.Notify.Subscribe(&notify.DiscordDM{Token: token})
+	s.notifyDiscord = true
+}
+
+// banned returns true if a global ban covers the requesting user or address
+func (s *Server) banned(req *http.Request) bool {
+	return s.Channels.Banned(s.sessionUser(req), net.ParseIP(s.clientIP(req)))
+}
@@ -4,36 +4,85 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"eaglesong.dev/gunk/ingest"
+	"eaglesong.dev/gunk/locale"
 	"eaglesong.dev/gunk/model"
+	"eaglesong.dev/gunk/notify"
 	"github.com/gorilla/mux"
 	"golang.org/x/oauth2"
 )
 
 type Server struct {
-	Secure        bool     // set secure cookies
-	BaseURL       string   // base URL
-	AdvertiseRTMP string   // base URL to advertise for RTMP ingest
-	AdvertiseLive *url.URL // base URL to advertise for direct HTTP streams
+	Secure        bool          // set secure cookies
+	BaseURL       string        // base URL
+	UI            string        // local path or URL of the UI
+	AdvertiseRTMP string        // base URL to advertise for RTMP ingest
+	AdvertiseLive *url.URL      // base URL to advertise for direct HTTP streams
+	AdvertiseRTSP string        // base URL to advertise for RTSP playback
+	AnnounceLead  time.Duration // how far ahead to announce scheduled streams
+	APIDocs       bool          // serve Swagger UI at /api/docs
+	// EmbedAncestors lists the sites allowed to frame /embed pages, or is
+	// empty to allow any
+	EmbedAncestors []string
+	// MaxChannels is how many channels each user may create, not counting
+	// rooms, or 0 for no limit. It can be overridden per user.
+	MaxChannels int
+	// DeactivateAfterMonths deactivates users who haven't logged in or gone
+	// live for this long, or is 0 to keep everyone
+	DeactivateAfterMonths int
+	// ReleaseAfterDays is how long deactivated users have to reactivate
+	// before their channels are deleted
+	ReleaseAfterDays int
 
 	key    [32]byte
 	router *mux.Router
 	oauth  oauth2.Config
 	ws     websockets
 
+	patreon     oauth2.Config
+	patreonBusy sync.Map
+
 	webhookURL string
 	checkGuild string
 
+	limits         rateLimits
+	trustedProxies []*net.IPNet
+	publicCache    publicCache
+
+	nsfw        NSFWHook
+	directory   Directory
+	nsfwChecked sync.Map
+
+	securityNoticed sync.Map
+	notifyEmail     bool
+	notifyDiscord   bool
+	inbox           inboxListeners
+	messages        *locale.Catalog
+	templates       *locale.Templates
+	parties         watchParties
+
 	Channels ingest.Manager
+	Notify   notify.Bus
 }
 
 func (s *Server) Initialize() {
 	s.ws.OnNew = s.onWebsocket
+	s.messages = locale.Builtin()
+	s.Notify.Lookup = model.GetNotifyPrefs
+	s.Notify.Render = s.renderNotification
+	s.Notify.Subscribe(notify.SinkFunc(s.inboxSink))
+	s.Notify.Subscribe(notify.Webhook)
 	s.Channels.PublishEvent = s.PublishEvent
 	s.Channels.FTL.CheckUser = model.VerifyFTL
+	s.Channels.FTL.AuthFailed = s.AuthFailed
+	s.Channels.LivePublisher = s.livePublisher
+	s.Channels.SessionEnded = s.sessionEnded
 	s.Channels.FTL.Publish = s.Channels.Publish
 	s.Channels.Initialize()
 }
@@ -43,38 +92,134 @@ func (s *Server) Handler() http.Handler {
 	s.router = r
 	r.HandleFunc("/ws", s.ws.ServeHTTP)
 	// video
-	r.HandleFunc("/live/{channel}.ts", s.viewPlayTS).Methods("GET").Name("live")
+	r.HandleFunc("/live/{channel}.{ext:[a-z0-9]+}", s.viewPlayStream).Methods("GET").Name("live")
 	r.HandleFunc("/hls/{channel}/{filename}", s.viewPlayHLS).Methods("GET")
 	// RTC
 	r.HandleFunc("/sdp/{channel}", s.viewPlaySDP).Methods("POST")
 	// UI
-	uiRoutes(r)
+	s.uiRoutes(r, s.UI)
+	r.HandleFunc("/oembed", s.viewOEmbed).Methods("GET")
+	r.HandleFunc("/embed/{channel}", s.viewEmbed).Methods("GET")
+	r.HandleFunc("/widget/{channel}", s.viewWidget).Methods("GET")
+	r.HandleFunc("/widget.js", viewWidgetJS).Methods("GET")
 	r.HandleFunc("/channels.json", s.viewChannelInfo)
-	r.HandleFunc("/thumbs/{channel}/{timestamp}.jpg", s.viewThumb).Name("thumbs")
+	r.HandleFunc("/feed.xml", s.viewFeed).Methods("GET")
+	r.HandleFunc("/api/instance", s.viewInstance).Methods("GET")
+	r.HandleFunc("/api/directory", s.viewDirectory).Methods("GET")
+	r.HandleFunc("/api/directory", s.viewDirectoryReport).Methods("POST")
+	r.HandleFunc("/thumbs/{channel}/{timestamp}.{ext:jpg|webp}", s.viewThumb).Name("thumbs")
+	r.HandleFunc("/previews/{channel}/{timestamp}.mp4", s.viewPreview).Name("previews")
+	r.HandleFunc("/api/channels/{channel}", s.viewChannel).Methods("GET")
+	r.HandleFunc("/api/schedule", s.viewUpcoming).Methods("GET")
+	r.HandleFunc("/api/channels/{channel}/sessions", s.viewSessions).Methods("GET")
+	r.HandleFunc("/api/channels/{channel}/status", s.viewStatus).Methods("GET")
+	r.HandleFunc("/api/channels/{channel}/playback", s.viewPlayback).Methods("GET")
+	r.HandleFunc("/api/channels/{channel}/badge.svg", s.viewBadge).Methods("GET")
+	r.HandleFunc("/api/channels/{channel}/playout", s.viewPlayoutSchedule).Methods("GET")
+	r.HandleFunc("/api/channels/{channel}/redeem", s.viewRedeem).Methods("POST")
+	r.HandleFunc("/api/channels/{channel}/acknowledge", s.viewAckContent).Methods("POST")
+	r.HandleFunc("/api/channels/{channel}/live", s.viewKick).Methods("DELETE")
+	r.HandleFunc("/api/channels/{channel}/metadata", s.viewMetadata).Methods("POST")
+	r.HandleFunc("/api/channels/{channel}/cues", s.viewCue).Methods("POST")
+	r.HandleFunc("/api/channels/{channel}/usage", s.viewUsage).Methods("GET")
+	r.HandleFunc("/api/channels/{channel}/analytics", s.viewAnalytics).Methods("GET")
+	r.HandleFunc("/api/account", s.viewAccount).Methods("GET")
+	r.HandleFunc("/api/account/reactivate", s.viewAccountReactivate).Methods("POST")
 	// login
 	r.HandleFunc("/oauth2/user", s.viewUser).Methods("GET")
 	r.HandleFunc("/oauth2/initiate", s.viewOauthLogin).Methods("GET")
 	r.HandleFunc("/oauth2/cb", s.viewOauthCB).Methods("GET")
 	r.HandleFunc("/oauth2/logout", s.viewOauthLogout).Methods("POST")
+	r.HandleFunc("/oauth2/patreon/initiate", s.viewPatreonLogin).Methods("GET")
+	r.HandleFunc("/oauth2/patreon/cb", s.viewPatreonCB).Methods("GET")
+	r.HandleFunc("/oauth/authorize", s.viewAuthorize).Methods("GET")
+	r.HandleFunc("/oauth/authorize", s.viewAuthorizeDecide).Methods("POST")
+	r.HandleFunc("/oauth/token", s.viewOauthToken).Methods("POST")
+	r.HandleFunc("/api/patreon", s.viewPatreonUnlink).Methods("DELETE")
+	r.HandleFunc("/api/notifications", s.viewNotifications).Methods("GET")
+	r.HandleFunc("/api/notifications/seen", s.viewNotificationsSeen).Methods("POST")
+	r.HandleFunc("/api/notifications/unread", s.viewNotificationsUnread).Methods("GET")
+	r.HandleFunc("/api/notifications/events", s.viewNotificationEvents).Methods("GET")
+	r.HandleFunc("/api/notifications/{id:[0-9]+}/seen", s.viewNotificationSeen).Methods("POST")
+	r.HandleFunc("/api/settings/key-rotation", s.viewKeyRotation).Methods("GET")
+	r.HandleFunc("/api/settings/key-rotation", s.viewKeyRotationUpdate).Methods("PUT")
+	r.HandleFunc("/api/settings/notifications", s.viewNotifySettings).Methods("GET")
+	r.HandleFunc("/api/settings/notifications", s.viewNotifySettingsUpdate).Methods("PUT")
+	r.HandleFunc("/api/tokens", s.viewTokens).Methods("GET")
+	r.HandleFunc("/api/tokens", s.viewTokensCreate).Methods("POST")
+	r.HandleFunc("/api/tokens/{id:[0-9]+}", s.viewTokensDelete).Methods("DELETE")
+	// API description
+	r.HandleFunc("/api/openapi.json", viewOpenAPI).Methods("GET")
+	if s.APIDocs {
+		r.HandleFunc("/api/docs", viewAPIDocs).Methods("GET")
+	}
 	// model
 	r.HandleFunc("/api/mychannels", s.viewDefs).Methods("GET")
 	r.HandleFunc("/api/mychannels", s.viewDefsCreate).Methods("POST")
-	r.HandleFunc("/api/mychannels/{name}", s.viewDefsUpdate).Methods("PUT")
+	r.HandleFunc("/api/mychannels/{name}", s.viewDefsUpdate).Methods("PUT", "PATCH")
 	r.HandleFunc("/api/mychannels/{name}", s.viewDefsDelete).Methods("DELETE")
-	return middleware(r)
+	r.HandleFunc("/api/rooms", s.viewRooms).Methods("GET")
+	r.HandleFunc("/api/rooms", s.viewRoomsCreate).Methods("POST")
+	r.HandleFunc("/api/rooms/{name}", s.viewRoomsDelete).Methods("DELETE")
+	r.HandleFunc("/api/bookmarks", s.viewBookmarks).Methods("GET")
+	r.HandleFunc("/api/bookmarks", s.viewBookmarksCreate).Methods("POST")
+	r.HandleFunc("/api/bookmarks/{id:[0-9]+}", s.viewBookmarksDelete).Methods("DELETE")
+	r.HandleFunc("/api/parties", s.viewPartiesCreate).Methods("POST")
+	r.HandleFunc("/api/parties/{id}", s.viewParty).Methods("GET")
+	r.HandleFunc("/api/parties/{id}/ws", s.viewPartyWS).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/ingest-options", s.viewIngestOptions).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/key-uses", s.viewKeyUses).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/mobile", s.viewMobile).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/mobile.png", s.viewMobileQR).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/passes", s.viewPasses).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/passes", s.viewPassesCreate).Methods("POST")
+	r.HandleFunc("/api/mychannels/{name}/members", s.viewMembers).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/members", s.viewMembersAdd).Methods("POST")
+	r.HandleFunc("/api/mychannels/{name}/members/{user}", s.viewMembersDelete).Methods("DELETE")
+	r.HandleFunc("/api/mychannels/{name}/targets", s.viewTargets).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/targets", s.viewTargetsCreate).Methods("POST")
+	r.HandleFunc("/api/mychannels/{name}/targets/{id:[0-9]+}", s.viewTargetsUpdate).Methods("PATCH")
+	r.HandleFunc("/api/mychannels/{name}/targets/{id:[0-9]+}", s.viewTargetsDelete).Methods("DELETE")
+	r.HandleFunc("/api/mychannels/{name}/targets/{id:[0-9]+}/test", s.viewTargetsTest).Methods("POST")
+	r.HandleFunc("/api/mychannels/{name}/playout", s.viewPlayout).Methods("GET")
+	r.HandleFunc("/api/mychannels/{name}/playout", s.viewPlayoutUpdate).Methods("PUT")
+	r.HandleFunc("/api/mychannels/{name}/schedule", s.viewScheduleCreate).Methods("POST")
+	r.HandleFunc("/api/mychannels/{name}/schedule/{id:[0-9]+}", s.viewScheduleDelete).Methods("DELETE")
+	return middleware(s.rateLimit(s.cachePublic(s.checkToken(s.checkCSRF(r)))))
 }
 
 func (s *Server) checkAuth(rw http.ResponseWriter, req *http.Request) string {
-	var info discordUser
-	err := s.unseal(req, loginCookie, &info)
-	if err == nil {
-		return info.ID
+	if userID := s.loggedInUser(req); userID != "" {
+		return userID
 	}
 	log.Printf("error: authentication failed for %s to %s", req.RemoteAddr, req.URL)
 	http.Error(rw, "not authorized", 401)
 	return ""
 }
 
+// loggedInUser returns the ID of the logged-in user, or an empty string for
+// anonymous requests. Banned users are treated as anonymous.
+func (s *Server) loggedInUser(req *http.Request) string {
+	userID := s.sessionUser(req)
+	if s.Channels.Banned(userID, nil) {
+		return ""
+	}
+	return userID
+}
+
+// sessionUser returns the ID of the user the request is authenticated as,
+// whether or not they are banned
+func (s *Server) sessionUser(req *http.Request) string {
+	if userID, _ := req.Context().Value(tokenUserKey{}).(string); userID != "" {
+		return userID
+	}
+	var info discordUser
+	if err := s.unseal(req, loginCookie, &info); err != nil {
+		return ""
+	}
+	return info.ID
+}
+
 func parseRequest(rw http.ResponseWriter, req *http.Request, d interface{}) bool {
 	blob, err := ioutil.ReadAll(req.Body)
 	if err != nil {
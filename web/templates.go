@@ -0,0 +1,39 @@
+package web
+
+import (
+	"context"
+	"log"
+	"net/url"
+
+	"eaglesong.dev/gunk/locale"
+	"eaglesong.dev/gunk/model"
+)
+
+// SetTemplates sets the operator's templates for announcements, webhooks and
+// emails
+func (s *Server) SetTemplates(t *locale.Templates) {
+	s.templates = t
+}
+
+// templateChannel looks up what templates are told about a channel. If that
+// fails they still get its name and watch page.
+func (s *Server) templateChannel(ctx context.Context, name string) locale.Channel {
+	ch := locale.Channel{
+		Name:        name,
+		DisplayName: name,
+		URL:         s.BaseURL + "/watch/" + url.PathEscape(name),
+	}
+	info, err := model.GetChannelInfo(ctx, name)
+	if err != nil {
+		log.Printf("warning: getting channel %q for templates: %s", name, err)
+		return ch
+	}
+	s.populateChannel(info)
+	ch.DisplayName = channelLabel(info)
+	ch.Title = info.Title
+	// announcements go places that can't show content warnings first
+	if info.ThumbUpdated > 0 && info.Rating == model.RatingGeneral {
+		ch.Thumbnail = s.BaseURL + info.Thumb
+	}
+	return ch
+}
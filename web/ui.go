@@ -5,17 +5,12 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
 	"regexp"
 
 	"github.com/gorilla/mux"
 )
 
-func uiRoutes(r *mux.Router) {
-	uiLoc := os.Getenv("UI")
-	if uiLoc == "" {
-		log.Fatalln("set UI to location of UI, either local path or URL")
-	}
+func (s *Server) uiRoutes(r *mux.Router, uiLoc string) {
 	u, err := url.Parse(uiLoc)
 	if err != nil {
 		log.Fatalln("error:", err)
@@ -32,7 +27,7 @@ func uiRoutes(r *mux.Router) {
 	})
 	r.Handle("/", indexHandler)
 	r.Handle("/mychannels", indexHandler)
-	r.Handle("/watch/{channel}", indexHandler)
+	r.Handle("/watch/{channel}", s.oembedDiscovery(s.watchFallback(indexHandler)))
 	r.NotFoundHandler = cacheImmutable(handler)
 
 	// proxy avatars to avoid being blocked by privacy tools
@@ -0,0 +1,45 @@
+package web
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultUsageDays = 30
+	maxUsageDays     = 366
+)
+
+// viewUsage shows how much a channel has sent to viewers each day
+func (s *Server) viewUsage(rw http.ResponseWriter, req *http.Request) {
+	userID := s.checkAuth(rw, req)
+	if userID == "" {
+		return
+	}
+	days := defaultUsageDays
+	if v := req.FormValue("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxUsageDays {
+			http.Error(rw, "days must be 1 to "+strconv.Itoa(maxUsageDays), 400)
+			return
+		}
+		days = n
+	}
+	name := mux.Vars(req)["channel"]
+	usage, err := model.ListUsage(req.Context(), userID, name, days)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: listing usage of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	writeJSON(rw, usage)
+}
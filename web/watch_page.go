@@ -0,0 +1,144 @@
+package web
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// noJSAgents are clients that fetch pages without running scripts, so would
+// only see an empty shell of the UI
+var noJSAgents = regexp.MustCompile(`(?i)^(curl|wget|lynx|links|elinks|w3m|httpie|python-|go-http-client)|bot\b|crawler|spider|facebookexternalhit|embedly`)
+
+var watchPage = template.Must(template.New("watch").Funcs(template.FuncMap{
+	"time": func(ms int64) string { return time.UnixMilli(ms).UTC().Format("Mon 2 Jan 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Title}} - {{.Site}}</title>
+<meta property="og:title" content="{{.Title}}">
+{{if .Thumb}}<meta property="og:image" content="{{.Thumb}}">{{end}}
+{{with .Info.Description}}<meta name="description" content="{{.}}">{{end}}
+</head>
+<body>
+<h1>{{.Name}}</h1>
+{{if .Info.Live}}
+<p><strong>Live now</strong>{{with .Info.Title}}: {{.}}{{end}} ({{.Info.Viewers}} watching)</p>
+{{else}}
+<p>Offline.{{with .Info.OfflineText}} {{.}}{{end}}</p>
+{{range .Info.OfflineLinks}}<p><a href="{{.URL}}" rel="nofollow noopener">{{.Label}}</a></p>
+{{end}}
+{{end}}
+{{if .Thumb}}<p><img src="{{.Thumb}}" alt="Thumbnail of {{.Name}}" width="640"></p>{{end}}
+{{with .Info.Description}}<p>{{.}}</p>{{end}}
+{{if .Restricted}}
+<p>This channel needs a pass, membership or content acknowledgment. <a href="{{.WatchURL}}">Open it in a browser with JavaScript</a> to continue.</p>
+{{else}}
+<p>Watch in a player such as VLC or mpv:</p>
+<ul>
+<li>HLS: <a href="{{.HLSURL}}">{{.HLSURL}}</a></li>
+<li>MPEG-TS: <a href="{{.LiveURL}}">{{.LiveURL}}</a></li>
+</ul>
+{{end}}
+{{with .Info.Upcoming}}
+<h2>Schedule</h2>
+<ul>
+{{range .}}<li>{{time .Start}}{{with .Title}}: {{.}}{{end}} ({{.Minutes}} minutes)</li>
+{{end}}
+</ul>
+{{end}}
+<p><a href="{{.SiteURL}}">{{.Site}}</a></p>
+</body>
+</html>
+`))
+
+type watchPageInfo struct {
+	Info            *model.ChannelInfo
+	Name, Title     string
+	Site, SiteURL   string
+	WatchURL, Thumb string
+	HLSURL, LiveURL string
+	Restricted      bool
+}
+
+// wantsStatic returns true for clients that won't run the UI's scripts, or
+// that asked for the plain page
+func wantsStatic(req *http.Request) bool {
+	return req.URL.Query().Has("nojs") || noJSAgents.MatchString(req.UserAgent())
+}
+
+// watchFallback serves a plain watch page to clients that don't run
+// JavaScript, and passes everyone else on to the UI
+func (s *Server) watchFallback(ui http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !wantsStatic(req) {
+			ui.ServeHTTP(rw, req)
+			return
+		}
+		s.viewWatchStatic(rw, req)
+	})
+}
+
+func (s *Server) viewWatchStatic(rw http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["channel"]
+	info, err := model.GetChannelInfo(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting channel %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	rules, err := model.ChannelAccess(req.Context(), name)
+	if err != nil {
+		log.Printf("error: checking access to %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.populateChannel(info)
+	s.Channels.PopulateLive([]*model.ChannelInfo{info})
+	info.Upcoming, err = model.ListSchedule(req.Context(), name)
+	if err != nil {
+		log.Printf("error: getting schedule of %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	title := info.Title
+	if title == "" {
+		title = channelLabel(info)
+	}
+	base, _ := url.Parse(s.BaseURL)
+	data := watchPageInfo{
+		Info:       info,
+		Name:       channelLabel(info),
+		Title:      title,
+		Site:       base.Host,
+		SiteURL:    s.BaseURL + "/",
+		WatchURL:   s.BaseURL + "/watch/" + url.PathEscape(name),
+		HLSURL:     s.BaseURL + "/hls/" + url.PathEscape(name) + "/index.m3u8",
+		LiveURL:    info.LiveURL,
+		Restricted: rules.Gated() || rules.Rating != model.RatingGeneral,
+	}
+	if info.ThumbUpdated > 0 {
+		data.Thumb = s.BaseURL + info.Thumb
+	}
+	if u, err := url.Parse(data.LiveURL); err == nil && u.Host == "" {
+		data.LiveURL = s.BaseURL + data.LiveURL
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; frame-ancestors 'none'")
+	if err := watchPage.Execute(rw, data); err != nil {
+		log.Printf("error: rendering watch page of %q: %s", name, err)
+	}
+}
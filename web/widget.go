@@ -0,0 +1,136 @@
+package web
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"eaglesong.dev/gunk/model"
+	"github.com/gorilla/mux"
+	"github.com/jackc/pgx/v5"
+)
+
+// widgetPoll is how often the widget checks whether the channel is live, in
+// milliseconds. It reads the public API, whose responses are cached.
+const widgetPoll = 30000
+
+var widgetPage = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.Name}}</title>
+<style>
+html, body { margin: 0; height: 100%; font-family: sans-serif; font-size: 14px; }
+body { background: {{if .Light}}#fff{{else}}#18181b{{end}}; color: {{if .Light}}#222{{else}}#eee{{end}}; }
+a { display: flex; flex-direction: column; height: 100%; color: inherit; text-decoration: none; }
+.thumb { flex: 1; min-height: 0; background: #000 center / cover no-repeat; position: relative; }
+.state { position: absolute; top: 6px; left: 6px; padding: 1px 6px; border-radius: 3px; background: #555; color: #fff; font-weight: bold; font-size: 12px; text-transform: uppercase; }
+.live .state { background: #e05d44; }
+.info { padding: 6px 8px; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+.name { font-weight: bold; }
+</style>
+</head>
+<body>
+<a id="w" href="{{.WatchURL}}" target="_blank" rel="noopener"{{if .Live}} class="live"{{end}}>
+{{if .ShowThumb}}<div class="thumb" id="thumb"{{if .Thumb}} style="background-image: url('{{.Thumb}}')"{{end}}><span class="state" id="state">{{if .Live}}Live{{else}}Offline{{end}}</span></div>{{end}}
+<div class="info">{{if not .ShowThumb}}<span class="state" id="state">{{if .Live}}Live{{else}}Offline{{end}}</span> {{end}}<span class="name">{{.Name}}</span>{{if .ShowViewers}} <span id="viewers">{{if .Live}}· {{.Viewers}} watching{{end}}</span>{{end}}<div id="title">{{.Title}}</div></div>
+</a>
+<script>
+(function() {
+  var api = {{.APIURL}}, showThumb = {{.ShowThumb}};
+  function update() {
+    fetch(api).then(function(resp) { return resp.json(); }).then(function(info) {
+      document.getElementById("w").className = info.live ? "live" : "";
+      document.getElementById("state").textContent = info.live ? "Live" : "Offline";
+      var viewers = document.getElementById("viewers");
+      if (viewers) viewers.textContent = info.live ? "· " + info.viewers + " watching" : "";
+      document.getElementById("title").textContent = info.title || "";
+      if (showThumb && info.thumb) document.getElementById("thumb").style.backgroundImage = "url('" + info.thumb + "')";
+    }).catch(function() {});
+  }
+  setInterval(update, {{.Poll}});
+})();
+</script>
+</body>
+</html>
+`))
+
+// widgetScript lets other sites add the widget with a script tag, as
+// <script src="https://example.com/widget.js" data-channel="name" async></script>
+// The data-theme, data-thumb, data-viewers, data-width and data-height
+// attributes configure it.
+const widgetScript = `(function() {
+  var script = document.currentScript;
+  if (!script || !script.dataset.channel) return;
+  var d = script.dataset, params = [];
+  ["theme", "thumb", "viewers"].forEach(function(k) {
+    if (d[k]) params.push(k + "=" + encodeURIComponent(d[k]));
+  });
+  var frame = document.createElement("iframe");
+  frame.src = new URL(script.src).origin + "/widget/" + encodeURIComponent(d.channel) + (params.length ? "?" + params.join("&") : "");
+  frame.width = d.width || "320";
+  frame.height = d.height || (d.thumb === "0" ? "60" : "220");
+  frame.title = d.channel;
+  frame.loading = "lazy";
+  frame.style.border = "0";
+  script.parentNode.insertBefore(frame, script.nextSibling);
+})();
+`
+
+type widgetInfo struct {
+	Name, Title             string
+	WatchURL, Thumb, APIURL string
+	Live                    bool
+	Viewers                 int
+	Light                   bool
+	ShowThumb, ShowViewers  bool
+	Poll                    int
+}
+
+// viewWidget serves a small "now live" card for framing on other sites, which
+// links to the watch page. Thumbnails of channels that aren't rated for
+// everyone are left out, as the site embedding the widget can't be asked to
+// show warnings first.
+func (s *Server) viewWidget(rw http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["channel"]
+	info, err := model.GetChannelInfo(req.Context(), name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.NotFound(rw, req)
+		return
+	} else if err != nil {
+		log.Printf("error: getting channel %q: %s", name, err)
+		http.Error(rw, "", 500)
+		return
+	}
+	s.populateChannel(info)
+	s.Channels.PopulateLive([]*model.ChannelInfo{info})
+	data := widgetInfo{
+		Name:        channelLabel(info),
+		Title:       info.Title,
+		Thumb:       info.Thumb,
+		WatchURL:    s.BaseURL + "/watch/" + url.PathEscape(name),
+		APIURL:      "/api/channels/" + url.PathEscape(name),
+		Live:        info.Live,
+		Viewers:     info.Viewers,
+		Light:       req.URL.Query().Get("theme") == "light",
+		ShowThumb:   queryFlag(req, "thumb", true) && info.Rating == model.RatingGeneral,
+		ShowViewers: queryFlag(req, "viewers", true),
+		Poll:        widgetPoll,
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", badgeMaxAge)
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; img-src 'self'; connect-src 'self'; frame-ancestors "+s.frameAncestors())
+	if err := widgetPage.Execute(rw, data); err != nil {
+		log.Printf("error: rendering widget of %q: %s", name, err)
+	}
+}
+
+// viewWidgetJS serves the script that adds the widget to a page
+func viewWidgetJS(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	rw.Header().Set("Cache-Control", "max-age=3600, public")
+	rw.Write([]byte(widgetScript))
+}